@@ -0,0 +1,7 @@
+// Package claude provides the public API of the Claude Agent SDK for Go.
+//
+// It builds on the internal transport and types packages to offer high-level
+// helpers that drive a complete Claude Code CLI session (SendAndWait,
+// ResumeAndSend), an interactive multi-turn Client and an AgentRunner that
+// dispatches tool calls to Go handlers until the agent finishes.
+package claude
@@ -0,0 +1,332 @@
+package claude
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/anthropics/claude-agent-sdk-go/internal/query"
+	"github.com/anthropics/claude-agent-sdk-go/internal/transport"
+	"github.com/anthropics/claude-agent-sdk-go/internal/types"
+)
+
+// Client is an interactive, multi-turn session with Claude.
+//
+// Connect starts the CLI and performs the initialize handshake; Query sends a
+// prompt and may be called repeatedly; responses are read with
+// ReceiveMessages, ReceiveResponse or TryReceive. Close ends the session.
+type Client struct {
+	options *types.ClaudeAgentOptions
+
+	mu          sync.RWMutex
+	transport   *transport.SubprocessCLITransport
+	query       *query.Query
+	stopSignals func()       // Removes the SIGINT handler, if installed
+	prompts     atomic.Int64 // Number of prompts sent, identifying the current turn
+}
+
+// NewClient creates a new Client. The options are validated but no process is
+// started until Connect is called.
+func NewClient(options *types.ClaudeAgentOptions) (*Client, error) {
+	if options == nil {
+		options = types.NewClaudeAgentOptions()
+	}
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	return &Client{options: options}, nil
+}
+
+// Connect starts the CLI and registers hooks with the initialize handshake
+func (c *Client) Connect(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.transport != nil {
+		return nil // Already connected
+	}
+	return c.connectLocked(ctx)
+}
+
+// connectLocked starts a new session with c.options; c.mu must be held
+func (c *Client) connectLocked(ctx context.Context) error {
+	t := transport.NewSubprocessCLITransport("", c.options)
+	if err := t.Connect(ctx); err != nil {
+		return err
+	}
+
+	// The session outlives ctx, which only bounds the connection
+	q := query.New(t, c.options)
+	q.Start(context.WithoutCancel(ctx))
+	if _, err := q.Initialize(ctx); err != nil {
+		q.Close()
+		_ = t.Close(ctx)
+		return err
+	}
+
+	c.transport = t
+	c.query = q
+	if c.options.SignalHandling && c.stopSignals == nil {
+		c.stopSignals = c.handleSignals()
+	}
+	return nil
+}
+
+// Query sends a prompt to Claude. Responses are delivered to the message channel.
+func (c *Client) Query(ctx context.Context, prompt string) error {
+	return c.QueryPrompt(ctx, types.TextPrompt(prompt))
+}
+
+// QueryPrompt is Query for a prompt that need not be plain text, such as
+// images alongside text. With a Tracer set, each prompt gets a query span
+// that ends when its ResultMessage arrives.
+func (c *Client) QueryPrompt(ctx context.Context, prompt types.Prompt) error {
+	t, q, err := c.session()
+	if err != nil {
+		return err
+	}
+
+	prompt, err = submitPrompt(ctx, c.options, t.SessionID(), prompt)
+	if err != nil {
+		return err
+	}
+
+	data, err := marshalUserPrompt(prompt)
+	if err != nil {
+		return err
+	}
+
+	attributes := queryAttributes(c.options)
+	if sessionID := t.SessionID(); sessionID != "" {
+		attributes["session_id"] = sessionID
+	}
+
+	// Tracking starts first so that no reply is missed
+	abort := q.StartQuerySpan(ctx, attributes)
+	q.PromptSent()
+	c.prompts.Add(1)
+	if err := t.Write(ctx, data); err != nil {
+		abort(err)
+		return err
+	}
+	return nil
+}
+
+// Progress returns the progress of the current query: the turns taken, the
+// wall and API time spent so far and the MaxTurns limit, so a UI can show
+// "turn 3 of 10" while the query runs. It is the zero Progress before the
+// first query.
+func (c *Client) Progress() types.Progress {
+	_, q, err := c.session()
+	if err != nil {
+		return types.Progress{}
+	}
+	return q.Progress()
+}
+
+// WriteMessage sends a user message, such as one built with
+// types.NewToolResultsMessage, to Claude
+func (c *Client) WriteMessage(ctx context.Context, msg *types.UserMessage) error {
+	t, _, err := c.session()
+	if err != nil {
+		return err
+	}
+
+	data, err := types.MarshalUserInput(msg, defaultSessionID)
+	if err != nil {
+		return err
+	}
+	return t.Write(ctx, string(data))
+}
+
+// WriteRaw writes data to the CLI's stdin verbatim, with no newline framing,
+// such as experimental control messages the SDK does not model yet. It
+// bypasses all SDK validation and framing: data that is not well-formed
+// stream-json breaks the session. For advanced use only.
+func (c *Client) WriteRaw(ctx context.Context, data []byte) error {
+	t, _, err := c.session()
+	if err != nil {
+		return err
+	}
+	return t.WriteRaw(ctx, data)
+}
+
+// ReceiveMessages returns the channel of every message received in the
+// session. It is closed when the session ends.
+func (c *Client) ReceiveMessages(ctx context.Context) <-chan types.Message {
+	_, q, err := c.session()
+	if err != nil {
+		closed := make(chan types.Message)
+		close(closed)
+		return closed
+	}
+	return q.Messages()
+}
+
+// ReceiveResponse returns a channel yielding messages up to and including the
+// next ResultMessage. It is closed after the result, when the session ends or
+// when ctx is cancelled.
+func (c *Client) ReceiveResponse(ctx context.Context) <-chan types.Message {
+	messages := c.ReceiveMessages(ctx)
+	response := make(chan types.Message)
+
+	go func() {
+		defer close(response)
+		for {
+			select {
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				select {
+				case response <- msg:
+				case <-ctx.Done():
+					return
+				}
+				if msg.Type() == types.MessageTypeResult {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return response
+}
+
+// TryReceive returns the next available message without blocking. The boolean
+// is false if no message is ready, the session has ended or the client is not
+// connected.
+func (c *Client) TryReceive() (types.Message, bool) {
+	_, q, err := c.session()
+	if err != nil {
+		return nil, false
+	}
+
+	select {
+	case msg, ok := <-q.Messages():
+		return msg, ok
+	default:
+		return nil, false
+	}
+}
+
+// Interrupt asks Claude to stop the current turn, cancelling the contexts
+// of callbacks still running for it
+func (c *Client) Interrupt(ctx context.Context) error {
+	_, q, err := c.session()
+	if err != nil {
+		return err
+	}
+	return q.Interrupt(ctx)
+}
+
+// Wait blocks until the session ends and returns nil on clean completion or
+// the terminal error otherwise. An interactive session only ends once the CLI
+// exits, so Wait is typically used after the last response has been received.
+func (c *Client) Wait(ctx context.Context) error {
+	t, _, err := c.session()
+	if err != nil {
+		return err
+	}
+	return t.Wait(ctx)
+}
+
+// AddMCPServer makes another MCP server available mid-conversation.
+//
+// The control protocol has no request for registering servers on a running
+// session, so the CLI is restarted with the server added and resumes the
+// current session. Call it between turns: a response in progress is lost, and
+// channels from ReceiveMessages are closed, so call it again afterwards. The
+// options passed to NewClient are not modified.
+//
+// If the CLI fails to start with the new server, the session is resumed
+// without it and the error is returned; the client stays connected with its
+// previous servers. Only if resuming fails too is the client left
+// disconnected, with both errors returned, and Connect starts a new session.
+func (c *Client) AddMCPServer(ctx context.Context, name string, config *types.MCPServerConfig) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.transport == nil {
+		err := types.NewCLIConnectionError("client is not connected; call Connect first", nil)
+		err.Kind = types.ErrNotConnected
+		return err
+	}
+	sessionID := c.transport.SessionID()
+	if sessionID == "" {
+		return types.NewCLIConnectionError("session ID is not known yet; wait for the first message before adding an MCP server", nil)
+	}
+
+	options := resumeOptions(c.options, sessionID)
+	options.WithMCPServer(name, config)
+	if err := options.Validate(); err != nil {
+		return err
+	}
+
+	c.query.Close()
+	_ = c.transport.Close(ctx)
+	c.transport = nil
+	c.query = nil
+
+	previous := c.options
+	c.options = options
+	err := c.connectLocked(ctx)
+	if err == nil {
+		return nil
+	}
+
+	// Resume the session with the servers it had before
+	c.options = resumeOptions(previous, sessionID)
+	if restoreErr := c.connectLocked(ctx); restoreErr != nil {
+		return errors.Join(err, fmt.Errorf("failed to resume session without MCP server %q: %w", name, restoreErr))
+	}
+	return err
+}
+
+// resumeOptions returns a copy of options that resumes sessionID
+func resumeOptions(options *types.ClaudeAgentOptions, sessionID string) *types.ClaudeAgentOptions {
+	resumed := options.Clone()
+	resumed.WithResume(sessionID)
+	resumed.ContinueConversation = false
+	resumed.ForkSession = false
+	resumed.ResumeSessionAt = nil
+	return resumed
+}
+
+// Close ends the session and stops the CLI
+func (c *Client) Close(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.transport == nil {
+		return nil
+	}
+
+	if c.stopSignals != nil {
+		c.stopSignals()
+		c.stopSignals = nil
+	}
+
+	c.query.Close()
+	err := c.transport.Close(ctx)
+	c.transport = nil
+	c.query = nil
+	return err
+}
+
+// session returns the connected transport and query
+func (c *Client) session() (*transport.SubprocessCLITransport, *query.Query, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.transport == nil {
+		err := types.NewCLIConnectionError("client is not connected; call Connect first", nil)
+		err.Kind = types.ErrNotConnected
+		return nil, nil, err
+	}
+	return c.transport, c.query, nil
+}
@@ -0,0 +1,465 @@
+package claude
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anthropics/claude-agent-sdk-go/internal/types"
+)
+
+// interactiveMockCLI answers the initialize request and replies to every
+// prompt with an assistant message and a result
+const interactiveMockCLI = `#!/bin/bash
+while read -r line; do
+    case "$line" in
+        *'"subtype":"initialize"'*)
+            id=$(echo "$line" | sed -E 's/.*"request_id":"([^"]+)".*/\1/')
+            echo '{"type":"control_response","response":{"subtype":"success","request_id":"'"$id"'","response":{}}}'
+            ;;
+        *'"type":"user"'*)
+            echo '{"type":"assistant","content":[{"type":"text","text":"ok"}],"model":"claude"}'
+            echo '{"type":"result","subtype":"success","session_id":"client-session","result":"ok"}'
+            ;;
+    esac
+done
+`
+
+// connectClient connects a Client to a mock CLI running script
+func connectClient(t *testing.T, script string) *Client {
+	t.Helper()
+
+	cliPath := createMockCLI(t, script)
+	client, err := NewClient(types.NewClaudeAgentOptions().WithCLIPath(cliPath))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	t.Cleanup(func() {
+		_ = client.Close(context.Background())
+	})
+	return client
+}
+
+func TestClient_QueryAndReceiveResponse(t *testing.T) {
+	client := connectClient(t, interactiveMockCLI)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for turn := 0; turn < 2; turn++ {
+		if err := client.Query(ctx, "hello"); err != nil {
+			t.Fatalf("Query() error = %v", err)
+		}
+
+		var received []string
+		for msg := range client.ReceiveResponse(ctx) {
+			received = append(received, msg.Type())
+		}
+		if len(received) != 2 || received[1] != types.MessageTypeResult {
+			t.Errorf("Turn %d: expected assistant and result messages, got %v", turn, received)
+		}
+	}
+}
+
+func TestClient_WriteToolResults(t *testing.T) {
+	client := connectClient(t, interactiveMockCLI)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	msg := types.NewToolResultsMessage(
+		types.ToolResultBlock{ToolUseID: "tool_1", Content: "a"},
+		types.ToolResultBlock{ToolUseID: "tool_2", Content: "b"},
+	)
+	if err := client.WriteMessage(ctx, msg); err != nil {
+		t.Fatalf("WriteMessage() error = %v", err)
+	}
+
+	var last types.Message
+	for m := range client.ReceiveResponse(ctx) {
+		last = m
+	}
+	if last == nil || last.Type() != types.MessageTypeResult {
+		t.Errorf("Expected a result after writing tool results, got %v", last)
+	}
+}
+
+func TestClient_TryReceive(t *testing.T) {
+	client := connectClient(t, interactiveMockCLI)
+
+	if msg, ok := client.TryReceive(); ok {
+		t.Fatalf("Expected no message before querying, got %v", msg)
+	}
+
+	if err := client.Query(context.Background(), "hello"); err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+
+	var received []types.Message
+	deadline := time.Now().Add(5 * time.Second)
+	for len(received) < 2 && time.Now().Before(deadline) {
+		if msg, ok := client.TryReceive(); ok {
+			received = append(received, msg)
+			continue
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if len(received) != 2 || received[1].Type() != types.MessageTypeResult {
+		t.Errorf("Expected assistant and result messages, got %v", received)
+	}
+}
+
+func TestClient_NotConnected(t *testing.T) {
+	client, err := NewClient(nil)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	if err := client.Query(context.Background(), "hello"); !errors.Is(err, types.ErrNotConnected) {
+		t.Errorf("Query() before Connect error = %v, want ErrNotConnected", err)
+	}
+	if _, ok := client.TryReceive(); ok {
+		t.Error("Expected TryReceive to report no message before Connect")
+	}
+	if err := client.Close(context.Background()); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
+
+func TestClient_Wait(t *testing.T) {
+	// The mock answers initialize, then exits after the first prompt's result
+	client := connectClient(t, `#!/bin/bash
+read -r line
+id=$(echo "$line" | sed -E 's/.*"request_id":"([^"]+)".*/\1/')
+echo '{"type":"control_response","response":{"subtype":"success","request_id":"'"$id"'","response":{}}}'
+read -r line
+echo '{"type":"result","subtype":"success","session_id":"client-session","result":"done"}'
+`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.Query(ctx, "hello"); err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	for range client.ReceiveMessages(ctx) {
+	}
+
+	if err := client.Wait(ctx); err != nil {
+		t.Errorf("Wait() error = %v, want nil", err)
+	}
+}
+
+func TestClient_AddMCPServer(t *testing.T) {
+	argsFile := filepath.Join(t.TempDir(), "args")
+	script := `#!/bin/bash
+printf '%s\n' "$@" > ` + argsFile + `
+` + strings.TrimPrefix(interactiveMockCLI, "#!/bin/bash\n")
+	options := types.NewClaudeAgentOptions().WithCLIPath(createMockCLI(t, script))
+	client, err := NewClient(options)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	server := &types.MCPServerConfig{Type: "stdio", Command: "extra-server"}
+	if err := client.AddMCPServer(ctx, "extra", server); err == nil {
+		t.Error("Expected AddMCPServer() to fail before Connect")
+	}
+
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close(context.Background())
+	}()
+
+	if err := client.AddMCPServer(ctx, "extra", server); err == nil {
+		t.Error("Expected AddMCPServer() to fail before the session ID is known")
+	}
+
+	if err := client.Query(ctx, "hello"); err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	for range client.ReceiveResponse(ctx) {
+	}
+
+	if err := client.AddMCPServer(ctx, "extra", server); err != nil {
+		t.Fatalf("AddMCPServer() error = %v", err)
+	}
+
+	data, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatalf("Failed to read CLI args: %v", err)
+	}
+	args := string(data)
+	if !strings.Contains(args, "--resume\nclient-session\n") {
+		t.Errorf("Expected restarted CLI to resume the session, got args:\n%s", args)
+	}
+	if !strings.Contains(args, `"extra"`) {
+		t.Errorf("Expected restarted CLI to receive the added server, got args:\n%s", args)
+	}
+	if len(options.MCPServers) != 0 || options.Resume != nil {
+		t.Error("AddMCPServer should not modify the caller's options")
+	}
+
+	// The restarted session keeps answering
+	if err := client.Query(ctx, "hello again"); err != nil {
+		t.Fatalf("Query() after AddMCPServer error = %v", err)
+	}
+	var received []string
+	for msg := range client.ReceiveResponse(ctx) {
+		received = append(received, msg.Type())
+	}
+	if len(received) != 2 {
+		t.Errorf("Expected assistant and result messages after restart, got %v", received)
+	}
+}
+
+func TestClient_AddMCPServerRestoresSession(t *testing.T) {
+	// The CLI refuses to start with the broken server
+	argsFile := filepath.Join(t.TempDir(), "args")
+	script := `#!/bin/bash
+printf '%s\n' "$@" > ` + argsFile + `
+case "$*" in
+    *broken-server*) echo "failed to start MCP server" >&2; exit 1 ;;
+esac
+` + strings.TrimPrefix(interactiveMockCLI, "#!/bin/bash\n")
+	client := connectClient(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.Query(ctx, "hello"); err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	for range client.ReceiveResponse(ctx) {
+	}
+
+	server := &types.MCPServerConfig{Type: "stdio", Command: "broken-server"}
+	if err := client.AddMCPServer(ctx, "broken", server); err == nil {
+		t.Fatal("Expected AddMCPServer() to fail when the CLI does not start")
+	}
+
+	data, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatalf("Failed to read CLI args: %v", err)
+	}
+	args := string(data)
+	if !strings.Contains(args, "--resume\nclient-session\n") || strings.Contains(args, "broken-server") {
+		t.Errorf("Expected the session to be resumed without the server, got args:\n%s", args)
+	}
+
+	// The client is still connected to the resumed session
+	if err := client.Query(ctx, "hello again"); err != nil {
+		t.Fatalf("Query() after failed AddMCPServer error = %v", err)
+	}
+	var received []string
+	for msg := range client.ReceiveResponse(ctx) {
+		received = append(received, msg.Type())
+	}
+	if len(received) != 2 {
+		t.Errorf("Expected assistant and result messages after restoring, got %v", received)
+	}
+}
+
+func TestClient_QueryUserPromptSubmitHook(t *testing.T) {
+	inputFile := filepath.Join(t.TempDir(), "stdin.txt")
+	mockScript := `#!/bin/bash
+while read -r line; do
+    case "$line" in
+        *'"subtype":"initialize"'*)
+            id=$(echo "$line" | sed -E 's/.*"request_id":"([^"]+)".*/\1/')
+            echo '{"type":"control_response","response":{"subtype":"success","request_id":"'"$id"'","response":{}}}'
+            ;;
+        *'"type":"user"'*)
+            printf '%s\n' "$line" >> ` + inputFile + `
+            echo '{"type":"result","subtype":"success","session_id":"client-session","result":"ok"}'
+            ;;
+    esac
+done
+`
+	hook := func(ctx context.Context, input interface{}, toolUseID *string, hookContext *types.HookContext) (map[string]interface{}, error) {
+		prompt, _ := input.(map[string]any)["prompt"].(string)
+		if strings.Contains(prompt, "DROP TABLE") {
+			return map[string]any{"decision": "block", "reason": "destructive request"}, nil
+		}
+		return map[string]any{
+			"hookSpecificOutput": map[string]any{
+				"hookEventName": "UserPromptSubmit",
+				"updatedPrompt": strings.ReplaceAll(prompt, "hunter2", "[REDACTED]"),
+			},
+		}, nil
+	}
+
+	cliPath := createMockCLI(t, mockScript)
+	client, err := NewClient(types.NewClaudeAgentOptions().
+		WithCLIPath(cliPath).
+		WithHook(types.HookEventUserPromptSubmit, types.HookMatcher{Hooks: []types.HookFunc{hook}}))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close(context.Background())
+	}()
+
+	var blocked *types.PromptBlockedError
+	if err := client.Query(ctx, "DROP TABLE users"); !errors.As(err, &blocked) || blocked.Reason != "destructive request" {
+		t.Fatalf("Query() error = %v, want PromptBlockedError", err)
+	}
+
+	if err := client.Query(ctx, "my password is hunter2"); err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	for range client.ReceiveResponse(ctx) {
+	}
+
+	data, err := os.ReadFile(inputFile)
+	if err != nil {
+		t.Fatalf("Failed to read mock input: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 1 {
+		t.Fatalf("Expected only the allowed prompt to reach the CLI, got %q", data)
+	}
+	if !strings.Contains(string(data), `"content":"my password is [REDACTED]"`) {
+		t.Errorf("Expected the redacted prompt to reach the CLI, got %q", data)
+	}
+}
+
+// spanTracer reports the attributes of query spans once they end
+type spanTracer struct {
+	ended chan map[string]string
+}
+
+func (s *spanTracer) StartSpan(ctx context.Context, name string, attributes map[string]string) (context.Context, types.Span) {
+	ended := s.ended
+	if name != types.SpanQuery {
+		ended = make(chan map[string]string, 1)
+	}
+	return ctx, &endedSpan{attributes: attributes, ended: ended}
+}
+
+type endedSpan struct {
+	attributes map[string]string
+	ended      chan map[string]string
+}
+
+func (s *endedSpan) SetAttribute(key, value string) { s.attributes[key] = value }
+func (s *endedSpan) RecordError(err error)          {}
+func (s *endedSpan) End()                           { s.ended <- s.attributes }
+
+func TestClient_QuerySpan(t *testing.T) {
+	tracer := &spanTracer{ended: make(chan map[string]string, 1)}
+	client, err := NewClient(types.NewClaudeAgentOptions().
+		WithCLIPath(createMockCLI(t, interactiveMockCLI)).
+		WithModel("claude-sonnet-4-5").
+		WithTracer(tracer))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close(context.Background())
+	}()
+
+	if err := client.Query(ctx, "hello"); err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	for range client.ReceiveResponse(ctx) {
+	}
+
+	select {
+	case attributes := <-tracer.ended:
+		if attributes["model"] != "claude-sonnet-4-5" || attributes["session_id"] != "client-session" {
+			t.Errorf("Unexpected query span attributes: %v", attributes)
+		}
+	case <-ctx.Done():
+		t.Fatal("Expected the query span to end with the result")
+	}
+}
+
+func TestClient_Progress(t *testing.T) {
+	// The result is only sent once the test writes "finish"
+	script := `#!/bin/bash
+while read -r line; do
+    case "$line" in
+        *'"subtype":"initialize"'*)
+            id=$(echo "$line" | sed -E 's/.*"request_id":"([^"]+)".*/\1/')
+            echo '{"type":"control_response","response":{"subtype":"success","request_id":"'"$id"'","response":{}}}'
+            ;;
+        *'"type":"user"'*)
+            echo '{"type":"assistant","content":[{"type":"text","text":"working"}],"model":"claude","id":"msg_1"}'
+            ;;
+        finish)
+            echo '{"type":"result","subtype":"success","session_id":"client-session","num_turns":4,"duration_ms":1500,"duration_api_ms":900}'
+            ;;
+    esac
+done
+`
+	cliPath := createMockCLI(t, script)
+	client, err := NewClient(types.NewClaudeAgentOptions().WithCLIPath(cliPath).WithMaxTurns(10))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if got := client.Progress(); got != (types.Progress{}) {
+		t.Errorf("Progress() before Connect = %+v", got)
+	}
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close(context.Background())
+	}()
+
+	if err := client.Query(ctx, "hello"); err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	messages := client.ReceiveResponse(ctx)
+	if msg := <-messages; msg == nil || msg.Type() != types.MessageTypeAssistant {
+		t.Fatalf("Expected an assistant message, got %v", msg)
+	}
+
+	progress := client.Progress()
+	if progress.Turns != 1 || progress.MaxTurns != 10 || progress.Done || progress.Elapsed <= 0 {
+		t.Errorf("Progress() mid-query = %+v, want turn 1 of 10", progress)
+	}
+
+	if err := client.WriteRaw(ctx, []byte("finish\n")); err != nil {
+		t.Fatalf("WriteRaw() error = %v", err)
+	}
+	for range messages {
+	}
+
+	want := types.Progress{Turns: 4, MaxTurns: 10, Elapsed: 1500 * time.Millisecond, APIDuration: 900 * time.Millisecond, Done: true}
+	if got := client.Progress(); got != want {
+		t.Errorf("Progress() after result = %+v, want %+v", got, want)
+	}
+}
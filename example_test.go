@@ -0,0 +1,84 @@
+package claude_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	claude "github.com/anthropics/claude-agent-sdk-go"
+	"github.com/anthropics/claude-agent-sdk-go/internal/types"
+)
+
+// allowUnderRoot returns a CanUseTool callback that grants access to blocked
+// paths under root by adding their directory to the session
+func allowUnderRoot(root string) func(string, map[string]any, interface{}) (types.PermissionResult, error) {
+	return func(tool string, input map[string]any, ctx interface{}) (types.PermissionResult, error) {
+		permissionContext, ok := ctx.(*types.ToolPermissionContext)
+		if !ok || permissionContext.BlockedPath == nil {
+			return types.PermissionResult{Behavior: "allow"}, nil
+		}
+
+		path := filepath.Clean(*permissionContext.BlockedPath)
+		if path != root && !strings.HasPrefix(path, root+string(filepath.Separator)) {
+			return types.PermissionResult{
+				Behavior: "deny",
+				Message:  fmt.Sprintf("%s is outside %s", path, root),
+			}, nil
+		}
+
+		return types.PermissionResult{Behavior: "allow"}.AddDirectory(filepath.Dir(path)), nil
+	}
+}
+
+// ExampleSendAndWait_blockedPath demonstrates auto-allowing blocked paths under a root directory
+func ExampleSendAndWait_blockedPath() {
+	options := types.NewClaudeAgentOptions().
+		WithCanUseTool(allowUnderRoot("/srv/projects"))
+
+	result, _, err := claude.SendAndWait(context.Background(), "Summarize /srv/projects/api/README.md", options)
+	if err != nil {
+		log.Printf("Query failed: %v", err)
+		return
+	}
+
+	if result.Result != nil {
+		fmt.Println(*result.Result)
+	}
+}
+
+// secretPattern matches API keys and tokens that must not leave the machine
+var secretPattern = regexp.MustCompile(`\b(sk-ant-[A-Za-z0-9_-]+|ghp_[A-Za-z0-9]{36}|AKIA[0-9A-Z]{16})\b`)
+
+// redactSecrets is a UserPromptSubmit hook that replaces secrets in the
+// prompt before it is sent
+func redactSecrets(ctx context.Context, input interface{}, toolUseID *string, hookContext *types.HookContext) (map[string]interface{}, error) {
+	prompt, _ := input.(map[string]any)["prompt"].(string)
+	return map[string]interface{}{
+		"hookSpecificOutput": map[string]interface{}{
+			"hookEventName": "UserPromptSubmit",
+			"updatedPrompt": secretPattern.ReplaceAllString(prompt, "[REDACTED]"),
+		},
+	}, nil
+}
+
+// ExampleSendAndWait_redactSecrets demonstrates a hook that redacts secrets from prompts
+func ExampleSendAndWait_redactSecrets() {
+	options := types.NewClaudeAgentOptions().
+		WithHook(types.HookEventUserPromptSubmit, types.HookMatcher{
+			Hooks: []types.HookFunc{redactSecrets},
+		})
+
+	prompt := "Why does this fail? curl -H 'x-api-key: sk-ant-api03-abc123' https://api.anthropic.com"
+	result, _, err := claude.SendAndWait(context.Background(), prompt, options)
+	if err != nil {
+		log.Printf("Query failed: %v", err)
+		return
+	}
+
+	if result.Result != nil {
+		fmt.Println(*result.Result)
+	}
+}
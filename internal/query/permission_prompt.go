@@ -0,0 +1,102 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/anthropics/claude-agent-sdk-go/internal/types"
+)
+
+// mcpProtocolVersion is the MCP protocol version the permission prompt server
+// reports when the CLI does not request one
+const mcpProtocolVersion = "2024-11-05"
+
+// permissionPromptInputSchema describes the arguments the CLI passes to a
+// permission prompt tool
+var permissionPromptInputSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"tool_name":   map[string]any{"type": "string"},
+		"input":       map[string]any{"type": "object"},
+		"tool_use_id": map[string]any{"type": "string"},
+	},
+	"required": []string{"tool_name", "input"},
+}
+
+// handlePermissionPrompt answers a JSONRPC message sent to the in-process
+// permission prompt server. A tools/call invokes PermissionPromptHandler and
+// returns its decision as JSON text, the result format the CLI expects from a
+// permission prompt tool.
+func (q *Query) handlePermissionPrompt(ctx context.Context, message map[string]any) map[string]any {
+	method, _ := message["method"].(string)
+	params, _ := message["params"].(map[string]any)
+
+	switch method {
+	case "initialize":
+		version, _ := params["protocolVersion"].(string)
+		if version == "" {
+			version = mcpProtocolVersion
+		}
+		return jsonrpcResult(message, map[string]any{
+			"protocolVersion": version,
+			"capabilities":    map[string]any{"tools": map[string]any{}},
+			"serverInfo":      map[string]any{"name": types.PermissionPromptServerName, "version": "1.0.0"},
+		})
+
+	case "tools/list":
+		return jsonrpcResult(message, map[string]any{
+			"tools": []any{map[string]any{
+				"name":        types.PermissionPromptTool,
+				"description": "Decides whether Claude may use a tool",
+				"inputSchema": permissionPromptInputSchema,
+			}},
+		})
+
+	case "tools/call":
+		arguments, _ := params["arguments"].(map[string]any)
+		toolName, _ := arguments["tool_name"].(string)
+		input, _ := arguments["input"].(map[string]any)
+
+		response, err := invokeCallback("PermissionPromptHandler", func() (map[string]any, error) {
+			if toolName == types.ToolNameExitPlanMode && q.options.PlanApproval != nil {
+				return permissionResponse(q.reviewPlan(input), input)
+			}
+			result, err := q.options.PermissionPromptHandler(toolName, input, &types.ToolPermissionContext{Signal: ctx})
+			if err != nil {
+				return nil, err
+			}
+			return permissionResponse(result, input)
+		})
+		if err != nil {
+			return toolErrorResponse(message, err)
+		}
+
+		text, err := json.Marshal(response)
+		if err != nil {
+			return toolErrorResponse(message, err)
+		}
+		return jsonrpcResult(message, map[string]any{
+			"content": []any{map[string]any{"type": types.ContentTypeText, "text": string(text)}},
+		})
+
+	default:
+		if method == "" || message["id"] == nil {
+			// Notifications such as notifications/initialized need no answer
+			return jsonrpcResult(message, map[string]any{})
+		}
+		return map[string]any{
+			"jsonrpc": "2.0",
+			"id":      message["id"],
+			"error":   map[string]any{"code": -32601, "message": "method not found: " + method},
+		}
+	}
+}
+
+// jsonrpcResult builds the JSONRPC response to message carrying result
+func jsonrpcResult(message map[string]any, result map[string]any) map[string]any {
+	return map[string]any{
+		"jsonrpc": "2.0",
+		"id":      message["id"],
+		"result":  result,
+	}
+}
@@ -0,0 +1,85 @@
+package query
+
+import (
+	"context"
+	"strings"
+
+	"github.com/anthropics/claude-agent-sdk-go/internal/types"
+)
+
+// SubmitPrompt runs the UserPromptSubmit hooks of options on prompt and
+// returns the prompt to send. Hooks run in order, each seeing the prompt as
+// left by the previous one. A hook that blocks the prompt, or fails, stops
+// it with a PromptBlockedError.
+func SubmitPrompt(ctx context.Context, options *types.ClaudeAgentOptions, sessionID, prompt string) (string, error) {
+	hookContext := &types.HookContext{
+		SessionID: sessionID,
+		Event:     types.HookEventUserPromptSubmit,
+	}
+	if options.PermissionMode != nil {
+		hookContext.PermissionMode = *options.PermissionMode
+	}
+
+	var contexts []string
+	for _, matcher := range options.Hooks[types.HookEventUserPromptSubmit] {
+		for _, hook := range matcher.Hooks {
+			input := map[string]any{
+				"hook_event_name": string(types.HookEventUserPromptSubmit),
+				"session_id":      sessionID,
+				"prompt":          prompt,
+			}
+			if options.CWD != nil {
+				input["cwd"] = *options.CWD
+			}
+			if hookContext.PermissionMode != "" {
+				input["permission_mode"] = string(hookContext.PermissionMode)
+			}
+
+			output, err := invokeCallback("UserPromptSubmit hook", func() (map[string]any, error) {
+				return hook(ctx, input, nil, hookContext)
+			})
+			if err != nil {
+				return "", types.NewPromptBlockedError("UserPromptSubmit hook failed", err)
+			}
+
+			if reason, blocked := promptBlocked(output); blocked {
+				message := "prompt blocked by UserPromptSubmit hook"
+				if reason != "" {
+					message += ": " + reason
+				}
+				blockedErr := types.NewPromptBlockedError(message, nil)
+				blockedErr.Reason = reason
+				return "", blockedErr
+			}
+
+			specific, _ := output["hookSpecificOutput"].(map[string]any)
+			if updated, ok := specific["updatedPrompt"].(string); ok {
+				prompt = updated
+			}
+			if extra, _ := specific["additionalContext"].(string); extra != "" {
+				contexts = append(contexts, extra)
+			}
+		}
+	}
+
+	// Added context follows the prompt, as the CLI places it after the
+	// prompt it annotates
+	if len(contexts) > 0 {
+		prompt = strings.Join(append([]string{prompt}, contexts...), "\n\n")
+	}
+	return prompt, nil
+}
+
+// promptBlocked reports whether a UserPromptSubmit hook output blocks the
+// prompt, and the reason it gave
+func promptBlocked(output map[string]any) (string, bool) {
+	if decision, _ := output["decision"].(string); decision == "block" {
+		reason, _ := output["reason"].(string)
+		return reason, true
+	}
+	if proceed, ok := output["continue"].(bool); ok && !proceed {
+		reason, _ := output["stopReason"].(string)
+		return reason, true
+	}
+	return "", false
+}
@@ -0,0 +1,173 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/anthropics/claude-agent-sdk-go/internal/types"
+)
+
+// promptHook returns a UserPromptSubmit hook returning output
+func promptHook(output func(prompt string) (map[string]any, error)) types.HookFunc {
+	return func(ctx context.Context, input interface{}, toolUseID *string, hookContext *types.HookContext) (map[string]interface{}, error) {
+		prompt, _ := input.(map[string]any)["prompt"].(string)
+		return output(prompt)
+	}
+}
+
+func TestSubmitPrompt(t *testing.T) {
+	hookErr := errors.New("scanner unavailable")
+
+	tests := []struct {
+		name       string
+		hooks      []types.HookFunc
+		want       string
+		wantBlock  bool
+		wantCause  error
+		wantReason string
+	}{
+		{
+			name: "no hooks",
+			want: "hello",
+		},
+		{
+			name: "empty output keeps prompt",
+			hooks: []types.HookFunc{promptHook(func(string) (map[string]any, error) {
+				return map[string]any{}, nil
+			})},
+			want: "hello",
+		},
+		{
+			name: "hooks rewrite in order",
+			hooks: []types.HookFunc{
+				promptHook(func(prompt string) (map[string]any, error) {
+					return map[string]any{"hookSpecificOutput": map[string]any{"updatedPrompt": prompt + " world"}}, nil
+				}),
+				promptHook(func(prompt string) (map[string]any, error) {
+					return map[string]any{"hookSpecificOutput": map[string]any{"updatedPrompt": strings.ToUpper(prompt)}}, nil
+				}),
+			},
+			want: "HELLO WORLD",
+		},
+		{
+			name: "additional context follows prompt",
+			hooks: []types.HookFunc{promptHook(func(string) (map[string]any, error) {
+				return map[string]any{"hookSpecificOutput": map[string]any{"additionalContext": "Today is Monday."}}, nil
+			})},
+			want: "hello\n\nToday is Monday.",
+		},
+		{
+			name: "decision block",
+			hooks: []types.HookFunc{promptHook(func(string) (map[string]any, error) {
+				return map[string]any{"decision": "block", "reason": "contains a secret"}, nil
+			})},
+			wantBlock:  true,
+			wantReason: "contains a secret",
+		},
+		{
+			name: "continue false",
+			hooks: []types.HookFunc{promptHook(func(string) (map[string]any, error) {
+				return map[string]any{"continue": false, "stopReason": "outside working hours"}, nil
+			})},
+			wantBlock:  true,
+			wantReason: "outside working hours",
+		},
+		{
+			name: "hook error blocks",
+			hooks: []types.HookFunc{promptHook(func(string) (map[string]any, error) {
+				return nil, hookErr
+			})},
+			wantBlock: true,
+			wantCause: hookErr,
+		},
+		{
+			name: "hook panic blocks",
+			hooks: []types.HookFunc{promptHook(func(string) (map[string]any, error) {
+				panic("boom")
+			})},
+			wantBlock: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := types.NewClaudeAgentOptions()
+			if len(tt.hooks) > 0 {
+				options.WithHook(types.HookEventUserPromptSubmit, types.HookMatcher{Hooks: tt.hooks})
+			}
+
+			got, err := SubmitPrompt(context.Background(), options, "session-1", "hello")
+
+			if !tt.wantBlock {
+				if err != nil {
+					t.Fatalf("SubmitPrompt() error = %v", err)
+				}
+				if got != tt.want {
+					t.Errorf("SubmitPrompt() = %q, want %q", got, tt.want)
+				}
+				return
+			}
+
+			var blocked *types.PromptBlockedError
+			if !errors.As(err, &blocked) {
+				t.Fatalf("SubmitPrompt() error = %v, want PromptBlockedError", err)
+			}
+			if tt.wantCause != nil && !errors.Is(err, tt.wantCause) {
+				t.Errorf("SubmitPrompt() error = %v, want it to wrap %v", err, tt.wantCause)
+			}
+			if blocked.Reason != tt.wantReason {
+				t.Errorf("Reason = %q, want %q", blocked.Reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestSubmitPrompt_HookInput(t *testing.T) {
+	var gotInput map[string]any
+	var gotContext *types.HookContext
+	hook := func(ctx context.Context, input interface{}, toolUseID *string, hookContext *types.HookContext) (map[string]interface{}, error) {
+		gotInput, _ = input.(map[string]any)
+		gotContext = hookContext
+		return nil, nil
+	}
+
+	options := types.NewClaudeAgentOptions().
+		WithPermissionMode(types.PermissionModeAcceptEdits).
+		WithHook(types.HookEventUserPromptSubmit, types.HookMatcher{Hooks: []types.HookFunc{hook}})
+
+	if _, err := SubmitPrompt(context.Background(), options, "session-1", "hello"); err != nil {
+		t.Fatalf("SubmitPrompt() error = %v", err)
+	}
+
+	if gotInput["hook_event_name"] != "UserPromptSubmit" || gotInput["prompt"] != "hello" || gotInput["session_id"] != "session-1" {
+		t.Errorf("Hook input = %v", gotInput)
+	}
+	if gotInput["permission_mode"] != string(types.PermissionModeAcceptEdits) {
+		t.Errorf("permission_mode = %v, want %s", gotInput["permission_mode"], types.PermissionModeAcceptEdits)
+	}
+	want := types.HookContext{SessionID: "session-1", Event: types.HookEventUserPromptSubmit, PermissionMode: types.PermissionModeAcceptEdits}
+	if gotContext == nil || *gotContext != want {
+		t.Errorf("Hook context = %+v, want %+v", gotContext, want)
+	}
+}
+
+func TestQuery_RegisterHooksSkipsUserPromptSubmit(t *testing.T) {
+	hook := func(ctx context.Context, input interface{}, toolUseID *string, hookContext *types.HookContext) (map[string]interface{}, error) {
+		return nil, nil
+	}
+	options := types.NewClaudeAgentOptions().
+		WithHook(types.HookEventPreToolUse, types.HookMatcher{Hooks: []types.HookFunc{hook}}).
+		WithHook(types.HookEventUserPromptSubmit, types.HookMatcher{Hooks: []types.HookFunc{hook}})
+
+	q := New(newMockTransport(), options)
+	config := q.registerHooks()
+
+	if _, ok := config[string(types.HookEventUserPromptSubmit)]; ok {
+		t.Error("UserPromptSubmit hooks should run in the SDK, not be registered with the CLI")
+	}
+	if _, ok := config[string(types.HookEventPreToolUse)]; !ok {
+		t.Error("PreToolUse hooks should be registered with the CLI")
+	}
+}
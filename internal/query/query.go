@@ -0,0 +1,867 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/anthropics/claude-agent-sdk-go/internal/transport"
+	"github.com/anthropics/claude-agent-sdk-go/internal/types"
+)
+
+// DefaultControlTimeout bounds the wait for a control response when the
+// caller's context has no deadline and no ControlTimeout is configured
+const DefaultControlTimeout = 60 * time.Second
+
+// Query implements the bidirectional control protocol on top of a Transport.
+//
+// It reads messages from the transport, answers control requests sent by the
+// CLI (tool permissions, hook callbacks and SDK MCP messages), correlates the
+// responses to control requests sent by the SDK and forwards every other
+// message to the consumer.
+//
+// A single goroutine reads the transport and answers control requests inline,
+// so a control request is answered before any message that followed it is
+// delivered. Regular messages are queued and delivered in the order the CLI
+// emitted them by a second goroutine, so a consumer that is slow to read never
+// delays a control response the CLI is waiting for.
+type Query struct {
+	transport transport.Transport
+	options   *types.ClaudeAgentOptions
+
+	// Hook callbacks registered during initialization, keyed by callback ID
+	hookCallbacks  map[string]hookRegistration
+	nextCallbackID int
+
+	// Current permission mode, updated by SetPermissionMode
+	permissionMode types.PermissionMode
+
+	// Control requests sent by the SDK that are waiting for a response
+	pendingResponses map[string]chan controlResult
+	requestCounter   int
+	initializeID     string // Request ID of the pending initialize request
+
+	// Open tool-use spans, keyed by tool use ID
+	toolSpans map[string]types.Span
+
+	// Open query spans of prompts awaiting their result, oldest first
+	querySpans []*querySpan
+
+	// Cancels the callbacks of control requests being handled, keyed by request ID
+	inFlight map[string]context.CancelCauseFunc
+
+	// Progress of the current query
+	progress *types.ProgressTracker
+
+	mu          sync.Mutex         // Mutex for thread safety
+	messageChan chan types.Message // Channel for regular messages
+	ctx         context.Context    // Context for cancellation
+	cancel      context.CancelFunc // Cancellation function
+}
+
+// hookRegistration is a hook callback and the event it was registered for
+type hookRegistration struct {
+	callback types.HookFunc
+	event    types.HookEvent
+}
+
+// controlResult is the outcome of a control request sent by the SDK
+type controlResult struct {
+	response map[string]any
+	err      error
+}
+
+// New creates a new Query on top of the given transport
+func New(t transport.Transport, options *types.ClaudeAgentOptions) *Query {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var permissionMode types.PermissionMode
+	if options.PermissionMode != nil {
+		permissionMode = *options.PermissionMode
+	}
+	maxTurns := 0
+	if options.MaxTurns != nil {
+		maxTurns = *options.MaxTurns
+	}
+
+	return &Query{
+		transport:        t,
+		options:          options,
+		permissionMode:   permissionMode,
+		hookCallbacks:    make(map[string]hookRegistration),
+		pendingResponses: make(map[string]chan controlResult),
+		toolSpans:        make(map[string]types.Span),
+		inFlight:         make(map[string]context.CancelCauseFunc),
+		progress:         types.NewProgressTracker(maxTurns),
+		messageChan:      make(chan types.Message, options.GetMessageChannelBuffer()),
+		ctx:              ctx,
+		cancel:           cancel,
+	}
+}
+
+// Start starts reading messages from the transport. Spans created by the
+// control loop use ctx as their parent.
+func (q *Query) Start(ctx context.Context) {
+	go q.readMessages(ctx)
+}
+
+// Messages returns the channel of regular (non-control) messages, in the order
+// the CLI emitted them
+func (q *Query) Messages() <-chan types.Message {
+	return q.messageChan
+}
+
+// Close stops the control loop. The underlying transport is not closed.
+func (q *Query) Close() {
+	q.cancel()
+}
+
+// PromptSent starts tracking the progress of a query whose prompt is being
+// written to the transport
+func (q *Query) PromptSent() {
+	q.progress.Start()
+}
+
+// Progress returns the progress of the current query
+func (q *Query) Progress() types.Progress {
+	return q.progress.Progress()
+}
+
+// Initialize sends the initialize control request, registering the configured hooks
+func (q *Query) Initialize(ctx context.Context) (map[string]any, error) {
+	request := map[string]any{
+		"subtype": types.SubtypeInitialize,
+	}
+	if hooks := q.registerHooks(); len(hooks) > 0 {
+		request["hooks"] = hooks
+	}
+
+	return q.sendControlRequest(ctx, request)
+}
+
+// Interrupt asks the CLI to interrupt the current turn. Callbacks still
+// running for the CLI's control requests, such as CanUseTool, hooks and SDK
+// MCP tools, first have their contexts cancelled with cause ErrInterrupted.
+func (q *Query) Interrupt(ctx context.Context) error {
+	q.cancelInFlight(types.ErrInterrupted)
+
+	_, err := q.sendControlRequest(ctx, map[string]any{
+		"subtype": types.SubtypeInterrupt,
+	})
+	return err
+}
+
+// SetPermissionMode changes the permission mode of the running session
+func (q *Query) SetPermissionMode(ctx context.Context, mode types.PermissionMode) error {
+	_, err := q.sendControlRequest(ctx, map[string]any{
+		"subtype": types.SubtypeSetPermissionMode,
+		"mode":    string(mode),
+	})
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	q.permissionMode = mode
+	q.mu.Unlock()
+	return nil
+}
+
+// registerHooks assigns callback IDs to the configured hooks and builds the
+// hook configuration sent with the initialize request
+func (q *Query) registerHooks() map[string]any {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	config := make(map[string]any)
+	for event, matchers := range q.options.Hooks {
+		// Prompts pass through their hooks in SubmitPrompt before reaching
+		// the CLI, which could not rewrite them
+		if event == types.HookEventUserPromptSubmit {
+			continue
+		}
+		entries := make([]map[string]any, 0, len(matchers))
+		for _, matcher := range matchers {
+			callbackIDs := make([]string, 0, len(matcher.Hooks))
+			for _, hook := range matcher.Hooks {
+				callbackID := fmt.Sprintf("hook_%d", q.nextCallbackID)
+				q.nextCallbackID++
+				q.hookCallbacks[callbackID] = hookRegistration{callback: hook, event: event}
+				callbackIDs = append(callbackIDs, callbackID)
+			}
+
+			entry := map[string]any{"hookCallbackIds": callbackIDs}
+			if matcher.Matcher != "" {
+				entry["matcher"] = matcher.Matcher
+			}
+			entries = append(entries, entry)
+		}
+		config[string(event)] = entries
+	}
+	return config
+}
+
+// sendControlRequest sends a control request to the CLI and waits for its response
+func (q *Query) sendControlRequest(ctx context.Context, request map[string]any) (response map[string]any, err error) {
+	subtype, _ := request["subtype"].(string)
+	ctx, span := types.StartSpan(ctx, q.options.Tracer, types.SpanControlRequest, map[string]string{
+		"subtype":   subtype,
+		"direction": "outbound",
+	})
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	// Never wait forever for a CLI that does not answer
+	timeout := q.controlTimeout()
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	q.mu.Lock()
+	q.requestCounter++
+	requestID := fmt.Sprintf("req_%d", q.requestCounter)
+	responseChan := make(chan controlResult, 1)
+	q.pendingResponses[requestID] = responseChan
+	if subtype == types.SubtypeInitialize {
+		q.initializeID = requestID
+	}
+	q.mu.Unlock()
+
+	defer func() {
+		q.mu.Lock()
+		delete(q.pendingResponses, requestID)
+		if q.initializeID == requestID {
+			q.initializeID = ""
+		}
+		q.mu.Unlock()
+	}()
+
+	data, err := json.Marshal(&types.SDKControlRequest{
+		Type_:   types.ControlTypeRequest,
+		ID:      requestID,
+		Request: request,
+	})
+	if err != nil {
+		return nil, types.NewControlProtocolError("failed to encode control request", err)
+	}
+
+	if err := q.transport.Write(ctx, string(data)); err != nil {
+		return nil, err
+	}
+
+	select {
+	case result := <-responseChan:
+		return result.response, result.err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err := types.NewControlProtocolError(
+				fmt.Sprintf("timed out waiting for %s control response (request %s)", subtype, requestID),
+				ctx.Err(),
+			)
+			err.Kind = types.ErrControlTimeout
+			return nil, err
+		}
+		return nil, types.NewControlProtocolError(fmt.Sprintf("control request %s cancelled", subtype), ctx.Err())
+	case <-q.ctx.Done():
+		err := types.NewControlProtocolError("query closed while waiting for control response", nil)
+		err.Kind = types.ErrClosed
+		return nil, err
+	}
+}
+
+// controlTimeout returns the configured control timeout or the default
+func (q *Query) controlTimeout() time.Duration {
+	if q.options.ControlTimeout != nil {
+		return *q.options.ControlTimeout
+	}
+	return DefaultControlTimeout
+}
+
+// readMessages routes messages from the transport until it is exhausted
+func (q *Query) readMessages(ctx context.Context) {
+	queue := newMessageQueue(q.ctx, q.options.GetMaxQueuedMessages())
+	go q.deliverMessages(queue)
+	defer queue.close()
+	defer q.failPendingResponses()
+	defer q.endToolSpans()
+	defer q.endQuerySpans()
+
+	push := queue.push
+	if q.options.OutputThrottle != nil {
+		throttle := newStreamThrottle(queue, *q.options.OutputThrottle)
+		push = throttle.push
+		defer throttle.flush()
+	}
+
+	for msg := range q.transport.ReadMessages(ctx) {
+		switch m := msg.(type) {
+		case *types.SDKControlResponse:
+			q.handleControlResponse(m)
+
+		case *types.SDKControlRequest:
+			q.handleControlRequest(ctx, m)
+
+		default:
+			q.traceMessage(ctx, msg)
+			q.progress.Observe(msg)
+			push(msg)
+
+			if result, ok := msg.(*types.ResultMessage); ok && result.IsError {
+				q.failInitialize(result.ExecutionError())
+			}
+		}
+
+		if q.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// deliverMessages forwards queued messages to the consumer until the queue is
+// closed and drained or the query is closed
+func (q *Query) deliverMessages(queue *messageQueue) {
+	defer close(q.messageChan)
+
+	for {
+		msg, ok := queue.pop(q.ctx)
+		if !ok {
+			return
+		}
+		select {
+		case q.messageChan <- msg:
+		case <-q.ctx.Done():
+			return
+		}
+	}
+}
+
+// messageQueue is a FIFO of messages waiting for the consumer, holding at
+// most limit messages
+type messageQueue struct {
+	mu       sync.Mutex
+	messages []types.Message
+	closed   bool
+	limit    int
+	ctx      context.Context // Unblocks a push waiting for space when done
+	notify   chan struct{}   // Signalled when a message is pushed or the queue is closed
+	space    chan struct{}   // Signalled when a message is popped
+}
+
+func newMessageQueue(ctx context.Context, limit int) *messageQueue {
+	return &messageQueue{
+		limit:  limit,
+		ctx:    ctx,
+		notify: make(chan struct{}, 1),
+		space:  make(chan struct{}, 1),
+	}
+}
+
+// push appends msg to the queue, blocking while the queue is full. The
+// message is dropped if ctx is done first.
+func (mq *messageQueue) push(msg types.Message) {
+	for {
+		mq.mu.Lock()
+		if len(mq.messages) < mq.limit {
+			mq.messages = append(mq.messages, msg)
+			mq.mu.Unlock()
+			mq.signal()
+			return
+		}
+		mq.mu.Unlock()
+
+		select {
+		case <-mq.space:
+		case <-mq.ctx.Done():
+			return
+		}
+	}
+}
+
+// close marks the end of the queue; queued messages can still be popped
+func (mq *messageQueue) close() {
+	mq.mu.Lock()
+	mq.closed = true
+	mq.mu.Unlock()
+	mq.signal()
+}
+
+func (mq *messageQueue) signal() {
+	select {
+	case mq.notify <- struct{}{}:
+	default:
+	}
+}
+
+// pop removes the oldest message, blocking until one is available. It returns
+// false once the queue is closed and empty or ctx is done.
+func (mq *messageQueue) pop(ctx context.Context) (types.Message, bool) {
+	for {
+		mq.mu.Lock()
+		if len(mq.messages) > 0 {
+			msg := mq.messages[0]
+			mq.messages[0] = nil
+			mq.messages = mq.messages[1:]
+			mq.mu.Unlock()
+
+			select {
+			case mq.space <- struct{}{}:
+			default:
+			}
+			return msg, true
+		}
+		closed := mq.closed
+		mq.mu.Unlock()
+
+		if closed {
+			return nil, false
+		}
+		select {
+		case <-mq.notify:
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+// failPendingResponses unblocks control requests still waiting for a response
+func (q *Query) failPendingResponses() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for requestID, responseChan := range q.pendingResponses {
+		err := types.NewControlProtocolError("transport closed before control response "+requestID, nil)
+		err.Kind = types.ErrClosed
+		responseChan <- controlResult{err: err}
+		delete(q.pendingResponses, requestID)
+	}
+}
+
+// failInitialize fails a pending initialize request with err. The CLI reports
+// some failures, such as resuming an unknown session, with an error result
+// instead of answering initialize, which would otherwise wait for the control
+// timeout.
+func (q *Query) failInitialize(err error) {
+	q.mu.Lock()
+	responseChan, ok := q.pendingResponses[q.initializeID]
+	if ok {
+		delete(q.pendingResponses, q.initializeID)
+	}
+	q.mu.Unlock()
+
+	if ok {
+		responseChan <- controlResult{err: err}
+	}
+}
+
+// handleControlResponse delivers a control response to the waiting request
+func (q *Query) handleControlResponse(msg *types.SDKControlResponse) {
+	response, ok := msg.Response.(map[string]any)
+	if !ok {
+		return
+	}
+
+	requestID, _ := response["request_id"].(string)
+
+	q.mu.Lock()
+	responseChan, ok := q.pendingResponses[requestID]
+	if ok {
+		delete(q.pendingResponses, requestID)
+	}
+	q.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	if subtype, _ := response["subtype"].(string); subtype == types.ControlResponseTypeError {
+		errorMsg, _ := response["error"].(string)
+		responseChan <- controlResult{err: types.NewControlProtocolError(errorMsg, nil)}
+		return
+	}
+
+	payload, _ := response["response"].(map[string]any)
+	responseChan <- controlResult{response: payload}
+}
+
+// handleControlRequest answers a control request sent by the CLI
+func (q *Query) handleControlRequest(ctx context.Context, msg *types.SDKControlRequest) {
+	request, _ := msg.Request.(map[string]any)
+	subtype, _ := request["subtype"].(string)
+
+	ctx, span := types.StartSpan(ctx, q.options.Tracer, types.SpanControlRequest, map[string]string{
+		"subtype":    subtype,
+		"direction":  "inbound",
+		"request_id": msg.ID,
+	})
+	defer span.End()
+
+	// Callbacks get a context that is cancelled with the session, on Close or
+	// by Interrupt
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	stop := context.AfterFunc(q.ctx, func() { cancel(nil) })
+	defer stop()
+	defer q.trackInFlight(msg.ID, cancel)()
+
+	var response types.ControlResponse
+	status := types.ControlResponseTypeSuccess
+	if result, err := q.dispatchControlRequest(ctx, msg); err != nil {
+		response = types.NewErrorResponse(msg.ID, err.Error())
+		status = types.ControlResponseTypeError
+		span.RecordError(err)
+	} else {
+		response = types.NewSuccessResponse(msg.ID, result)
+	}
+
+	if q.options.Metrics != nil {
+		q.options.Metrics.IncCounter(types.MetricControlRequestsHandled, map[string]string{
+			"subtype": subtype,
+			"status":  status,
+		})
+	}
+
+	data, err := types.MarshalControlResponse(response)
+	if err != nil {
+		q.transport.OnError(err)
+		return
+	}
+
+	if err := q.transport.Write(q.ctx, string(data)); err != nil {
+		q.transport.OnError(err)
+	}
+}
+
+// trackInFlight records cancel as cancelling the callbacks of control request
+// id until the returned function is called
+func (q *Query) trackInFlight(id string, cancel context.CancelCauseFunc) func() {
+	q.mu.Lock()
+	q.inFlight[id] = cancel
+	q.mu.Unlock()
+
+	return func() {
+		q.mu.Lock()
+		delete(q.inFlight, id)
+		q.mu.Unlock()
+	}
+}
+
+// cancelInFlight cancels the callbacks of all control requests being handled
+func (q *Query) cancelInFlight(cause error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, cancel := range q.inFlight {
+		cancel(cause)
+	}
+}
+
+// dispatchControlRequest routes a control request to the matching handler
+func (q *Query) dispatchControlRequest(ctx context.Context, msg *types.SDKControlRequest) (map[string]any, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, types.NewJSONDecodeError("failed to encode control request", err)
+	}
+
+	request, err := types.UnmarshalControlRequest(data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch r := request.(type) {
+	case *types.PermissionRequestWrapper:
+		return q.handlePermissionRequest(ctx, r.Request())
+	case *types.HookCallbackRequestWrapper:
+		return q.handleHookCallback(ctx, r.Request())
+	case *types.MCPMessageRequestWrapper:
+		return q.handleMCPMessage(ctx, r.Request())
+	default:
+		return nil, types.NewControlProtocolError("unsupported control request subtype: "+request.Type(), nil)
+	}
+}
+
+// handlePermissionRequest invokes the CanUseTool callback
+func (q *Query) handlePermissionRequest(ctx context.Context, req *types.PermissionRequest) (map[string]any, error) {
+	if req.ToolName == types.ToolNameExitPlanMode && q.options.PlanApproval != nil {
+		return invokeCallback("PlanApproval", func() (map[string]any, error) {
+			return permissionResponse(q.reviewPlan(req.Input), req.Input)
+		})
+	}
+	if q.options.CanUseTool == nil {
+		return nil, types.NewControlProtocolError("canUseTool callback is not provided", nil)
+	}
+
+	permissionContext := &types.ToolPermissionContext{
+		Suggestions:      req.PermissionSuggestions,
+		SuggestedUpdates: types.ParsePermissionUpdates(req.PermissionSuggestions),
+		BlockedPath:      req.BlockedPath,
+		Signal:           ctx,
+	}
+	if dir, ok := q.untrustedDir(req); ok {
+		permissionContext.UntrustedDir = &dir
+	}
+
+	return invokeCallback("CanUseTool", func() (map[string]any, error) {
+		result, err := q.options.CanUseTool(req.ToolName, req.Input, permissionContext)
+		if err != nil {
+			return nil, err
+		}
+		return permissionResponse(result, req.Input)
+	})
+}
+
+// reviewPlan asks the PlanApproval callback about the plan of an ExitPlanMode
+// request. A rejected plan is denied without interrupting, so Claude stays in
+// plan mode and can revise it.
+func (q *Query) reviewPlan(input map[string]any) types.PermissionResult {
+	plan, _ := input["plan"].(string)
+	if q.options.PlanApproval(plan) {
+		return types.PermissionResult{Behavior: types.PermissionBehaviorAllow}
+	}
+	return types.PermissionResult{
+		Behavior: types.PermissionBehaviorDeny,
+		Message:  "The user rejected the plan. Keep planning and present a revised plan.",
+	}
+}
+
+// untrustedDir returns the untrusted workspace directory a permission request
+// accesses, judged by its blocked path or the path arguments of file tools
+func (q *Query) untrustedDir(req *types.PermissionRequest) (string, bool) {
+	trust := q.options.WorkspaceTrust
+	if trust == nil || len(trust.Untrusted) == 0 {
+		return "", false
+	}
+
+	cwd := ""
+	if q.options.CWD != nil {
+		cwd = *q.options.CWD
+	}
+
+	paths := make([]string, 0, 4)
+	if req.BlockedPath != nil {
+		paths = append(paths, *req.BlockedPath)
+	}
+	for _, key := range []string{"file_path", "notebook_path", "path"} {
+		if path, ok := req.Input[key].(string); ok && path != "" {
+			paths = append(paths, path)
+		}
+	}
+
+	for _, path := range paths {
+		if dir, ok := trust.UntrustedDir(path, cwd); ok {
+			return dir, true
+		}
+	}
+	return "", false
+}
+
+// permissionResponse converts a PermissionResult into the control response payload
+func permissionResponse(result types.PermissionResult, input map[string]any) (map[string]any, error) {
+	if err := result.Validate(); err != nil {
+		return nil, types.NewControlProtocolError("invalid permission result", err)
+	}
+
+	switch result.Behavior {
+	case types.PermissionBehaviorAllow:
+		response := map[string]any{
+			"behavior":     types.PermissionBehaviorAllow,
+			"updatedInput": input,
+		}
+		if result.UpdatedInput != nil {
+			response["updatedInput"] = result.UpdatedInput
+		}
+		if len(result.UpdatedPermissions) > 0 {
+			response["updatedPermissions"] = result.UpdatedPermissions
+		}
+		return response, nil
+
+	case types.PermissionBehaviorDeny:
+		response := map[string]any{
+			"behavior": types.PermissionBehaviorDeny,
+			"message":  result.Message,
+		}
+		if result.Interrupt {
+			response["interrupt"] = true
+		}
+		if len(result.UpdatedPermissions) > 0 {
+			response["updatedPermissions"] = result.UpdatedPermissions
+		}
+		return response, nil
+
+	default:
+		return nil, types.NewControlProtocolError("invalid permission behavior: "+result.Behavior, nil)
+	}
+}
+
+// handleHookCallback invokes a registered hook callback
+func (q *Query) handleHookCallback(ctx context.Context, req *types.HookCallbackRequest) (map[string]any, error) {
+	q.mu.Lock()
+	registration, ok := q.hookCallbacks[req.CallbackID]
+	permissionMode := q.permissionMode
+	q.mu.Unlock()
+
+	if !ok {
+		return nil, types.NewControlProtocolError("no hook callback found for ID: "+req.CallbackID, nil)
+	}
+
+	hookContext := &types.HookContext{
+		Event:          registration.event,
+		PermissionMode: permissionMode,
+	}
+	if source, ok := q.transport.(interface{ SessionID() string }); ok {
+		hookContext.SessionID = source.SessionID()
+	}
+
+	// The hook input reported by the CLI is the most accurate source
+	if input, ok := req.Input.(map[string]any); ok {
+		if sessionID, _ := input["session_id"].(string); sessionID != "" {
+			hookContext.SessionID = sessionID
+		}
+		if mode, _ := input["permission_mode"].(string); mode != "" {
+			hookContext.PermissionMode = types.PermissionMode(mode)
+		}
+	}
+
+	return invokeCallback("hook callback "+req.CallbackID, func() (map[string]any, error) {
+		return registration.callback(ctx, req.Input, req.ToolUseID, hookContext)
+	})
+}
+
+// handleMCPMessage routes a message to an in-process MCP server
+func (q *Query) handleMCPMessage(ctx context.Context, req *types.MCPMessageRequest) (map[string]any, error) {
+	if req.ServerName == types.PermissionPromptServerName && q.options.PermissionPromptHandler != nil {
+		message, _ := req.Message.(map[string]any)
+		return map[string]any{"mcp_response": q.handlePermissionPrompt(ctx, message)}, nil
+	}
+
+	config, ok := q.options.MCPServers[req.ServerName]
+	if !ok {
+		return nil, types.NewControlProtocolError("SDK MCP server not found: "+req.ServerName, nil)
+	}
+
+	handler, ok := config.Instance.(types.MCPServerHandler)
+	if !ok {
+		return nil, types.NewControlProtocolError("MCP server is not an in-process server: "+req.ServerName, nil)
+	}
+
+	message, _ := req.Message.(map[string]any)
+	tool := toolCallName(message)
+
+	// Tool output is flushed to the callback while the handler runs; closing
+	// it on return delivers the rest before the response is sent
+	if tool != "" {
+		output := q.toolOutput(req.ServerName, tool)
+		ctx = types.ContextWithToolOutput(ctx, output)
+		defer output.Close()
+	}
+
+	handle := func(ctx context.Context) (map[string]any, error) {
+		return invokeCallback("MCP server "+req.ServerName, func() (map[string]any, error) {
+			response, err := handler.HandleMessage(ctx, message)
+			if err != nil {
+				if toolCallName(message) == "" {
+					return nil, err
+				}
+				// A failed tool call is a tool result the model should see, not a protocol error
+				response = toolErrorResponse(message, err)
+			}
+			return map[string]any{"mcp_response": response}, nil
+		})
+	}
+
+	timeout, ok := config.ToolTimeouts[tool]
+	if tool == "" || !ok {
+		return handle(ctx)
+	}
+
+	// Run the handler in the background so a slow tool cannot stall the
+	// control loop. The channel is buffered: a handler finishing after the
+	// deadline has its result dropped, so the request is only answered once.
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	done := make(chan controlResult, 1)
+	go func() {
+		response, err := handle(ctx)
+		done <- controlResult{response: response, err: err}
+	}()
+
+	select {
+	case result := <-done:
+		return result.response, result.err
+	case <-ctx.Done():
+		if cause := context.Cause(ctx); errors.Is(cause, types.ErrInterrupted) {
+			return nil, types.NewControlProtocolError(
+				fmt.Sprintf("tool %s on MCP server %s was interrupted", tool, req.ServerName),
+				cause,
+			)
+		}
+		return nil, types.NewControlProtocolError(
+			fmt.Sprintf("tool %s on MCP server %s timed out after %s", tool, req.ServerName, timeout),
+			ctx.Err(),
+		)
+	}
+}
+
+// toolOutput creates the ToolOutput for a call to tool, flushing to
+// ToolOutputCallback if it is set
+func (q *Query) toolOutput(server, tool string) *types.ToolOutput {
+	callback := q.options.ToolOutputCallback
+	if callback == nil {
+		return types.NewToolOutput(0, nil)
+	}
+
+	interval := types.DefaultToolOutputFlushInterval
+	if q.options.ToolOutputFlushInterval != nil {
+		interval = *q.options.ToolOutputFlushInterval
+	}
+	return types.NewToolOutput(interval, func(chunk string) {
+		callback(server, tool, chunk)
+	})
+}
+
+// toolErrorResponse builds the JSONRPC response reporting a failed tools/call
+func toolErrorResponse(message map[string]any, err error) map[string]any {
+	return map[string]any{
+		"jsonrpc": "2.0",
+		"id":      message["id"],
+		"result": map[string]any{
+			"content": []any{map[string]any{"type": types.ContentTypeText, "text": err.Error()}},
+			"isError": true,
+		},
+	}
+}
+
+// toolCallName returns the tool name of a JSONRPC tools/call message
+func toolCallName(message map[string]any) string {
+	if method, _ := message["method"].(string); method != "tools/call" {
+		return ""
+	}
+	params, _ := message["params"].(map[string]any)
+	name, _ := params["name"].(string)
+	return name
+}
+
+// invokeCallback runs a user callback, converting a panic into an error so a
+// buggy callback degrades into an error response instead of crashing the
+// control loop
+func invokeCallback(name string, callback func() (map[string]any, error)) (result map[string]any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(os.Stderr, "Warning: recovered from panic in %s: %v\n%s", name, r, debug.Stack())
+			result = nil
+			err = types.NewControlProtocolError(fmt.Sprintf("%s panicked: %v", name, r), nil)
+		}
+	}()
+
+	return callback()
+}
@@ -0,0 +1,1192 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anthropics/claude-agent-sdk-go/internal/types"
+)
+
+// mockTransport is an in-memory Transport that records writes and
+// automatically answers control requests sent by the SDK
+type mockTransport struct {
+	messages chan types.Message
+	writes   chan string
+
+	mu     sync.Mutex
+	errors []error
+}
+
+func newMockTransport() *mockTransport {
+	return &mockTransport{
+		messages: make(chan types.Message, 10),
+		writes:   make(chan string, 10),
+	}
+}
+
+func (m *mockTransport) Connect(ctx context.Context) error { return nil }
+func (m *mockTransport) Close(ctx context.Context) error   { return nil }
+func (m *mockTransport) IsReady() bool                     { return true }
+func (m *mockTransport) EndInput(ctx context.Context) error {
+	return nil
+}
+
+func (m *mockTransport) Write(ctx context.Context, data string) error {
+	var request types.SDKControlRequest
+	if err := json.Unmarshal([]byte(data), &request); err == nil && request.Type_ == types.ControlTypeRequest {
+		m.messages <- &types.SDKControlResponse{
+			Type_: types.ControlTypeResponse,
+			Response: map[string]any{
+				"subtype":    types.ControlResponseTypeSuccess,
+				"request_id": request.ID,
+				"response":   map[string]any{},
+			},
+		}
+		return nil
+	}
+
+	m.writes <- data
+	return nil
+}
+
+func (m *mockTransport) ReadMessages(ctx context.Context) <-chan types.Message {
+	return m.messages
+}
+
+func (m *mockTransport) OnError(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errors = append(m.errors, err)
+}
+
+// sendControlRequest pushes a control request from the "CLI" and returns the SDK's response
+func (m *mockTransport) sendControlRequest(t *testing.T, requestID string, request map[string]any) map[string]any {
+	t.Helper()
+
+	m.messages <- &types.SDKControlRequest{
+		Type_:   types.ControlTypeRequest,
+		ID:      requestID,
+		Request: request,
+	}
+
+	select {
+	case data := <-m.writes:
+		var wrapper struct {
+			Response map[string]any `json:"response"`
+		}
+		if err := json.Unmarshal([]byte(data), &wrapper); err != nil {
+			t.Fatalf("Failed to decode control response: %v", err)
+		}
+		return wrapper.Response
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for control response")
+		return nil
+	}
+}
+
+// assertMessageDelivered checks that the control loop still forwards regular messages
+func assertMessageDelivered(t *testing.T, m *mockTransport, q *Query) {
+	t.Helper()
+
+	m.messages <- &types.ResultMessage{Subtype: "success"}
+	select {
+	case msg := <-q.Messages():
+		if msg.Type() != types.MessageTypeResult {
+			t.Errorf("Expected result message, got %s", msg.Type())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Control loop stopped delivering messages")
+	}
+}
+
+// unresponsiveTransport accepts writes but never answers control requests
+type unresponsiveTransport struct {
+	*mockTransport
+}
+
+func (u *unresponsiveTransport) Write(ctx context.Context, data string) error {
+	return nil
+}
+
+func TestQuery_ControlTimeout(t *testing.T) {
+	options := types.NewClaudeAgentOptions().WithControlTimeout(50 * time.Millisecond)
+
+	q := New(&unresponsiveTransport{newMockTransport()}, options)
+	q.Start(context.Background())
+	defer q.Close()
+
+	start := time.Now()
+	err := q.Interrupt(context.Background())
+
+	var protocolErr *types.ControlProtocolError
+	if !errors.As(err, &protocolErr) {
+		t.Fatalf("Expected ControlProtocolError, got %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected error to wrap context.DeadlineExceeded, got %v", err)
+	}
+	if !errors.Is(err, types.ErrControlTimeout) {
+		t.Errorf("Expected error to match ErrControlTimeout, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "timed out waiting for interrupt control response") {
+		t.Errorf("Unexpected error message: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Interrupt took %v, expected the control timeout to apply", elapsed)
+	}
+}
+
+func TestQuery_ControlTimeoutCallerDeadline(t *testing.T) {
+	// The caller's deadline takes precedence over the configured timeout
+	options := types.NewClaudeAgentOptions().WithControlTimeout(time.Hour)
+
+	q := New(&unresponsiveTransport{newMockTransport()}, options)
+	q.Start(context.Background())
+	defer q.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := q.Interrupt(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestQuery_CanUseTool(t *testing.T) {
+	options := types.NewClaudeAgentOptions().
+		WithCanUseTool(func(tool string, input map[string]any, ctx interface{}) (types.PermissionResult, error) {
+			if tool == "Read" {
+				return types.PermissionResult{Behavior: "allow"}, nil
+			}
+			return types.PermissionResult{Behavior: "deny", Message: "not allowed"}, nil
+		})
+
+	m := newMockTransport()
+	q := New(m, options)
+	q.Start(context.Background())
+	defer q.Close()
+
+	response := m.sendControlRequest(t, "req_1", map[string]any{
+		"subtype":   types.SubtypeCanUseTool,
+		"tool_name": "Read",
+		"input":     map[string]any{"file_path": "README.md"},
+	})
+	payload, _ := response["response"].(map[string]any)
+	if payload["behavior"] != "allow" {
+		t.Errorf("Expected allow behavior, got %v", response)
+	}
+
+	response = m.sendControlRequest(t, "req_2", map[string]any{
+		"subtype":   types.SubtypeCanUseTool,
+		"tool_name": "Bash",
+		"input":     map[string]any{"command": "rm -rf /"},
+	})
+	payload, _ = response["response"].(map[string]any)
+	if payload["behavior"] != "deny" || payload["message"] != "not allowed" {
+		t.Errorf("Expected deny behavior, got %v", response)
+	}
+}
+
+func TestQuery_PlanApproval(t *testing.T) {
+	var plans []string
+	var canUseToolCalls []string
+	options := types.NewClaudeAgentOptions().
+		WithPlanApproval(func(plan string) bool {
+			plans = append(plans, plan)
+			return strings.Contains(plan, "tests")
+		}).
+		WithCanUseTool(func(tool string, input map[string]any, ctx interface{}) (types.PermissionResult, error) {
+			canUseToolCalls = append(canUseToolCalls, tool)
+			return types.PermissionResult{Behavior: types.PermissionBehaviorAllow}, nil
+		})
+
+	m := newMockTransport()
+	q := New(m, options)
+	q.Start(context.Background())
+	defer q.Close()
+
+	tests := []struct {
+		name         string
+		plan         string
+		wantBehavior string
+	}{
+		{name: "approved", plan: "1. Fix the bug\n2. Add tests", wantBehavior: types.PermissionBehaviorAllow},
+		{name: "rejected", plan: "1. Rewrite everything", wantBehavior: types.PermissionBehaviorDeny},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := map[string]any{"plan": tt.plan}
+			response := m.sendControlRequest(t, fmt.Sprintf("req_%d", i), map[string]any{
+				"subtype":   types.SubtypeCanUseTool,
+				"tool_name": types.ToolNameExitPlanMode,
+				"input":     input,
+			})
+			payload, _ := response["response"].(map[string]any)
+			if payload["behavior"] != tt.wantBehavior {
+				t.Fatalf("Expected %s behavior, got %v", tt.wantBehavior, response)
+			}
+			if tt.wantBehavior == types.PermissionBehaviorAllow && !reflect.DeepEqual(payload["updatedInput"], input) {
+				t.Errorf("Expected the plan input to be passed through, got %v", payload["updatedInput"])
+			}
+			if tt.wantBehavior == types.PermissionBehaviorDeny && (payload["message"] == "" || payload["interrupt"] == true) {
+				t.Errorf("Expected a rejection message without interrupt, got %v", payload)
+			}
+		})
+	}
+
+	if !reflect.DeepEqual(plans, []string{tests[0].plan, tests[1].plan}) {
+		t.Errorf("PlanApproval received %q", plans)
+	}
+
+	// Other tools still go to CanUseTool
+	m.sendControlRequest(t, "req_bash", map[string]any{
+		"subtype":   types.SubtypeCanUseTool,
+		"tool_name": "Bash",
+		"input":     map[string]any{"command": "ls"},
+	})
+	if !reflect.DeepEqual(canUseToolCalls, []string{"Bash"}) {
+		t.Errorf("CanUseTool received %v, want only Bash", canUseToolCalls)
+	}
+}
+
+func TestQuery_PlanApprovalWithoutCanUseTool(t *testing.T) {
+	options := types.NewClaudeAgentOptions().
+		WithPlanApproval(func(plan string) bool { return true })
+
+	m := newMockTransport()
+	q := New(m, options)
+	q.Start(context.Background())
+	defer q.Close()
+
+	response := m.sendControlRequest(t, "req_1", map[string]any{
+		"subtype":   types.SubtypeCanUseTool,
+		"tool_name": types.ToolNameExitPlanMode,
+		"input":     map[string]any{"plan": "Ship it"},
+	})
+	payload, _ := response["response"].(map[string]any)
+	if payload["behavior"] != types.PermissionBehaviorAllow {
+		t.Errorf("Expected allow behavior, got %v", response)
+	}
+}
+
+func TestQuery_InterruptCancelsCallbacks(t *testing.T) {
+	started := make(chan struct{})
+	causes := make(chan error, 1)
+	options := types.NewClaudeAgentOptions().
+		WithCanUseTool(func(tool string, input map[string]any, ctx interface{}) (types.PermissionResult, error) {
+			signal := ctx.(*types.ToolPermissionContext).Signal
+			close(started)
+			<-signal.Done()
+			causes <- context.Cause(signal)
+			return types.PermissionResult{}, signal.Err()
+		})
+
+	m := newMockTransport()
+	q := New(m, options)
+	q.Start(context.Background())
+	defer q.Close()
+
+	m.messages <- &types.SDKControlRequest{
+		Type_:   types.ControlTypeRequest,
+		ID:      "req_slow",
+		Request: map[string]any{"subtype": types.SubtypeCanUseTool, "tool_name": "Bash", "input": map[string]any{}},
+	}
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for CanUseTool to start")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := q.Interrupt(ctx); err != nil {
+		t.Fatalf("Interrupt() error = %v", err)
+	}
+
+	if cause := <-causes; !errors.Is(cause, types.ErrInterrupted) {
+		t.Errorf("Callback context cause = %v, want ErrInterrupted", cause)
+	}
+	select {
+	case data := <-m.writes:
+		if !strings.Contains(data, `"subtype":"error"`) || !strings.Contains(data, "req_slow") {
+			t.Errorf("Expected an error response to req_slow, got %s", data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for the interrupted request to be answered")
+	}
+}
+
+func TestQuery_CanUseToolBlockedPath(t *testing.T) {
+	var gotPath *string
+	options := types.NewClaudeAgentOptions().
+		WithCanUseTool(func(tool string, input map[string]any, ctx interface{}) (types.PermissionResult, error) {
+			permissionContext, ok := ctx.(*types.ToolPermissionContext)
+			if !ok {
+				t.Errorf("Expected *types.ToolPermissionContext, got %T", ctx)
+				return types.PermissionResult{Behavior: "deny"}, nil
+			}
+			gotPath = permissionContext.BlockedPath
+			return types.PermissionResult{
+				Behavior: "allow",
+				UpdatedPermissions: []types.PermissionUpdate{{
+					Type:        types.PermissionUpdateTypeAddDirectories,
+					Directories: []string{"/outside"},
+					Destination: "session",
+				}},
+			}, nil
+		})
+
+	m := newMockTransport()
+	q := New(m, options)
+	q.Start(context.Background())
+	defer q.Close()
+
+	response := m.sendControlRequest(t, "req_1", map[string]any{
+		"subtype":      types.SubtypeCanUseTool,
+		"tool_name":    "Read",
+		"input":        map[string]any{"file_path": "/outside/notes.txt"},
+		"blocked_path": "/outside/notes.txt",
+	})
+
+	if gotPath == nil || *gotPath != "/outside/notes.txt" {
+		t.Errorf("Expected blocked path to reach the callback, got %v", gotPath)
+	}
+
+	payload, _ := response["response"].(map[string]any)
+	updates, _ := payload["updatedPermissions"].([]any)
+	if len(updates) != 1 {
+		t.Fatalf("Expected one permission update, got %v", payload)
+	}
+	update, _ := updates[0].(map[string]any)
+	if update["type"] != types.PermissionUpdateTypeAddDirectories {
+		t.Errorf("Expected addDirectories update, got %v", update)
+	}
+}
+
+func TestQuery_CanUseToolSuggestedUpdates(t *testing.T) {
+	var gotSuggestions []types.PermissionUpdate
+	options := types.NewClaudeAgentOptions().
+		WithCanUseTool(func(tool string, input map[string]any, ctx interface{}) (types.PermissionResult, error) {
+			permissionContext, _ := ctx.(*types.ToolPermissionContext)
+			gotSuggestions = permissionContext.SuggestedUpdates
+			return types.PermissionResult{Behavior: "allow"}.WithUpdates(permissionContext.SuggestedUpdates...), nil
+		})
+
+	m := newMockTransport()
+	q := New(m, options)
+	q.Start(context.Background())
+	defer q.Close()
+
+	response := m.sendControlRequest(t, "req_1", map[string]any{
+		"subtype":   types.SubtypeCanUseTool,
+		"tool_name": "Bash",
+		"input":     map[string]any{"command": "npm test"},
+		"permission_suggestions": []any{map[string]any{
+			"type":        "addRules",
+			"rules":       []any{map[string]any{"toolName": "Bash", "ruleContent": "npm test"}},
+			"behavior":    "allow",
+			"destination": "localSettings",
+		}},
+	})
+
+	if len(gotSuggestions) != 1 || gotSuggestions[0].Rules[0].RuleContent != "npm test" {
+		t.Fatalf("Expected the suggested rule to reach the callback, got %+v", gotSuggestions)
+	}
+
+	payload, _ := response["response"].(map[string]any)
+	updates, _ := payload["updatedPermissions"].([]any)
+	if len(updates) != 1 {
+		t.Fatalf("Expected the accepted suggestion as a permission update, got %v", payload)
+	}
+	if update, _ := updates[0].(map[string]any); update["destination"] != types.PermissionDestinationLocalSettings {
+		t.Errorf("Expected the suggestion's destination to be kept, got %v", update)
+	}
+}
+
+func TestQuery_CanUseToolDenyRule(t *testing.T) {
+	options := types.NewClaudeAgentOptions().
+		WithCanUseTool(func(tool string, input map[string]any, ctx interface{}) (types.PermissionResult, error) {
+			return types.PermissionResult{Behavior: "deny", Message: "never"}.AddRule("Bash", "rm:*"), nil
+		})
+
+	m := newMockTransport()
+	q := New(m, options)
+	q.Start(context.Background())
+	defer q.Close()
+
+	response := m.sendControlRequest(t, "req_1", map[string]any{
+		"subtype":   types.SubtypeCanUseTool,
+		"tool_name": "Bash",
+		"input":     map[string]any{"command": "rm -rf /tmp/x"},
+	})
+
+	payload, _ := response["response"].(map[string]any)
+	if payload["behavior"] != types.PermissionBehaviorDeny {
+		t.Fatalf("Expected a deny response, got %v", response)
+	}
+	updates, _ := payload["updatedPermissions"].([]any)
+	if len(updates) != 1 {
+		t.Fatalf("Expected the deny rule as a permission update, got %v", payload)
+	}
+	if update, _ := updates[0].(map[string]any); update["behavior"] != types.PermissionBehaviorDeny {
+		t.Errorf("Expected a rule that denies, got %v", update)
+	}
+}
+
+func TestQuery_CanUseToolUntrustedDir(t *testing.T) {
+	var gotDirs []*string
+	options := types.NewClaudeAgentOptions().
+		WithCWD("/repo").
+		WithWorkspaceTrust(types.WorkspaceTrust{Trusted: []string{"/repo"}, Untrusted: []string{"/repo/vendor"}}).
+		WithCanUseTool(func(tool string, input map[string]any, ctx interface{}) (types.PermissionResult, error) {
+			gotDirs = append(gotDirs, ctx.(*types.ToolPermissionContext).UntrustedDir)
+			return types.PermissionResult{Behavior: "deny"}, nil
+		})
+
+	m := newMockTransport()
+	q := New(m, options)
+	q.Start(context.Background())
+	defer q.Close()
+
+	m.sendControlRequest(t, "req_1", map[string]any{
+		"subtype":   types.SubtypeCanUseTool,
+		"tool_name": "Edit",
+		"input":     map[string]any{"file_path": "vendor/lib/a.go"},
+	})
+	m.sendControlRequest(t, "req_2", map[string]any{
+		"subtype":   types.SubtypeCanUseTool,
+		"tool_name": "Edit",
+		"input":     map[string]any{"file_path": "/repo/main.go"},
+	})
+
+	if len(gotDirs) != 2 {
+		t.Fatalf("Expected 2 permission checks, got %d", len(gotDirs))
+	}
+	if gotDirs[0] == nil || *gotDirs[0] != "/repo/vendor" {
+		t.Errorf("Expected untrusted dir /repo/vendor for vendored file, got %v", gotDirs[0])
+	}
+	if gotDirs[1] != nil {
+		t.Errorf("Expected no untrusted dir for trusted file, got %q", *gotDirs[1])
+	}
+}
+
+func TestQuery_CanUseToolPanicRecovered(t *testing.T) {
+	options := types.NewClaudeAgentOptions().
+		WithCanUseTool(func(tool string, input map[string]any, ctx interface{}) (types.PermissionResult, error) {
+			panic("boom")
+		})
+
+	m := newMockTransport()
+	q := New(m, options)
+	q.Start(context.Background())
+	defer q.Close()
+
+	response := m.sendControlRequest(t, "req_1", map[string]any{
+		"subtype":   types.SubtypeCanUseTool,
+		"tool_name": "Bash",
+		"input":     map[string]any{},
+	})
+
+	if response["subtype"] != types.ControlResponseTypeError {
+		t.Errorf("Expected error response, got %v", response)
+	}
+	if errMsg, _ := response["error"].(string); !strings.Contains(errMsg, "panicked: boom") {
+		t.Errorf("Expected panic in error message, got %q", errMsg)
+	}
+
+	assertMessageDelivered(t, m, q)
+}
+
+func TestQuery_HookCallbackPanicRecovered(t *testing.T) {
+	hook := func(ctx context.Context, input interface{}, toolUseID *string, hookContext *types.HookContext) (map[string]interface{}, error) {
+		panic("hook failure")
+	}
+	options := types.NewClaudeAgentOptions().
+		WithHook(types.HookEventPreToolUse, types.HookMatcher{Matcher: "Bash", Hooks: []types.HookFunc{hook}})
+
+	m := newMockTransport()
+	q := New(m, options)
+	q.Start(context.Background())
+	defer q.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := q.Initialize(ctx); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+
+	response := m.sendControlRequest(t, "req_1", map[string]any{
+		"subtype":     types.SubtypeHookCallback,
+		"callback_id": "hook_0",
+		"input":       map[string]any{},
+	})
+
+	if response["subtype"] != types.ControlResponseTypeError {
+		t.Errorf("Expected error response, got %v", response)
+	}
+	if errMsg, _ := response["error"].(string); !strings.Contains(errMsg, "panicked: hook failure") {
+		t.Errorf("Expected panic in error message, got %q", errMsg)
+	}
+
+	assertMessageDelivered(t, m, q)
+}
+
+// panickingMCPServer is an in-process MCP server whose handler panics
+type panickingMCPServer struct{}
+
+func (s *panickingMCPServer) HandleMessage(ctx context.Context, message map[string]any) (map[string]any, error) {
+	panic("tool crashed")
+}
+
+func TestQuery_MCPHandlerPanicRecovered(t *testing.T) {
+	options := types.NewClaudeAgentOptions().
+		WithMCPServer("tools", &types.MCPServerConfig{Type: "sdk", Name: "tools", Instance: &panickingMCPServer{}})
+
+	m := newMockTransport()
+	q := New(m, options)
+	q.Start(context.Background())
+	defer q.Close()
+
+	response := m.sendControlRequest(t, "req_1", map[string]any{
+		"subtype":     types.SubtypeMCPMessage,
+		"server_name": "tools",
+		"message":     map[string]any{"jsonrpc": "2.0", "id": 1, "method": "tools/call"},
+	})
+
+	if response["subtype"] != types.ControlResponseTypeError {
+		t.Errorf("Expected error response, got %v", response)
+	}
+
+	assertMessageDelivered(t, m, q)
+}
+
+func TestQuery_PermissionPromptHandler(t *testing.T) {
+	var gotTool string
+	options := types.NewClaudeAgentOptions().
+		WithPermissionPromptHandler(func(tool string, input map[string]any, ctx interface{}) (types.PermissionResult, error) {
+			gotTool = tool
+			if _, ok := ctx.(*types.ToolPermissionContext); !ok {
+				t.Errorf("Expected *types.ToolPermissionContext, got %T", ctx)
+			}
+			if input["command"] == "rm -rf /" {
+				return types.PermissionResult{Behavior: types.PermissionBehaviorDeny, Message: "destructive"}, nil
+			}
+			return types.PermissionResult{Behavior: types.PermissionBehaviorAllow}, nil
+		})
+
+	m := newMockTransport()
+	q := New(m, options)
+	q.Start(context.Background())
+	defer q.Close()
+
+	mcpResponse := func(requestID string, message map[string]any) map[string]any {
+		t.Helper()
+		response := m.sendControlRequest(t, requestID, map[string]any{
+			"subtype":     types.SubtypeMCPMessage,
+			"server_name": types.PermissionPromptServerName,
+			"message":     message,
+		})
+		payload, _ := response["response"].(map[string]any)
+		result, _ := payload["mcp_response"].(map[string]any)
+		return result
+	}
+
+	list := mcpResponse("req_1", map[string]any{"jsonrpc": "2.0", "id": 1, "method": "tools/list"})
+	if !strings.Contains(fmt.Sprint(list["result"]), "name:"+types.PermissionPromptTool) {
+		t.Errorf("Expected tools/list to include %q, got %v", types.PermissionPromptTool, list)
+	}
+
+	tests := []struct {
+		name    string
+		command string
+		want    map[string]any
+	}{
+		{
+			name:    "allow",
+			command: "ls",
+			want:    map[string]any{"behavior": "allow", "updatedInput": map[string]any{"command": "ls"}},
+		},
+		{
+			name:    "deny",
+			command: "rm -rf /",
+			want:    map[string]any{"behavior": "deny", "message": "destructive"},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			call := mcpResponse(fmt.Sprintf("req_call_%d", i), map[string]any{
+				"jsonrpc": "2.0",
+				"id":      i + 2,
+				"method":  "tools/call",
+				"params": map[string]any{
+					"name":      types.PermissionPromptTool,
+					"arguments": map[string]any{"tool_name": "Bash", "input": map[string]any{"command": tt.command}, "tool_use_id": "toolu_1"},
+				},
+			})
+
+			result, _ := call["result"].(map[string]any)
+			content, _ := result["content"].([]any)
+			if len(content) != 1 {
+				t.Fatalf("Expected one content block, got %v", call)
+			}
+			text, _ := content[0].(map[string]any)["text"].(string)
+			var got map[string]any
+			if err := json.Unmarshal([]byte(text), &got); err != nil {
+				t.Fatalf("Expected JSON decision, got %q: %v", text, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decision = %v, want %v", got, tt.want)
+			}
+			if gotTool != "Bash" {
+				t.Errorf("Handler received tool %q, want Bash", gotTool)
+			}
+		})
+	}
+}
+
+// orderingTransport records the control responses written by the SDK
+type orderingTransport struct {
+	*mockTransport
+
+	mu        sync.Mutex
+	responses []string
+}
+
+func (o *orderingTransport) Write(ctx context.Context, data string) error {
+	var response types.SDKControlResponse
+	if err := json.Unmarshal([]byte(data), &response); err == nil && response.Type_ == types.ControlTypeResponse {
+		payload, _ := response.Response.(map[string]any)
+		requestID, _ := payload["request_id"].(string)
+		o.mu.Lock()
+		o.responses = append(o.responses, requestID)
+		o.mu.Unlock()
+		return nil
+	}
+	return o.mockTransport.Write(ctx, data)
+}
+
+func (o *orderingTransport) answered(requestID string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, id := range o.responses {
+		if id == requestID {
+			return true
+		}
+	}
+	return false
+}
+
+func TestQuery_MessageOrdering(t *testing.T) {
+	options := types.NewClaudeAgentOptions().
+		WithCanUseTool(func(tool string, input map[string]any, ctx interface{}) (types.PermissionResult, error) {
+			return types.PermissionResult{Behavior: types.PermissionBehaviorAllow}, nil
+		})
+
+	const count = 50
+	o := &orderingTransport{mockTransport: newMockTransport()}
+	q := New(o, options)
+	q.Start(context.Background())
+	defer q.Close()
+
+	// The CLI interleaves permission requests with regular messages
+	go func() {
+		for i := 0; i < count; i++ {
+			o.messages <- &types.SDKControlRequest{
+				Type_:   types.ControlTypeRequest,
+				ID:      fmt.Sprintf("req_cli_%d", i),
+				Request: map[string]any{"subtype": types.SubtypeCanUseTool, "tool_name": "Read", "input": map[string]any{}},
+			}
+			o.messages <- &types.SystemMessage{Subtype: fmt.Sprint(i)}
+		}
+	}()
+
+	// Meanwhile the SDK sends its own control requests
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	interrupts := make(chan error, 1)
+	go func() {
+		for i := 0; i < count; i++ {
+			if err := q.Interrupt(ctx); err != nil {
+				interrupts <- err
+				return
+			}
+		}
+		interrupts <- nil
+	}()
+
+	for i := 0; i < count; i++ {
+		select {
+		case msg := <-q.Messages():
+			system, ok := msg.(*types.SystemMessage)
+			if !ok || system.Subtype != fmt.Sprint(i) {
+				t.Fatalf("Message %d out of order: %+v", i, msg)
+			}
+			// A control request is answered before any later message is delivered
+			if !o.answered(fmt.Sprintf("req_cli_%d", i)) {
+				t.Fatalf("Message %d delivered before req_cli_%d was answered", i, i)
+			}
+		case <-ctx.Done():
+			t.Fatalf("Timeout waiting for message %d", i)
+		}
+	}
+
+	if err := <-interrupts; err != nil {
+		t.Errorf("Interrupt() error = %v", err)
+	}
+}
+
+func TestQuery_ControlRequestNotBlockedBySlowConsumer(t *testing.T) {
+	options := types.NewClaudeAgentOptions().
+		WithCanUseTool(func(tool string, input map[string]any, ctx interface{}) (types.PermissionResult, error) {
+			return types.PermissionResult{Behavior: types.PermissionBehaviorAllow}, nil
+		})
+
+	m := newMockTransport()
+	q := New(m, options)
+	q.Start(context.Background())
+	defer q.Close()
+
+	// More messages than the consumer channel holds, none of them read yet
+	const count = 500
+	go func() {
+		for i := 0; i < count; i++ {
+			m.messages <- &types.SystemMessage{Subtype: fmt.Sprint(i)}
+		}
+		m.messages <- &types.SDKControlRequest{
+			Type_:   types.ControlTypeRequest,
+			ID:      "req_cli_1",
+			Request: map[string]any{"subtype": types.SubtypeCanUseTool, "tool_name": "Read", "input": map[string]any{}},
+		}
+	}()
+
+	select {
+	case data := <-m.writes:
+		if !strings.Contains(data, "req_cli_1") {
+			t.Errorf("Expected response to req_cli_1, got %s", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Control request was not answered while the consumer was not reading")
+	}
+
+	for i := 0; i < count; i++ {
+		msg := <-q.Messages()
+		if system, ok := msg.(*types.SystemMessage); !ok || system.Subtype != fmt.Sprint(i) {
+			t.Fatalf("Message %d out of order: %+v", i, msg)
+		}
+	}
+}
+
+func TestQuery_MaxQueuedMessagesBlocksReader(t *testing.T) {
+	options := types.NewClaudeAgentOptions().
+		WithMessageChannelBuffer(0).
+		WithMaxQueuedMessages(5)
+
+	m := newMockTransport()
+	q := New(m, options)
+	q.Start(context.Background())
+	defer q.Close()
+
+	const count = 100
+	var sent atomic.Int32
+	go func() {
+		for i := 0; i < count; i++ {
+			m.messages <- &types.SystemMessage{Subtype: fmt.Sprint(i)}
+			sent.Add(1)
+		}
+	}()
+
+	// The reader stops once the queue is full, leaving the rest unread
+	time.Sleep(200 * time.Millisecond)
+	if n := sent.Load(); n >= count/2 {
+		t.Errorf("Expected the reader to stop at the queue limit, but %d messages were read", n)
+	}
+
+	for i := 0; i < count; i++ {
+		select {
+		case msg := <-q.Messages():
+			if system, ok := msg.(*types.SystemMessage); !ok || system.Subtype != fmt.Sprint(i) {
+				t.Fatalf("Message %d out of order: %+v", i, msg)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timeout waiting for message %d", i)
+		}
+	}
+}
+
+// failingMCPServer is an in-process MCP server whose handler returns an error
+type failingMCPServer struct{}
+
+func (s *failingMCPServer) HandleMessage(ctx context.Context, message map[string]any) (map[string]any, error) {
+	return nil, errors.New("disk full")
+}
+
+func TestQuery_MCPToolErrorIsToolResult(t *testing.T) {
+	options := types.NewClaudeAgentOptions().
+		WithMCPServer("tools", &types.MCPServerConfig{Type: "sdk", Name: "tools", Instance: &failingMCPServer{}})
+
+	m := newMockTransport()
+	q := New(m, options)
+	q.Start(context.Background())
+	defer q.Close()
+
+	response := m.sendControlRequest(t, "req_1", map[string]any{
+		"subtype":     types.SubtypeMCPMessage,
+		"server_name": "tools",
+		"message": map[string]any{
+			"jsonrpc": "2.0",
+			"id":      7,
+			"method":  "tools/call",
+			"params":  map[string]any{"name": "save"},
+		},
+	})
+
+	if response["subtype"] != types.ControlResponseTypeSuccess {
+		t.Fatalf("Expected success response carrying the tool error, got %v", response)
+	}
+	payload, _ := response["response"].(map[string]any)
+	mcpResponse, _ := payload["mcp_response"].(map[string]any)
+	result, _ := mcpResponse["result"].(map[string]any)
+	if result["isError"] != true || mcpResponse["id"] != float64(7) {
+		t.Errorf("Expected isError tool result for request 7, got %v", mcpResponse)
+	}
+	if !strings.Contains(fmt.Sprint(result["content"]), "disk full") {
+		t.Errorf("Expected error text in content, got %v", result["content"])
+	}
+
+	// Non-tool messages still fail as protocol errors
+	response = m.sendControlRequest(t, "req_2", map[string]any{
+		"subtype":     types.SubtypeMCPMessage,
+		"server_name": "tools",
+		"message":     map[string]any{"jsonrpc": "2.0", "id": 8, "method": "tools/list"},
+	})
+	if response["subtype"] != types.ControlResponseTypeError {
+		t.Errorf("Expected error response for tools/list, got %v", response)
+	}
+}
+
+// slowMCPServer is an in-process MCP server whose handler ignores cancellation
+// for a while before answering
+type slowMCPServer struct {
+	delay     time.Duration
+	cancelled chan error
+	finished  chan struct{}
+}
+
+func (s *slowMCPServer) HandleMessage(ctx context.Context, message map[string]any) (map[string]any, error) {
+	<-ctx.Done()
+	s.cancelled <- ctx.Err()
+	time.Sleep(s.delay)
+	close(s.finished)
+	return map[string]any{"jsonrpc": "2.0", "id": message["id"], "result": map[string]any{}}, nil
+}
+
+func TestQuery_MCPToolTimeout(t *testing.T) {
+	server := &slowMCPServer{
+		delay:     100 * time.Millisecond,
+		cancelled: make(chan error, 1),
+		finished:  make(chan struct{}),
+	}
+	config := (&types.MCPServerConfig{Type: "sdk", Name: "tools", Instance: server}).
+		WithToolTimeout("slow", 50*time.Millisecond)
+	options := types.NewClaudeAgentOptions().WithMCPServer("tools", config)
+
+	m := newMockTransport()
+	q := New(m, options)
+	q.Start(context.Background())
+	defer q.Close()
+
+	response := m.sendControlRequest(t, "req_1", map[string]any{
+		"subtype":     types.SubtypeMCPMessage,
+		"server_name": "tools",
+		"message": map[string]any{
+			"jsonrpc": "2.0",
+			"id":      1,
+			"method":  "tools/call",
+			"params":  map[string]any{"name": "slow", "arguments": map[string]any{}},
+		},
+	})
+
+	if response["subtype"] != types.ControlResponseTypeError {
+		t.Fatalf("Expected error response, got %v", response)
+	}
+	if errMsg, _ := response["error"].(string); !strings.Contains(errMsg, "timed out") {
+		t.Errorf("Expected timeout error, got %q", errMsg)
+	}
+	if err := <-server.cancelled; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected handler context to hit its deadline, got %v", err)
+	}
+
+	// The late completion must not produce a second response
+	<-server.finished
+	select {
+	case data := <-m.writes:
+		t.Errorf("Unexpected duplicate response: %s", data)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	assertMessageDelivered(t, m, q)
+}
+
+// tailingMCPServer is an in-process MCP server whose tool writes its output
+// incrementally
+type tailingMCPServer struct {
+	lines []string
+}
+
+func (s *tailingMCPServer) HandleMessage(ctx context.Context, message map[string]any) (map[string]any, error) {
+	output := types.ToolOutputFromContext(ctx)
+	for _, line := range s.lines {
+		_, _ = output.WriteString(line + "\n")
+		time.Sleep(20 * time.Millisecond)
+	}
+	return output.Response(message), nil
+}
+
+func TestQuery_MCPToolOutputCallback(t *testing.T) {
+	var mu sync.Mutex
+	var chunks []string
+	callback := func(server, tool, chunk string) {
+		if server != "logs" || tool != "tail" {
+			t.Errorf("callback(%q, %q) for an unexpected tool", server, tool)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		chunks = append(chunks, chunk)
+	}
+
+	server := &tailingMCPServer{lines: []string{"one", "two", "three"}}
+	options := types.NewClaudeAgentOptions().
+		WithMCPServer("logs", &types.MCPServerConfig{Type: "sdk", Name: "logs", Instance: server}).
+		WithToolOutputCallback(10*time.Millisecond, callback)
+
+	m := newMockTransport()
+	q := New(m, options)
+	q.Start(context.Background())
+	defer q.Close()
+
+	response := m.sendControlRequest(t, "req_1", map[string]any{
+		"subtype":     types.SubtypeMCPMessage,
+		"server_name": "logs",
+		"message": map[string]any{
+			"jsonrpc": "2.0",
+			"id":      1,
+			"method":  "tools/call",
+			"params":  map[string]any{"name": "tail", "arguments": map[string]any{}},
+		},
+	})
+	if response["subtype"] != types.ControlResponseTypeSuccess {
+		t.Fatalf("Expected success response, got %v", response)
+	}
+
+	// Every chunk was flushed, in order, before the result was sent
+	mu.Lock()
+	defer mu.Unlock()
+	if got := strings.Join(chunks, ""); got != "one\ntwo\nthree\n" {
+		t.Errorf("Flushed output = %q, want all lines in order", got)
+	}
+	if len(chunks) < 2 {
+		t.Errorf("Got %d chunks, want output flushed while the tool ran", len(chunks))
+	}
+}
+
+func TestQuery_HookContextCancelledOnClose(t *testing.T) {
+	started := make(chan struct{})
+	hookErr := make(chan error, 1)
+	hook := func(ctx context.Context, input interface{}, toolUseID *string, hookContext *types.HookContext) (map[string]interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		hookErr <- ctx.Err()
+		return nil, ctx.Err()
+	}
+	options := types.NewClaudeAgentOptions().
+		WithHook(types.HookEventPreToolUse, types.HookMatcher{Hooks: []types.HookFunc{hook}})
+
+	m := newMockTransport()
+	q := New(m, options)
+	q.Start(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := q.Initialize(ctx); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+
+	m.messages <- &types.SDKControlRequest{
+		Type_:   types.ControlTypeRequest,
+		ID:      "req_1",
+		Request: map[string]any{"subtype": types.SubtypeHookCallback, "callback_id": "hook_0"},
+	}
+
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for hook to start")
+	}
+
+	q.Close()
+
+	select {
+	case err := <-hookErr:
+		if err != context.Canceled {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Hook context was not cancelled on Close")
+	}
+}
+
+func TestQuery_CanUseToolSignalCancelledWithSession(t *testing.T) {
+	started := make(chan struct{})
+	signalErr := make(chan error, 1)
+	options := types.NewClaudeAgentOptions().
+		WithCanUseTool(func(tool string, input map[string]any, ctx interface{}) (types.PermissionResult, error) {
+			signal := ctx.(*types.ToolPermissionContext).Signal
+			close(started)
+			<-signal.Done()
+			signalErr <- signal.Err()
+			return types.PermissionResult{Behavior: "deny"}, nil
+		})
+
+	sessionCtx, cancelSession := context.WithCancel(context.Background())
+	m := newMockTransport()
+	q := New(m, options)
+	q.Start(sessionCtx)
+	defer q.Close()
+
+	m.messages <- &types.SDKControlRequest{
+		Type_:   types.ControlTypeRequest,
+		ID:      "req_1",
+		Request: map[string]any{"subtype": types.SubtypeCanUseTool, "tool_name": "Bash", "input": map[string]any{}},
+	}
+
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for CanUseTool to start")
+	}
+
+	cancelSession()
+
+	select {
+	case err := <-signalErr:
+		if err != context.Canceled {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Permission signal was not cancelled with the session context")
+	}
+}
+
+func TestQuery_HookContext(t *testing.T) {
+	contexts := make(chan *types.HookContext, 3)
+	hook := func(ctx context.Context, input interface{}, toolUseID *string, hookContext *types.HookContext) (map[string]interface{}, error) {
+		contexts <- hookContext
+		return map[string]interface{}{}, nil
+	}
+	options := types.NewClaudeAgentOptions().
+		WithPermissionMode(types.PermissionModeAcceptEdits).
+		WithHook(types.HookEventPostToolUse, types.HookMatcher{Hooks: []types.HookFunc{hook}})
+
+	m := newMockTransport()
+	q := New(m, options)
+	q.Start(context.Background())
+	defer q.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := q.Initialize(ctx); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+
+	hookRequest := func(requestID string, input map[string]any) *types.HookContext {
+		t.Helper()
+		m.sendControlRequest(t, requestID, map[string]any{
+			"subtype":     types.SubtypeHookCallback,
+			"callback_id": "hook_0",
+			"input":       input,
+		})
+		return <-contexts
+	}
+
+	got := hookRequest("req_1", map[string]any{})
+	if got.Event != types.HookEventPostToolUse || got.PermissionMode != types.PermissionModeAcceptEdits || got.SessionID != "" {
+		t.Errorf("Unexpected hook context: %+v", got)
+	}
+
+	if err := q.SetPermissionMode(ctx, types.PermissionModePlan); err != nil {
+		t.Fatalf("SetPermissionMode() error = %v", err)
+	}
+	if got := hookRequest("req_2", map[string]any{}); got.PermissionMode != types.PermissionModePlan {
+		t.Errorf("Expected permission mode to follow SetPermissionMode, got %+v", got)
+	}
+
+	got = hookRequest("req_3", map[string]any{"session_id": "session-1", "permission_mode": "bypassPermissions"})
+	if got.SessionID != "session-1" || got.PermissionMode != types.PermissionModeBypassPermission {
+		t.Errorf("Expected hook input to populate the context, got %+v", got)
+	}
+}
+
+func TestQuery_UnknownHookCallback(t *testing.T) {
+	m := newMockTransport()
+	q := New(m, types.NewClaudeAgentOptions())
+	q.Start(context.Background())
+	defer q.Close()
+
+	response := m.sendControlRequest(t, "req_1", map[string]any{
+		"subtype":     types.SubtypeHookCallback,
+		"callback_id": "missing",
+		"input":       map[string]any{},
+	})
+
+	if response["subtype"] != types.ControlResponseTypeError {
+		t.Errorf("Expected error response, got %v", response)
+	}
+}
+
+// countingCollector counts control request metrics by status
+type countingCollector struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (c *countingCollector) IncCounter(name string, labels map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[name+":"+labels["subtype"]+":"+labels["status"]]++
+}
+
+func (c *countingCollector) ObserveDuration(name string, duration time.Duration, labels map[string]string) {
+}
+
+func TestQuery_ControlRequestMetrics(t *testing.T) {
+	collector := &countingCollector{counts: make(map[string]int)}
+	options := types.NewClaudeAgentOptions().WithMetrics(collector)
+
+	m := newMockTransport()
+	q := New(m, options)
+	q.Start(context.Background())
+	defer q.Close()
+
+	m.sendControlRequest(t, "req_1", map[string]any{
+		"subtype":   types.SubtypeCanUseTool,
+		"tool_name": "Bash",
+		"input":     map[string]any{},
+	})
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	key := types.MetricControlRequestsHandled + ":" + types.SubtypeCanUseTool + ":" + types.ControlResponseTypeError
+	if collector.counts[key] != 1 {
+		t.Errorf("Expected 1 failed can_use_tool request, got %v", collector.counts)
+	}
+}
@@ -0,0 +1,154 @@
+package query
+
+import (
+	"sync"
+	"time"
+
+	"github.com/anthropics/claude-agent-sdk-go/internal/types"
+)
+
+// streamThrottle limits the rate at which stream events are queued for the
+// consumer to one per interval. Stream events arriving sooner are held back in
+// order and released one per interval; a delta arriving while the last held
+// event is a delta of the same kind for the same block is merged into it.
+// Complete messages are queued immediately, after all held events so that
+// order is kept.
+type streamThrottle struct {
+	mu       sync.Mutex
+	queue    *messageQueue
+	interval time.Duration
+	last     time.Time            // When a stream event was last queued
+	pending  []*types.StreamEvent // Stream events being held back, oldest first
+	timer    *time.Timer
+}
+
+// newStreamThrottle creates a throttle queuing at most perSecond stream events
+// per second on queue
+func newStreamThrottle(queue *messageQueue, perSecond int) *streamThrottle {
+	return &streamThrottle{queue: queue, interval: time.Second / time.Duration(perSecond)}
+}
+
+// push queues msg, or holds it back if it is a stream event arriving too soon
+func (s *streamThrottle) push(msg types.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event, ok := msg.(*types.StreamEvent)
+	if !ok {
+		s.flushLocked()
+		s.queue.push(msg)
+		return
+	}
+
+	if len(s.pending) > 0 {
+		if !mergeDelta(s.pending[len(s.pending)-1], event) {
+			s.pending = append(s.pending, event)
+		}
+		return
+	}
+	if wait := s.interval - time.Since(s.last); wait > 0 {
+		s.pending = append(s.pending, event)
+		s.scheduleLocked(wait)
+		return
+	}
+
+	s.queue.push(event)
+	s.last = time.Now()
+}
+
+// scheduleLocked releases the oldest held event after wait; the caller must
+// hold s.mu
+func (s *streamThrottle) scheduleLocked(wait time.Duration) {
+	var timer *time.Timer
+	timer = time.AfterFunc(wait, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		// A flush stopped this timer after it fired
+		if s.timer != timer {
+			return
+		}
+		s.timer = nil
+		s.releaseLocked()
+	})
+	s.timer = timer
+}
+
+// releaseLocked queues the oldest held event and schedules the next one; the
+// caller must hold s.mu
+func (s *streamThrottle) releaseLocked() {
+	if len(s.pending) == 0 {
+		return
+	}
+	s.queue.push(s.pending[0])
+	s.pending = s.pending[1:]
+	s.last = time.Now()
+	if len(s.pending) > 0 {
+		s.scheduleLocked(s.interval)
+	}
+}
+
+// flush queues all held events
+func (s *streamThrottle) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushLocked()
+}
+
+// flushLocked queues all held events; the caller must hold s.mu
+func (s *streamThrottle) flushLocked() {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if len(s.pending) == 0 {
+		return
+	}
+	for _, event := range s.pending {
+		s.queue.push(event)
+	}
+	s.pending = nil
+	s.last = time.Now()
+}
+
+// deltaFields maps the delta types that can be merged to their string field
+var deltaFields = map[string]string{
+	"text_delta":       "text",
+	"input_json_delta": "partial_json",
+	"thinking_delta":   "thinking",
+}
+
+// mergeDelta appends the delta of next to pending if both are deltas of the
+// same kind for the same block, reporting whether it did
+func mergeDelta(pending, next *types.StreamEvent) bool {
+	if pending.SessionID != next.SessionID || pending.Event["index"] != next.Event["index"] {
+		return false
+	}
+	if (pending.ParentToolUseID == nil) != (next.ParentToolUseID == nil) ||
+		(pending.ParentToolUseID != nil && *pending.ParentToolUseID != *next.ParentToolUseID) {
+		return false
+	}
+	if pending.Event["type"] != "content_block_delta" || next.Event["type"] != "content_block_delta" {
+		return false
+	}
+
+	delta, _ := pending.Event["delta"].(map[string]any)
+	more, _ := next.Event["delta"].(map[string]any)
+	deltaType, _ := delta["type"].(string)
+	field, ok := deltaFields[deltaType]
+	if !ok || more["type"] != deltaType {
+		return false
+	}
+	text, ok := delta[field].(string)
+	if !ok {
+		return false
+	}
+	moreText, ok := more[field].(string)
+	if !ok {
+		return false
+	}
+
+	// The pending event is not delivered yet, so its maps can be updated
+	delta[field] = text + moreText
+	return true
+}
@@ -0,0 +1,166 @@
+package query
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/anthropics/claude-agent-sdk-go/internal/types"
+)
+
+// textDeltaEvent builds a text delta stream event for block index
+func textDeltaEvent(index int, text string) *types.StreamEvent {
+	return &types.StreamEvent{
+		Type_:     types.MessageTypeStreamEvent,
+		SessionID: "session-1",
+		Event: map[string]any{
+			"type":  "content_block_delta",
+			"index": float64(index),
+			"delta": map[string]any{"type": "text_delta", "text": text},
+		},
+	}
+}
+
+// queued returns the messages waiting in queue
+func queued(queue *messageQueue) []types.Message {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	return append([]types.Message(nil), queue.messages...)
+}
+
+// describe summarizes messages as their text deltas or types
+func describe(messages []types.Message) []string {
+	var out []string
+	for _, msg := range messages {
+		if event, ok := msg.(*types.StreamEvent); ok {
+			if text, ok := event.TextDelta(); ok {
+				out = append(out, text)
+				continue
+			}
+		}
+		out = append(out, msg.Type())
+	}
+	return out
+}
+
+func TestStreamThrottle(t *testing.T) {
+	queue := newMessageQueue(context.Background(), types.DefaultMaxQueuedMessages)
+	throttle := newStreamThrottle(queue, 1) // An interval long enough to hold deltas back
+
+	throttle.push(textDeltaEvent(0, "Hel"))
+	throttle.push(textDeltaEvent(0, "lo"))
+	throttle.push(textDeltaEvent(0, ", world"))
+
+	if got := describe(queued(queue)); len(got) != 1 || got[0] != "Hel" {
+		t.Fatalf("Queued = %q, want only the first delta", got)
+	}
+
+	// A complete message releases the held delta first
+	throttle.push(&types.AssistantMessage{Content: []types.ContentBlock{}})
+	want := []string{"Hel", "lo, world", types.MessageTypeAssistant}
+	if got := describe(queued(queue)); len(got) != len(want) || got[1] != want[1] || got[2] != want[2] {
+		t.Fatalf("Queued = %q, want %q", got, want)
+	}
+
+	// Deltas of different blocks are not merged
+	throttle.push(textDeltaEvent(1, "a"))
+	throttle.push(textDeltaEvent(2, "b"))
+	throttle.flush()
+	if got := describe(queued(queue))[3:]; len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("Queued = %q, want the deltas of both blocks", got)
+	}
+}
+
+func TestStreamThrottle_FlushesAfterInterval(t *testing.T) {
+	queue := newMessageQueue(context.Background(), types.DefaultMaxQueuedMessages)
+	throttle := newStreamThrottle(queue, 20)
+
+	throttle.push(textDeltaEvent(0, "a"))
+	throttle.push(textDeltaEvent(0, "b"))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(queued(queue)) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := describe(queued(queue)); len(got) != 2 || got[1] != "b" {
+		t.Errorf("Queued = %q, want the held delta delivered after the interval", got)
+	}
+}
+
+func TestStreamThrottle_OtherEventsKeepOrder(t *testing.T) {
+	queue := newMessageQueue(context.Background(), types.DefaultMaxQueuedMessages)
+	throttle := newStreamThrottle(queue, 1)
+
+	throttle.push(textDeltaEvent(0, "a"))
+	throttle.push(textDeltaEvent(0, "b"))
+	throttle.push(&types.StreamEvent{Type_: types.MessageTypeStreamEvent, Event: map[string]any{"type": "content_block_stop", "index": float64(0)}})
+	throttle.push(textDeltaEvent(1, "c"))
+
+	if got := describe(queued(queue)); len(got) != 1 {
+		t.Fatalf("Queued = %q, want the stop event held back with the deltas", got)
+	}
+
+	throttle.flush()
+	want := []string{"a", "b", types.MessageTypeStreamEvent, "c"}
+	if got := describe(queued(queue)); len(got) != len(want) || got[1] != want[1] || got[2] != want[2] || got[3] != want[3] {
+		t.Errorf("Queued = %q, want %q", got, want)
+	}
+}
+
+func TestStreamThrottle_LimitsAllStreamEvents(t *testing.T) {
+	queue := newMessageQueue(context.Background(), types.DefaultMaxQueuedMessages)
+	throttle := newStreamThrottle(queue, 20)
+
+	// Events that cannot be merged are released at the limited rate
+	for i := 0; i < 100; i++ {
+		throttle.push(&types.StreamEvent{
+			Type_: types.MessageTypeStreamEvent,
+			Event: map[string]any{"type": "content_block_start", "index": float64(i)},
+		})
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if got := len(queued(queue)); got < 2 || got > 10 {
+		t.Errorf("Queued %d events after 200ms, want about 5 at 20 per second", got)
+	}
+
+	throttle.flush()
+	messages := queued(queue)
+	if len(messages) != 100 {
+		t.Fatalf("Queued %d events after flush, want 100", len(messages))
+	}
+	for i, msg := range messages {
+		if index := msg.(*types.StreamEvent).Event["index"]; index != float64(i) {
+			t.Fatalf("Event %d has index %v, want the original order", i, index)
+		}
+	}
+}
+
+func TestStreamThrottle_MergesToolInputDeltas(t *testing.T) {
+	queue := newMessageQueue(context.Background(), types.DefaultMaxQueuedMessages)
+	throttle := newStreamThrottle(queue, 1)
+
+	inputDelta := func(partial string) *types.StreamEvent {
+		return &types.StreamEvent{
+			Type_: types.MessageTypeStreamEvent,
+			Event: map[string]any{
+				"type":  "content_block_delta",
+				"index": float64(1),
+				"delta": map[string]any{"type": "input_json_delta", "partial_json": partial},
+			},
+		}
+	}
+	throttle.push(inputDelta(`{"pa`))
+	throttle.push(inputDelta(`th":`))
+	throttle.push(inputDelta(`"a.go"}`))
+	throttle.flush()
+
+	messages := queued(queue)
+	if len(messages) != 2 {
+		t.Fatalf("Queued %d events, want the first delta and the merged rest", len(messages))
+	}
+	delta := messages[1].(*types.StreamEvent).Event["delta"].(map[string]any)
+	if delta["partial_json"] != `th":"a.go"}` {
+		t.Errorf("Merged partial JSON = %v", delta["partial_json"])
+	}
+}
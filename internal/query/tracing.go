@@ -0,0 +1,134 @@
+package query
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/anthropics/claude-agent-sdk-go/internal/types"
+)
+
+// traceMessage starts a tool-use span for every tool_use block and ends it
+// when the matching tool_result block arrives
+func (q *Query) traceMessage(ctx context.Context, msg types.Message) {
+	if q.options.Tracer == nil {
+		return
+	}
+
+	switch m := msg.(type) {
+	case *types.AssistantMessage:
+		for _, block := range m.Content {
+			toolUse, ok := block.(*types.ToolUseBlock)
+			if !ok {
+				continue
+			}
+
+			_, span := types.StartSpan(ctx, q.options.Tracer, types.SpanToolUse, map[string]string{
+				"tool_name":   toolUse.Name,
+				"tool_use_id": toolUse.ID,
+			})
+
+			q.mu.Lock()
+			q.toolSpans[toolUse.ID] = span
+			q.mu.Unlock()
+		}
+
+	case *types.ResultMessage:
+		q.mu.Lock()
+		if len(q.querySpans) == 0 {
+			q.mu.Unlock()
+			return
+		}
+		pending := q.querySpans[0]
+		q.querySpans = q.querySpans[1:]
+		q.mu.Unlock()
+
+		pending.span.SetAttribute("session_id", m.SessionID)
+		if m.IsError {
+			pending.span.RecordError(m.ExecutionError())
+		}
+		pending.span.End()
+
+	case *types.UserMessage:
+		blocks, ok := m.Content.([]types.ContentBlock)
+		if !ok {
+			return
+		}
+
+		for _, block := range blocks {
+			toolResult, ok := block.(*types.ToolResultBlock)
+			if !ok {
+				continue
+			}
+
+			q.mu.Lock()
+			span, ok := q.toolSpans[toolResult.ToolUseID]
+			delete(q.toolSpans, toolResult.ToolUseID)
+			q.mu.Unlock()
+
+			if !ok {
+				continue
+			}
+			if toolResult.IsError != nil && *toolResult.IsError {
+				span.RecordError(fmt.Errorf("tool %s returned an error result", toolResult.ToolUseID))
+			}
+			span.End()
+		}
+	}
+}
+
+// endToolSpans ends tool-use spans that never received a result
+func (q *Query) endToolSpans() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for toolUseID, span := range q.toolSpans {
+		span.End()
+		delete(q.toolSpans, toolUseID)
+	}
+}
+
+// querySpan is the query span of a prompt awaiting its result
+type querySpan struct {
+	span types.Span
+}
+
+// StartQuerySpan starts a query span for a prompt about to be written to the
+// transport. Prompts are answered in order, so each ResultMessage ends the
+// oldest open query span. The returned function ends the span with an error
+// if the prompt could not be sent.
+func (q *Query) StartQuerySpan(ctx context.Context, attributes map[string]string) (abort func(err error)) {
+	if q.options.Tracer == nil {
+		return func(error) {}
+	}
+
+	_, span := types.StartSpan(ctx, q.options.Tracer, types.SpanQuery, attributes)
+	pending := &querySpan{span: span}
+	q.mu.Lock()
+	q.querySpans = append(q.querySpans, pending)
+	q.mu.Unlock()
+
+	return func(err error) {
+		q.mu.Lock()
+		for i, open := range q.querySpans {
+			if open == pending {
+				q.querySpans = append(q.querySpans[:i:i], q.querySpans[i+1:]...)
+				break
+			}
+		}
+		q.mu.Unlock()
+
+		span.RecordError(err)
+		span.End()
+	}
+}
+
+// endQuerySpans ends query spans whose prompts never received a result
+func (q *Query) endQuerySpans() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, pending := range q.querySpans {
+		pending.span.End()
+	}
+	q.querySpans = nil
+}
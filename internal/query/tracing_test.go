@@ -0,0 +1,218 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anthropics/claude-agent-sdk-go/internal/types"
+)
+
+// spanKey is the context key recording the active span name
+type spanKey struct{}
+
+// recordedSpan is a span captured by recordingTracer
+type recordedSpan struct {
+	mu         sync.Mutex
+	name       string
+	parent     string
+	attributes map[string]string
+	err        error
+	ended      chan struct{}
+}
+
+func (s *recordedSpan) SetAttribute(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attributes[key] = value
+}
+
+func (s *recordedSpan) RecordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+func (s *recordedSpan) End() { close(s.ended) }
+
+// waitEnded reports whether the span ends within a short timeout
+func (s *recordedSpan) waitEnded() bool {
+	select {
+	case <-s.ended:
+		return true
+	case <-time.After(time.Second):
+		return false
+	}
+}
+
+// recordingTracer records every span it starts
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+func (r *recordingTracer) StartSpan(ctx context.Context, name string, attributes map[string]string) (context.Context, types.Span) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	parent, _ := ctx.Value(spanKey{}).(string)
+	span := &recordedSpan{name: name, parent: parent, attributes: attributes, ended: make(chan struct{})}
+	r.spans = append(r.spans, span)
+	return context.WithValue(ctx, spanKey{}, name), span
+}
+
+func (r *recordingTracer) find(name string) []*recordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var found []*recordedSpan
+	for _, span := range r.spans {
+		if span.name == name {
+			found = append(found, span)
+		}
+	}
+	return found
+}
+
+func TestQuery_ToolUseSpans(t *testing.T) {
+	tracer := &recordingTracer{}
+	options := types.NewClaudeAgentOptions().WithTracer(tracer)
+
+	m := newMockTransport()
+	q := New(m, options)
+	q.Start(context.WithValue(context.Background(), spanKey{}, types.SpanQuery))
+	defer q.Close()
+
+	isError := true
+	m.messages <- &types.AssistantMessage{
+		Content: []types.ContentBlock{
+			&types.ToolUseBlock{ID: "tool_1", Name: "Bash", Input: map[string]any{}},
+		},
+	}
+	m.messages <- &types.UserMessage{
+		Content: []types.ContentBlock{
+			&types.ToolResultBlock{ToolUseID: "tool_1", Content: "failed", IsError: &isError},
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-q.Messages():
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timeout waiting for message")
+		}
+	}
+
+	spans := tracer.find(types.SpanToolUse)
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 tool use span, got %d", len(spans))
+	}
+	span := spans[0]
+	if span.attributes["tool_name"] != "Bash" || span.parent != types.SpanQuery {
+		t.Errorf("Unexpected tool use span: %+v", span)
+	}
+	if !span.waitEnded() {
+		t.Fatal("Expected tool use span to be ended")
+	}
+	span.mu.Lock()
+	defer span.mu.Unlock()
+	if span.err == nil {
+		t.Error("Expected tool use span to record the error result")
+	}
+}
+
+func TestQuery_ControlRequestSpanPropagatesContext(t *testing.T) {
+	tracer := &recordingTracer{}
+	var hookSpan string
+	hook := func(ctx context.Context, input interface{}, toolUseID *string, hookContext *types.HookContext) (map[string]interface{}, error) {
+		hookSpan, _ = ctx.Value(spanKey{}).(string)
+		return map[string]interface{}{}, nil
+	}
+	options := types.NewClaudeAgentOptions().
+		WithTracer(tracer).
+		WithHook(types.HookEventPreToolUse, types.HookMatcher{Hooks: []types.HookFunc{hook}})
+
+	m := newMockTransport()
+	q := New(m, options)
+	q.Start(context.Background())
+	defer q.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := q.Initialize(ctx); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+
+	m.sendControlRequest(t, "req_1", map[string]any{
+		"subtype":     types.SubtypeHookCallback,
+		"callback_id": "hook_0",
+		"input":       map[string]any{},
+	})
+
+	if hookSpan != types.SpanControlRequest {
+		t.Errorf("Expected hook to receive the control request span context, got %q", hookSpan)
+	}
+
+	directions := make(map[string]bool)
+	for _, span := range tracer.find(types.SpanControlRequest) {
+		directions[span.attributes["direction"]] = span.waitEnded()
+	}
+	if !directions["outbound"] || !directions["inbound"] {
+		t.Errorf("Expected ended inbound and outbound control request spans, got %v", directions)
+	}
+}
+
+func TestQuery_QuerySpans(t *testing.T) {
+	tracer := &recordingTracer{}
+	options := types.NewClaudeAgentOptions().WithTracer(tracer)
+
+	m := newMockTransport()
+	q := New(m, options)
+	q.Start(context.Background())
+	defer q.Close()
+
+	// The aborted prompt was never sent, so the results answer the others
+	q.StartQuerySpan(context.Background(), map[string]string{"model": "claude-sonnet-4-5"})
+	abort := q.StartQuerySpan(context.Background(), map[string]string{})
+	q.StartQuerySpan(context.Background(), map[string]string{})
+	abort(errors.New("write failed"))
+
+	m.messages <- &types.ResultMessage{Subtype: "success", SessionID: "session-1"}
+	m.messages <- &types.ResultMessage{Subtype: "error_during_execution", IsError: true, SessionID: "session-1"}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-q.Messages():
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timeout waiting for message")
+		}
+	}
+
+	spans := tracer.find(types.SpanQuery)
+	if len(spans) != 3 {
+		t.Fatalf("Expected 3 query spans, got %d", len(spans))
+	}
+	for i, span := range spans {
+		if !span.waitEnded() {
+			t.Fatalf("Expected query span %d to be ended", i)
+		}
+	}
+
+	first, aborted, failed := spans[0], spans[1], spans[2]
+	first.mu.Lock()
+	if first.attributes["model"] != "claude-sonnet-4-5" || first.attributes["session_id"] != "session-1" || first.err != nil {
+		t.Errorf("Unexpected first query span: %+v", first.attributes)
+	}
+	first.mu.Unlock()
+	aborted.mu.Lock()
+	if aborted.err == nil || aborted.attributes["session_id"] != "" {
+		t.Errorf("Expected the aborted span to record the write error only, got %+v", aborted)
+	}
+	aborted.mu.Unlock()
+	failed.mu.Lock()
+	if failed.err == nil {
+		t.Error("Expected the last query span to record the error result")
+	}
+	failed.mu.Unlock()
+}
@@ -0,0 +1,117 @@
+package transport
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/anthropics/claude-agent-sdk-go/internal/types"
+)
+
+// heartbeat detects a CLI that stops producing output during a turn. A turn
+// starts when a user message is written and ends with its result. The CLI is
+// not expected to go quiet in between, except while it waits for the SDK to
+// answer one of its control requests, such as a permission prompt.
+type heartbeat struct {
+	mu       sync.Mutex
+	timeout  time.Duration
+	active   bool                // Whether a turn is waiting for its result
+	lastSeen time.Time           // When the CLI last produced output, or the turn started
+	pending  map[string]struct{} // Control requests from the CLI awaiting a response
+	stalled  bool                // Whether the current silence was already reported
+}
+
+// newHeartbeat creates a heartbeat that reports silences of at least timeout
+func newHeartbeat(timeout time.Duration) *heartbeat {
+	return &heartbeat{timeout: timeout, pending: make(map[string]struct{})}
+}
+
+// StartTurn marks a turn as started, as when the prompt is passed on the
+// command line
+func (h *heartbeat) StartTurn() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.active {
+		h.active = true
+		h.lastSeen = time.Now()
+	}
+}
+
+// Output records that the CLI wrote a line
+func (h *heartbeat) Output() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastSeen = time.Now()
+	h.stalled = false
+}
+
+// Received records a message read from the CLI. A control request stops
+// being awaited when the CLI cancels it or the turn ends, even if it was never
+// answered.
+func (h *heartbeat) Received(message types.Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch m := message.(type) {
+	case *types.ResultMessage:
+		h.active = false
+		clear(h.pending)
+	case *types.SDKControlRequest:
+		h.pending[m.ID] = struct{}{}
+	case *types.UnknownMessage:
+		if m.Type_ != types.ControlTypeCancelRequest {
+			return
+		}
+		var cancel struct {
+			RequestID string `json:"request_id"`
+		}
+		if err := json.Unmarshal(m.Raw, &cancel); err != nil {
+			return
+		}
+		if _, ok := h.pending[cancel.RequestID]; ok {
+			delete(h.pending, cancel.RequestID)
+			h.lastSeen = time.Now()
+		}
+	}
+}
+
+// Sent records data written to the CLI: user messages start a turn, and
+// control responses let the CLI continue
+func (h *heartbeat) Sent(data string) {
+	var header struct {
+		Type     string `json:"type"`
+		Response struct {
+			RequestID string `json:"request_id"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal([]byte(data), &header); err != nil {
+		return
+	}
+
+	switch header.Type {
+	case types.MessageTypeUser:
+		h.StartTurn()
+	case types.ControlTypeResponse:
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.pending[header.Response.RequestID]; ok {
+			delete(h.pending, header.Response.RequestID)
+			h.lastSeen = time.Now()
+		}
+	}
+}
+
+// Check reports whether the CLI has been silent for the timeout during a
+// turn. Each silence is reported once.
+func (h *heartbeat) Check(now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.active || h.stalled || len(h.pending) > 0 || now.Sub(h.lastSeen) < h.timeout {
+		return false
+	}
+	h.stalled = true
+	return true
+}
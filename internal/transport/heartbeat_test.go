@@ -0,0 +1,119 @@
+package transport
+
+import (
+	"testing"
+	"time"
+
+	"github.com/anthropics/claude-agent-sdk-go/internal/types"
+)
+
+func TestHeartbeat(t *testing.T) {
+	const timeout = time.Minute
+
+	tests := []struct {
+		name  string
+		setup func(h *heartbeat)
+		want  bool
+	}{
+		{
+			name:  "no turn",
+			setup: func(h *heartbeat) {},
+			want:  false,
+		},
+		{
+			name:  "silent turn",
+			setup: func(h *heartbeat) { h.Sent(`{"type":"user"}`) },
+			want:  true,
+		},
+		{
+			name: "turn ended by result",
+			setup: func(h *heartbeat) {
+				h.Sent(`{"type":"user"}`)
+				h.Received(&types.ResultMessage{})
+			},
+			want: false,
+		},
+		{
+			name: "waiting for a control response",
+			setup: func(h *heartbeat) {
+				h.StartTurn()
+				h.Received(&types.SDKControlRequest{ID: "req_1"})
+			},
+			want: false,
+		},
+		{
+			name: "control response sent",
+			setup: func(h *heartbeat) {
+				h.StartTurn()
+				h.Received(&types.SDKControlRequest{ID: "req_1"})
+				h.Sent(`{"type":"control_response","response":{"request_id":"req_1"}}`)
+			},
+			want: true,
+		},
+		{
+			name: "control request cancelled",
+			setup: func(h *heartbeat) {
+				h.StartTurn()
+				h.Received(&types.SDKControlRequest{ID: "req_1"})
+				h.Received(&types.UnknownMessage{
+					Type_: types.ControlTypeCancelRequest,
+					Raw:   []byte(`{"type":"control_cancel_request","request_id":"req_1"}`),
+				})
+			},
+			want: true,
+		},
+		{
+			name: "other control request cancelled",
+			setup: func(h *heartbeat) {
+				h.StartTurn()
+				h.Received(&types.SDKControlRequest{ID: "req_1"})
+				h.Received(&types.UnknownMessage{
+					Type_: types.ControlTypeCancelRequest,
+					Raw:   []byte(`{"type":"control_cancel_request","request_id":"req_2"}`),
+				})
+			},
+			want: false,
+		},
+		{
+			name: "unanswered control request cleared by the result",
+			setup: func(h *heartbeat) {
+				h.StartTurn()
+				h.Received(&types.SDKControlRequest{ID: "req_1"})
+				h.Received(&types.ResultMessage{})
+				h.Sent(`{"type":"user"}`)
+			},
+			want: true,
+		},
+		{
+			name: "silence already reported",
+			setup: func(h *heartbeat) {
+				h.StartTurn()
+				h.Check(time.Now().Add(timeout))
+			},
+			want: false,
+		},
+		{
+			name: "output after a reported silence",
+			setup: func(h *heartbeat) {
+				h.StartTurn()
+				h.Check(time.Now().Add(timeout))
+				h.Output()
+			},
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHeartbeat(timeout)
+			tt.setup(h)
+
+			if h.Check(time.Now()) {
+				t.Error("Check() before the timeout = true, want false")
+			}
+			if got := h.Check(time.Now().Add(2 * timeout)); got != tt.want {
+				t.Errorf("Check() after the timeout = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
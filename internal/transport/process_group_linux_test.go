@@ -0,0 +1,113 @@
+//go:build linux
+
+package transport
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/anthropics/claude-agent-sdk-go/internal/types"
+)
+
+// processRunning reports whether pid is alive and not a zombie
+func processRunning(pid int) bool {
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+func TestSubprocessCLITransport_ProcessGroup(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "grandchild.pid")
+	mockScript := `#!/bin/bash
+sleep 30 > /dev/null 2>&1 &
+echo $! > ` + pidFile + `
+echo '{"type":"system","subtype":"init","session_id":"test"}'
+cat > /dev/null
+`
+	cliPath := createMockCLI(t, mockScript)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+	t.Setenv("CLAUDE_AGENT_SDK_SKIP_VERSION_CHECK", "1")
+
+	options := types.NewClaudeAgentOptions().WithProcessGroup(true)
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = cliPath
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := transport.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect to mock CLI: %v", err)
+	}
+	<-transport.ReadMessages(ctx)
+
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatalf("Failed to read grandchild pid: %v", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatalf("Invalid grandchild pid %q: %v", data, err)
+	}
+	if !processRunning(pid) {
+		t.Fatal("Expected grandchild to be running before Close")
+	}
+
+	if err := transport.Close(ctx); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for processRunning(pid) {
+		if time.Now().After(deadline) {
+			_ = syscall.Kill(pid, syscall.SIGKILL)
+			t.Fatal("Expected Close to terminate the grandchild process")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSubprocessCLITransport_SignalHandlingUsesProcessGroup(t *testing.T) {
+	mockScript := `#!/bin/bash
+echo '{"type":"system","subtype":"init","session_id":"test"}'
+cat > /dev/null
+`
+	cliPath := createMockCLI(t, mockScript)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+	t.Setenv("CLAUDE_AGENT_SDK_SKIP_VERSION_CHECK", "1")
+
+	options := types.NewClaudeAgentOptions().WithSignalHandling(true)
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = cliPath
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := transport.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect to mock CLI: %v", err)
+	}
+	defer func() {
+		_ = transport.Close(ctx)
+	}()
+	<-transport.ReadMessages(ctx)
+
+	pid := transport.cmd.Process.Pid
+	if pgid, err := syscall.Getpgid(pid); err != nil || pgid != pid {
+		t.Errorf("Expected the CLI to lead its own process group, got pgid %d (%v) for pid %d", pgid, err, pid)
+	}
+	if options.ProcessGroup {
+		t.Error("The caller's options must not be modified")
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !unix
+
+package transport
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+// processGroupsSupported reports whether the CLI can run in its own process
+// group on this platform
+const processGroupsSupported = false
+
+// startInProcessGroup makes cmd start in a new process group led by the process
+func startInProcessGroup(cmd *exec.Cmd) error {
+	return fmt.Errorf("process groups are not supported on %s", runtime.GOOS)
+}
+
+// signalProcessGroup sends sig to every process in the group led by pid
+func signalProcessGroup(pid int, sig syscall.Signal) error {
+	return fmt.Errorf("process groups are not supported on %s", runtime.GOOS)
+}
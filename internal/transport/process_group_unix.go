@@ -0,0 +1,26 @@
+//go:build unix
+
+package transport
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// processGroupsSupported reports whether the CLI can run in its own process
+// group on this platform
+const processGroupsSupported = true
+
+// startInProcessGroup makes cmd start in a new process group led by the process
+func startInProcessGroup(cmd *exec.Cmd) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	return nil
+}
+
+// signalProcessGroup sends sig to every process in the group led by pid
+func signalProcessGroup(pid int, sig syscall.Signal) error {
+	return syscall.Kill(-pid, sig)
+}
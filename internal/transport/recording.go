@@ -0,0 +1,64 @@
+package transport
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// Recording directions
+const (
+	// RecordDirectionStdout marks a raw line read from the CLI's stdout
+	RecordDirectionStdout = "stdout"
+
+	// RecordDirectionStdin marks data written to the CLI's stdin
+	RecordDirectionStdin = "stdin"
+)
+
+// RecordEntry is a single line of a session recording
+type RecordEntry struct {
+	Direction string `json:"direction"`
+	Data      string `json:"data"`
+}
+
+// recorder appends session traffic to a JSON Lines file
+type recorder struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// newRecorder creates (or truncates) the recording file at path. The
+// recording holds every prompt and tool input and output, so a new file is
+// readable by the current user only.
+func newRecorder(path string) (*recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &recorder{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// Record appends an entry; it is a no-op once the recorder is closed
+func (r *recorder) Record(direction, data string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	return r.encoder.Encode(RecordEntry{Direction: direction, Data: data})
+}
+
+// Close closes the recording file
+func (r *recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
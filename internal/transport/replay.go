@@ -0,0 +1,177 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/anthropics/claude-agent-sdk-go/internal/types"
+)
+
+// ReplayTransport implements Transport by replaying a session recorded with
+// WithRecordTo. Recorded stdout lines are run through the message parser and
+// delivered in order; writes are accepted and discarded. No CLI or network
+// access is needed.
+type ReplayTransport struct {
+	path string
+
+	ready       bool
+	mu          sync.RWMutex
+	ctx         context.Context
+	cancel      context.CancelFunc
+	messageChan chan types.Message
+	errorChan   chan error
+}
+
+// NewReplayTransport creates a transport replaying the recording at path
+func NewReplayTransport(path string) *ReplayTransport {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &ReplayTransport{
+		path:        path,
+		ctx:         ctx,
+		cancel:      cancel,
+		messageChan: make(chan types.Message, 100),
+		errorChan:   make(chan error, 10),
+	}
+}
+
+// Connect loads the recording and starts replaying its stdout lines
+func (t *ReplayTransport) Connect(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.ready {
+		return nil
+	}
+
+	lines, err := readRecording(t.path)
+	if err != nil {
+		return err
+	}
+
+	t.ready = true
+	go t.replay(lines)
+	return nil
+}
+
+// readRecording returns the recorded stdout lines in order
+func readRecording(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, types.NewCLIConnectionError("failed to open recording", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		var entry RecordEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, types.NewJSONDecodeError(fmt.Sprintf("invalid recording entry on line %d", lineNum), err)
+		}
+		if entry.Direction == RecordDirectionStdout {
+			lines = append(lines, entry.Data)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, types.NewCLIConnectionError("failed to read recording", err)
+	}
+	return lines, nil
+}
+
+// replay parses the recorded stdout lines, accumulating partial JSON the same
+// way the subprocess transport does
+func (t *ReplayTransport) replay(lines []string) {
+	defer close(t.messageChan)
+
+	jsonBuffer := ""
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		jsonBuffer += line
+		if !json.Valid([]byte(jsonBuffer)) {
+			continue
+		}
+
+		message, err := types.UnmarshalMessage([]byte(jsonBuffer))
+		jsonBuffer = ""
+		if err != nil {
+			t.OnError(err)
+			continue
+		}
+
+		select {
+		case t.messageChan <- message:
+		case <-t.ctx.Done():
+			return
+		}
+	}
+}
+
+// Close stops the replay
+func (t *ReplayTransport) Close(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.ready = false
+	t.cancel()
+	return nil
+}
+
+// Write accepts and discards data sent to the replayed session
+func (t *ReplayTransport) Write(ctx context.Context, data string) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if !t.ready {
+		err := types.NewCLIConnectionError("transport is not ready for writing", nil)
+		err.Kind = types.ErrNotConnected
+		if t.ctx.Err() != nil {
+			err.Kind = types.ErrClosed
+		}
+		return err
+	}
+	return nil
+}
+
+// ReadMessages returns the channel of replayed messages
+func (t *ReplayTransport) ReadMessages(ctx context.Context) <-chan types.Message {
+	return t.messageChan
+}
+
+// OnError records an error without blocking
+func (t *ReplayTransport) OnError(err error) {
+	select {
+	case t.errorChan <- err:
+	default:
+		// Error channel is full, drop the error
+	}
+}
+
+// IsReady returns whether the transport is replaying
+func (t *ReplayTransport) IsReady() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.ready
+}
+
+// EndInput is a no-op for replayed sessions
+func (t *ReplayTransport) EndInput(ctx context.Context) error {
+	return nil
+}
+
+// Flush is a no-op for replayed sessions
+func (t *ReplayTransport) Flush(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,151 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/anthropics/claude-agent-sdk-go/internal/types"
+)
+
+func TestSubprocessCLITransport_RecordAndReplay(t *testing.T) {
+	mockScript := `#!/bin/bash
+read -r line
+echo '{"type":"system","subtype":"init","data":{}}'
+echo '{"type":"assistant","message":{"model":"claude","content":[{"type":"text","text":"Hi"}]}}'
+echo '{"type":"result","subtype":"success","session_id":"test"}'
+`
+	cliPath := createMockCLI(t, mockScript)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+
+	recordPath := filepath.Join(t.TempDir(), "session.jsonl")
+	options := types.NewClaudeAgentOptions().WithRecordTo(recordPath)
+
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = cliPath
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := transport.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect to mock CLI: %v", err)
+	}
+	if err := transport.Write(ctx, `{"type":"user"}`); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	var recorded []string
+	for msg := range transport.ReadMessages(ctx) {
+		recorded = append(recorded, msg.Type())
+	}
+	_ = transport.Close(ctx)
+
+	if info, err := os.Stat(recordPath); err != nil {
+		t.Fatalf("Failed to stat recording: %v", err)
+	} else if runtime.GOOS != "windows" && info.Mode().Perm() != 0o600 {
+		t.Errorf("Recording mode = %v, want -rw-------", info.Mode().Perm())
+	}
+
+	entries := readRecordEntries(t, recordPath)
+	if len(entries) != 4 {
+		t.Fatalf("Expected 4 recorded entries, got %d: %v", len(entries), entries)
+	}
+	if entries[0].Direction != RecordDirectionStdin || entries[0].Data != `{"type":"user"}` {
+		t.Errorf("Expected stdin write first, got %+v", entries[0])
+	}
+
+	replay := NewReplayTransport(recordPath)
+	if err := replay.Connect(ctx); err != nil {
+		t.Fatalf("ReplayTransport.Connect() error = %v", err)
+	}
+	defer func() {
+		_ = replay.Close(ctx)
+	}()
+
+	var replayed []string
+	for msg := range replay.ReadMessages(ctx) {
+		replayed = append(replayed, msg.Type())
+	}
+
+	if len(replayed) != len(recorded) {
+		t.Fatalf("Replayed %v, recorded %v", replayed, recorded)
+	}
+	for i := range recorded {
+		if replayed[i] != recorded[i] {
+			t.Errorf("Message %d: replayed %s, recorded %s", i, replayed[i], recorded[i])
+		}
+	}
+}
+
+func TestReplayTransport_PartialJSON(t *testing.T) {
+	recordPath := filepath.Join(t.TempDir(), "session.jsonl")
+	file, err := os.Create(recordPath)
+	if err != nil {
+		t.Fatalf("Failed to create recording: %v", err)
+	}
+	encoder := json.NewEncoder(file)
+	for _, entry := range []RecordEntry{
+		{Direction: RecordDirectionStdout, Data: `{"type":"result",`},
+		{Direction: RecordDirectionStdout, Data: `"subtype":"success","session_id":"test"}`},
+	} {
+		if err := encoder.Encode(entry); err != nil {
+			t.Fatalf("Failed to write recording: %v", err)
+		}
+	}
+	_ = file.Close()
+
+	replay := NewReplayTransport(recordPath)
+	if err := replay.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	var messages []types.Message
+	for msg := range replay.ReadMessages(context.Background()) {
+		messages = append(messages, msg)
+	}
+	if len(messages) != 1 || messages[0].Type() != types.MessageTypeResult {
+		t.Errorf("Expected a single result message, got %v", messages)
+	}
+}
+
+func TestReplayTransport_ImplementsTransport(t *testing.T) {
+	var _ Transport = NewReplayTransport("session.jsonl")
+}
+
+func TestReplayTransport_MissingFile(t *testing.T) {
+	replay := NewReplayTransport(filepath.Join(t.TempDir(), "missing.jsonl"))
+	if err := replay.Connect(context.Background()); err == nil {
+		t.Error("Expected error for missing recording")
+	}
+}
+
+// readRecordEntries reads all entries of a recording file
+func readRecordEntries(t *testing.T, path string) []RecordEntry {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open recording: %v", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	var entries []RecordEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry RecordEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Invalid recording entry: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
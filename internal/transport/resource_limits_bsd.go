@@ -0,0 +1,29 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package transport
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+
+	"github.com/anthropics/claude-agent-sdk-go/internal/types"
+)
+
+// checkResourceLimits reports whether limits can be applied on this platform
+func checkResourceLimits(limits *types.ResourceLimits) error {
+	if limits.MaxMemoryBytes > 0 || limits.MaxCPUTime > 0 {
+		return fmt.Errorf("memory and CPU limits are not supported on %s", runtime.GOOS)
+	}
+	return nil
+}
+
+// applyResourceLimits applies limits to the running process pid
+func applyResourceLimits(pid int, limits *types.ResourceLimits) error {
+	if limits.Niceness > 0 {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, pid, limits.Niceness); err != nil {
+			return fmt.Errorf("failed to set process niceness: %w", err)
+		}
+	}
+	return nil
+}
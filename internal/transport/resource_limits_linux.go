@@ -0,0 +1,81 @@
+//go:build linux
+
+package transport
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/anthropics/claude-agent-sdk-go/internal/types"
+)
+
+// checkResourceLimits reports whether limits can be applied on this platform
+func checkResourceLimits(limits *types.ResourceLimits) error {
+	return nil
+}
+
+// applyResourceLimits applies limits to the running process pid
+func applyResourceLimits(pid int, limits *types.ResourceLimits) error {
+	if limits.MaxMemoryBytes > 0 {
+		if err := prlimit(pid, syscall.RLIMIT_DATA, limits.MaxMemoryBytes); err != nil {
+			return fmt.Errorf("failed to set memory limit: %w", err)
+		}
+	}
+
+	if limits.MaxCPUTime > 0 {
+		seconds := uint64((limits.MaxCPUTime + time.Second - 1) / time.Second)
+		if err := prlimit(pid, syscall.RLIMIT_CPU, seconds); err != nil {
+			return fmt.Errorf("failed to set CPU time limit: %w", err)
+		}
+	}
+
+	if limits.Niceness > 0 {
+		if err := setNiceness(pid, limits.Niceness); err != nil {
+			return fmt.Errorf("failed to set process niceness: %w", err)
+		}
+	}
+	return nil
+}
+
+// rlimit64 is the argument of the prlimit64 system call
+type rlimit64 struct {
+	cur uint64
+	max uint64
+}
+
+// prlimit sets both the soft and hard limit of resource for process pid
+func prlimit(pid, resource int, limit uint64) error {
+	rlimit := rlimit64{cur: limit, max: limit}
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(resource), uintptr(unsafe.Pointer(&rlimit)), 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// setNiceness sets the niceness of every thread of process pid. Linux applies
+// priorities per thread; threads started later inherit the main thread's, so
+// it is set first.
+func setNiceness(pid, niceness int) error {
+	if err := syscall.Setpriority(syscall.PRIO_PROCESS, pid, niceness); err != nil {
+		return err
+	}
+
+	tasks, err := os.ReadDir(fmt.Sprintf("/proc/%d/task", pid))
+	if err != nil {
+		return nil // The main thread is set; /proc may not be mounted
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil || tid == pid {
+			continue
+		}
+		// A thread may exit in the meantime
+		_ = syscall.Setpriority(syscall.PRIO_PROCESS, tid, niceness)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+//go:build linux
+
+package transport
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anthropics/claude-agent-sdk-go/internal/types"
+)
+
+func TestSubprocessCLITransport_ResourceLimits(t *testing.T) {
+	// Limits are applied just after the process starts, so the script waits
+	// before reporting its own
+	mockScript := `#!/bin/bash
+sleep 0.3
+nice=$(cut -d' ' -f19 /proc/$$/stat)
+data=$(awk '/Max data size/ {print $4}' /proc/$$/limits)
+cpu=$(awk '/Max cpu time/ {print $4}' /proc/$$/limits)
+echo '{"type":"system","subtype":"init","data":{"nice":"'"$nice"'","data":"'"$data"'","cpu":"'"$cpu"'"}}'
+echo '{"type":"result","subtype":"success","session_id":"test"}'
+`
+	cliPath := createMockCLI(t, mockScript)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+	t.Setenv("CLAUDE_AGENT_SDK_SKIP_VERSION_CHECK", "1")
+
+	options := types.NewClaudeAgentOptions().
+		WithResourceLimits(types.ResourceLimits{
+			Niceness:       5,
+			MaxMemoryBytes: 1 << 30,
+			MaxCPUTime:     1500 * time.Millisecond,
+		})
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = cliPath
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := transport.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect to mock CLI: %v", err)
+	}
+	defer func() {
+		_ = transport.Close(ctx)
+	}()
+
+	var init *types.SystemMessage
+	for msg := range transport.ReadMessages(ctx) {
+		if system, ok := msg.(*types.SystemMessage); ok {
+			init = system
+		}
+	}
+	if init == nil {
+		t.Fatal("Expected an init message")
+	}
+
+	want := map[string]string{"nice": "5", "data": "1073741824", "cpu": "2"}
+	for key, value := range want {
+		if init.Data[key] != value {
+			t.Errorf("%s = %v, want %s", key, init.Data[key], value)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package transport
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/anthropics/claude-agent-sdk-go/internal/types"
+)
+
+// checkResourceLimits reports whether limits can be applied on this platform
+func checkResourceLimits(limits *types.ResourceLimits) error {
+	if *limits != (types.ResourceLimits{}) {
+		return fmt.Errorf("process resource limits are not supported on %s", runtime.GOOS)
+	}
+	return nil
+}
+
+// applyResourceLimits applies limits to the running process pid
+func applyResourceLimits(pid int, limits *types.ResourceLimits) error {
+	return nil
+}
@@ -0,0 +1,49 @@
+package transport
+
+import "sync"
+
+// DefaultStderrBufferLines is the number of trailing stderr lines kept for crash reports
+const DefaultStderrBufferLines = 100
+
+// stderrBuffer is a fixed-size ring buffer holding the most recent stderr lines
+type stderrBuffer struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+	full  bool
+}
+
+// newStderrBuffer creates a ring buffer holding up to size lines
+func newStderrBuffer(size int) *stderrBuffer {
+	return &stderrBuffer{lines: make([]string, size)}
+}
+
+// Add appends a line, evicting the oldest one when the buffer is full
+func (b *stderrBuffer) Add(line string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.lines) == 0 {
+		return
+	}
+
+	b.lines[b.next] = line
+	b.next = (b.next + 1) % len(b.lines)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// Lines returns the buffered lines, oldest first
+func (b *stderrBuffer) Lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.full {
+		return append([]string(nil), b.lines[:b.next]...)
+	}
+
+	lines := make([]string, 0, len(b.lines))
+	lines = append(lines, b.lines[b.next:]...)
+	return append(lines, b.lines[:b.next]...)
+}
@@ -0,0 +1,63 @@
+package transport
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStderrBuffer(t *testing.T) {
+	tests := []struct {
+		name  string
+		size  int
+		lines []string
+		want  []string
+	}{
+		{
+			name:  "empty",
+			size:  3,
+			lines: nil,
+			want:  nil,
+		},
+		{
+			name:  "partially filled",
+			size:  3,
+			lines: []string{"a", "b"},
+			want:  []string{"a", "b"},
+		},
+		{
+			name:  "exactly full",
+			size:  3,
+			lines: []string{"a", "b", "c"},
+			want:  []string{"a", "b", "c"},
+		},
+		{
+			name:  "wrapped",
+			size:  3,
+			lines: []string{"a", "b", "c", "d", "e"},
+			want:  []string{"c", "d", "e"},
+		},
+		{
+			name:  "disabled",
+			size:  0,
+			lines: []string{"a"},
+			want:  nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buffer := newStderrBuffer(tt.size)
+			for _, line := range tt.lines {
+				buffer.Add(line)
+			}
+
+			got := buffer.Lines()
+			if len(got) == 0 && len(tt.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Lines() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -4,11 +4,16 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -30,17 +35,46 @@ const (
 
 	// CLICodeEntrypoint is the entrypoint identifier for the CLI
 	CLICodeEntrypoint = "sdk-go"
+
+	// debugFlag is the CLI flag, without dashes, that writes debug logs to stderr
+	debugFlag = "debug-to-stderr"
+)
+
+// Close reasons reported by CloseReason
+const (
+	// CloseReasonResult means the session ended after a result message
+	CloseReasonResult = "result"
+
+	// CloseReasonContextCancelled means the session was closed because its context was cancelled
+	CloseReasonContextCancelled = "context_cancelled"
+
+	// CloseReasonProcessExited means the process exited cleanly without a final result
+	CloseReasonProcessExited = "process_exited"
+
+	// CloseReasonProcessCrashed means the process exited with a non-zero code
+	CloseReasonProcessCrashed = "process_crashed"
+
+	// CloseReasonBufferExceeded means output was dropped for exceeding the maximum buffer size
+	CloseReasonBufferExceeded = "buffer_exceeded"
+
+	// CloseReasonClosed means the caller closed the transport
+	CloseReasonClosed = "closed"
+
+	// CloseReasonHeartbeatTimeout means the process was killed after producing
+	// no output for the heartbeat timeout
+	CloseReasonHeartbeatTimeout = "heartbeat_timeout"
 )
 
 // SubprocessCLITransport implements Transport using Claude Code CLI subprocess
 type SubprocessCLITransport struct {
 	// Configuration
-	prompt        string                    // The prompt to send
-	options       *types.ClaudeAgentOptions // Transport options
-	isStreaming   bool                      // Whether we're in streaming mode
-	cliPath       string                    // Path to Claude CLI
-	cwd           string                    // Working directory
-	maxBufferSize int                       // Maximum buffer size
+	prompt        string                       // The prompt to send
+	options       *types.ClaudeAgentOptions    // Transport options
+	isStreaming   bool                         // Whether we're in streaming mode
+	cliPath       string                       // Path to Claude CLI
+	cwd           string                       // Working directory
+	maxBufferSize int                          // Maximum buffer size
+	mcpHeaders    map[string]map[string]string // Headers resolved from MCP header providers
 
 	// Process management
 	cmd    *exec.Cmd          // The subprocess command
@@ -51,13 +85,41 @@ type SubprocessCLITransport struct {
 	stderr io.ReadCloser      // stderr pipe
 
 	// Stream management
-	stdoutReader *bufio.Scanner // Buffered stdout reader
-	stdinWriter  *bufio.Writer  // Buffered stdin writer
+	stdoutReader *bufio.Reader // Buffered stdout reader
+	stdinWriter  *bufio.Writer // Buffered stdin writer
 
 	// State
-	ready     bool         // Whether transport is ready
-	mu        sync.RWMutex // Mutex for thread safety
-	exitError error        // Error that caused process exit
+	state       connState    // Lifecycle state, guarding against reconnecting a closed transport
+	ready       bool         // Whether transport is ready
+	inputClosed bool         // Whether EndInput closed stdin
+	mu          sync.RWMutex // Mutex for thread safety
+	exitError   error        // Error that caused process exit
+	lastResult  bool         // Whether the last received message was a result
+	lastWrite   time.Time    // When stdin was last written, for keepalives
+	sessionID   string       // Most recent session ID seen in a message
+	reapOnce    sync.Once    // Ensures the process is waited for exactly once
+	started     bool         // Whether the init message was received
+	protocol    int          // Protocol version from the init message, if newer than supported
+
+	// releaseSession releases the resumed session claimed in the
+	// SessionRegistry, if any
+	releaseSession func()
+
+	// Whether partial messages were seen and checked; used only by the reader
+	sawStreamEvent  bool
+	partialsChecked bool
+
+	// Whether the context window threshold is crossed; used only by the reader
+	contextCrossed bool
+
+	// Close reason, recorded once when the session ends
+	closeReason string
+	closeErr    error
+	done        chan struct{} // Closed once the close reason is recorded
+	initialized chan struct{} // Closed when the init message is received
+
+	// Error from the most recent result message, if it was flagged as an error
+	resultErr error
 
 	// Message handling
 	messageChan chan types.Message // Channel for outgoing messages
@@ -66,10 +128,47 @@ type SubprocessCLITransport struct {
 	// Stderr handling
 	stderrCallback func(string)  // Callback for stderr output
 	stderrDone     chan struct{} // Channel to signal stderr handling done
+	stderrBuffer   *stderrBuffer // Trailing stderr lines for crash reports (nil if disabled)
+
+	// Session recording (nil unless RecordTo is set)
+	recorder *recorder
+
+	// Rebuilds streamed blocks for BlockCallback (nil unless it is set)
+	blockAssembler *types.BlockAssembler
+
+	// Detects a silently hung CLI (nil unless HeartbeatTimeout is set)
+	heartbeat *heartbeat
 }
 
-// NewSubprocessCLITransport creates a new SubprocessCLITransport
+// connState is the lifecycle state of a transport. A transport moves from
+// new to connected to closed and is not reused after Close.
+type connState int
+
+const (
+	stateNew connState = iota
+	stateConnected
+	stateClosed
+)
+
+// NewSubprocessCLITransport creates a new SubprocessCLITransport. It keeps a
+// snapshot of options, so changes the caller makes afterwards do not affect
+// the command run at Connect.
 func NewSubprocessCLITransport(prompt string, options *types.ClaudeAgentOptions) *SubprocessCLITransport {
+	options = options.Clone()
+
+	// The CLI flag passed as an extra argument is the older way of enabling
+	// debug logging; fold it into Debug so the flag is passed once
+	if _, ok := options.ExtraArgs[debugFlag]; ok {
+		delete(options.ExtraArgs, debugFlag)
+		options.Debug = true
+	}
+
+	// A terminal's SIGINT reaches the whole foreground process group; the CLI
+	// needs a group of its own for the signal handler to interrupt it instead
+	if options.SignalHandling && processGroupsSupported {
+		options.ProcessGroup = true
+	}
+
 	// Create a cancellable context
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -98,6 +197,21 @@ func NewSubprocessCLITransport(prompt string, options *types.ClaudeAgentOptions)
 		maxBufferSize = *options.MaxBufferSize
 	}
 
+	// Set stderr buffer lines
+	stderrBufferLines := DefaultStderrBufferLines
+	if options.StderrBufferLines != nil {
+		stderrBufferLines = *options.StderrBufferLines
+	}
+	var stderrBuf *stderrBuffer
+	if stderrBufferLines > 0 {
+		stderrBuf = newStderrBuffer(stderrBufferLines)
+	}
+
+	var blockAssembler *types.BlockAssembler
+	if options.BlockCallback != nil {
+		blockAssembler = types.NewBlockAssembler()
+	}
+
 	return &SubprocessCLITransport{
 		prompt:         prompt,
 		options:        options,
@@ -107,38 +221,111 @@ func NewSubprocessCLITransport(prompt string, options *types.ClaudeAgentOptions)
 		maxBufferSize:  maxBufferSize,
 		ctx:            ctx,
 		cancel:         cancel,
-		messageChan:    make(chan types.Message, 100), // Buffered channel
-		errorChan:      make(chan error, 10),          // Buffered channel for errors
+		messageChan:    make(chan types.Message, options.GetMessageChannelBuffer()),
+		errorChan:      make(chan error, 10), // Buffered channel for errors
 		stderrCallback: options.StderrCallback,
 		stderrDone:     make(chan struct{}),
+		stderrBuffer:   stderrBuf,
+		done:           make(chan struct{}),
+		initialized:    make(chan struct{}),
+		blockAssembler: blockAssembler,
 	}
 }
 
+// cliFallback is the command name findCLI returns when it finds no CLI; Connect
+// resolves it on PATH again
+const cliFallback = "claude"
+
 // findCLI finds the Claude Code CLI binary in common locations
 func findCLI() string {
 	// First check PATH
-	if cli, err := exec.LookPath("claude"); err == nil {
+	if cli, err := exec.LookPath(cliFallback); err == nil {
 		return cli
 	}
 
 	// Check common installation locations
+	for _, path := range cliLocations() {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+
+	// If not found, this will be caught during connect()
+	return cliFallback
+}
+
+// cliLocations returns the common installation locations of the CLI checked
+// after PATH
+func cliLocations() []string {
 	homeDir, _ := os.UserHomeDir()
-	locations := []string{
+	return []string{
 		filepath.Join(homeDir, ".npm-global", "bin", "claude"),
 		"/usr/local/bin/claude",
 		filepath.Join(homeDir, ".local", "bin", "claude"),
 		filepath.Join(homeDir, "node_modules", ".bin", "claude"),
 		filepath.Join(homeDir, ".yarn", "bin", "claude"),
 	}
+}
+
+// resolveFallbackCLI looks up the CLI on PATH again when no CLI path was
+// configured and none was found at construction, since it may have been
+// installed since. It reports every location checked if there is still none.
+func (t *SubprocessCLITransport) resolveFallbackCLI() error {
+	if t.options.CLIPath != nil || t.cliPath != cliFallback {
+		return nil
+	}
 
-	for _, path := range locations {
+	if cli, err := exec.LookPath(cliFallback); err == nil {
+		t.cliPath = cli
+		return nil
+	}
+	for _, path := range cliLocations() {
 		if _, err := os.Stat(path); err == nil {
-			return path
+			t.cliPath = path
+			return nil
 		}
 	}
 
-	// If not found, this will be caught during connect()
-	return "claude" // Default to "claude" to trigger proper error during connect
+	checked := "  $PATH (" + os.Getenv("PATH") + ")\n  " + strings.Join(cliLocations(), "\n  ")
+	return types.NewCLINotFoundError(
+		fmt.Sprintf("Claude Code not found on PATH or in any known location. Checked:\n%s\n\nInstall with: npm install -g @anthropic-ai/claude-code\n\nOr provide the path via ClaudeAgentOptions.WithCLIPath()", checked),
+		exec.ErrNotFound,
+	)
+}
+
+// DryRunCommand returns the command line Connect would run, without starting
+// the process. Dynamic MCP headers are resolved only on Connect, so servers
+// using them appear with their static headers.
+func (t *SubprocessCLITransport) DryRunCommand() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.buildCommand()
+}
+
+// permissionWarning returns the warning to log when the session runs in
+// bypassPermissions mode, or an empty string otherwise
+func (t *SubprocessCLITransport) permissionWarning() string {
+	if t.options.PermissionMode == nil || *t.options.PermissionMode != types.PermissionModeBypassPermission {
+		return ""
+	}
+	if t.options.DangerouslyBypassPermissions {
+		return "WARNING: all permission checks are bypassed for this session (WithDangerouslyBypassAllPermissions); every tool call runs without approval"
+	}
+	return "WARNING: all permission checks are bypassed for this session; every tool call runs without approval. Use WithDangerouslyBypassAllPermissions to make this choice explicit"
+}
+
+// sdkIdentity returns the entrypoint and version identifiers passed to the
+// CLI, with the application's user agent appended to the SDK's own
+func (t *SubprocessCLITransport) sdkIdentity() (entrypoint, version string) {
+	entrypoint, version = CLICodeEntrypoint, ClaudeAgentSDKVersion
+	if name := t.options.UserAgentName; name != "" {
+		entrypoint += " " + name
+		version += " " + name
+		if t.options.UserAgentVersion != "" {
+			version += "/" + t.options.UserAgentVersion
+		}
+	}
+	return entrypoint, version
 }
 
 // buildCommand builds the CLI command with appropriate arguments
@@ -159,14 +346,16 @@ func (t *SubprocessCLITransport) buildCommand() []string {
 		}
 	}
 
-	// Allowed tools
-	if len(t.options.AllowedTools) > 0 {
-		cmd = append(cmd, "--allowedTools", strings.Join(t.options.AllowedTools, ","))
+	// Tools are passed one per flag so commas inside patterns such as
+	// Bash(cmd1,cmd2) are never split into separate tools
+	for _, tool := range t.options.AllowedTools {
+		cmd = append(cmd, "--allowedTools", tool)
 	}
-
-	// Disallowed tools
-	if len(t.options.DisallowedTools) > 0 {
-		cmd = append(cmd, "--disallowedTools", strings.Join(t.options.DisallowedTools, ","))
+	for _, pattern := range t.options.AllowedToolPatterns {
+		cmd = append(cmd, "--allowedTools", pattern.String())
+	}
+	for _, tool := range t.options.DisallowedTools {
+		cmd = append(cmd, "--disallowedTools", tool)
 	}
 
 	// Max turns
@@ -179,6 +368,18 @@ func (t *SubprocessCLITransport) buildCommand() []string {
 		cmd = append(cmd, "--model", *t.options.Model)
 	}
 
+	// Extended thinking budget
+	if t.options.MaxThinkingTokens != nil {
+		cmd = append(cmd, "--max-thinking-tokens", strconv.Itoa(*t.options.MaxThinkingTokens))
+	}
+
+	// Structured output schema
+	if t.options.OutputSchema != nil {
+		if schemaJSON, err := json.Marshal(t.options.OutputSchema); err == nil {
+			cmd = append(cmd, "--json-schema", string(schemaJSON))
+		}
+	}
+
 	// Permission prompt tool name
 	if t.options.PermissionPromptToolName != nil {
 		cmd = append(cmd, "--permission-prompt-tool", *t.options.PermissionPromptToolName)
@@ -198,21 +399,32 @@ func (t *SubprocessCLITransport) buildCommand() []string {
 	if t.options.Resume != nil {
 		cmd = append(cmd, "--resume", *t.options.Resume)
 	}
+	if t.options.ResumeSessionAt != nil {
+		cmd = append(cmd, "--resume-session-at", *t.options.ResumeSessionAt)
+	}
 
 	// Settings file
 	if t.options.Settings != nil {
 		cmd = append(cmd, "--settings", *t.options.Settings)
 	}
 
-	// Additional directories
-	for _, dir := range t.options.AddDirs {
-		cmd = append(cmd, "--add-dir", dir)
+	// Additional directories, including trusted workspace directories
+	addDirs := t.options.AddDirs
+	if t.options.WorkspaceTrust != nil {
+		addDirs = append(append([]string(nil), addDirs...), t.options.WorkspaceTrust.Trusted...)
+	}
+	seenDirs := make(map[string]bool, len(addDirs))
+	for _, dir := range addDirs {
+		if !seenDirs[dir] {
+			seenDirs[dir] = true
+			cmd = append(cmd, "--add-dir", dir)
+		}
 	}
 
 	// MCP servers
-	if len(t.options.MCPServers) > 0 {
+	if len(t.options.MCPServers) > 0 || t.options.PermissionPromptHandler != nil {
 		mcpConfig := map[string]interface{}{
-			"mcpServers": t.options.MCPServers,
+			"mcpServers": t.mcpServersWithHeaders(),
 		}
 		if configJSON, err := json.Marshal(mcpConfig); err == nil {
 			cmd = append(cmd, "--mcp-config", string(configJSON))
@@ -229,6 +441,11 @@ func (t *SubprocessCLITransport) buildCommand() []string {
 		cmd = append(cmd, "--fork-session")
 	}
 
+	// Echo user input on the output stream
+	if t.options.ReplayUserMessages {
+		cmd = append(cmd, "--replay-user-messages")
+	}
+
 	// Agents
 	if len(t.options.Agents) > 0 {
 		if agentsJSON, err := json.Marshal(t.options.Agents); err == nil {
@@ -243,10 +460,23 @@ func (t *SubprocessCLITransport) buildCommand() []string {
 			sources[i] = string(source)
 		}
 		cmd = append(cmd, "--setting-sources", strings.Join(sources, ","))
+	} else if t.options.NoSettingSources {
+		cmd = append(cmd, "--setting-sources", "")
+	}
+
+	// Debug logging
+	if t.options.Debug {
+		cmd = append(cmd, "--"+debugFlag)
 	}
 
-	// Extra arguments
-	for key, value := range t.options.ExtraArgs {
+	// Extra arguments, sorted so the command is deterministic
+	extraKeys := make([]string, 0, len(t.options.ExtraArgs))
+	for key := range t.options.ExtraArgs {
+		extraKeys = append(extraKeys, key)
+	}
+	sort.Strings(extraKeys)
+	for _, key := range extraKeys {
+		value := t.options.ExtraArgs[key]
 		if value == nil {
 			// Boolean flag without value
 			cmd = append(cmd, "--"+key)
@@ -256,6 +486,9 @@ func (t *SubprocessCLITransport) buildCommand() []string {
 		}
 	}
 
+	// Raw arguments, verbatim and in order
+	cmd = append(cmd, t.options.RawArgs...)
+
 	// User
 	if t.options.User != nil {
 		cmd = append(cmd, "--user", *t.options.User)
@@ -273,13 +506,153 @@ func (t *SubprocessCLITransport) buildCommand() []string {
 	return cmd
 }
 
-// Connect starts the subprocess and prepares for communication
+// resolveMCPHeaders evaluates the header providers of all configured MCP servers
+func (t *SubprocessCLITransport) resolveMCPHeaders() error {
+	t.mcpHeaders = nil
+	for name, config := range t.options.MCPServers {
+		if config.HeadersProvider == nil {
+			continue
+		}
+
+		headers, err := config.HeadersProvider()
+		if err != nil {
+			return types.NewCLIConnectionError(fmt.Sprintf("failed to resolve headers for MCP server %q", name), err)
+		}
+
+		if t.mcpHeaders == nil {
+			t.mcpHeaders = make(map[string]map[string]string)
+		}
+		t.mcpHeaders[name] = headers
+	}
+	return nil
+}
+
+// processExitError describes an unsuccessful exit, including the terminating
+// signal when the process was killed
+func processExitError(state *os.ProcessState) *types.ProcessError {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		exitError := types.NewProcessError(
+			fmt.Sprintf("Claude Code process terminated by signal %d (%s)", int(status.Signal()), status.Signal()),
+			fmt.Errorf("signal: %s", status.Signal()),
+		)
+		exitError.ExitCode = -1
+		exitError.Signal = status.Signal()
+		return exitError
+	}
+
+	exitError := types.NewProcessError(
+		fmt.Sprintf("Claude Code process exited with code %d", state.ExitCode()),
+		fmt.Errorf("exit code %d", state.ExitCode()),
+	)
+	exitError.ExitCode = state.ExitCode()
+	return exitError
+}
+
+// environment returns the user-provided environment: Env merged over any env files
+func (t *SubprocessCLITransport) environment() (map[string]string, error) {
+	env := make(map[string]string, len(t.options.Env))
+	for _, path := range t.options.EnvFiles {
+		fileEnv, err := types.LoadEnvFile(path)
+		if err != nil {
+			return nil, types.NewCLIConnectionError("failed to load env file", err)
+		}
+		for k, v := range fileEnv {
+			env[k] = v
+		}
+	}
+	for k, v := range t.options.Env {
+		env[k] = v
+	}
+	return env, nil
+}
+
+// essentialEnv lists the variables passed on with CleanEnv even when not
+// allowlisted, as the CLI needs them to find programs, its configuration and
+// a temporary directory
+var essentialEnv = []string{
+	"PATH", "HOME", "USER", "LOGNAME", "SHELL", "TMPDIR", "LANG", "LC_ALL",
+	// Windows
+	"SYSTEMROOT", "COMSPEC", "PATHEXT", "USERPROFILE", "APPDATA", "LOCALAPPDATA", "TEMP", "TMP",
+}
+
+// parentEnvironment returns the variables of the SDK's own environment to pass
+// on to the CLI: all of them, or with CleanEnv only the essential and
+// allowlisted ones
+func (t *SubprocessCLITransport) parentEnvironment() []string {
+	parent := os.Environ()
+	if !t.options.CleanEnv {
+		return parent
+	}
+
+	allowed := make(map[string]bool, len(essentialEnv)+len(t.options.EnvAllowlist))
+	for _, key := range essentialEnv {
+		allowed[envKey(key)] = true
+	}
+	for _, key := range t.options.EnvAllowlist {
+		allowed[envKey(key)] = true
+	}
+
+	kept := make([]string, 0, len(allowed))
+	for _, entry := range parent {
+		if key, _, ok := strings.Cut(entry, "="); ok && allowed[envKey(key)] {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// envKey normalizes an environment variable name for comparison; names are
+// case-insensitive on Windows
+func envKey(key string) string {
+	if runtime.GOOS == "windows" {
+		return strings.ToUpper(key)
+	}
+	return key
+}
+
+// mcpServersWithHeaders returns the MCP server configuration with resolved headers applied
+func (t *SubprocessCLITransport) mcpServersWithHeaders() map[string]types.MCPServerConfig {
+	servers := make(map[string]types.MCPServerConfig, len(t.options.MCPServers))
+	for name, config := range t.options.MCPServers {
+		if resolved, ok := t.mcpHeaders[name]; ok {
+			headers := make(map[string]string, len(config.Headers)+len(resolved))
+			for k, v := range config.Headers {
+				headers[k] = v
+			}
+			for k, v := range resolved {
+				headers[k] = v
+			}
+			config.Headers = headers
+		}
+		servers[name] = config
+	}
+	if t.options.PermissionPromptHandler != nil {
+		servers[types.PermissionPromptServerName] = types.MCPServerConfig{
+			Type: types.MCPServerTypeSDK,
+			Name: types.PermissionPromptServerName,
+		}
+	}
+	return servers
+}
+
+// Connect starts the subprocess and prepares for communication. Connecting an
+// already connected transport is a no-op; connecting a closed transport
+// returns a CLIConnectionError.
 func (t *SubprocessCLITransport) Connect(ctx context.Context) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	if t.cmd != nil {
+	switch t.state {
+	case stateConnected:
 		return nil // Already connected
+	case stateClosed:
+		err := types.NewCLIConnectionError("transport is closed; create a new transport to reconnect", nil)
+		err.Kind = types.ErrClosed
+		return err
+	}
+
+	if err := t.resolveFallbackCLI(); err != nil {
+		return err
 	}
 
 	// Validate CLI path exists
@@ -299,23 +672,68 @@ func (t *SubprocessCLITransport) Connect(ctx context.Context) error {
 		}
 	}
 
+	if warning := t.permissionWarning(); warning != "" {
+		fmt.Fprintln(os.Stderr, warning)
+	}
+
+	// Resolve dynamic MCP headers so refreshed tokens are used on every connect
+	if err := t.resolveMCPHeaders(); err != nil {
+		return err
+	}
+
+	if t.options.ResourceLimits != nil {
+		if err := checkResourceLimits(t.options.ResourceLimits); err != nil {
+			return types.NewCLIConnectionError("invalid resource limits", err)
+		}
+	}
+
+	// Load env files before the command exists so a bad file leaves the transport reusable
+	env, err := t.environment()
+	if err != nil {
+		return err
+	}
+
+	// Claim the resumed session, releasing it again if the process fails to start
+	if err := t.claimSession(); err != nil {
+		return err
+	}
+	defer func() {
+		if t.state != stateConnected {
+			t.releaseSessionLocked()
+		}
+	}()
+
 	// Build command
 	cmdArgs := t.buildCommand()
-	t.cmd = exec.CommandContext(t.ctx, cmdArgs[0], cmdArgs[1:]...)
+	cmd := exec.CommandContext(t.ctx, cmdArgs[0], cmdArgs[1:]...)
+
+	// Cancelling the context asks the CLI to exit; os/exec kills it if it is
+	// still running closeGracePeriod later
+	cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
+	cmd.WaitDelay = closeGracePeriod
+	if t.options.ProcessGroup {
+		if err := startInProcessGroup(cmd); err != nil {
+			return types.NewCLIConnectionError("failed to configure process group", err)
+		}
+		cmd.Cancel = func() error { return signalProcessGroup(cmd.Process.Pid, syscall.SIGTERM) }
+	}
+	t.cmd = cmd
 
 	// Set up environment
-	processEnv := make([]string, 0, len(os.Environ())+len(t.options.Env)+2)
-	processEnv = append(processEnv, os.Environ()...)
+	parentEnv := t.parentEnvironment()
+	processEnv := make([]string, 0, len(parentEnv)+len(env)+2)
+	processEnv = append(processEnv, parentEnv...)
 
 	// Add user-provided environment variables
-	for k, v := range t.options.Env {
+	for k, v := range env {
 		processEnv = append(processEnv, fmt.Sprintf("%s=%s", k, v))
 	}
 
 	// Add SDK-specific environment variables
+	entrypoint, version := t.sdkIdentity()
 	processEnv = append(processEnv,
-		fmt.Sprintf("CLAUDE_CODE_ENTRYPOINT=%s", CLICodeEntrypoint),
-		fmt.Sprintf("CLAUDE_AGENT_SDK_VERSION=%s", ClaudeAgentSDKVersion),
+		fmt.Sprintf("CLAUDE_CODE_ENTRYPOINT=%s", entrypoint),
+		fmt.Sprintf("CLAUDE_AGENT_SDK_VERSION=%s", version),
 	)
 
 	// Set working directory PWD if different from current
@@ -327,45 +745,82 @@ func (t *SubprocessCLITransport) Connect(ctx context.Context) error {
 	t.cmd.Dir = t.cwd
 
 	// Set up pipes
-	var err error
 	t.stdin, err = t.cmd.StdinPipe()
 	if err != nil {
 		return types.NewCLIConnectionError("failed to create stdin pipe", err)
 	}
 
-	t.stdout, err = t.cmd.StdoutPipe()
+	// stdout and stderr use plain pipes rather than StdoutPipe and StderrPipe,
+	// whose read ends Wait closes: they must stay open until drained, and the
+	// process is only reaped after that. The child's ends are closed here once
+	// the process has inherited them.
+	var childEnds []*os.File
+	defer func() {
+		for _, f := range childEnds {
+			_ = f.Close()
+		}
+	}()
+
+	stdoutRead, stdoutWrite, err := os.Pipe()
 	if err != nil {
 		_ = t.stdin.Close()
 		return types.NewCLIConnectionError("failed to create stdout pipe", err)
 	}
+	t.stdout = stdoutRead
+	t.cmd.Stdout = stdoutWrite
+	childEnds = append(childEnds, stdoutWrite)
 
-	// Pipe stderr if we have a callback or debug mode is enabled
-	shouldPipeStderr := t.stderrCallback != nil
-	for key := range t.options.ExtraArgs {
-		if key == "debug-to-stderr" {
-			shouldPipeStderr = true
-			break
-		}
-	}
+	// Pipe stderr if we have a callback, capture crash output or debug mode is enabled
+	shouldPipeStderr := t.stderrCallback != nil || t.options.StderrWriter != nil || t.stderrBuffer != nil || t.options.Debug
 
 	if shouldPipeStderr {
-		t.stderr, err = t.cmd.StderrPipe()
+		stderrRead, stderrWrite, err := os.Pipe()
 		if err != nil {
 			_ = t.stdin.Close()
 			_ = t.stdout.Close()
 			return types.NewCLIConnectionError("failed to create stderr pipe", err)
 		}
+		t.stderr = stderrRead
+		t.cmd.Stderr = stderrWrite
+		childEnds = append(childEnds, stderrWrite)
+	}
+
+	// Open the session recording
+	if t.options.RecordTo != nil {
+		t.recorder, err = newRecorder(*t.options.RecordTo)
+		if err != nil {
+			t.cleanupPipes()
+			return types.NewCLIConnectionError("failed to open recording file", err)
+		}
 	}
 
 	// Start the process
 	if err := t.cmd.Start(); err != nil {
 		t.cleanupPipes()
+		t.closeRecorder()
 		return types.NewCLIConnectionError(fmt.Sprintf("failed to start Claude Code: %v", err), err)
 	}
+	t.recordCounter(types.MetricSubprocessStarts, nil)
+
+	// Limits are applied as soon as the process runs; os/exec cannot set them
+	// between fork and exec
+	if t.options.ResourceLimits != nil {
+		if err := applyResourceLimits(t.cmd.Process.Pid, t.options.ResourceLimits); err != nil {
+			_ = t.cmd.Process.Kill()
+			t.reap(t.cmd)
+			t.cleanupPipes()
+			t.closeRecorder()
+			return types.NewCLIConnectionError("failed to apply resource limits", err)
+		}
+	}
 
 	// Set up buffered I/O
-	t.stdoutReader = bufio.NewScanner(t.stdout)
-	t.stdinWriter = bufio.NewWriter(t.stdin)
+	t.stdoutReader = bufio.NewReaderSize(t.stdout, 64*1024)
+	if t.options.StdinBufferSize != nil && *t.options.StdinBufferSize > 0 {
+		t.stdinWriter = bufio.NewWriterSize(t.stdin, *t.options.StdinBufferSize)
+	} else {
+		t.stdinWriter = bufio.NewWriter(t.stdin)
+	}
 
 	// Start message reading loop
 	go t.messageReaderLoop()
@@ -375,15 +830,53 @@ func (t *SubprocessCLITransport) Connect(ctx context.Context) error {
 		go t.stderrHandler()
 	}
 
+	if t.options.KeepaliveInterval != nil && t.isStreaming {
+		go t.keepaliveLoop(*t.options.KeepaliveInterval)
+	}
+
+	if t.options.HeartbeatTimeout != nil {
+		t.heartbeat = newHeartbeat(*t.options.HeartbeatTimeout)
+		if !t.isStreaming {
+			t.heartbeat.StartTurn()
+		}
+		go t.heartbeatLoop(t.heartbeat)
+	}
+
 	// Close stdin immediately for non-streaming mode
 	if !t.isStreaming {
 		_ = t.stdin.Close()
 	}
 
+	t.state = stateConnected
 	t.ready = true
 	return nil
 }
 
+// claimSession claims the session being resumed in the SessionRegistry, if
+// one is configured. Forked sessions get a new ID and need no claim.
+func (t *SubprocessCLITransport) claimSession() error {
+	registry := t.options.SessionRegistry
+	if registry == nil || t.options.Resume == nil || t.options.ForkSession {
+		return nil
+	}
+
+	release, err := registry.Claim(*t.options.Resume)
+	if err != nil {
+		return err
+	}
+	t.releaseSession = release
+	return nil
+}
+
+// releaseSessionLocked releases the claimed session, if any; the caller must
+// hold t.mu
+func (t *SubprocessCLITransport) releaseSessionLocked() {
+	if t.releaseSession != nil {
+		t.releaseSession()
+		t.releaseSession = nil
+	}
+}
+
 // checkClaudeVersion checks if the Claude Code CLI meets minimum version requirements
 func (t *SubprocessCLITransport) checkClaudeVersion(ctx context.Context) error {
 	// Create a context with timeout for version check
@@ -455,30 +948,51 @@ func (t *SubprocessCLITransport) compareVersions(v1, v2 string) int {
 func (t *SubprocessCLITransport) messageReaderLoop() {
 	defer close(t.messageChan)
 
-	// Get reader and ready state atomically
+	// The ready flag is not checked: a write may already have failed and
+	// cleared it, and the process must still be reaped to report why
 	t.mu.Lock()
 	reader := t.stdoutReader
-	ready := t.ready
+	stdout := t.stdout
+	heartbeat := t.heartbeat
 	t.mu.Unlock()
 
-	if !ready || reader == nil {
+	if reader == nil {
 		return
 	}
+	defer func() {
+		_ = stdout.Close()
+	}()
 
 	jsonBuffer := ""
+	var bufferErr error
+	var readErr error
 
-	// Configure scanner to handle long lines
-	buf := make([]byte, 0, 64*1024)  // 64KB initial buffer
-	reader.Buffer(buf, 10*1024*1024) // 10MB max token size
+	// Lines are read whole with no size ceiling; only the buffer and message
+	// size policies below limit what is accepted
+	for eof := false; !eof; {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			eof = true
+			if !errors.Is(err, io.EOF) {
+				readErr = types.NewCLIConnectionError("error reading from stdout", err)
+				t.OnError(readErr)
+			}
+			if line == "" {
+				continue
+			}
+		}
 
-	for reader.Scan() {
 		select {
 		case <-t.ctx.Done():
 			return
 		default:
 		}
 
-		line := reader.Text()
+		line = strings.TrimSuffix(line, "\n")
+		t.record(RecordDirectionStdout, line)
+		if heartbeat != nil {
+			heartbeat.Output()
+		}
 		if strings.TrimSpace(line) == "" {
 			continue
 		}
@@ -499,12 +1013,28 @@ func (t *SubprocessCLITransport) messageReaderLoop() {
 			maxBufferSize := t.maxBufferSize
 			t.mu.RUnlock()
 
-			// Check buffer size
-			if len(jsonBuffer) > maxBufferSize {
-				t.OnError(types.NewJSONDecodeError(
+			// A buffer over the message size limit can only complete into an oversized message
+			if limit := t.options.MaxMessageSize; limit != nil && len(jsonBuffer) > *limit {
+				t.recordCounter(types.MetricParseErrors, map[string]string{"reason": "message_too_large"})
+				tooLarge := types.NewMessageTooLargeError(
+					fmt.Sprintf("message exceeded maximum message size of %d bytes", *limit),
+					nil,
+				)
+				tooLarge.Size = len(jsonBuffer)
+				tooLarge.Limit = *limit
+				t.OnError(tooLarge)
+				jsonBuffer = ""
+				continue
+			}
+
+			// Check buffer size; oversized tool results may be spilled to disk instead
+			if len(jsonBuffer) > maxBufferSize && !t.canSpill(jsonBuffer) {
+				t.recordCounter(types.MetricParseErrors, map[string]string{"reason": "buffer_overflow"})
+				bufferErr = types.NewJSONDecodeError(
 					fmt.Sprintf("JSON message exceeded maximum buffer size of %d bytes", maxBufferSize),
 					fmt.Errorf("buffer size %d exceeds limit %d", len(jsonBuffer), maxBufferSize),
-				))
+				)
+				t.OnError(bufferErr)
 				jsonBuffer = ""
 				continue
 			}
@@ -512,15 +1042,69 @@ func (t *SubprocessCLITransport) messageReaderLoop() {
 			// Try to parse JSON
 			var data map[string]interface{}
 			if err := json.Unmarshal([]byte(jsonBuffer), &data); err == nil {
+				if sessionID := sessionIDFrom(data); sessionID != "" {
+					t.mu.Lock()
+					t.sessionID = sessionID
+					t.mu.Unlock()
+				}
+
 				// Successfully parsed, convert to Message and send
 				if message, err := t.parseMessage(data); err == nil {
+					t.recordMessage(message)
+					if heartbeat != nil {
+						heartbeat.Received(message)
+					}
+
+					if !t.acceptStreamEvent(message) {
+						jsonBuffer = ""
+						continue
+					}
+
+					if user, ok := message.(*types.UserMessage); ok {
+						if err := t.spillToolResults(user); err != nil {
+							t.OnError(err)
+						}
+					}
+
+					if system, ok := message.(*types.SystemMessage); ok && system.Subtype == types.SystemSubtypeInit {
+						t.checkProtocolVersion(system)
+						t.notifySessionStart(system)
+					}
+
+					if event, ok := message.(*types.StreamEvent); ok && t.blockAssembler != nil {
+						if block, ok, err := t.blockAssembler.Add(event); err != nil {
+							t.OnError(err)
+						} else if ok {
+							t.invokeCallback("BlockCallback", func() { t.options.BlockCallback(block) })
+						}
+					}
+
+					if assistant, ok := message.(*types.AssistantMessage); ok && t.options.ContextWindowCallback != nil {
+						t.checkContextWindow(assistant)
+					}
+
+					if event, ok := message.(*types.StreamEvent); ok && t.options.PartialTextCallback != nil {
+						if delta, ok := event.TextDelta(); ok {
+							t.invokeCallback("PartialTextCallback", func() { t.options.PartialTextCallback(delta) })
+						}
+					}
+
+					result, isResult := message.(*types.ResultMessage)
+					t.mu.Lock()
+					t.lastResult = isResult
+					if isResult {
+						t.resultErr = result.ExecutionError()
+					}
+					t.mu.Unlock()
+
 					select {
 					case t.messageChan <- message:
 					case <-t.ctx.Done():
 						return
 					}
 				} else {
-					t.OnError(err)
+					t.recordCounter(types.MetricParseErrors, map[string]string{"reason": "invalid_message"})
+					t.OnError(t.protocolParseError(err))
 				}
 				jsonBuffer = ""
 			}
@@ -528,23 +1112,20 @@ func (t *SubprocessCLITransport) messageReaderLoop() {
 		}
 	}
 
-	// Check for scanner errors
-	if err := reader.Err(); err != nil {
-		t.OnError(types.NewCLIConnectionError("error reading from stdout", err))
-	}
-
 	// Wait for process to complete and check exit code (with proper synchronization)
 	t.mu.Lock()
 	cmd := t.cmd
 	t.mu.Unlock()
 
 	if cmd != nil && cmd.Process != nil {
-		state, err := cmd.Process.Wait()
-		if err == nil && state.ExitCode() != 0 {
-			exitError := types.NewProcessError(
-				fmt.Sprintf("Claude Code process exited with code %d", state.ExitCode()),
-				fmt.Errorf("exit code %d", state.ExitCode()),
-			)
+		state := t.reap(cmd)
+		if t.ctx.Err() != nil {
+			// Close ended the session and recorded why
+			return
+		}
+		if state != nil && !state.Success() {
+			exitError := processExitError(state)
+			exitError.Stderr = t.capturedStderr()
 
 			// Set exitError atomically
 			t.mu.Lock()
@@ -552,17 +1133,415 @@ func (t *SubprocessCLITransport) messageReaderLoop() {
 			t.mu.Unlock()
 
 			t.OnError(exitError)
+			t.setCloseReason(CloseReasonProcessCrashed, exitError)
+			return
+		}
+	}
+
+	t.mu.RLock()
+	lastResult := t.lastResult
+	t.mu.RUnlock()
+
+	switch {
+	case bufferErr != nil && !lastResult:
+		t.setCloseReason(CloseReasonBufferExceeded, bufferErr)
+	case lastResult:
+		t.setCloseReason(CloseReasonResult, nil)
+	default:
+		t.setCloseReason(CloseReasonProcessExited, readErr)
+	}
+}
+
+// sessionIDFrom extracts the session ID from a raw message, looking at the
+// top level first and then at the data of system messages
+func sessionIDFrom(data map[string]interface{}) string {
+	if sessionID, ok := data["session_id"].(string); ok && sessionID != "" {
+		return sessionID
+	}
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if sessionID, ok := nested["session_id"].(string); ok {
+			return sessionID
+		}
+	}
+	return ""
+}
+
+// SessionID returns the most recent session ID seen in any message, or an
+// empty string if none has been received yet. It is safe for concurrent use.
+func (t *SubprocessCLITransport) SessionID() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.sessionID
+}
+
+// acceptStreamEvent reports whether message should be delivered. Stream events
+// are dropped with a warning when partial messages are disabled, in case the
+// CLI sends them anyway; when they are enabled but the first assistant message
+// arrives without any, a warning says the CLI ignored the option.
+func (t *SubprocessCLITransport) acceptStreamEvent(message types.Message) bool {
+	switch message.(type) {
+	case *types.StreamEvent:
+		if t.options.IncludePartialMessages {
+			t.sawStreamEvent = true
+			return true
+		}
+		if !t.partialsChecked {
+			t.partialsChecked = true
+			fmt.Fprintln(os.Stderr, "Warning: Claude Code sent stream events although partial messages are disabled; dropping them")
+		}
+		return false
+
+	case *types.AssistantMessage:
+		if t.options.IncludePartialMessages && !t.sawStreamEvent && !t.partialsChecked {
+			t.partialsChecked = true
+			fmt.Fprintln(os.Stderr, "Warning: partial messages are enabled but Claude Code sent no stream events; this CLI version may not support --include-partial-messages")
+		}
+	}
+	return true
+}
+
+// checkContextWindow invokes ContextWindowCallback when the context used by
+// the main agent crosses the threshold
+func (t *SubprocessCLITransport) checkContextWindow(msg *types.AssistantMessage) {
+	if msg.ParentToolUseID != nil || msg.Usage == nil {
+		return
+	}
+
+	model := msg.Model
+	if model == "" && t.options.Model != nil {
+		model = *t.options.Model
+	}
+	budget := types.EstimateContextBudget(model, msg.Usage)
+
+	crossed := budget.Fraction() >= t.options.ContextWindowThreshold
+	if crossed && !t.contextCrossed {
+		t.invokeCallback("ContextWindowCallback", func() { t.options.ContextWindowCallback(budget) })
+	}
+	t.contextCrossed = crossed
+}
+
+// checkProtocolVersion warns when the CLI speaks a stream-json protocol
+// version outside the supported range
+func (t *SubprocessCLITransport) checkProtocolVersion(init *types.SystemMessage) {
+	version, _ := init.ProtocolVersion()
+	switch {
+	case version > types.MaxProtocolVersion:
+		t.mu.Lock()
+		t.protocol = version
+		t.mu.Unlock()
+		fmt.Fprintf(os.Stderr, "Warning: Claude Code speaks stream-json protocol version %d, but this SDK supports versions %d to %d. Messages may fail to parse; upgrade the Agent SDK.\n", version, types.MinProtocolVersion, types.MaxProtocolVersion)
+	case version < types.MinProtocolVersion:
+		fmt.Fprintf(os.Stderr, "Warning: Claude Code speaks stream-json protocol version %d, but this SDK supports versions %d to %d. Some features may not work correctly; upgrade Claude Code.\n", version, types.MinProtocolVersion, types.MaxProtocolVersion)
+	}
+}
+
+// protocolParseError explains a parse failure caused by a newer protocol
+// version, which would otherwise look like a malformed message
+func (t *SubprocessCLITransport) protocolParseError(err error) error {
+	t.mu.RLock()
+	version := t.protocol
+	t.mu.RUnlock()
+
+	if version == 0 {
+		return err
+	}
+	return types.NewMessageParseError(fmt.Sprintf("failed to parse message from CLI speaking unsupported protocol version %d (supported: %d to %d)", version, types.MinProtocolVersion, types.MaxProtocolVersion), err)
+}
+
+// notifySessionStart marks the session initialized and invokes
+// OnSessionStart for the first init message
+func (t *SubprocessCLITransport) notifySessionStart(init *types.SystemMessage) {
+	t.mu.Lock()
+	first := !t.started
+	t.started = true
+	t.mu.Unlock()
+
+	if !first {
+		return
+	}
+	close(t.initialized)
+
+	if t.options.OnSessionStart != nil {
+		sessionID, _ := init.Data["session_id"].(string)
+		model, _ := init.Data["model"].(string)
+		t.invokeCallback("OnSessionStart", func() { t.options.OnSessionStart(sessionID, model) })
+	}
+}
+
+// invokeCallback runs a user callback from the reader loop, recovering
+// from a panic so it cannot crash the process or stop reading. The panic
+// is reported on the error channel.
+func (t *SubprocessCLITransport) invokeCallback(name string, callback func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(os.Stderr, "Warning: recovered from panic in %s: %v\n%s", name, r, debug.Stack())
+			t.OnError(types.NewControlProtocolError(fmt.Sprintf("%s panicked: %v", name, r), nil))
 		}
+	}()
+
+	callback()
+}
+
+// ConnectWithReadyTimeout connects like Connect, then waits up to timeout
+// for the CLI's init message, after which the session is ready for use. If
+// the process exits or the timeout elapses first, the transport is closed
+// and an error is returned; a timeout matches ErrReadyTimeout.
+func (t *SubprocessCLITransport) ConnectWithReadyTimeout(ctx context.Context, timeout time.Duration) error {
+	if err := t.Connect(ctx); err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-t.initialized:
+		return nil
+	case <-t.done:
+		_, closeErr := t.CloseReason()
+		err = types.NewCLIConnectionError("process exited before the session was initialized", closeErr)
+	case <-timer.C:
+		timeoutErr := types.NewCLIConnectionError(fmt.Sprintf("no init message within %v", timeout), nil)
+		timeoutErr.Kind = types.ErrReadyTimeout
+		err = timeoutErr
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	_ = t.Close(context.Background())
+	return err
+}
+
+// setCloseReason records why the session ended; only the first reason is kept
+func (t *SubprocessCLITransport) setCloseReason(reason string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.setCloseReasonLocked(reason, err)
+}
+
+// setCloseReasonLocked records the close reason; the caller must hold t.mu
+func (t *SubprocessCLITransport) setCloseReasonLocked(reason string, err error) {
+	if t.closeReason == "" {
+		t.closeReason = reason
+		t.closeErr = err
+		close(t.done)
+	}
+}
+
+// Wait blocks until the session ends. It returns nil if the session completed
+// cleanly, or the terminal error: a process or read error, an error-flagged
+// final result, or the cancellation that closed the session. If the CLI exits
+// without producing a result, a ProcessError is returned.
+func (t *SubprocessCLITransport) Wait(ctx context.Context) error {
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	switch {
+	case t.closeErr != nil:
+		return t.closeErr
+	case t.closeReason == CloseReasonProcessExited:
+		return types.NewProcessError("process exited without a result message", nil)
+	default:
+		return t.resultErr
 	}
 }
 
+// CloseReason reports why the session ended and the associated error, if any.
+// It returns an empty reason while the session is still running.
+func (t *SubprocessCLITransport) CloseReason() (reason string, err error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.closeReason, t.closeErr
+}
+
 // parseMessage parses a generic map into a typed Message
 func (t *SubprocessCLITransport) parseMessage(data map[string]interface{}) (types.Message, error) {
 	// Convert to JSON and use existing unmarshaler
-	if jsonData, err := json.Marshal(data); err == nil {
-		return types.UnmarshalMessage(jsonData)
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, types.NewMessageParseError("failed to parse message", nil)
+	}
+
+	message, err := types.UnmarshalMessage(jsonData)
+	if unknown, ok := message.(*types.UnknownMessage); ok && t.options.StrictMessageTypes {
+		return nil, types.NewMessageParseError("unknown message type: "+unknown.Type(), nil)
+	}
+	return message, err
+}
+
+// keepaliveLoop writes a blank line whenever stdin has been idle for interval.
+// Checks are jittered by up to 10% so concurrent sessions do not write in lockstep.
+// The write happens under the transport lock, so it never splits a message.
+func (t *SubprocessCLITransport) keepaliveLoop(interval time.Duration) {
+	jittered := func() time.Duration {
+		return interval - interval/10 + time.Duration(rand.Int63n(int64(interval/5)+1))
+	}
+
+	timer := time.NewTimer(jittered())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-t.ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		t.mu.Lock()
+		if t.ready && t.stdinWriter != nil && time.Since(t.lastWrite) >= interval-interval/10 {
+			// Failures surface on the next Write
+			if err := t.stdinWriter.WriteByte('\n'); err == nil {
+				_ = t.stdinWriter.Flush()
+			}
+			t.lastWrite = time.Now()
+		}
+		t.mu.Unlock()
+
+		timer.Reset(jittered())
+	}
+}
+
+// heartbeatLoop reports a silently hung CLI, checking several times per
+// timeout, and kills it if HeartbeatKill is set
+func (t *SubprocessCLITransport) heartbeatLoop(h *heartbeat) {
+	interval := h.timeout / 4
+	if interval <= 0 {
+		interval = h.timeout
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.ctx.Done():
+			return
+		case <-t.done:
+			return
+		case now := <-ticker.C:
+			if !h.Check(now) {
+				continue
+			}
+		}
+
+		stalled := types.NewProcessError(
+			fmt.Sprintf("Claude Code produced no output for %s during a turn", h.timeout),
+			types.ErrHeartbeatTimeout,
+		)
+		if t.stderrBuffer != nil {
+			stalled.Stderr = t.stderrBuffer.Lines()
+		}
+
+		// The lock keeps Close from closing the error channel meanwhile
+		t.mu.Lock()
+		if t.state == stateClosed {
+			t.mu.Unlock()
+			return
+		}
+		t.OnError(stalled)
+		if !t.options.HeartbeatKill {
+			t.mu.Unlock()
+			continue
+		}
+
+		t.setCloseReasonLocked(CloseReasonHeartbeatTimeout, stalled)
+		if t.cmd != nil && t.cmd.Process != nil {
+			_ = t.cmd.Process.Kill()
+			if t.options.ProcessGroup {
+				_ = signalProcessGroup(t.cmd.Process.Pid, syscall.SIGKILL)
+			}
+		}
+		t.mu.Unlock()
+		return
+	}
+}
+
+// canSpill reports whether an oversized buffer is a complete message carrying
+// tool results that can be spilled to disk
+func (t *SubprocessCLITransport) canSpill(jsonBuffer string) bool {
+	return t.options.ToolResultSpillThreshold != nil &&
+		strings.Contains(jsonBuffer, `"tool_result"`) &&
+		json.Valid([]byte(jsonBuffer))
+}
+
+// spillToolResults writes tool result content above the spill threshold to
+// files and replaces it with ToolResultFile references
+func (t *SubprocessCLITransport) spillToolResults(msg *types.UserMessage) error {
+	if t.options.ToolResultSpillThreshold == nil {
+		return nil
+	}
+	blocks, ok := msg.Content.([]types.ContentBlock)
+	if !ok {
+		return nil
+	}
+
+	dir := ""
+	if t.options.ToolResultSpillDir != nil {
+		dir = *t.options.ToolResultSpillDir
+	}
+
+	for _, block := range blocks {
+		result, ok := block.(*types.ToolResultBlock)
+		if !ok {
+			continue
+		}
+
+		var data []byte
+		if text, ok := result.Content.(string); ok {
+			data = []byte(text)
+		} else {
+			encoded, err := json.Marshal(result.Content)
+			if err != nil {
+				return types.NewJSONDecodeError("failed to encode tool result content", err)
+			}
+			data = encoded
+		}
+		if len(data) <= *t.options.ToolResultSpillThreshold {
+			continue
+		}
+
+		file, err := os.CreateTemp(dir, "claude-tool-result-*")
+		if err != nil {
+			return types.NewCLIConnectionError("failed to create tool result file", err)
+		}
+		_, err = file.Write(data)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			_ = os.Remove(file.Name())
+			return types.NewCLIConnectionError("failed to write tool result file", err)
+		}
+
+		result.Content = &types.ToolResultFile{Path: file.Name(), Size: len(data)}
+	}
+	return nil
+}
+
+// recordMessage reports metrics for a successfully parsed message
+func (t *SubprocessCLITransport) recordMessage(message types.Message) {
+	t.recordCounter(types.MetricMessagesReceived, map[string]string{"type": message.Type()})
+
+	if result, ok := message.(*types.ResultMessage); ok && t.options.Metrics != nil {
+		t.options.Metrics.ObserveDuration(
+			types.MetricTurnDuration,
+			time.Duration(result.DurationMS)*time.Millisecond,
+			map[string]string{"subtype": result.Subtype},
+		)
+	}
+}
+
+// recordCounter increments a counter on the configured metrics collector
+func (t *SubprocessCLITransport) recordCounter(name string, labels map[string]string) {
+	if t.options.Metrics != nil {
+		t.options.Metrics.IncCounter(name, labels)
 	}
-	return nil, types.NewMessageParseError("failed to parse message", nil)
 }
 
 // stderrHandler handles stderr output
@@ -581,60 +1560,215 @@ func (t *SubprocessCLITransport) stderrHandler() {
 		default:
 		}
 
+		if w := t.options.StderrWriter; w != nil {
+			raw := scanner.Bytes()
+			_, _ = w.Write(append(append(make([]byte, 0, len(raw)+1), raw...), '\n'))
+		}
+
 		line := strings.TrimSpace(scanner.Text())
-		if line != "" && t.stderrCallback != nil {
+		if line == "" {
+			continue
+		}
+		if t.stderrBuffer != nil {
+			t.stderrBuffer.Add(line)
+		}
+		if t.stderrCallback != nil {
 			t.stderrCallback(line)
 		}
 	}
 }
 
+// capturedStderr returns the trailing stderr lines once the stderr handler has
+// drained the pipe, or whatever was captured if it does not finish in time
+func (t *SubprocessCLITransport) capturedStderr() []string {
+	if t.stderrBuffer == nil {
+		return nil
+	}
+
+	t.mu.RLock()
+	piped := t.stderr != nil
+	t.mu.RUnlock()
+
+	if piped {
+		select {
+		case <-t.stderrDone:
+		case <-time.After(time.Second):
+		}
+	}
+	return t.stderrBuffer.Lines()
+}
+
+// closeGracePeriod is how long Close waits for the CLI to exit after SIGTERM
+// before killing it
+var closeGracePeriod = 5 * time.Second
+
+// reap waits for the process to exit and returns its state. It waits only
+// once however many callers ask, which also releases the goroutine os/exec
+// uses to watch the transport context.
+func (t *SubprocessCLITransport) reap(cmd *exec.Cmd) *os.ProcessState {
+	t.reapOnce.Do(func() {
+		_ = cmd.Wait()
+	})
+	return cmd.ProcessState
+}
+
+// exitErrorGrace bounds how long a failed write waits for the process to be
+// reaped so the exit reason can be reported instead of the pipe error
+const exitErrorGrace = 500 * time.Millisecond
+
 // Write writes data to the transport
 func (t *SubprocessCLITransport) Write(ctx context.Context, data string) error {
+	return t.write(ctx, data, true)
+}
+
+// WriteRaw writes data to stdin verbatim, without the newline that frames
+// each message. It is an escape hatch for CLI features the SDK does not model:
+// data bypasses all validation, so a malformed or partial message corrupts the
+// stream. For advanced use only.
+func (t *SubprocessCLITransport) WriteRaw(ctx context.Context, data []byte) error {
+	return t.write(ctx, string(data), false)
+}
+
+// write writes data to stdin, followed by a newline if framed
+func (t *SubprocessCLITransport) write(ctx context.Context, data string, framed bool) error {
+	// Exclusive lock: writes share the buffered stdin writer and may update state
+	t.mu.Lock()
+	pipeFailed, err := t.writeLocked(data, framed)
+	t.mu.Unlock()
+
+	if !pipeFailed {
+		return err
+	}
+
+	// A broken pipe usually means the process died; the reader loop is reaping
+	// it concurrently, and its exit error explains the failure better
+	if exitErr := t.awaitExitError(ctx); exitErr != nil {
+		return types.NewCLIConnectionError(
+			fmt.Sprintf("cannot write to process that exited with error: %v", exitErr),
+			exitErr,
+		)
+	}
+	return err
+}
+
+// awaitExitError waits briefly for the session to end and returns the
+// process exit error, if the process failed
+func (t *SubprocessCLITransport) awaitExitError(ctx context.Context) *types.ProcessError {
+	timer := time.NewTimer(exitErrorGrace)
+	defer timer.Stop()
+
+	select {
+	case <-t.done:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
 	t.mu.RLock()
 	defer t.mu.RUnlock()
+	exitErr, _ := t.exitError.(*types.ProcessError)
+	return exitErr
+}
 
+// writeLocked writes a message to stdin, followed by a newline if framed; the
+// caller must hold t.mu. pipeFailed reports whether the write itself failed,
+// as opposed to being refused.
+func (t *SubprocessCLITransport) writeLocked(data string, framed bool) (pipeFailed bool, err error) {
 	if !t.ready || t.stdinWriter == nil {
-		return types.NewCLIConnectionError("transport is not ready for writing", nil)
+		return false, t.notWritableLocked()
 	}
 
 	if t.cmd != nil && t.cmd.ProcessState != nil && t.cmd.ProcessState.Exited() {
-		return types.NewCLIConnectionError(
+		return false, types.NewCLIConnectionError(
 			fmt.Sprintf("cannot write to terminated process (exit code: %d)", t.cmd.ProcessState.ExitCode()),
 			nil,
 		)
 	}
 
 	if t.exitError != nil {
-		return types.NewCLIConnectionError(
+		return false, types.NewCLIConnectionError(
 			fmt.Sprintf("cannot write to process that exited with error: %v", t.exitError),
 			t.exitError,
 		)
 	}
 
-	// Write with newline
-	if _, err := t.stdinWriter.WriteString(data + "\n"); err != nil {
+	t.record(RecordDirectionStdin, data)
+	t.lastWrite = time.Now()
+	if t.heartbeat != nil {
+		t.heartbeat.Sent(data)
+	}
+
+	// Write the payload and newline separately to avoid concatenating per message
+	_, err = t.stdinWriter.WriteString(data)
+	if err == nil && framed {
+		err = t.stdinWriter.WriteByte('\n')
+	}
+	if err != nil {
 		t.ready = false
 		writeErr := types.NewCLIConnectionError("failed to write to stdin", err)
 		t.exitError = writeErr
-		return writeErr
+		return true, writeErr
 	}
 
 	// Flush to ensure data is sent
+	if err := t.flushLocked(); err != nil {
+		return true, err
+	}
+
+	return false, nil
+}
+
+// Flush sends any data buffered for stdin to the CLI without ending input.
+// Write already flushes each message, so this only matters for data written
+// by other means.
+func (t *SubprocessCLITransport) Flush(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.ready || t.stdinWriter == nil {
+		return t.notWritableLocked()
+	}
+	return t.flushLocked()
+}
+
+// flushLocked flushes the buffered stdin writer; the caller must hold t.mu.
+// A failed flush leaves the transport unusable.
+func (t *SubprocessCLITransport) flushLocked() error {
 	if err := t.stdinWriter.Flush(); err != nil {
 		t.ready = false
 		flushErr := types.NewCLIConnectionError("failed to flush stdin", err)
 		t.exitError = flushErr
 		return flushErr
 	}
-
 	return nil
 }
 
+// notWritableLocked returns the error for a write to stdin while it cannot
+// be written, tagged with the reason; the caller must hold t.mu
+func (t *SubprocessCLITransport) notWritableLocked() error {
+	err := types.NewCLIConnectionError("transport is not ready for writing", nil)
+	switch {
+	case t.state == stateClosed:
+		err.Kind = types.ErrClosed
+	case t.inputClosed:
+		err.Kind = types.ErrInputClosed
+	default:
+		err.Kind = types.ErrNotConnected
+	}
+	return err
+}
+
 // ReadMessages returns a channel for reading messages
 func (t *SubprocessCLITransport) ReadMessages(ctx context.Context) <-chan types.Message {
 	return t.messageChan
 }
 
+// Errors returns the channel of errors reported while reading, such as
+// messages that could not be parsed. Reading continues after each error; the
+// channel is closed by Close. Errors are dropped while the channel is full.
+func (t *SubprocessCLITransport) Errors() <-chan error {
+	return t.errorChan
+}
+
 // OnError handles errors from the transport
 func (t *SubprocessCLITransport) OnError(err error) {
 	select {
@@ -657,6 +1791,7 @@ func (t *SubprocessCLITransport) EndInput(ctx context.Context) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
+	t.inputClosed = true
 	if t.stdinWriter != nil {
 		_ = t.stdinWriter.Flush()
 		t.stdinWriter = nil
@@ -672,17 +1807,38 @@ func (t *SubprocessCLITransport) EndInput(ctx context.Context) error {
 	return nil
 }
 
-// Close closes the transport and cleans up resources
+// Close closes the transport and cleans up resources. A closed transport
+// cannot be connected again.
 func (t *SubprocessCLITransport) Close(ctx context.Context) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	if !t.ready {
+	previous := t.state
+	t.state = stateClosed
+	if previous == stateNew {
+		t.setCloseReasonLocked(CloseReasonClosed, nil)
+		t.cancel()
+		return nil
+	}
+	t.releaseSessionLocked()
+
+	// A failed write leaves the transport connected but not ready; its
+	// process and goroutines still need stopping
+	if previous != stateConnected {
 		return nil
 	}
 
 	t.ready = false
 
+	switch {
+	case ctx.Err() != nil:
+		t.setCloseReasonLocked(CloseReasonContextCancelled, ctx.Err())
+	case t.lastResult:
+		t.setCloseReasonLocked(CloseReasonResult, nil)
+	default:
+		t.setCloseReasonLocked(CloseReasonClosed, nil)
+	}
+
 	// Cancel context to stop all goroutines
 	t.cancel()
 
@@ -696,51 +1852,59 @@ func (t *SubprocessCLITransport) Close(ctx context.Context) error {
 		t.stdin = nil
 	}
 
-	// Wait for stderr handler to finish
+	// Wait for the process to exit. Cancelling the context sent it SIGTERM,
+	// and os/exec kills it if it is still running after closeGracePeriod.
+	if t.cmd != nil && t.cmd.Process != nil {
+		t.reap(t.cmd)
+
+		// Kill processes the CLI left behind in its group, such as MCP servers
+		if t.options.ProcessGroup {
+			_ = signalProcessGroup(t.cmd.Process.Pid, syscall.SIGKILL)
+		}
+	}
+
+	// Stop the stderr handler; closing the pipe unblocks it even if a child
+	// process still holds the write end open
 	if t.stderr != nil {
+		_ = t.stderr.Close()
 		select {
 		case <-t.stderrDone:
 		case <-time.After(5 * time.Second):
 			// Timeout waiting for stderr handler
 		}
-		_ = t.stderr.Close()
 		t.stderr = nil
 	}
 
-	// Terminate process if still running
-	if t.cmd != nil && t.cmd.Process != nil {
-		if t.cmd.ProcessState == nil || !t.cmd.ProcessState.Exited() {
-			// Try graceful termination first
-			if err := t.cmd.Process.Signal(syscall.SIGTERM); err == nil {
-				// Wait a bit for graceful termination
-				done := make(chan error, 1)
-				go func() {
-					_, err := t.cmd.Process.Wait()
-					done <- err
-				}()
-
-				select {
-				case <-done:
-					// Process terminated gracefully
-				case <-time.After(5 * time.Second):
-					// Force kill if timeout
-					_ = t.cmd.Process.Kill()
-				}
-			}
-		}
-	}
-
 	// Clean up
 	t.cmd = nil
 	t.stdoutReader = nil
 	t.exitError = nil
 
+	t.closeRecorder()
+
 	// Close channels
 	close(t.errorChan)
 
 	return nil
 }
 
+// record appends traffic to the session recording, if enabled
+func (t *SubprocessCLITransport) record(direction, data string) {
+	if t.recorder == nil {
+		return
+	}
+	if err := t.recorder.Record(direction, data); err != nil {
+		t.OnError(types.NewCLIConnectionError("failed to write session recording", err))
+	}
+}
+
+// closeRecorder closes the session recording, if enabled
+func (t *SubprocessCLITransport) closeRecorder() {
+	if t.recorder != nil {
+		_ = t.recorder.Close()
+	}
+}
+
 // cleanupPipes cleans up standard I/O pipes
 func (t *SubprocessCLITransport) cleanupPipes() {
 	if t.stdin != nil {
@@ -1,12 +1,19 @@
 package transport
 
 import (
+	"bufio"
 	"context"
 	"errors"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -84,8 +91,8 @@ func TestSubprocessCLITransport_BuildCommand(t *testing.T) {
 		"--output-format", "stream-json",
 		"--verbose",
 		"--model", "claude-3-sonnet-20240229",
-		"--allowedTools", "tool1,tool2",
-		"--disallowedTools", "bad_tool",
+		"--allowedTools tool1 --allowedTools tool2",
+		"--disallowedTools bad_tool",
 		"--max-turns", "5",
 		"--permission-mode", "default",
 		"--continue",
@@ -99,6 +106,312 @@ func TestSubprocessCLITransport_BuildCommand(t *testing.T) {
 	}
 }
 
+func TestSubprocessCLITransport_Debug(t *testing.T) {
+	tests := []struct {
+		name    string
+		options *types.ClaudeAgentOptions
+		want    bool
+	}{
+		{name: "off", options: types.NewClaudeAgentOptions(), want: false},
+		{name: "WithDebug", options: types.NewClaudeAgentOptions().WithDebug(true), want: true},
+		{name: "extra arg", options: types.NewClaudeAgentOptions().WithExtraArg("debug-to-stderr", nil), want: true},
+		{name: "both", options: types.NewClaudeAgentOptions().WithDebug(true).WithExtraArg("debug-to-stderr", nil), want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := NewSubprocessCLITransport("test", tt.options)
+			transport.cliPath = "/path/to/claude"
+
+			count := 0
+			for _, arg := range transport.buildCommand() {
+				if arg == "--debug-to-stderr" {
+					count++
+				}
+			}
+			if tt.want && count != 1 {
+				t.Errorf("Expected --debug-to-stderr once, got %d times", count)
+			}
+			if !tt.want && count != 0 {
+				t.Errorf("Expected no --debug-to-stderr, got %d", count)
+			}
+			if transport.options.Debug != tt.want {
+				t.Errorf("Debug = %v, want %v", transport.options.Debug, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubprocessCLITransport_DebugPipesStderr(t *testing.T) {
+	cliPath := createMockCLI(t, `#!/bin/bash
+echo '{"type":"system","subtype":"init","session_id":"test"}'
+`)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+
+	transport := NewSubprocessCLITransport("test", types.NewClaudeAgentOptions().WithDebug(true))
+	transport.cliPath = cliPath
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := transport.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect to mock CLI: %v", err)
+	}
+	defer func() {
+		_ = transport.Close(ctx)
+	}()
+
+	transport.mu.RLock()
+	piped := transport.stderr != nil
+	transport.mu.RUnlock()
+	if !piped {
+		t.Error("Expected stderr to be piped in debug mode")
+	}
+}
+
+func TestSubprocessCLITransport_BuildCommand_OutputSchema(t *testing.T) {
+	options := types.NewClaudeAgentOptions().
+		WithOutputSchema(map[string]any{"type": "object", "required": []string{"answer"}})
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = "/path/to/claude"
+
+	values := flagValues(transport.buildCommand(), "--json-schema")
+	want := `{"required":["answer"],"type":"object"}`
+	if len(values) != 1 || values[0] != want {
+		t.Errorf("--json-schema values = %v, want [%s]", values, want)
+	}
+}
+
+func TestSubprocessCLITransport_BuildCommand_WorkspaceTrust(t *testing.T) {
+	options := types.NewClaudeAgentOptions().
+		WithAddDirs("/shared").
+		WithWorkspaceTrust(types.WorkspaceTrust{
+			Trusted:   []string{"/repo", "/shared"},
+			Untrusted: []string{"/repo/vendor"},
+		})
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = "/path/to/claude"
+
+	got := flagValues(transport.buildCommand(), "--add-dir")
+	want := []string{"/shared", "/repo"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("--add-dir values = %v, want %v", got, want)
+	}
+}
+
+func TestSubprocessCLITransport_BuildCommand_PermissionPromptHandler(t *testing.T) {
+	options := types.NewClaudeAgentOptions().
+		WithPermissionPromptHandler(func(string, map[string]any, interface{}) (types.PermissionResult, error) {
+			return types.PermissionResult{Behavior: types.PermissionBehaviorAllow}, nil
+		})
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = "/path/to/claude"
+	cmd := transport.buildCommand()
+
+	if got := flagValues(cmd, "--permission-prompt-tool"); !reflect.DeepEqual(got, []string{types.PermissionPromptToolName}) {
+		t.Errorf("--permission-prompt-tool values = %v, want [%s]", got, types.PermissionPromptToolName)
+	}
+	configs := flagValues(cmd, "--mcp-config")
+	want := `{"mcpServers":{"sdk_permission_prompt":{"type":"sdk","name":"sdk_permission_prompt"}}}`
+	if len(configs) != 1 || configs[0] != want {
+		t.Errorf("--mcp-config values = %v, want [%s]", configs, want)
+	}
+}
+
+func TestSubprocessCLITransport_MessageChannelBuffer(t *testing.T) {
+	transport := NewSubprocessCLITransport("test", types.NewClaudeAgentOptions().WithMessageChannelBuffer(7))
+	if got := cap(transport.messageChan); got != 7 {
+		t.Errorf("message channel capacity = %d, want 7", got)
+	}
+}
+
+// flagValues returns every value passed for flag in cmd
+func flagValues(cmd []string, flag string) []string {
+	var values []string
+	for i, arg := range cmd {
+		if arg == flag && i+1 < len(cmd) {
+			values = append(values, cmd[i+1])
+		}
+	}
+	return values
+}
+
+func TestSubprocessCLITransport_BuildCommand_WithAllowedToolPatterns(t *testing.T) {
+	options := types.NewClaudeAgentOptions().
+		WithAllowedTools("Read").
+		WithAllowedToolPatterns(
+			types.ToolPattern{Name: "Bash", Pattern: "git:*"},
+			types.ToolPattern{Name: "Edit", Pattern: "/src/**"},
+		)
+
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = "claude"
+	cmd := transport.buildCommand()
+
+	got := flagValues(cmd, "--allowedTools")
+	want := []string{"Read", "Bash(git:*)", "Edit(/src/**)"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("--allowedTools values = %v, want %v", got, want)
+	}
+}
+
+func TestSubprocessCLITransport_BuildCommand_ToolsWithCommas(t *testing.T) {
+	options := types.NewClaudeAgentOptions().
+		WithAllowedTools("Bash(cmd1,cmd2)").
+		WithAllowedToolPatterns(types.ToolPattern{Name: "Bash", Pattern: "git log,git diff"}).
+		WithDisallowedTools("Write", "Bash(rm,mv)")
+
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = "claude"
+	cmd := transport.buildCommand()
+
+	allowed := flagValues(cmd, "--allowedTools")
+	wantAllowed := []string{"Bash(cmd1,cmd2)", "Bash(git log,git diff)"}
+	if strings.Join(allowed, "|") != strings.Join(wantAllowed, "|") {
+		t.Errorf("--allowedTools values = %v, want %v", allowed, wantAllowed)
+	}
+
+	disallowed := flagValues(cmd, "--disallowedTools")
+	wantDisallowed := []string{"Write", "Bash(rm,mv)"}
+	if strings.Join(disallowed, "|") != strings.Join(wantDisallowed, "|") {
+		t.Errorf("--disallowedTools values = %v, want %v", disallowed, wantDisallowed)
+	}
+}
+
+func TestSubprocessCLITransport_BuildCommand_WithRawArgs(t *testing.T) {
+	value := "json"
+	options := types.NewClaudeAgentOptions().
+		WithExtraArg("zeta", nil).
+		WithExtraArg("alpha", &value).
+		WithExtraArg("mid", nil).
+		WithRawArgs("--debug", "api,mcp").
+		WithRawArgs("--strict-mcp-config")
+
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = "claude"
+	cmd := strings.Join(transport.buildCommand(), " ")
+
+	want := "--alpha json --mid --zeta --debug api,mcp --strict-mcp-config"
+	if !strings.Contains(cmd, want) {
+		t.Errorf("Expected sorted extra args followed by raw args %q, got: %s", want, cmd)
+	}
+	if !strings.HasSuffix(cmd, "--strict-mcp-config --input-format stream-json") {
+		t.Errorf("Expected raw args before the input format, got: %s", cmd)
+	}
+}
+
+func TestSubprocessCLITransport_BuildCommand_Deterministic(t *testing.T) {
+	options := types.NewClaudeAgentOptions()
+	for _, key := range []string{"e", "d", "c", "b", "a", "f", "g", "h"} {
+		value := key + "-value"
+		options.WithExtraArg(key, &value)
+	}
+
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = "claude"
+	first := transport.buildCommand()
+
+	want := []string{"--a", "a-value", "--b", "b-value", "--c", "c-value", "--d", "d-value"}
+	if !strings.Contains(strings.Join(first, " "), strings.Join(want, " ")) {
+		t.Errorf("Expected extra args sorted by key, got: %v", first)
+	}
+
+	for i := 0; i < 20; i++ {
+		if cmd := transport.buildCommand(); strings.Join(cmd, "\x00") != strings.Join(first, "\x00") {
+			t.Fatalf("buildCommand() changed between calls:\n%v\n%v", first, cmd)
+		}
+	}
+}
+
+func TestSubprocessCLITransport_BuildCommand_WithReplayUserMessages(t *testing.T) {
+	for _, replay := range []bool{false, true} {
+		options := types.NewClaudeAgentOptions().WithReplayUserMessages(replay)
+
+		transport := NewSubprocessCLITransport("test", options)
+		transport.cliPath = "claude"
+
+		if got := containsArg(transport.buildCommand(), "--replay-user-messages"); got != replay {
+			t.Errorf("replay=%v: --replay-user-messages present = %v", replay, got)
+		}
+	}
+}
+
+func TestSubprocessCLITransport_BuildCommand_WithResumeSessionAt(t *testing.T) {
+	options := types.NewClaudeAgentOptions().
+		WithResume("session_123").
+		WithResumeSessionAt("uuid-1").
+		WithForkSession(true)
+
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = "claude"
+
+	cmd := transport.buildCommand()
+	if got := flagValues(cmd, "--resume-session-at"); !reflect.DeepEqual(got, []string{"uuid-1"}) {
+		t.Errorf("--resume-session-at values = %v, want [uuid-1]", got)
+	}
+	if !containsArg(cmd, "--fork-session") {
+		t.Error("Expected --fork-session to be passed")
+	}
+}
+
+func TestSubprocessCLITransport_BuildCommand_SettingSources(t *testing.T) {
+	tests := []struct {
+		name    string
+		options *types.ClaudeAgentOptions
+		want    []string
+	}{
+		{name: "unset", options: types.NewClaudeAgentOptions(), want: nil},
+		{name: "explicit", options: types.NewClaudeAgentOptions().WithSettingSources(types.SettingSourceUser, types.SettingSourceProject), want: []string{"user,project"}},
+		{name: "none", options: types.NewClaudeAgentOptions().WithoutSettingSources(), want: []string{""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := NewSubprocessCLITransport("test", tt.options)
+			transport.cliPath = "claude"
+
+			if got := flagValues(transport.buildCommand(), "--setting-sources"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("--setting-sources values = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubprocessCLITransport_OptionsSnapshot(t *testing.T) {
+	options := types.NewClaudeAgentOptions().
+		WithModel("claude-sonnet-4-5").
+		WithAllowedTools("Read")
+
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = "claude"
+
+	// Changes after construction do not affect the command
+	options.WithModel("claude-opus-4").WithAllowedTools("Bash")
+	options.AllowedTools[0] = "Write"
+
+	cmd := transport.buildCommand()
+	if got := flagValues(cmd, "--model"); !reflect.DeepEqual(got, []string{"claude-sonnet-4-5"}) {
+		t.Errorf("--model values = %v, want [claude-sonnet-4-5]", got)
+	}
+	if got := flagValues(cmd, "--allowedTools"); !reflect.DeepEqual(got, []string{"Read"}) {
+		t.Errorf("--allowedTools values = %v, want [Read]", got)
+	}
+}
+
+func TestSubprocessCLITransport_BuildCommand_WithMaxThinkingTokens(t *testing.T) {
+	options := types.NewClaudeAgentOptions().WithMaxThinkingTokens(8000)
+
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = "claude"
+	cmd := transport.buildCommand()
+
+	if got := flagValues(cmd, "--max-thinking-tokens"); len(got) != 1 || got[0] != "8000" {
+		t.Errorf("--max-thinking-tokens values = %v, want [8000]", got)
+	}
+}
+
 func TestSubprocessCLITransport_BuildCommand_WithSystemPrompt(t *testing.T) {
 	// Test with string system prompt
 	options1 := types.NewClaudeAgentOptions().WithSystemPrompt("You are a helpful assistant")
@@ -151,6 +464,75 @@ func TestSubprocessCLITransport_BuildCommand_WithMCPServers(t *testing.T) {
 	}
 }
 
+func TestSubprocessCLITransport_BuildCommand_WithMCPHeadersProvider(t *testing.T) {
+	token := "token-1"
+	mcpConfig := types.MCPServerConfig{
+		Type:    "http",
+		URL:     "https://example.com/mcp",
+		Headers: map[string]string{"X-Static": "static"},
+		HeadersProvider: func() (map[string]string, error) {
+			return map[string]string{"Authorization": "Bearer " + token}, nil
+		},
+	}
+
+	options := types.NewClaudeAgentOptions().WithMCPServer("remote", &mcpConfig)
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = "claude"
+
+	if err := transport.resolveMCPHeaders(); err != nil {
+		t.Fatalf("Failed to resolve MCP headers: %v", err)
+	}
+	cmdStr := strings.Join(transport.buildCommand(), " ")
+	if !strings.Contains(cmdStr, "Bearer token-1") || !strings.Contains(cmdStr, "X-Static") {
+		t.Errorf("Command should contain resolved and static headers, got: %s", cmdStr)
+	}
+
+	// Headers are re-evaluated on every resolve, so refreshed tokens are picked up
+	token = "token-2"
+	if err := transport.resolveMCPHeaders(); err != nil {
+		t.Fatalf("Failed to resolve MCP headers: %v", err)
+	}
+	cmdStr = strings.Join(transport.buildCommand(), " ")
+	if !strings.Contains(cmdStr, "Bearer token-2") {
+		t.Errorf("Command should contain refreshed token, got: %s", cmdStr)
+	}
+	if options.MCPServers["remote"].Headers["Authorization"] != "" {
+		t.Error("Resolved headers should not be written back into the options")
+	}
+}
+
+func TestSubprocessCLITransport_Connect_MCPHeadersProviderError(t *testing.T) {
+	cliPath := createMockCLI(t, "#!/bin/bash\nexit 0\n")
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+
+	mcpConfig := types.MCPServerConfig{
+		Type: "sse",
+		URL:  "https://example.com/sse",
+		HeadersProvider: func() (map[string]string, error) {
+			return nil, errors.New("token expired")
+		},
+	}
+
+	options := types.NewClaudeAgentOptions().WithMCPServer("remote", &mcpConfig)
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = cliPath
+
+	err := transport.Connect(context.Background())
+	if err == nil {
+		t.Fatal("Expected error when MCP headers provider fails")
+	}
+
+	var connErr *types.CLIConnectionError
+	if !errors.As(err, &connErr) {
+		t.Errorf("Expected CLIConnectionError, got %T", err)
+	}
+	if !strings.Contains(err.Error(), "token expired") {
+		t.Errorf("Expected provider error in message, got: %v", err)
+	}
+}
+
 func TestSubprocessCLITransport_BuildCommand_WithAgents(t *testing.T) {
 	agent := types.AgentDefinition{
 		Description: "Test agent",
@@ -212,6 +594,49 @@ func TestSubprocessCLITransport_Connect_InvalidCLI(t *testing.T) {
 	}
 }
 
+func TestSubprocessCLITransport_Connect_FallbackCLI(t *testing.T) {
+	t.Setenv("CLAUDE_AGENT_SDK_SKIP_VERSION_CHECK", "1")
+	t.Setenv("HOME", t.TempDir())
+	pathDir := t.TempDir()
+	t.Setenv("PATH", pathDir)
+	for _, path := range cliLocations() {
+		if _, err := os.Stat(path); err == nil {
+			t.Skipf("CLI installed at %s, so the fallback is never used", path)
+		}
+	}
+
+	transport := NewSubprocessCLITransport("test", types.NewClaudeAgentOptions())
+	if transport.cliPath != cliFallback {
+		t.Fatalf("cliPath = %q, want the %q fallback", transport.cliPath, cliFallback)
+	}
+
+	err := transport.Connect(context.Background())
+	var cliErr *types.CLINotFoundError
+	if !errors.As(err, &cliErr) {
+		t.Fatalf("Connect() error = %v, want CLINotFoundError", err)
+	}
+	if !strings.Contains(err.Error(), "not found on PATH") || !strings.Contains(err.Error(), pathDir) ||
+		!strings.Contains(err.Error(), "/usr/local/bin/claude") {
+		t.Errorf("Expected the error to list the locations checked, got:\n%v", err)
+	}
+	if strings.Contains(err.Error(), "not found at: claude") {
+		t.Errorf("Error should not refer to a file named claude, got:\n%v", err)
+	}
+
+	// A CLI installed on PATH after construction is found at Connect
+	transport = NewSubprocessCLITransport("test", types.NewClaudeAgentOptions())
+	if err := os.WriteFile(filepath.Join(pathDir, "claude"), []byte("#!/bin/bash\nexit 0\n"), 0755); err != nil {
+		t.Fatalf("Failed to write mock CLI: %v", err)
+	}
+	if err := transport.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	_ = transport.Close(context.Background())
+	if want := filepath.Join(pathDir, "claude"); transport.cliPath != want {
+		t.Errorf("cliPath = %q, want %q", transport.cliPath, want)
+	}
+}
+
 func TestSubprocessCLITransport_Write_NotReady(t *testing.T) {
 	options := types.NewClaudeAgentOptions()
 	transport := NewSubprocessCLITransport("test", options)
@@ -300,7 +725,7 @@ func TestSubprocessCLITransport_ParseMessage(t *testing.T) {
 }
 
 func TestSubprocessCLITransport_ParseMessage_Invalid(t *testing.T) {
-	transport := &SubprocessCLITransport{}
+	transport := NewSubprocessCLITransport("test", types.NewClaudeAgentOptions().WithStrictMessageTypes(true))
 
 	// Test invalid message type
 	invalidMsg := map[string]interface{}{
@@ -319,6 +744,29 @@ func TestSubprocessCLITransport_ParseMessage_Invalid(t *testing.T) {
 	}
 }
 
+func TestSubprocessCLITransport_ParseMessage_Unknown(t *testing.T) {
+	transport := NewSubprocessCLITransport("test", types.NewClaudeAgentOptions())
+
+	message, err := transport.parseMessage(map[string]interface{}{
+		"type":    "future_type",
+		"content": "test",
+	})
+	if err != nil {
+		t.Fatalf("parseMessage() error = %v", err)
+	}
+
+	unknown, ok := message.(*types.UnknownMessage)
+	if !ok {
+		t.Fatalf("Expected UnknownMessage, got %T", message)
+	}
+	if unknown.Type() != "future_type" {
+		t.Errorf("Type() = %q, want %q", unknown.Type(), "future_type")
+	}
+	if !strings.Contains(string(unknown.Raw), `"content":"test"`) {
+		t.Errorf("Expected raw JSON to be preserved, got %s", unknown.Raw)
+	}
+}
+
 func TestSubprocessCLITransport_EndInput(t *testing.T) {
 	options := types.NewClaudeAgentOptions()
 	transport := NewSubprocessCLITransport("test", options)
@@ -581,3 +1029,1858 @@ sleep 0.1
 		t.Error("Expected at least one message")
 	}
 }
+
+// recordingCollector is a MetricsCollector that records everything it receives
+type recordingCollector struct {
+	mu        sync.Mutex
+	counters  map[string]int
+	durations map[string][]time.Duration
+}
+
+func newRecordingCollector() *recordingCollector {
+	return &recordingCollector{
+		counters:  make(map[string]int),
+		durations: make(map[string][]time.Duration),
+	}
+}
+
+func (c *recordingCollector) IncCounter(name string, labels map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := name
+	if msgType, ok := labels["type"]; ok {
+		key = name + ":" + msgType
+	}
+	c.counters[key]++
+}
+
+func (c *recordingCollector) ObserveDuration(name string, duration time.Duration, labels map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.durations[name] = append(c.durations[name], duration)
+}
+
+func (c *recordingCollector) counter(key string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counters[key]
+}
+
+func TestSubprocessCLITransport_Metrics(t *testing.T) {
+	mockScript := `#!/bin/bash
+echo '{"type":"system","subtype":"init","data":{}}'
+echo '{"subtype":"bogus"}'
+echo '{"type":"result","subtype":"success","duration_ms":1500,"session_id":"test"}'
+`
+	cliPath := createMockCLI(t, mockScript)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+
+	collector := newRecordingCollector()
+	options := types.NewClaudeAgentOptions().WithMetrics(collector)
+
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = cliPath
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := transport.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect to mock CLI: %v", err)
+	}
+	defer func() {
+		_ = transport.Close(ctx)
+	}()
+
+	for range transport.ReadMessages(ctx) {
+	}
+
+	if got := collector.counter(types.MetricSubprocessStarts); got != 1 {
+		t.Errorf("Expected 1 subprocess start, got %d", got)
+	}
+	if got := collector.counter(types.MetricMessagesReceived + ":" + types.MessageTypeSystem); got != 1 {
+		t.Errorf("Expected 1 system message, got %d", got)
+	}
+	if got := collector.counter(types.MetricMessagesReceived + ":" + types.MessageTypeResult); got != 1 {
+		t.Errorf("Expected 1 result message, got %d", got)
+	}
+	if got := collector.counter(types.MetricParseErrors); got != 1 {
+		t.Errorf("Expected 1 parse error, got %d", got)
+	}
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	durations := collector.durations[types.MetricTurnDuration]
+	if len(durations) != 1 || durations[0] != 1500*time.Millisecond {
+		t.Errorf("Expected turn duration of 1.5s, got %v", durations)
+	}
+}
+
+func TestSubprocessCLITransport_CloseReason(t *testing.T) {
+	tests := []struct {
+		name       string
+		script     string
+		wantReason string
+		wantErr    bool
+	}{
+		{
+			name:       "result",
+			script:     "#!/bin/bash\necho '{\"type\":\"result\",\"subtype\":\"success\",\"session_id\":\"test\"}'\n",
+			wantReason: CloseReasonResult,
+		},
+		{
+			name:       "process exited",
+			script:     "#!/bin/bash\necho '{\"type\":\"system\",\"subtype\":\"init\",\"data\":{}}'\n",
+			wantReason: CloseReasonProcessExited,
+		},
+		{
+			name:       "process crashed",
+			script:     "#!/bin/bash\necho 'boom' >&2\nexit 3\n",
+			wantReason: CloseReasonProcessCrashed,
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cliPath := createMockCLI(t, tt.script)
+			defer func() {
+				_ = os.RemoveAll(filepath.Dir(cliPath))
+			}()
+
+			transport := NewSubprocessCLITransport("test", types.NewClaudeAgentOptions())
+			transport.cliPath = cliPath
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			if err := transport.Connect(ctx); err != nil {
+				t.Fatalf("Failed to connect to mock CLI: %v", err)
+			}
+			defer func() {
+				_ = transport.Close(ctx)
+			}()
+
+			for range transport.ReadMessages(ctx) {
+			}
+
+			reason, err := transport.CloseReason()
+			if reason != tt.wantReason {
+				t.Errorf("CloseReason() reason = %q, want %q", reason, tt.wantReason)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CloseReason() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSubprocessCLITransport_StderrFanOut(t *testing.T) {
+	mockScript := `#!/bin/bash
+printf '  first\n\nsecond\n' >&2
+`
+	cliPath := createMockCLI(t, mockScript)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+
+	var logged, inspected []string
+	var raw strings.Builder
+	options := types.NewClaudeAgentOptions().
+		WithStderrCallback(func(line string) { logged = append(logged, line) }).
+		WithStderrCallback(func(line string) { inspected = append(inspected, line) }).
+		WithStderrWriter(&raw)
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = cliPath
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := transport.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect to mock CLI: %v", err)
+	}
+	for range transport.ReadMessages(ctx) {
+	}
+	// Wait for the stderr handler to read to EOF, as Close stops it early
+	select {
+	case <-transport.stderrDone:
+	case <-ctx.Done():
+		t.Fatal("Timed out waiting for the stderr handler")
+	}
+	if err := transport.Close(ctx); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	want := []string{"first", "second"}
+	if !reflect.DeepEqual(logged, want) || !reflect.DeepEqual(inspected, want) {
+		t.Errorf("Callbacks received %q and %q, want %q each", logged, inspected, want)
+	}
+	if got, want := raw.String(), "  first\n\nsecond\n"; got != want {
+		t.Errorf("StderrWriter received %q, want %q", got, want)
+	}
+}
+
+func TestSubprocessCLITransport_CrashIncludesStderr(t *testing.T) {
+	mockScript := `#!/bin/bash
+for i in 1 2 3 4; do echo "stderr line $i" >&2; done
+exit 2
+`
+	cliPath := createMockCLI(t, mockScript)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+
+	options := types.NewClaudeAgentOptions().WithStderrBufferLines(2)
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = cliPath
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := transport.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect to mock CLI: %v", err)
+	}
+	defer func() {
+		_ = transport.Close(ctx)
+	}()
+
+	for range transport.ReadMessages(ctx) {
+	}
+
+	_, err := transport.CloseReason()
+	var processErr *types.ProcessError
+	if !errors.As(err, &processErr) {
+		t.Fatalf("Expected ProcessError, got %v", err)
+	}
+
+	want := []string{"stderr line 3", "stderr line 4"}
+	if strings.Join(processErr.Stderr, "|") != strings.Join(want, "|") {
+		t.Errorf("ProcessError.Stderr = %v, want %v", processErr.Stderr, want)
+	}
+	if !strings.Contains(processErr.Error(), "stderr line 4") {
+		t.Errorf("Expected stderr in error message, got %q", processErr.Error())
+	}
+	if processErr.ExitCode != 2 || processErr.Signaled() {
+		t.Errorf("Expected clean exit code 2, got code %d signal %v", processErr.ExitCode, processErr.Signal)
+	}
+}
+
+func TestSubprocessCLITransport_KilledBySignal(t *testing.T) {
+	mockScript := `#!/bin/bash
+kill -KILL $$
+`
+	cliPath := createMockCLI(t, mockScript)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+
+	transport := NewSubprocessCLITransport("test", types.NewClaudeAgentOptions())
+	transport.cliPath = cliPath
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := transport.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect to mock CLI: %v", err)
+	}
+	defer func() {
+		_ = transport.Close(ctx)
+	}()
+
+	for range transport.ReadMessages(ctx) {
+	}
+
+	_, err := transport.CloseReason()
+	var processErr *types.ProcessError
+	if !errors.As(err, &processErr) {
+		t.Fatalf("Expected ProcessError, got %v", err)
+	}
+	if !processErr.Signaled() || processErr.Signal != syscall.SIGKILL {
+		t.Errorf("Expected SIGKILL, got signal %v", processErr.Signal)
+	}
+	if processErr.ExitCode != -1 {
+		t.Errorf("ExitCode = %d, want -1", processErr.ExitCode)
+	}
+	if !strings.Contains(processErr.Error(), "signal 9 (killed)") {
+		t.Errorf("Expected signal in error message, got %q", processErr.Error())
+	}
+}
+
+func TestSubprocessCLITransport_CloseReason_Close(t *testing.T) {
+	cliPath := createMockCLI(t, "#!/bin/bash\ncat >/dev/null\n")
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+
+	t.Run("closed by caller", func(t *testing.T) {
+		transport := NewSubprocessCLITransport("test", types.NewClaudeAgentOptions())
+		transport.cliPath = cliPath
+
+		if err := transport.Connect(context.Background()); err != nil {
+			t.Fatalf("Failed to connect to mock CLI: %v", err)
+		}
+		if reason, _ := transport.CloseReason(); reason != "" {
+			t.Errorf("Expected no close reason while running, got %q", reason)
+		}
+
+		_ = transport.Close(context.Background())
+
+		if reason, err := transport.CloseReason(); reason != CloseReasonClosed || err != nil {
+			t.Errorf("CloseReason() = (%q, %v), want (%q, nil)", reason, err, CloseReasonClosed)
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		transport := NewSubprocessCLITransport("test", types.NewClaudeAgentOptions())
+		transport.cliPath = cliPath
+
+		ctx, cancel := context.WithCancel(context.Background())
+		if err := transport.Connect(ctx); err != nil {
+			t.Fatalf("Failed to connect to mock CLI: %v", err)
+		}
+
+		cancel()
+		_ = transport.Close(ctx)
+
+		reason, err := transport.CloseReason()
+		if reason != CloseReasonContextCancelled {
+			t.Errorf("CloseReason() reason = %q, want %q", reason, CloseReasonContextCancelled)
+		}
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("CloseReason() error = %v, want context.Canceled", err)
+		}
+	})
+}
+
+func TestSubprocessCLITransport_PartialTextCallback(t *testing.T) {
+	mockScript := `#!/bin/bash
+echo '{"type":"stream_event","uuid":"u1","session_id":"test","event":{"type":"message_start"}}'
+echo '{"type":"stream_event","uuid":"u2","session_id":"test","event":{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello, "}}}'
+echo '{"type":"stream_event","uuid":"u3","session_id":"test","event":{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{"}}}'
+echo '{"type":"stream_event","uuid":"u4","session_id":"test","event":{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"world"}}}'
+echo '{"type":"result","subtype":"success","session_id":"test"}'
+`
+	cliPath := createMockCLI(t, mockScript)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+
+	var deltas []string
+	options := types.NewClaudeAgentOptions().
+		WithPartialTextCallback(func(delta string) {
+			deltas = append(deltas, delta)
+		})
+
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = cliPath
+
+	if !containsArg(transport.buildCommand(), "--include-partial-messages") {
+		t.Error("Expected partial text callback to enable --include-partial-messages")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := transport.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect to mock CLI: %v", err)
+	}
+	defer func() {
+		_ = transport.Close(ctx)
+	}()
+
+	for range transport.ReadMessages(ctx) {
+	}
+
+	if got := strings.Join(deltas, ""); got != "Hello, world" {
+		t.Errorf("Partial text = %q, want %q (deltas %v)", got, "Hello, world", deltas)
+	}
+}
+
+func TestSubprocessCLITransport_BlockCallback(t *testing.T) {
+	mockScript := `#!/bin/bash
+echo '{"type":"stream_event","uuid":"u1","session_id":"test","event":{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}}'
+echo '{"type":"stream_event","uuid":"u2","session_id":"test","event":{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Reading"}}}'
+echo '{"type":"stream_event","uuid":"u3","session_id":"test","event":{"type":"content_block_stop","index":0}}'
+echo '{"type":"stream_event","uuid":"u4","session_id":"test","event":{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"t1","name":"Read","input":{}}}}'
+echo '{"type":"stream_event","uuid":"u5","session_id":"test","event":{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"path\":"}}}'
+echo '{"type":"stream_event","uuid":"u6","session_id":"test","event":{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"a.go\"}"}}}'
+echo '{"type":"stream_event","uuid":"u7","session_id":"test","event":{"type":"content_block_stop","index":1}}'
+echo '{"type":"result","subtype":"success","session_id":"test"}'
+`
+	cliPath := createMockCLI(t, mockScript)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+
+	var blocks []types.ContentBlock
+	options := types.NewClaudeAgentOptions().
+		WithBlockCallback(func(block types.ContentBlock) {
+			blocks = append(blocks, block)
+		})
+
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = cliPath
+
+	if !containsArg(transport.buildCommand(), "--include-partial-messages") {
+		t.Error("Expected block callback to enable --include-partial-messages")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := transport.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect to mock CLI: %v", err)
+	}
+	defer func() {
+		_ = transport.Close(ctx)
+	}()
+
+	for range transport.ReadMessages(ctx) {
+	}
+
+	if len(blocks) != 2 {
+		t.Fatalf("Expected 2 blocks, got %d", len(blocks))
+	}
+	if text, ok := blocks[0].(*types.TextBlock); !ok || text.Text != "Reading" {
+		t.Errorf("First block = %+v, want text %q", blocks[0], "Reading")
+	}
+	toolUse, ok := blocks[1].(*types.ToolUseBlock)
+	if !ok || toolUse.ID != "t1" || toolUse.Input["path"] != "a.go" {
+		t.Errorf("Second block = %+v, want Read tool use of a.go", blocks[1])
+	}
+}
+
+// containsArg reports whether cmd contains arg
+func containsArg(cmd []string, arg string) bool {
+	for _, a := range cmd {
+		if a == arg {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSessionIDFrom(t *testing.T) {
+	tests := []struct {
+		name string
+		data map[string]interface{}
+		want string
+	}{
+		{name: "top level", data: map[string]interface{}{"type": "result", "session_id": "abc"}, want: "abc"},
+		{name: "system data", data: map[string]interface{}{"type": "system", "data": map[string]interface{}{"session_id": "def"}}, want: "def"},
+		{name: "missing", data: map[string]interface{}{"type": "assistant"}, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sessionIDFrom(tt.data); got != tt.want {
+				t.Errorf("sessionIDFrom() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubprocessCLITransport_SessionID(t *testing.T) {
+	mockScript := `#!/bin/bash
+echo '{"type":"system","subtype":"init","session_id":"session-42","data":{}}'
+read -r line
+echo '{"type":"result","subtype":"success","session_id":"session-42"}'
+`
+	cliPath := createMockCLI(t, mockScript)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+
+	transport := NewSubprocessCLITransport("test", types.NewClaudeAgentOptions())
+	transport.cliPath = cliPath
+
+	if got := transport.SessionID(); got != "" {
+		t.Errorf("Expected empty session ID before connect, got %q", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := transport.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect to mock CLI: %v", err)
+	}
+	defer func() {
+		_ = transport.Close(ctx)
+	}()
+
+	messages := transport.ReadMessages(ctx)
+	<-messages
+
+	// The session is still running: the mock waits for input
+	done := make(chan string)
+	go func() {
+		done <- transport.SessionID()
+	}()
+	if got := <-done; got != "session-42" {
+		t.Errorf("SessionID() = %q, want %q", got, "session-42")
+	}
+
+	if err := transport.Write(ctx, `{"type":"user"}`); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	for range messages {
+	}
+}
+
+func TestSubprocessCLITransport_WriteRaw(t *testing.T) {
+	inputFile := filepath.Join(t.TempDir(), "stdin.txt")
+	mockScript := `#!/bin/bash
+IFS= read -r line
+printf '%s' "$line" > ` + inputFile + `
+echo '{"type":"result","subtype":"success","session_id":"test"}'
+`
+	cliPath := createMockCLI(t, mockScript)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+	t.Setenv("CLAUDE_AGENT_SDK_SKIP_VERSION_CHECK", "1")
+
+	transport := NewSubprocessCLITransport("test", types.NewClaudeAgentOptions())
+	transport.cliPath = cliPath
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := transport.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect to mock CLI: %v", err)
+	}
+	defer func() {
+		_ = transport.Close(ctx)
+	}()
+
+	// Raw writes are not framed, so the two chunks arrive as one line
+	for _, chunk := range []string{`{"type":"experimental",`, "\"n\":1}\n"} {
+		if err := transport.WriteRaw(ctx, []byte(chunk)); err != nil {
+			t.Fatalf("WriteRaw() error = %v", err)
+		}
+	}
+	for range transport.ReadMessages(ctx) {
+	}
+
+	data, err := os.ReadFile(inputFile)
+	if err != nil {
+		t.Fatalf("Failed to read mock input: %v", err)
+	}
+	if want := `{"type":"experimental","n":1}`; string(data) != want {
+		t.Errorf("CLI received %q, want %q", data, want)
+	}
+}
+
+func TestSubprocessCLITransport_WriteSentinelErrors(t *testing.T) {
+	mockScript := `#!/bin/bash
+cat > /dev/null
+`
+	cliPath := createMockCLI(t, mockScript)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+	t.Setenv("CLAUDE_AGENT_SDK_SKIP_VERSION_CHECK", "1")
+
+	transport := NewSubprocessCLITransport("test", types.NewClaudeAgentOptions())
+	transport.cliPath = cliPath
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := transport.Write(ctx, `{}`); !errors.Is(err, types.ErrNotConnected) {
+		t.Errorf("Write() before Connect error = %v, want ErrNotConnected", err)
+	}
+
+	if err := transport.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect to mock CLI: %v", err)
+	}
+	if err := transport.EndInput(ctx); err != nil {
+		t.Fatalf("EndInput() error = %v", err)
+	}
+	if err := transport.Write(ctx, `{}`); !errors.Is(err, types.ErrInputClosed) {
+		t.Errorf("Write() after EndInput error = %v, want ErrInputClosed", err)
+	}
+
+	if err := transport.Close(ctx); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := transport.Write(ctx, `{}`); !errors.Is(err, types.ErrClosed) {
+		t.Errorf("Write() after Close error = %v, want ErrClosed", err)
+	}
+	if err := transport.Connect(ctx); !errors.Is(err, types.ErrClosed) {
+		t.Errorf("Connect() after Close error = %v, want ErrClosed", err)
+	}
+}
+
+func TestSubprocessCLITransport_Flush(t *testing.T) {
+	// The mock echoes the first line it reads back as a message
+	mockScript := `#!/bin/bash
+IFS= read -r line
+echo "$line"
+cat > /dev/null
+`
+	cliPath := createMockCLI(t, mockScript)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+	t.Setenv("CLAUDE_AGENT_SDK_SKIP_VERSION_CHECK", "1")
+
+	transport := NewSubprocessCLITransport("test", types.NewClaudeAgentOptions())
+	transport.cliPath = cliPath
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := transport.Flush(ctx); !errors.Is(err, types.ErrNotConnected) {
+		t.Errorf("Flush() before Connect error = %v, want ErrNotConnected", err)
+	}
+	if err := transport.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect to mock CLI: %v", err)
+	}
+	defer func() {
+		_ = transport.Close(ctx)
+	}()
+
+	// Buffer a message without flushing it, as a batched write would
+	transport.mu.Lock()
+	_, _ = transport.stdinWriter.WriteString(`{"type":"result","subtype":"success","session_id":"flushed"}` + "\n")
+	transport.mu.Unlock()
+
+	if err := transport.Flush(ctx); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	select {
+	case msg := <-transport.ReadMessages(ctx):
+		if result, ok := msg.(*types.ResultMessage); !ok || result.SessionID != "flushed" {
+			t.Errorf("Expected the flushed message echoed back, got %#v", msg)
+		}
+	case <-ctx.Done():
+		t.Fatal("Flushed message never reached the CLI")
+	}
+
+	// Input is still open after a flush
+	if err := transport.Write(ctx, `{}`); err != nil {
+		t.Errorf("Write() after Flush error = %v", err)
+	}
+
+	if err := transport.EndInput(ctx); err != nil {
+		t.Fatalf("EndInput() error = %v", err)
+	}
+	if err := transport.Flush(ctx); !errors.Is(err, types.ErrInputClosed) {
+		t.Errorf("Flush() after EndInput error = %v, want ErrInputClosed", err)
+	}
+}
+
+func TestSubprocessCLITransport_SessionRegistry(t *testing.T) {
+	mockScript := `#!/bin/bash
+cat > /dev/null
+`
+	cliPath := createMockCLI(t, mockScript)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+	t.Setenv("CLAUDE_AGENT_SDK_SKIP_VERSION_CHECK", "1")
+
+	registry := types.NewSessionRegistry()
+	newTransport := func(options *types.ClaudeAgentOptions) *SubprocessCLITransport {
+		transport := NewSubprocessCLITransport("", options.WithSessionRegistry(registry))
+		transport.cliPath = cliPath
+		return transport
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	first := newTransport(types.NewClaudeAgentOptions().WithResume("session-1"))
+	if err := first.Connect(ctx); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	second := newTransport(types.NewClaudeAgentOptions().WithResume("session-1"))
+	if err := second.Connect(ctx); !errors.Is(err, types.ErrSessionInUse) {
+		t.Errorf("Connect() resuming an active session error = %v, want ErrSessionInUse", err)
+	}
+
+	// Forking creates a new session, so it does not collide
+	fork := newTransport(types.NewClaudeAgentOptions().WithResume("session-1").WithForkSession(true))
+	if err := fork.Connect(ctx); err != nil {
+		t.Errorf("Connect() forking an active session error = %v", err)
+	}
+	_ = fork.Close(ctx)
+
+	if err := first.Close(ctx); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if registry.Active("session-1") {
+		t.Fatal("Expected Close to release the session")
+	}
+
+	third := newTransport(types.NewClaudeAgentOptions().WithResume("session-1"))
+	if err := third.Connect(ctx); err != nil {
+		t.Errorf("Connect() after the session was released error = %v", err)
+	}
+	_ = third.Close(ctx)
+}
+
+func TestSubprocessCLITransport_ConnectWithReadyTimeout(t *testing.T) {
+	t.Setenv("CLAUDE_AGENT_SDK_SKIP_VERSION_CHECK", "1")
+
+	tests := []struct {
+		name        string
+		script      string
+		wantErr     bool
+		wantTimeout bool
+	}{
+		{
+			name: "init received",
+			script: `#!/bin/bash
+echo '{"type":"system","subtype":"init","session_id":"ready","model":"claude"}'
+cat > /dev/null
+`,
+		},
+		{
+			name: "no init message",
+			script: `#!/bin/bash
+cat > /dev/null
+`,
+			wantErr:     true,
+			wantTimeout: true,
+		},
+		{
+			name: "process exits first",
+			script: `#!/bin/bash
+exit 1
+`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cliPath := createMockCLI(t, tt.script)
+			defer func() {
+				_ = os.RemoveAll(filepath.Dir(cliPath))
+			}()
+			transport := NewSubprocessCLITransport("", types.NewClaudeAgentOptions())
+			transport.cliPath = cliPath
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			defer func() {
+				_ = transport.Close(ctx)
+			}()
+
+			err := transport.ConnectWithReadyTimeout(ctx, 500*time.Millisecond)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ConnectWithReadyTimeout() error = %v, want error: %v", err, tt.wantErr)
+			}
+			if errors.Is(err, types.ErrReadyTimeout) != tt.wantTimeout {
+				t.Errorf("ConnectWithReadyTimeout() error = %v, want ErrReadyTimeout: %v", err, tt.wantTimeout)
+			}
+			if tt.wantErr && transport.IsReady() {
+				t.Error("Expected the transport to be closed after a failed ready wait")
+			}
+		})
+	}
+}
+
+func TestSubprocessCLITransport_PermissionWarning(t *testing.T) {
+	tests := []struct {
+		name         string
+		options      *types.ClaudeAgentOptions
+		wantWarning  bool
+		wantExplicit bool
+	}{
+		{"default mode", types.NewClaudeAgentOptions(), false, false},
+		{"accept edits", types.NewClaudeAgentOptions().WithPermissionMode(types.PermissionModeAcceptEdits), false, false},
+		{"bypass via permission mode", types.NewClaudeAgentOptions().WithPermissionMode(types.PermissionModeBypassPermission), true, false},
+		{"explicit bypass", types.NewClaudeAgentOptions().WithDangerouslyBypassAllPermissions(), true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warning := NewSubprocessCLITransport("test", tt.options).permissionWarning()
+			if (warning != "") != tt.wantWarning {
+				t.Fatalf("permissionWarning() = %q, want warning: %v", warning, tt.wantWarning)
+			}
+			if tt.wantWarning && strings.Contains(warning, "Use WithDangerouslyBypassAllPermissions") == tt.wantExplicit {
+				t.Errorf("permissionWarning() = %q, explicit: %v", warning, tt.wantExplicit)
+			}
+		})
+	}
+}
+
+func TestSubprocessCLITransport_UserAgent(t *testing.T) {
+	mockScript := `#!/bin/bash
+echo '{"type":"system","subtype":"init","data":{"entrypoint":"'"$CLAUDE_CODE_ENTRYPOINT"'","version":"'"$CLAUDE_AGENT_SDK_VERSION"'"}}'
+`
+	cliPath := createMockCLI(t, mockScript)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+
+	tests := []struct {
+		name           string
+		options        *types.ClaudeAgentOptions
+		wantEntrypoint string
+		wantVersion    string
+	}{
+		{
+			name:           "default",
+			options:        types.NewClaudeAgentOptions(),
+			wantEntrypoint: CLICodeEntrypoint,
+			wantVersion:    ClaudeAgentSDKVersion,
+		},
+		{
+			name:           "name and version",
+			options:        types.NewClaudeAgentOptions().WithUserAgent("my-app", "1.2.3"),
+			wantEntrypoint: CLICodeEntrypoint + " my-app",
+			wantVersion:    ClaudeAgentSDKVersion + " my-app/1.2.3",
+		},
+		{
+			name:           "name only",
+			options:        types.NewClaudeAgentOptions().WithUserAgent("my-app", ""),
+			wantEntrypoint: CLICodeEntrypoint + " my-app",
+			wantVersion:    ClaudeAgentSDKVersion + " my-app",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := NewSubprocessCLITransport("test", tt.options)
+			transport.cliPath = cliPath
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			if err := transport.Connect(ctx); err != nil {
+				t.Fatalf("Failed to connect to mock CLI: %v", err)
+			}
+			defer func() {
+				_ = transport.Close(ctx)
+			}()
+
+			msg, ok := <-transport.ReadMessages(ctx)
+			if !ok {
+				t.Fatal("Expected init message")
+			}
+			system, ok := msg.(*types.SystemMessage)
+			if !ok {
+				t.Fatalf("Expected SystemMessage, got %T", msg)
+			}
+			if system.Data["entrypoint"] != tt.wantEntrypoint {
+				t.Errorf("CLAUDE_CODE_ENTRYPOINT = %v, want %q", system.Data["entrypoint"], tt.wantEntrypoint)
+			}
+			if system.Data["version"] != tt.wantVersion {
+				t.Errorf("CLAUDE_AGENT_SDK_VERSION = %v, want %q", system.Data["version"], tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestSubprocessCLITransport_OnSessionStart(t *testing.T) {
+	mockScript := `#!/bin/bash
+echo '{"type":"system","subtype":"init","session_id":"session-1","model":"claude-sonnet-4-5","tools":["Read"]}'
+echo '{"type":"system","subtype":"init","session_id":"session-2","model":"claude-opus-4-1"}'
+echo '{"type":"result","subtype":"success","session_id":"session-1"}'
+`
+	cliPath := createMockCLI(t, mockScript)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+
+	type start struct{ sessionID, model string }
+	var starts []start
+	options := types.NewClaudeAgentOptions().
+		WithOnSessionStart(func(sessionID, model string) {
+			starts = append(starts, start{sessionID, model})
+		})
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = cliPath
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := transport.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect to mock CLI: %v", err)
+	}
+	defer func() {
+		_ = transport.Close(ctx)
+	}()
+
+	for range transport.ReadMessages(ctx) {
+	}
+
+	want := []start{{"session-1", "claude-sonnet-4-5"}}
+	if !reflect.DeepEqual(starts, want) {
+		t.Errorf("OnSessionStart calls = %v, want %v", starts, want)
+	}
+}
+
+func TestSubprocessCLITransport_StreamEventsDroppedWhenDisabled(t *testing.T) {
+	mockScript := `#!/bin/bash
+echo '{"type":"stream_event","uuid":"u1","session_id":"test","event":{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi"}}}'
+echo '{"type":"assistant","message":{"content":[{"type":"text","text":"Hi"}]}}'
+echo '{"type":"result","subtype":"success","session_id":"test"}'
+`
+	cliPath := createMockCLI(t, mockScript)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+
+	for _, include := range []bool{false, true} {
+		t.Run("include="+strconv.FormatBool(include), func(t *testing.T) {
+			options := types.NewClaudeAgentOptions().WithIncludePartialMessages(include)
+			transport := NewSubprocessCLITransport("test", options)
+			transport.cliPath = cliPath
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			if err := transport.Connect(ctx); err != nil {
+				t.Fatalf("Failed to connect to mock CLI: %v", err)
+			}
+			defer func() {
+				_ = transport.Close(ctx)
+			}()
+
+			var received []string
+			for msg := range transport.ReadMessages(ctx) {
+				received = append(received, msg.Type())
+			}
+
+			want := []string{types.MessageTypeAssistant, types.MessageTypeResult}
+			if include {
+				want = append([]string{types.MessageTypeStreamEvent}, want...)
+			}
+			if !reflect.DeepEqual(received, want) {
+				t.Errorf("Received %v, want %v", received, want)
+			}
+		})
+	}
+}
+
+func TestSubprocessCLITransport_NewerProtocolVersion(t *testing.T) {
+	mockScript := `#!/bin/bash
+echo '{"type":"system","subtype":"init","session_id":"test","protocol_version":99}'
+echo '{"type":"assistant","message":{"content":[{"type":"hologram"}]}}'
+echo '{"type":"result","subtype":"success","session_id":"test"}'
+`
+	cliPath := createMockCLI(t, mockScript)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+
+	transport := NewSubprocessCLITransport("test", types.NewClaudeAgentOptions())
+	transport.cliPath = cliPath
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := transport.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect to mock CLI: %v", err)
+	}
+	defer func() {
+		_ = transport.Close(ctx)
+	}()
+
+	for range transport.ReadMessages(ctx) {
+	}
+
+	select {
+	case err := <-transport.Errors():
+		var parseErr *types.MessageParseError
+		if !errors.As(err, &parseErr) || !strings.Contains(err.Error(), "protocol version 99") {
+			t.Errorf("Expected parse error naming protocol version 99, got %v", err)
+		}
+	default:
+		t.Fatal("Expected a parse error")
+	}
+}
+
+func TestSubprocessCLITransport_EnvFromFile(t *testing.T) {
+	mockScript := `#!/bin/bash
+echo '{"type":"system","subtype":"init","data":{"from_file":"'"$FROM_FILE"'","overridden":"'"$OVERRIDDEN"'"}}'
+`
+	cliPath := createMockCLI(t, mockScript)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+
+	envPath := filepath.Join(t.TempDir(), ".env")
+	content := "# test env\nFROM_FILE=\"file value\"\nOVERRIDDEN=from-file\n"
+	if err := os.WriteFile(envPath, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write env file: %v", err)
+	}
+
+	options := types.NewClaudeAgentOptions().
+		WithEnv(map[string]string{"OVERRIDDEN": "from-options"}).
+		WithEnvFromFile(envPath)
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = cliPath
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := transport.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect to mock CLI: %v", err)
+	}
+	defer func() {
+		_ = transport.Close(ctx)
+	}()
+
+	msg, ok := <-transport.ReadMessages(ctx)
+	if !ok {
+		t.Fatal("Expected init message")
+	}
+	system, ok := msg.(*types.SystemMessage)
+	if !ok {
+		t.Fatalf("Expected SystemMessage, got %T", msg)
+	}
+	if system.Data["from_file"] != "file value" {
+		t.Errorf("FROM_FILE = %v, want %q", system.Data["from_file"], "file value")
+	}
+	if system.Data["overridden"] != "from-options" {
+		t.Errorf("OVERRIDDEN = %v, want %q", system.Data["overridden"], "from-options")
+	}
+	if options.Env["FROM_FILE"] != "" {
+		t.Error("Connect should not modify the caller's Env")
+	}
+}
+
+func TestSubprocessCLITransport_Connect_EnvFileError(t *testing.T) {
+	options := types.NewClaudeAgentOptions().
+		WithEnvFromFile(filepath.Join(t.TempDir(), "missing.env"))
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = "/bin/true"
+
+	err := transport.Connect(context.Background())
+	var connErr *types.CLIConnectionError
+	if !errors.As(err, &connErr) {
+		t.Errorf("Expected CLIConnectionError, got %T: %v", err, err)
+	}
+}
+
+func TestSubprocessCLITransport_CleanEnv(t *testing.T) {
+	mockScript := `#!/bin/bash
+echo '{"type":"system","subtype":"init","data":{"secret":"'"${SDK_TEST_SECRET-unset}"'","allowed":"'"${SDK_TEST_ALLOWED-unset}"'","explicit":"'"${SDK_TEST_EXPLICIT-unset}"'","path":"'"${PATH-unset}"'"}}'
+`
+	cliPath := createMockCLI(t, mockScript)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+
+	t.Setenv("SDK_TEST_SECRET", "secret")
+	t.Setenv("SDK_TEST_ALLOWED", "allowed")
+
+	tests := []struct {
+		name    string
+		options *types.ClaudeAgentOptions
+		want    map[string]any
+	}{
+		{
+			name:    "inherits by default",
+			options: types.NewClaudeAgentOptions(),
+			want:    map[string]any{"secret": "secret", "allowed": "allowed", "explicit": "unset"},
+		},
+		{
+			name:    "clean env keeps essentials and Env",
+			options: types.NewClaudeAgentOptions().WithCleanEnv(true).WithEnv(map[string]string{"SDK_TEST_EXPLICIT": "explicit"}),
+			want:    map[string]any{"secret": "unset", "allowed": "unset", "explicit": "explicit"},
+		},
+		{
+			name:    "allowlist",
+			options: types.NewClaudeAgentOptions().WithEnvAllowlist("SDK_TEST_ALLOWED"),
+			want:    map[string]any{"secret": "unset", "allowed": "allowed", "explicit": "unset"},
+		},
+		{
+			name:    "clean env turned off again",
+			options: types.NewClaudeAgentOptions().WithEnvAllowlist("SDK_TEST_ALLOWED").WithCleanEnv(false),
+			want:    map[string]any{"secret": "secret", "allowed": "allowed", "explicit": "unset"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := NewSubprocessCLITransport("test", tt.options)
+			transport.cliPath = cliPath
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			if err := transport.Connect(ctx); err != nil {
+				t.Fatalf("Failed to connect to mock CLI: %v", err)
+			}
+			defer func() {
+				_ = transport.Close(ctx)
+			}()
+
+			msg, ok := <-transport.ReadMessages(ctx)
+			if !ok {
+				t.Fatal("Expected init message")
+			}
+			system, ok := msg.(*types.SystemMessage)
+			if !ok {
+				t.Fatalf("Expected SystemMessage, got %T", msg)
+			}
+			for key, want := range tt.want {
+				if system.Data[key] != want {
+					t.Errorf("%s = %v, want %q", key, system.Data[key], want)
+				}
+			}
+			if system.Data["path"] != os.Getenv("PATH") {
+				t.Errorf("PATH = %v, want it inherited", system.Data["path"])
+			}
+		})
+	}
+}
+
+func TestSubprocessCLITransport_CloseKillsProcessIgnoringSIGTERM(t *testing.T) {
+	mockScript := `#!/bin/bash
+trap '' TERM
+echo '{"type":"system","subtype":"init","session_id":"test"}'
+while true; do sleep 0.05; done
+`
+	cliPath := createMockCLI(t, mockScript)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+
+	// The version check would also run the script, which never exits
+	t.Setenv("CLAUDE_AGENT_SDK_SKIP_VERSION_CHECK", "1")
+
+	previous := closeGracePeriod
+	closeGracePeriod = 200 * time.Millisecond
+	defer func() {
+		closeGracePeriod = previous
+	}()
+
+	baseline := runtime.NumGoroutine()
+
+	options := types.NewClaudeAgentOptions().WithStderrCallback(func(string) {})
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = cliPath
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := transport.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect to mock CLI: %v", err)
+	}
+	if _, ok := <-transport.ReadMessages(ctx); !ok {
+		t.Fatal("Expected init message")
+	}
+
+	start := time.Now()
+	if err := transport.Close(ctx); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Close() took %v, want about the grace period", elapsed)
+	}
+
+	// Every goroutine started for the session terminates
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > baseline {
+		buf := make([]byte, 1<<16)
+		t.Errorf("%d goroutines leaked:\n%s", n-baseline, buf[:runtime.Stack(buf, true)])
+	}
+}
+
+func TestSubprocessCLITransport_CloseAfterFailedWrite(t *testing.T) {
+	// The CLI stops reading stdin, so writes fail, but keeps running
+	mockScript := `#!/bin/bash
+exec 0<&-
+echo '{"type":"system","subtype":"init","session_id":"test"}'
+exec sleep 30
+`
+	cliPath := createMockCLI(t, mockScript)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+	t.Setenv("CLAUDE_AGENT_SDK_SKIP_VERSION_CHECK", "1")
+
+	previous := closeGracePeriod
+	closeGracePeriod = 200 * time.Millisecond
+	defer func() {
+		closeGracePeriod = previous
+	}()
+
+	baseline := runtime.NumGoroutine()
+
+	options := types.NewClaudeAgentOptions().WithStderrCallback(func(string) {})
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = cliPath
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := transport.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect to mock CLI: %v", err)
+	}
+	if _, ok := <-transport.ReadMessages(ctx); !ok {
+		t.Fatal("Expected init message")
+	}
+
+	if err := transport.Write(ctx, `{"type":"user"}`); err == nil {
+		t.Fatal("Expected the write to a closed stdin to fail")
+	}
+	if transport.IsReady() {
+		t.Fatal("Expected the failed write to leave the transport not ready")
+	}
+	cmd := transport.cmd
+
+	if err := transport.Close(ctx); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// The process is reaped and the channels closed
+	if cmd.ProcessState == nil {
+		t.Errorf("Process %d was not reaped by Close", cmd.Process.Pid)
+	}
+	drained := make(chan struct{})
+	go func() {
+		for range transport.ReadMessages(ctx) {
+		}
+		for range transport.Errors() {
+		}
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Message and error channels were not closed by Close")
+	}
+
+	// Every goroutine started for the session terminates
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > baseline {
+		buf := make([]byte, 1<<16)
+		t.Errorf("%d goroutines leaked:\n%s", n-baseline, buf[:runtime.Stack(buf, true)])
+	}
+}
+
+func TestSubprocessCLITransport_ConnectAfterClose(t *testing.T) {
+	mockScript := `#!/bin/bash
+cat > /dev/null
+`
+	cliPath := createMockCLI(t, mockScript)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	t.Run("after connected session", func(t *testing.T) {
+		transport := NewSubprocessCLITransport("test", types.NewClaudeAgentOptions())
+		transport.cliPath = cliPath
+
+		if err := transport.Connect(ctx); err != nil {
+			t.Fatalf("Failed to connect to mock CLI: %v", err)
+		}
+		if err := transport.Connect(ctx); err != nil {
+			t.Errorf("Second Connect() error = %v, want nil", err)
+		}
+		if err := transport.Close(ctx); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+
+		err := transport.Connect(ctx)
+		var connErr *types.CLIConnectionError
+		if !errors.As(err, &connErr) {
+			t.Errorf("Expected CLIConnectionError, got %T: %v", err, err)
+		}
+	})
+
+	t.Run("before connect", func(t *testing.T) {
+		transport := NewSubprocessCLITransport("test", types.NewClaudeAgentOptions())
+		transport.cliPath = cliPath
+
+		if err := transport.Close(ctx); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+		err := transport.Connect(ctx)
+		var connErr *types.CLIConnectionError
+		if !errors.As(err, &connErr) {
+			t.Errorf("Expected CLIConnectionError, got %T: %v", err, err)
+		}
+	})
+
+	t.Run("retry after failed connect", func(t *testing.T) {
+		envPath := filepath.Join(t.TempDir(), ".env")
+		options := types.NewClaudeAgentOptions().WithEnvFromFile(envPath)
+		transport := NewSubprocessCLITransport("test", options)
+		transport.cliPath = cliPath
+
+		if err := transport.Connect(ctx); err == nil {
+			t.Fatal("Expected Connect() to fail with a missing env file")
+		}
+		if err := os.WriteFile(envPath, []byte("KEY=value\n"), 0600); err != nil {
+			t.Fatalf("Failed to write env file: %v", err)
+		}
+		if err := transport.Connect(ctx); err != nil {
+			t.Fatalf("Connect() after fixing the env file error = %v", err)
+		}
+		_ = transport.Close(ctx)
+	})
+}
+
+func TestSubprocessCLITransport_ToolResultSpill(t *testing.T) {
+	mockScript := `#!/bin/bash
+big=$(head -c 4096 /dev/zero | tr '\0' 'x')
+echo '{"type":"user","content":[{"type":"tool_result","tool_use_id":"t1","content":"'"$big"'"},{"type":"tool_result","tool_use_id":"t2","content":"small"}]}'
+echo '{"type":"result","subtype":"success","session_id":"test"}'
+`
+	cliPath := createMockCLI(t, mockScript)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+
+	spillDir := t.TempDir()
+	options := types.NewClaudeAgentOptions().
+		WithMaxBufferSize(1024).
+		WithToolResultSpill(spillDir, 100)
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = cliPath
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := transport.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect to mock CLI: %v", err)
+	}
+	defer func() {
+		_ = transport.Close(ctx)
+	}()
+
+	var user *types.UserMessage
+	for msg := range transport.ReadMessages(ctx) {
+		if m, ok := msg.(*types.UserMessage); ok {
+			user = m
+		}
+	}
+	if user == nil {
+		t.Fatal("Expected the oversized user message to be delivered")
+	}
+
+	blocks := user.Content.([]types.ContentBlock)
+	ref, ok := blocks[0].(*types.ToolResultBlock).Content.(*types.ToolResultFile)
+	if !ok {
+		t.Fatalf("Expected ToolResultFile, got %T", blocks[0].(*types.ToolResultBlock).Content)
+	}
+	if filepath.Dir(ref.Path) != spillDir || ref.Size != 4096 {
+		t.Errorf("Unexpected reference %+v", ref)
+	}
+	data, err := os.ReadFile(ref.Path)
+	if err != nil || string(data) != strings.Repeat("x", 4096) {
+		t.Errorf("Spilled file content mismatch (err %v, %d bytes)", err, len(data))
+	}
+
+	if content := blocks[1].(*types.ToolResultBlock).Content; content != "small" {
+		t.Errorf("Expected small result to stay inline, got %v", content)
+	}
+}
+
+func TestSubprocessCLITransport_HeartbeatTimeout(t *testing.T) {
+	// The mock answers the first message and then hangs without exiting
+	mockScript := `#!/bin/bash
+IFS= read -r line
+echo '{"type":"system","subtype":"input","data":{}}'
+exec sleep 30
+`
+	cliPath := createMockCLI(t, mockScript)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+	t.Setenv("CLAUDE_AGENT_SDK_SKIP_VERSION_CHECK", "1")
+
+	options := types.NewClaudeAgentOptions().WithHeartbeatTimeout(200*time.Millisecond, true)
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = cliPath
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := transport.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect to mock CLI: %v", err)
+	}
+	defer func() {
+		_ = transport.Close(ctx)
+	}()
+
+	if err := transport.Write(ctx, `{"type":"user"}`); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	select {
+	case err := <-transport.Errors():
+		var processErr *types.ProcessError
+		if !errors.As(err, &processErr) || !errors.Is(err, types.ErrHeartbeatTimeout) {
+			t.Errorf("Error = %v, want ProcessError wrapping ErrHeartbeatTimeout", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("Timed out waiting for the heartbeat error")
+	}
+
+	// The hung process is killed, ending the session
+	for range transport.ReadMessages(ctx) {
+	}
+	if reason, _ := transport.CloseReason(); reason != CloseReasonHeartbeatTimeout {
+		t.Errorf("CloseReason() = %q, want %q", reason, CloseReasonHeartbeatTimeout)
+	}
+}
+
+func TestSubprocessCLITransport_ContextWindowCallback(t *testing.T) {
+	// Usage rises past the threshold, drops after a compaction and rises
+	// again; the subagent message does not count
+	assistant := func(tokens int, parent string) string {
+		return `echo '{"type":"assistant","parent_tool_use_id":` + parent + `,"message":{"model":"claude-sonnet-4-5","content":[],"usage":{"input_tokens":` + strconv.Itoa(tokens) + `}}}'`
+	}
+	mockScript := "#!/bin/bash\n" + strings.Join([]string{
+		assistant(100000, "null"),
+		assistant(170000, "null"),
+		assistant(190000, "null"),
+		assistant(199000, `"tool_1"`),
+		assistant(60000, "null"),
+		assistant(180000, "null"),
+	}, "\n") + "\necho '{\"type\":\"result\",\"subtype\":\"success\",\"session_id\":\"test\"}'\n"
+	cliPath := createMockCLI(t, mockScript)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+
+	var crossings []int
+	options := types.NewClaudeAgentOptions().WithContextWindowCallback(0.8, func(budget types.ContextBudget) {
+		crossings = append(crossings, budget.Used)
+	})
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = cliPath
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := transport.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect to mock CLI: %v", err)
+	}
+	defer func() {
+		_ = transport.Close(ctx)
+	}()
+
+	for range transport.ReadMessages(ctx) {
+	}
+
+	if want := []int{170000, 180000}; !reflect.DeepEqual(crossings, want) {
+		t.Errorf("Crossings = %v, want %v", crossings, want)
+	}
+}
+
+func TestSubprocessCLITransport_CallbackPanicRecovered(t *testing.T) {
+	mockScript := `#!/bin/bash
+echo '{"type":"system","subtype":"init","session_id":"test","model":"claude-sonnet-4-5"}'
+echo '{"type":"stream_event","uuid":"u1","session_id":"test","event":{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}}'
+echo '{"type":"stream_event","uuid":"u2","session_id":"test","event":{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi"}}}'
+echo '{"type":"stream_event","uuid":"u3","session_id":"test","event":{"type":"content_block_stop","index":0}}'
+echo '{"type":"assistant","message":{"model":"claude-sonnet-4-5","content":[],"usage":{"input_tokens":190000}}}'
+echo '{"type":"result","subtype":"success","session_id":"test"}'
+`
+	cliPath := createMockCLI(t, mockScript)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+
+	options := types.NewClaudeAgentOptions().
+		WithOnSessionStart(func(sessionID, model string) { panic("session start") }).
+		WithPartialTextCallback(func(delta string) { panic("partial text") }).
+		WithBlockCallback(func(block types.ContentBlock) { panic("block") }).
+		WithContextWindowCallback(0.8, func(budget types.ContextBudget) { panic("context window") })
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = cliPath
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := transport.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect to mock CLI: %v", err)
+	}
+	defer func() {
+		_ = transport.Close(ctx)
+	}()
+
+	count := 0
+	for range transport.ReadMessages(ctx) {
+		count++
+	}
+	if count != 6 {
+		t.Errorf("Expected all 6 messages despite the panics, got %d", count)
+	}
+
+	var panics []string
+	for len(panics) < 4 {
+		select {
+		case err := <-transport.Errors():
+			var controlErr *types.ControlProtocolError
+			if errors.As(err, &controlErr) && strings.Contains(err.Error(), "panicked") {
+				panics = append(panics, err.Error())
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected 4 panics to be reported, got %v", panics)
+		}
+	}
+	for _, name := range []string{"OnSessionStart", "PartialTextCallback", "BlockCallback", "ContextWindowCallback"} {
+		if !strings.Contains(strings.Join(panics, "\n"), name) {
+			t.Errorf("Expected a panic reported for %s, got %v", name, panics)
+		}
+	}
+}
+
+func TestSubprocessCLITransport_Keepalive(t *testing.T) {
+	// The mock reports each line it reads and exits after two keepalives
+	mockScript := `#!/bin/bash
+blank=0
+while IFS= read -r line; do
+    if [ -z "$line" ]; then
+        blank=$((blank + 1))
+        echo '{"type":"system","subtype":"keepalive","data":{}}'
+        [ "$blank" -ge 2 ] && break
+    else
+        echo '{"type":"system","subtype":"input","data":{}}'
+    fi
+done
+echo '{"type":"result","subtype":"success","session_id":"test"}'
+`
+	cliPath := createMockCLI(t, mockScript)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+
+	options := types.NewClaudeAgentOptions().WithKeepalive(50 * time.Millisecond)
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = cliPath
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := transport.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect to mock CLI: %v", err)
+	}
+	defer func() {
+		_ = transport.Close(ctx)
+	}()
+
+	if err := transport.Write(ctx, `{"type":"user"}`); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	var subtypes []string
+	for msg := range transport.ReadMessages(ctx) {
+		if system, ok := msg.(*types.SystemMessage); ok {
+			subtypes = append(subtypes, system.Subtype)
+		}
+	}
+
+	want := []string{"input", "keepalive", "keepalive"}
+	if strings.Join(subtypes, ",") != strings.Join(want, ",") {
+		t.Errorf("Mock saw %v, want %v", subtypes, want)
+	}
+}
+
+func TestSubprocessCLITransport_Wait(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		wantErr any
+	}{
+		{
+			name:   "clean completion",
+			output: `echo '{"type":"result","subtype":"success","session_id":"test"}'`,
+		},
+		{
+			name:    "error result",
+			output:  `echo '{"type":"result","subtype":"error_during_execution","is_error":true,"session_id":"test","result":"boom"}'`,
+			wantErr: new(*types.ResultError),
+		},
+		{
+			name:    "crash",
+			output:  `exit 3`,
+			wantErr: new(*types.ProcessError),
+		},
+		{
+			name:    "no result",
+			output:  `echo '{"type":"system","subtype":"init","data":{}}'`,
+			wantErr: new(*types.ProcessError),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cliPath := createMockCLI(t, "#!/bin/bash\n"+tt.output+"\n")
+			defer func() {
+				_ = os.RemoveAll(filepath.Dir(cliPath))
+			}()
+
+			transport := NewSubprocessCLITransport("test", types.NewClaudeAgentOptions())
+			transport.cliPath = cliPath
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			if err := transport.Connect(ctx); err != nil {
+				t.Fatalf("Failed to connect to mock CLI: %v", err)
+			}
+			defer func() {
+				_ = transport.Close(ctx)
+			}()
+
+			go func() {
+				for range transport.ReadMessages(ctx) {
+				}
+			}()
+
+			err := transport.Wait(ctx)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Errorf("Wait() error = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.As(err, tt.wantErr) {
+				t.Errorf("Wait() error = %v (%T), want %T", err, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSubprocessCLITransport_Wait_ContextCancelled(t *testing.T) {
+	transport := NewSubprocessCLITransport("test", types.NewClaudeAgentOptions())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := transport.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() error = %v, want deadline exceeded", err)
+	}
+}
+
+func TestSubprocessCLITransport_Wait_ClosedBeforeConnect(t *testing.T) {
+	transport := NewSubprocessCLITransport("test", types.NewClaudeAgentOptions())
+	if err := transport.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := transport.Wait(ctx); err != nil {
+		t.Errorf("Wait() error = %v, want nil right after Close", err)
+	}
+	if reason, _ := transport.CloseReason(); reason != CloseReasonClosed {
+		t.Errorf("CloseReason() = %q, want %q", reason, CloseReasonClosed)
+	}
+}
+
+func TestSubprocessCLITransport_MaxMessageSize(t *testing.T) {
+	mockScript := `#!/bin/bash
+big=$(head -c 4096 /dev/zero | tr '\0' 'x')
+echo '{"type":"user","content":[{"type":"tool_result","tool_use_id":"t1","content":"'"$big"'"}]}'
+echo '{"type":"result","subtype":"success","session_id":"test"}'
+`
+	cliPath := createMockCLI(t, mockScript)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+
+	// The per-message limit applies even though the tool result could be spilled
+	options := types.NewClaudeAgentOptions().
+		WithMaxMessageSize(1024).
+		WithToolResultSpill(t.TempDir(), 100)
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = cliPath
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := transport.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect to mock CLI: %v", err)
+	}
+	defer func() {
+		_ = transport.Close(ctx)
+	}()
+
+	var received []string
+	for msg := range transport.ReadMessages(ctx) {
+		received = append(received, msg.Type())
+	}
+	if len(received) != 1 || received[0] != types.MessageTypeResult {
+		t.Errorf("Expected only the result message, got %v", received)
+	}
+
+	select {
+	case err := <-transport.errorChan:
+		var tooLarge *types.MessageTooLargeError
+		if !errors.As(err, &tooLarge) {
+			t.Fatalf("Expected MessageTooLargeError, got %T: %v", err, err)
+		}
+		if tooLarge.Limit != 1024 || tooLarge.Size <= 1024 {
+			t.Errorf("Unexpected size %d / limit %d", tooLarge.Size, tooLarge.Limit)
+		}
+	default:
+		t.Fatal("Expected an error to be reported")
+	}
+}
+
+func TestSubprocessCLITransport_WriteReportsExitError(t *testing.T) {
+	// The mock closes its stdin so writes fail with a broken pipe, then exits with an error
+	mockScript := `#!/bin/bash
+exec 0<&-
+sleep 0.1
+exit 3
+`
+	cliPath := createMockCLI(t, mockScript)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+
+	transport := NewSubprocessCLITransport("test", types.NewClaudeAgentOptions())
+	transport.cliPath = cliPath
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := transport.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect to mock CLI: %v", err)
+	}
+	defer func() {
+		_ = transport.Close(ctx)
+	}()
+	go func() {
+		for range transport.ReadMessages(ctx) {
+		}
+	}()
+
+	// Writes may be accepted until the mock has closed its stdin
+	var err error
+	for i := 0; i < 50 && err == nil; i++ {
+		err = transport.Write(ctx, `{"type":"user"}`)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var processErr *types.ProcessError
+	if !errors.As(err, &processErr) {
+		t.Fatalf("Expected write error to carry the ProcessError, got %v", err)
+	}
+	if processErr.ExitCode != 3 {
+		t.Errorf("ExitCode = %d, want 3", processErr.ExitCode)
+	}
+}
+
+func TestSubprocessCLITransport_LineLargerThan10MB(t *testing.T) {
+	const size = 11 * 1024 * 1024
+	mockScript := `#!/bin/bash
+big=$(head -c ` + strconv.Itoa(size) + ` /dev/zero | tr '\0' 'x')
+printf '{"type":"system","subtype":"big","data":{"payload":"%s"}}\n' "$big"
+echo '{"type":"result","subtype":"success","session_id":"test"}'
+`
+	cliPath := createMockCLI(t, mockScript)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cliPath))
+	}()
+
+	options := types.NewClaudeAgentOptions().WithMaxBufferSize(16 * 1024 * 1024)
+	transport := NewSubprocessCLITransport("test", options)
+	transport.cliPath = cliPath
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := transport.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect to mock CLI: %v", err)
+	}
+	defer func() {
+		_ = transport.Close(ctx)
+	}()
+
+	var received []types.Message
+	for msg := range transport.ReadMessages(ctx) {
+		received = append(received, msg)
+	}
+
+	if len(received) != 2 {
+		t.Fatalf("Expected 2 messages, got %d", len(received))
+	}
+	system, ok := received[0].(*types.SystemMessage)
+	if !ok {
+		t.Fatalf("Expected SystemMessage, got %T", received[0])
+	}
+	if payload, _ := system.Data["payload"].(string); len(payload) != size {
+		t.Errorf("Payload length = %d, want %d", len(payload), size)
+	}
+}
+
+// newPipeTransport returns a ready transport whose stdin is an in-process pipe
+// drained by a background goroutine
+func newPipeTransport(b *testing.B, options *types.ClaudeAgentOptions) *SubprocessCLITransport {
+	b.Helper()
+
+	reader, writer := io.Pipe()
+	go func() {
+		_, _ = io.Copy(io.Discard, reader)
+	}()
+	b.Cleanup(func() {
+		_ = writer.Close()
+	})
+
+	transport := NewSubprocessCLITransport("bench", options)
+	transport.stdin = writer
+	if options.StdinBufferSize != nil {
+		transport.stdinWriter = bufio.NewWriterSize(writer, *options.StdinBufferSize)
+	} else {
+		transport.stdinWriter = bufio.NewWriter(writer)
+	}
+	transport.ready = true
+	return transport
+}
+
+func benchmarkLargeWrites(b *testing.B, options *types.ClaudeAgentOptions) {
+	transport := newPipeTransport(b, options)
+	payload := strings.Repeat("x", 4*1024*1024)
+	ctx := context.Background()
+
+	b.SetBytes(int64(len(payload)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := transport.Write(ctx, payload); err != nil {
+			b.Fatalf("Write failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkWrite_LargeMessage_DefaultStdinBuffer(b *testing.B) {
+	benchmarkLargeWrites(b, types.NewClaudeAgentOptions())
+}
+
+func BenchmarkWrite_LargeMessage_1MBStdinBuffer(b *testing.B) {
+	benchmarkLargeWrites(b, types.NewClaudeAgentOptions().WithStdinBufferSize(1024*1024))
+}
+
+func BenchmarkWrite_SmallMessages(b *testing.B) {
+	transport := newPipeTransport(b, types.NewClaudeAgentOptions())
+	payload := `{"type":"control_response","response":{"subtype":"success","request_id":"req_1","response":{}}}`
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(payload)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := transport.Write(ctx, payload); err != nil {
+			b.Fatalf("Write failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package types
+
+import (
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// Attachment is file content sent to Claude alongside the prompt, as the
+// CLI does with content piped to its stdin. The SDK cannot pipe raw bytes:
+// stdin carries the stream-json protocol in streaming mode, so attachments
+// are encoded as content blocks of the initial user message instead.
+type Attachment struct {
+	// Name identifies the content to the model, such as a file name
+	Name string
+
+	// Reader supplies the content; it is read once when the session starts
+	Reader io.Reader
+}
+
+// NewAttachmentBlock reads r to the end and returns a text block presenting
+// its content to the model under name. The content must be UTF-8 text.
+func NewAttachmentBlock(name string, r io.Reader) (*TextBlock, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attachment %q: %w", name, err)
+	}
+	if !utf8.Valid(content) {
+		return nil, fmt.Errorf("attachment %q is not UTF-8 text", name)
+	}
+
+	return &TextBlock{
+		Type_: ContentTypeText,
+		Text:  fmt.Sprintf("<attachment name=%q>\n%s\n</attachment>", name, content),
+	}, nil
+}
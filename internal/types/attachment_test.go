@@ -0,0 +1,49 @@
+package types
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestNewAttachmentBlock(t *testing.T) {
+	tests := []struct {
+		name    string
+		reader  io.Reader
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "text",
+			reader: strings.NewReader("line 1\nline 2"),
+			want:   "<attachment name=\"notes.txt\">\nline 1\nline 2\n</attachment>",
+		},
+		{
+			name:    "binary content",
+			reader:  strings.NewReader("\xff\xfe"),
+			wantErr: true,
+		},
+		{
+			name:    "read error",
+			reader:  iotest.ErrReader(errors.New("disk failed")),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block, err := NewAttachmentBlock("notes.txt", tt.reader)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewAttachmentBlock() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if block.Type() != ContentTypeText || block.Text != tt.want {
+				t.Errorf("NewAttachmentBlock() = %q, want %q", block.Text, tt.want)
+			}
+		})
+	}
+}
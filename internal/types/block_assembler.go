@@ -0,0 +1,125 @@
+package types
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// BlockAssembler rebuilds content blocks from the stream events of partial
+// messages. Each block is returned once its content_block_stop event arrives,
+// so it can be rendered before the whole assistant message is complete.
+//
+// Blocks are tracked per parent tool use, since the streams of subagents
+// interleave with the main one and reuse block indexes.
+type BlockAssembler struct {
+	blocks map[blockKey]*partialBlock
+}
+
+// blockKey identifies a block being streamed
+type blockKey struct {
+	parentToolUseID string
+	index           int
+}
+
+// partialBlock accumulates the deltas of one block
+type partialBlock struct {
+	start     map[string]any
+	text      strings.Builder
+	json      strings.Builder
+	thinking  strings.Builder
+	signature strings.Builder
+}
+
+// NewBlockAssembler creates a new BlockAssembler
+func NewBlockAssembler() *BlockAssembler {
+	return &BlockAssembler{blocks: make(map[blockKey]*partialBlock)}
+}
+
+// Add consumes a stream event and returns the block it completes, if any.
+// Blocks of types other than text, thinking and tool_use are skipped.
+func (a *BlockAssembler) Add(event *StreamEvent) (ContentBlock, bool, error) {
+	eventType, _ := event.Event["type"].(string)
+	index, ok := event.Event["index"].(float64)
+	if !ok {
+		return nil, false, nil
+	}
+
+	key := blockKey{index: int(index)}
+	if event.ParentToolUseID != nil {
+		key.parentToolUseID = *event.ParentToolUseID
+	}
+
+	switch eventType {
+	case "content_block_start":
+		start, _ := event.Event["content_block"].(map[string]any)
+		a.blocks[key] = &partialBlock{start: start}
+
+	case "content_block_delta":
+		block, ok := a.blocks[key]
+		if !ok {
+			return nil, false, nil
+		}
+		delta, _ := event.Event["delta"].(map[string]any)
+		switch deltaType, _ := delta["type"].(string); deltaType {
+		case "text_delta":
+			text, _ := delta["text"].(string)
+			block.text.WriteString(text)
+		case "input_json_delta":
+			partial, _ := delta["partial_json"].(string)
+			block.json.WriteString(partial)
+		case "thinking_delta":
+			thinking, _ := delta["thinking"].(string)
+			block.thinking.WriteString(thinking)
+		case "signature_delta":
+			signature, _ := delta["signature"].(string)
+			block.signature.WriteString(signature)
+		}
+
+	case "content_block_stop":
+		block, ok := a.blocks[key]
+		if !ok {
+			return nil, false, nil
+		}
+		delete(a.blocks, key)
+		return block.complete()
+	}
+
+	return nil, false, nil
+}
+
+// complete builds the finished block from its start event and deltas
+func (b *partialBlock) complete() (ContentBlock, bool, error) {
+	blockType, _ := b.start["type"].(string)
+	switch blockType {
+	case ContentTypeText:
+		text, _ := b.start["text"].(string)
+		return &TextBlock{Type_: ContentTypeText, Text: text + b.text.String()}, true, nil
+
+	case ContentTypeThinking:
+		thinking, _ := b.start["thinking"].(string)
+		signature, _ := b.start["signature"].(string)
+		return &ThinkingBlock{
+			Type_:     ContentTypeThinking,
+			Thinking:  thinking + b.thinking.String(),
+			Signature: signature + b.signature.String(),
+		}, true, nil
+
+	case ContentTypeToolUse:
+		block := &ToolUseBlock{Type_: ContentTypeToolUse}
+		block.ID, _ = b.start["id"].(string)
+		block.Name, _ = b.start["name"].(string)
+		block.Input, _ = b.start["input"].(map[string]any)
+		if b.json.Len() > 0 {
+			if err := json.Unmarshal([]byte(b.json.String()), &block.Input); err != nil {
+				return nil, false, NewJSONDecodeError("failed to decode streamed tool input for "+block.Name, err)
+			}
+		}
+		if block.Input == nil {
+			block.Input = map[string]any{}
+		}
+		return block, true, nil
+
+	default:
+		return nil, false, nil
+	}
+}
@@ -0,0 +1,123 @@
+package types
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// streamEvents decodes stream events from JSON lines
+func streamEvents(t *testing.T, lines ...string) []*StreamEvent {
+	t.Helper()
+	events := make([]*StreamEvent, len(lines))
+	for i, line := range lines {
+		var event StreamEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("Invalid stream event %q: %v", line, err)
+		}
+		events[i] = &event
+	}
+	return events
+}
+
+func TestBlockAssembler(t *testing.T) {
+	tests := []struct {
+		name    string
+		events  []string
+		want    []ContentBlock
+		wantErr bool
+	}{
+		{
+			name: "text",
+			events: []string{
+				`{"event":{"type":"message_start"}}`,
+				`{"event":{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}}`,
+				`{"event":{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello, "}}}`,
+				`{"event":{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"world"}}}`,
+				`{"event":{"type":"content_block_stop","index":0}}`,
+			},
+			want: []ContentBlock{&TextBlock{Type_: ContentTypeText, Text: "Hello, world"}},
+		},
+		{
+			name: "thinking then tool use",
+			events: []string{
+				`{"event":{"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":""}}}`,
+				`{"event":{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"Need the file"}}}`,
+				`{"event":{"type":"content_block_delta","index":0,"delta":{"type":"signature_delta","signature":"sig"}}}`,
+				`{"event":{"type":"content_block_stop","index":0}}`,
+				`{"event":{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"t1","name":"Read","input":{}}}}`,
+				`{"event":{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"path\":"}}}`,
+				`{"event":{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"a.go\"}"}}}`,
+				`{"event":{"type":"content_block_stop","index":1}}`,
+			},
+			want: []ContentBlock{
+				&ThinkingBlock{Type_: ContentTypeThinking, Thinking: "Need the file", Signature: "sig"},
+				&ToolUseBlock{Type_: ContentTypeToolUse, ID: "t1", Name: "Read", Input: map[string]any{"path": "a.go"}},
+			},
+		},
+		{
+			name: "tool use without input",
+			events: []string{
+				`{"event":{"type":"content_block_start","index":0,"content_block":{"type":"tool_use","id":"t1","name":"Ping"}}}`,
+				`{"event":{"type":"content_block_stop","index":0}}`,
+			},
+			want: []ContentBlock{&ToolUseBlock{Type_: ContentTypeToolUse, ID: "t1", Name: "Ping", Input: map[string]any{}}},
+		},
+		{
+			name: "interleaved subagent stream",
+			events: []string{
+				`{"event":{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}}`,
+				`{"parent_tool_use_id":"task_1","event":{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}}`,
+				`{"event":{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"main"}}}`,
+				`{"parent_tool_use_id":"task_1","event":{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"sub"}}}`,
+				`{"parent_tool_use_id":"task_1","event":{"type":"content_block_stop","index":0}}`,
+				`{"event":{"type":"content_block_stop","index":0}}`,
+			},
+			want: []ContentBlock{
+				&TextBlock{Type_: ContentTypeText, Text: "sub"},
+				&TextBlock{Type_: ContentTypeText, Text: "main"},
+			},
+		},
+		{
+			name: "unsupported block type",
+			events: []string{
+				`{"event":{"type":"content_block_start","index":0,"content_block":{"type":"server_tool_use","id":"s1"}}}`,
+				`{"event":{"type":"content_block_stop","index":0}}`,
+			},
+		},
+		{
+			name: "invalid tool input",
+			events: []string{
+				`{"event":{"type":"content_block_start","index":0,"content_block":{"type":"tool_use","id":"t1","name":"Read"}}}`,
+				`{"event":{"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"{\"path\""}}}`,
+				`{"event":{"type":"content_block_stop","index":0}}`,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assembler := NewBlockAssembler()
+			var got []ContentBlock
+			var gotErr error
+			for _, event := range streamEvents(t, tt.events...) {
+				block, ok, err := assembler.Add(event)
+				if err != nil {
+					gotErr = err
+					continue
+				}
+				if ok {
+					got = append(got, block)
+				}
+			}
+
+			if (gotErr != nil) != tt.wantErr {
+				t.Errorf("Add() error = %v, wantErr %v", gotErr, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("blocks = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
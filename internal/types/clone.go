@@ -0,0 +1,90 @@
+package types
+
+import "reflect"
+
+// Clone returns a deep copy of the options, so that later changes to either
+// do not affect the other. Slices, maps, pointed-to values and JSON-like data
+// such as OutputSchema are copied. Behaviour is shared rather than copied:
+// callbacks, hooks, MCP server instances, attachment readers, Metrics,
+// Tracer and SessionRegistry refer to the same values in both.
+func (o *ClaudeAgentOptions) Clone() *ClaudeAgentOptions {
+	if o == nil {
+		return nil
+	}
+	return deepCopy(reflect.ValueOf(o)).Interface().(*ClaudeAgentOptions)
+}
+
+// deepCopy copies v recursively. Interface values are copied only when they
+// hold a map or slice; any other value they hold is shared, as are pointers to
+// structs with unexported state.
+func deepCopy(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() || hasUnexportedFields(v.Type().Elem()) {
+			return v
+		}
+		copied := reflect.New(v.Type().Elem())
+		copied.Elem().Set(deepCopy(v.Elem()))
+		return copied
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		copied := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			copied.Index(i).Set(deepCopy(v.Index(i)))
+		}
+		return copied
+
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		copied := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			copied.SetMapIndex(iter.Key(), deepCopy(iter.Value()))
+		}
+		return copied
+
+	case reflect.Struct:
+		// Unexported fields cannot be set and stay shallow copies
+		copied := reflect.New(v.Type()).Elem()
+		copied.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if field := copied.Field(i); field.CanSet() {
+				field.Set(deepCopy(v.Field(i)))
+			}
+		}
+		return copied
+
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		switch v.Elem().Kind() {
+		case reflect.Map, reflect.Slice:
+			copied := reflect.New(v.Type()).Elem()
+			copied.Set(deepCopy(v.Elem()))
+			return copied
+		}
+		return v
+
+	default:
+		return v
+	}
+}
+
+// hasUnexportedFields reports whether t is a struct with unexported fields
+func hasUnexportedFields(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if !t.Field(i).IsExported() {
+			return true
+		}
+	}
+	return false
+}
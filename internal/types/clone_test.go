@@ -0,0 +1,68 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClone(t *testing.T) {
+	server := &struct{ name string }{name: "calculator"}
+	canUseTool := func(string, map[string]any, interface{}) (PermissionResult, error) {
+		return PermissionResult{Behavior: "allow"}, nil
+	}
+
+	original := NewClaudeAgentOptions().
+		WithModel("claude-sonnet-4-5").
+		WithAllowedTools("Read").
+		WithEnv(map[string]string{"REGION": "us"}).
+		WithOutputSchema(map[string]any{"type": "object", "required": []any{"answer"}}).
+		WithWorkspaceTrust(WorkspaceTrust{Trusted: []string{"/srv"}}).
+		WithAgent("reviewer", AgentDefinition{Description: "Reviews code", Prompt: "Review", Tools: []string{"Read"}}).
+		WithMCPServer("calc", &MCPServerConfig{Type: "sdk", Name: "calc", Instance: server}).
+		WithCanUseTool(canUseTool).
+		WithSessionRegistry(NewSessionRegistry())
+
+	clone := original.Clone()
+	if !reflect.DeepEqual(clone.AllowedTools, original.AllowedTools) || *clone.Model != *original.Model {
+		t.Fatalf("Clone() = %+v, want a copy of %+v", clone, original)
+	}
+
+	// Changes to the clone do not reach the original
+	*clone.Model = "claude-opus-4"
+	clone.AllowedTools[0] = "Bash"
+	clone.Env["REGION"] = "eu"
+	clone.OutputSchema["required"].([]any)[0] = "other"
+	clone.WorkspaceTrust.Trusted[0] = "/tmp"
+	clone.Agents["reviewer"].Tools[0] = "Bash"
+
+	if *original.Model != "claude-sonnet-4-5" {
+		t.Errorf("Model = %s, want claude-sonnet-4-5", *original.Model)
+	}
+	if original.AllowedTools[0] != "Read" || original.Env["REGION"] != "us" {
+		t.Errorf("AllowedTools = %v, Env = %v; want the original values", original.AllowedTools, original.Env)
+	}
+	if original.OutputSchema["required"].([]any)[0] != "answer" {
+		t.Errorf("OutputSchema = %v, want the original schema", original.OutputSchema)
+	}
+	if original.WorkspaceTrust.Trusted[0] != "/srv" || original.Agents["reviewer"].Tools[0] != "Read" {
+		t.Errorf("WorkspaceTrust = %v, Agents = %v; want the original values", original.WorkspaceTrust, original.Agents)
+	}
+
+	// Behaviour is shared
+	if clone.MCPServers["calc"].Instance != server {
+		t.Error("MCP server instance should be shared, not copied")
+	}
+	if clone.SessionRegistry != original.SessionRegistry {
+		t.Error("SessionRegistry should be shared, not copied")
+	}
+	if clone.CanUseTool == nil {
+		t.Error("CanUseTool should be kept")
+	}
+}
+
+func TestClone_Nil(t *testing.T) {
+	var options *ClaudeAgentOptions
+	if options.Clone() != nil {
+		t.Error("Clone() of nil options should be nil")
+	}
+}
@@ -9,18 +9,68 @@ const (
 	MessageTypeStreamEvent = "stream_event"
 )
 
+// Stop reason constants reported on assistant messages
+const (
+	StopReasonEndTurn      = "end_turn"
+	StopReasonMaxTokens    = "max_tokens"
+	StopReasonToolUse      = "tool_use"
+	StopReasonStopSequence = "stop_sequence"
+)
+
+// System message subtype constants
+const (
+	SystemSubtypeInit            = "init"
+	SystemSubtypeCompactBoundary = "compact_boundary"
+)
+
+// Stream-json protocol versions supported by this SDK. The CLI reports the
+// version it speaks in the init message's protocol_version field; CLIs that
+// omit it speak version 1.
+const (
+	MinProtocolVersion = 1
+	MaxProtocolVersion = 1
+)
+
+// DefaultMessageChannelBuffer is the default capacity of message channels
+const DefaultMessageChannelBuffer = 100
+
+// DefaultMaxQueuedMessages is the default number of messages the control layer
+// queues for a consumer that falls behind
+const DefaultMaxQueuedMessages = 10000
+
+// Result message subtype constants
+const (
+	ResultSubtypeSuccess              = "success"
+	ResultSubtypeErrorMaxTurns        = "error_max_turns"
+	ResultSubtypeErrorDuringExecution = "error_during_execution"
+)
+
+// In-process MCP server and tool that serve the handler set with
+// WithPermissionPromptHandler. PermissionPromptToolName is the name passed to
+// the CLI with --permission-prompt-tool.
+const (
+	PermissionPromptServerName = "sdk_permission_prompt"
+	PermissionPromptTool       = "approve"
+	PermissionPromptToolName   = "mcp__" + PermissionPromptServerName + "__" + PermissionPromptTool
+)
+
+// ToolNameExitPlanMode is the tool the CLI calls in plan mode to present its plan for approval
+const ToolNameExitPlanMode = "ExitPlanMode"
+
 // Content block type constants
 const (
 	ContentTypeText       = "text"
 	ContentTypeThinking   = "thinking"
 	ContentTypeToolUse    = "tool_use"
 	ContentTypeToolResult = "tool_result"
+	ContentTypeImage      = "image"
 )
 
 // Control request/response type constants
 const (
 	ControlTypeRequest         = "control_request"
 	ControlTypeResponse        = "control_response"
+	ControlTypeCancelRequest   = "control_cancel_request"
 	ControlResponseTypeSuccess = "success"
 	ControlResponseTypeError   = "error"
 )
@@ -34,3 +84,28 @@ const (
 	SubtypeHookCallback      = "hook_callback"
 	SubtypeMCPMessage        = "mcp_message"
 )
+
+// Permission update type constants
+const (
+	PermissionUpdateTypeAddRules          = "addRules"
+	PermissionUpdateTypeReplaceRules      = "replaceRules"
+	PermissionUpdateTypeRemoveRules       = "removeRules"
+	PermissionUpdateTypeSetMode           = "setMode"
+	PermissionUpdateTypeAddDirectories    = "addDirectories"
+	PermissionUpdateTypeRemoveDirectories = "removeDirectories"
+)
+
+// Permission behavior constants
+const (
+	PermissionBehaviorAllow = "allow"
+	PermissionBehaviorDeny  = "deny"
+	PermissionBehaviorAsk   = "ask"
+)
+
+// Permission update destination constants
+const (
+	PermissionDestinationUserSettings    = "userSettings"
+	PermissionDestinationProjectSettings = "projectSettings"
+	PermissionDestinationLocalSettings   = "localSettings"
+	PermissionDestinationSession         = "session"
+)
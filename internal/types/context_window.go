@@ -0,0 +1,80 @@
+package types
+
+import "strings"
+
+// DefaultContextWindow is the context window assumed for models not listed
+// in ModelContextWindows
+const DefaultContextWindow = 200_000
+
+// ModelContextWindows maps model name prefixes to their context window in
+// tokens. The longest matching prefix wins, and a "[1m]" suffix selects the
+// one million token window.
+var ModelContextWindows = map[string]int{
+	"claude-opus-4":     200_000,
+	"claude-sonnet-4":   200_000,
+	"claude-haiku-4":    200_000,
+	"claude-3-7-sonnet": 200_000,
+	"claude-3-5-sonnet": 200_000,
+	"claude-3-5-haiku":  200_000,
+	"claude-3-opus":     200_000,
+	"claude-3-haiku":    200_000,
+	"opus":              200_000,
+	"sonnet":            200_000,
+	"haiku":             200_000,
+}
+
+// ContextWindow returns the context window of model in tokens, and whether
+// the model is known
+func ContextWindow(model string) (int, bool) {
+	if strings.HasSuffix(strings.ToLower(model), "[1m]") {
+		return 1_000_000, true
+	}
+
+	window, matched := DefaultContextWindow, ""
+	for prefix, size := range ModelContextWindows {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(matched) {
+			window, matched = size, prefix
+		}
+	}
+	return window, matched != ""
+}
+
+// ContextBudget describes how much of a model's context window is in use
+type ContextBudget struct {
+	Model  string
+	Window int // Context window of the model in tokens
+	Used   int // Tokens occupying the context
+}
+
+// Remaining returns the tokens left in the context window
+func (b ContextBudget) Remaining() int {
+	if b.Used >= b.Window {
+		return 0
+	}
+	return b.Window - b.Used
+}
+
+// Fraction returns the fraction of the context window in use
+func (b ContextBudget) Fraction() float64 {
+	if b.Window <= 0 {
+		return 0
+	}
+	return float64(b.Used) / float64(b.Window)
+}
+
+// EstimateContextBudget estimates the context in use after an API request
+// from its usage, as reported in AssistantMessage.Usage: the input tokens,
+// including those read from or written to the cache, plus the output tokens
+// that become part of the next request. The cumulative usage of a
+// ResultMessage overstates it.
+func EstimateContextBudget(model string, usage map[string]any) ContextBudget {
+	window, _ := ContextWindow(model)
+
+	used := 0
+	for _, key := range []string{"input_tokens", "cache_creation_input_tokens", "cache_read_input_tokens", "output_tokens"} {
+		if tokens, ok := AsInt64(usage[key]); ok {
+			used += int(tokens)
+		}
+	}
+	return ContextBudget{Model: model, Window: window, Used: used}
+}
@@ -0,0 +1,53 @@
+package types
+
+import "testing"
+
+func TestContextWindow(t *testing.T) {
+	tests := []struct {
+		model     string
+		want      int
+		wantKnown bool
+	}{
+		{model: "claude-sonnet-4-5-20250929", want: 200_000, wantKnown: true},
+		{model: "claude-3-5-haiku-latest", want: 200_000, wantKnown: true},
+		{model: "sonnet", want: 200_000, wantKnown: true},
+		{model: "claude-sonnet-4-5[1m]", want: 1_000_000, wantKnown: true},
+		{model: "unreleased-model", want: DefaultContextWindow},
+		{model: "", want: DefaultContextWindow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			got, known := ContextWindow(tt.model)
+			if got != tt.want || known != tt.wantKnown {
+				t.Errorf("ContextWindow(%q) = %d, %v, want %d, %v", tt.model, got, known, tt.want, tt.wantKnown)
+			}
+		})
+	}
+}
+
+func TestEstimateContextBudget(t *testing.T) {
+	usage := map[string]any{
+		"input_tokens":                10.0,
+		"cache_creation_input_tokens": 40_000.0,
+		"cache_read_input_tokens":     109_990.0,
+		"output_tokens":               10_000,
+		"service_tier":                "standard",
+	}
+
+	budget := EstimateContextBudget("claude-sonnet-4-5", usage)
+	if budget.Used != 160_000 {
+		t.Errorf("Used = %d, want 160000", budget.Used)
+	}
+	if budget.Remaining() != 40_000 {
+		t.Errorf("Remaining() = %d, want 40000", budget.Remaining())
+	}
+	if budget.Fraction() != 0.8 {
+		t.Errorf("Fraction() = %g, want 0.8", budget.Fraction())
+	}
+
+	over := ContextBudget{Window: 100, Used: 150}
+	if over.Remaining() != 0 {
+		t.Errorf("Remaining() over the window = %d, want 0", over.Remaining())
+	}
+}
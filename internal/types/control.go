@@ -193,48 +193,54 @@ type InterruptRequestWrapper struct {
 	request *InterruptRequest
 }
 
-func (w *InterruptRequestWrapper) Type() string      { return w.request.Type() }
-func (w *InterruptRequestWrapper) RequestID() string { return w.wrapper.ID }
+func (w *InterruptRequestWrapper) Type() string               { return w.request.Type() }
+func (w *InterruptRequestWrapper) RequestID() string          { return w.wrapper.ID }
+func (w *InterruptRequestWrapper) Request() *InterruptRequest { return w.request }
 
 type PermissionRequestWrapper struct {
 	wrapper *SDKControlRequest
 	request *PermissionRequest
 }
 
-func (w *PermissionRequestWrapper) Type() string      { return w.request.Type() }
-func (w *PermissionRequestWrapper) RequestID() string { return w.wrapper.ID }
+func (w *PermissionRequestWrapper) Type() string                { return w.request.Type() }
+func (w *PermissionRequestWrapper) RequestID() string           { return w.wrapper.ID }
+func (w *PermissionRequestWrapper) Request() *PermissionRequest { return w.request }
 
 type InitializeRequestWrapper struct {
 	wrapper *SDKControlRequest
 	request *InitializeRequest
 }
 
-func (w *InitializeRequestWrapper) Type() string      { return w.request.Type() }
-func (w *InitializeRequestWrapper) RequestID() string { return w.wrapper.ID }
+func (w *InitializeRequestWrapper) Type() string                { return w.request.Type() }
+func (w *InitializeRequestWrapper) RequestID() string           { return w.wrapper.ID }
+func (w *InitializeRequestWrapper) Request() *InitializeRequest { return w.request }
 
 type SetPermissionModeRequestWrapper struct {
 	wrapper *SDKControlRequest
 	request *SetPermissionModeRequest
 }
 
-func (w *SetPermissionModeRequestWrapper) Type() string      { return w.request.Type() }
-func (w *SetPermissionModeRequestWrapper) RequestID() string { return w.wrapper.ID }
+func (w *SetPermissionModeRequestWrapper) Type() string                       { return w.request.Type() }
+func (w *SetPermissionModeRequestWrapper) RequestID() string                  { return w.wrapper.ID }
+func (w *SetPermissionModeRequestWrapper) Request() *SetPermissionModeRequest { return w.request }
 
 type HookCallbackRequestWrapper struct {
 	wrapper *SDKControlRequest
 	request *HookCallbackRequest
 }
 
-func (w *HookCallbackRequestWrapper) Type() string      { return w.request.Type() }
-func (w *HookCallbackRequestWrapper) RequestID() string { return w.wrapper.ID }
+func (w *HookCallbackRequestWrapper) Type() string                  { return w.request.Type() }
+func (w *HookCallbackRequestWrapper) RequestID() string             { return w.wrapper.ID }
+func (w *HookCallbackRequestWrapper) Request() *HookCallbackRequest { return w.request }
 
 type MCPMessageRequestWrapper struct {
 	wrapper *SDKControlRequest
 	request *MCPMessageRequest
 }
 
-func (w *MCPMessageRequestWrapper) Type() string      { return w.request.Type() }
-func (w *MCPMessageRequestWrapper) RequestID() string { return w.wrapper.ID }
+func (w *MCPMessageRequestWrapper) Type() string                { return w.request.Type() }
+func (w *MCPMessageRequestWrapper) RequestID() string           { return w.wrapper.ID }
+func (w *MCPMessageRequestWrapper) Request() *MCPMessageRequest { return w.request }
 
 // MarshalControlResponse marshals a ControlResponse to JSON
 func MarshalControlResponse(resp ControlResponse) ([]byte, error) {
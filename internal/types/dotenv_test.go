@@ -0,0 +1,84 @@
+package types
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseEnv(t *testing.T) {
+	input := `# API credentials
+ANTHROPIC_API_KEY=sk-test-123
+export REGION=us-east-1
+
+EMPTY=
+UNQUOTED = spaced value # trailing comment
+HASH_IN_VALUE=abc#def
+DOUBLE="line one\nline \"two\""
+SINGLE='literal \n $HOME # not a comment'
+URL=https://example.com/?a=b
+`
+	env, err := ParseEnv(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseEnv() error = %v", err)
+	}
+
+	want := map[string]string{
+		"ANTHROPIC_API_KEY": "sk-test-123",
+		"REGION":            "us-east-1",
+		"EMPTY":             "",
+		"UNQUOTED":          "spaced value",
+		"HASH_IN_VALUE":     "abc#def",
+		"DOUBLE":            "line one\nline \"two\"",
+		"SINGLE":            `literal \n $HOME # not a comment`,
+		"URL":               "https://example.com/?a=b",
+	}
+	if len(env) != len(want) {
+		t.Errorf("ParseEnv() returned %d keys, want %d: %v", len(env), len(want), env)
+	}
+	for key, value := range want {
+		if env[key] != value {
+			t.Errorf("env[%s] = %q, want %q", key, env[key], value)
+		}
+	}
+}
+
+func TestParseEnv_Invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "missing equals", input: "NOT_A_PAIR"},
+		{name: "empty key", input: "=value"},
+		{name: "unterminated double quote", input: `KEY="value`},
+		{name: "unterminated single quote", input: `KEY='value`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseEnv(strings.NewReader(tt.input)); err == nil {
+				t.Error("Expected parse error")
+			}
+		})
+	}
+}
+
+func TestLoadEnvFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte("KEY=value\n"), 0600); err != nil {
+		t.Fatalf("Failed to write env file: %v", err)
+	}
+
+	env, err := LoadEnvFile(path)
+	if err != nil {
+		t.Fatalf("LoadEnvFile() error = %v", err)
+	}
+	if env["KEY"] != "value" {
+		t.Errorf("env[KEY] = %q, want %q", env["KEY"], "value")
+	}
+
+	if _, err := LoadEnvFile(filepath.Join(t.TempDir(), "missing.env")); err == nil {
+		t.Error("Expected error for missing file")
+	}
+}
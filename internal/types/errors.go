@@ -1,7 +1,42 @@
 package types
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"strings"
+)
+
+// Sentinel errors for common conditions. The typed errors below match them
+// with errors.Is through their Kind field, so callers can branch on the
+// condition without a type assertion.
+var (
+	// ErrNotConnected means the client or transport has not been connected
+	ErrNotConnected = errors.New("not connected")
+
+	// ErrClosed means the client or transport has been closed
+	ErrClosed = errors.New("closed")
+
+	// ErrInputClosed means the input stream was ended with EndInput
+	ErrInputClosed = errors.New("input closed")
+
+	// ErrControlTimeout means the CLI did not answer a control request in time
+	ErrControlTimeout = errors.New("control request timed out")
+
+	// ErrHeartbeatTimeout means the CLI produced no output for the heartbeat
+	// timeout during a turn
+	ErrHeartbeatTimeout = errors.New("heartbeat timed out")
+
+	// ErrReadyTimeout means the CLI did not send its init message in time
+	ErrReadyTimeout = errors.New("ready timeout")
+
+	// ErrSessionInUse means another transport sharing the SessionRegistry is
+	// already resuming the session
+	ErrSessionInUse = errors.New("session in use")
+
+	// ErrInterrupted is the cancellation cause of the contexts of callbacks
+	// still running when the turn is interrupted
+	ErrInterrupted = errors.New("interrupted")
 )
 
 // CLINotFoundError is returned when the Claude Code CLI cannot be found
@@ -33,6 +68,9 @@ func NewCLINotFoundError(message string, cause error) *CLINotFoundError {
 type CLIConnectionError struct {
 	Message string
 	Cause   error
+
+	// Kind is the sentinel error the condition matches, such as ErrClosed
+	Kind error
 }
 
 func (e *CLIConnectionError) Error() string {
@@ -46,6 +84,11 @@ func (e *CLIConnectionError) Unwrap() error {
 	return e.Cause
 }
 
+// Is reports whether target is the sentinel error for the condition
+func (e *CLIConnectionError) Is(target error) bool {
+	return e.Kind != nil && target == e.Kind
+}
+
 // NewCLIConnectionError creates a new CLIConnectionError
 func NewCLIConnectionError(message string, cause error) *CLIConnectionError {
 	return &CLIConnectionError{
@@ -58,19 +101,37 @@ func NewCLIConnectionError(message string, cause error) *CLIConnectionError {
 type ProcessError struct {
 	Message string
 	Cause   error
+
+	// Stderr holds the last lines the process wrote to stderr, if captured
+	Stderr []string
+
+	// ExitCode is the process exit code, or -1 if it was terminated by a signal
+	ExitCode int
+
+	// Signal is the signal that terminated the process, or nil if it exited normally
+	Signal os.Signal
 }
 
 func (e *ProcessError) Error() string {
+	msg := e.Message
 	if e.Cause != nil {
-		return fmt.Sprintf("%s: %v", e.Message, e.Cause)
+		msg = fmt.Sprintf("%s: %v", e.Message, e.Cause)
 	}
-	return e.Message
+	if len(e.Stderr) > 0 {
+		msg += "\nstderr:\n" + strings.Join(e.Stderr, "\n")
+	}
+	return msg
 }
 
 func (e *ProcessError) Unwrap() error {
 	return e.Cause
 }
 
+// Signaled reports whether the process was terminated by a signal
+func (e *ProcessError) Signaled() bool {
+	return e.Signal != nil
+}
+
 // NewProcessError creates a new ProcessError
 func NewProcessError(message string, cause error) *ProcessError {
 	return &ProcessError{
@@ -133,6 +194,9 @@ func NewMessageParseError(message string, cause error) *MessageParseError {
 type ControlProtocolError struct {
 	Message string
 	Cause   error
+
+	// Kind is the sentinel error the condition matches, such as ErrControlTimeout
+	Kind error
 }
 
 func (e *ControlProtocolError) Error() string {
@@ -146,6 +210,11 @@ func (e *ControlProtocolError) Unwrap() error {
 	return e.Cause
 }
 
+// Is reports whether target is the sentinel error for the condition
+func (e *ControlProtocolError) Is(target error) bool {
+	return e.Kind != nil && target == e.Kind
+}
+
 // NewControlProtocolError creates a new ControlProtocolError
 func NewControlProtocolError(message string, cause error) *ControlProtocolError {
 	return &ControlProtocolError{
@@ -178,3 +247,124 @@ func NewPermissionDeniedError(message string, cause error) *PermissionDeniedErro
 		Cause:   cause,
 	}
 }
+
+// ResultError is returned when a session finishes with an error result
+type ResultError struct {
+	Message string
+	Cause   error
+
+	// Subtype is the result subtype, such as "error_during_execution", if the
+	// error came from a result message
+	Subtype string
+
+	// Detail is the error text the CLI reported, if any
+	Detail string
+}
+
+func (e *ResultError) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Cause)
+	}
+	return e.Message
+}
+
+func (e *ResultError) Unwrap() error {
+	return e.Cause
+}
+
+// NewResultError creates a new ResultError
+func NewResultError(message string, cause error) *ResultError {
+	return &ResultError{
+		Message: message,
+		Cause:   cause,
+	}
+}
+
+// MessageTooLargeError is returned when a single message exceeds MaxMessageSize
+type MessageTooLargeError struct {
+	Message string
+	Cause   error
+
+	// Size is the number of bytes read before the message was rejected
+	Size int
+
+	// Limit is the configured maximum message size
+	Limit int
+}
+
+func (e *MessageTooLargeError) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Cause)
+	}
+	return e.Message
+}
+
+func (e *MessageTooLargeError) Unwrap() error {
+	return e.Cause
+}
+
+// NewMessageTooLargeError creates a new MessageTooLargeError
+func NewMessageTooLargeError(message string, cause error) *MessageTooLargeError {
+	return &MessageTooLargeError{
+		Message: message,
+		Cause:   cause,
+	}
+}
+
+// OutputSchemaError is returned when the final result does not conform to the
+// schema set with WithOutputSchema
+type OutputSchemaError struct {
+	Message string
+	Cause   error
+
+	// Path locates the offending value, such as "$.items[2].name"
+	Path string
+}
+
+func (e *OutputSchemaError) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Cause)
+	}
+	return e.Message
+}
+
+func (e *OutputSchemaError) Unwrap() error {
+	return e.Cause
+}
+
+// NewOutputSchemaError creates a new OutputSchemaError
+func NewOutputSchemaError(message string, cause error) *OutputSchemaError {
+	return &OutputSchemaError{
+		Message: message,
+		Cause:   cause,
+	}
+}
+
+// PromptBlockedError is returned when a UserPromptSubmit hook blocks a prompt
+// or fails while inspecting it
+type PromptBlockedError struct {
+	Message string
+	Cause   error
+
+	// Reason is the reason the hook gave for blocking the prompt, if any
+	Reason string
+}
+
+func (e *PromptBlockedError) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Cause)
+	}
+	return e.Message
+}
+
+func (e *PromptBlockedError) Unwrap() error {
+	return e.Cause
+}
+
+// NewPromptBlockedError creates a new PromptBlockedError
+func NewPromptBlockedError(message string, cause error) *PromptBlockedError {
+	return &PromptBlockedError{
+		Message: message,
+		Cause:   cause,
+	}
+}
@@ -2,6 +2,7 @@ package types
 
 import (
 	"errors"
+	"fmt"
 	"testing"
 )
 
@@ -97,6 +98,16 @@ func TestProcessError(t *testing.T) {
 	}
 }
 
+func TestProcessError_Stderr(t *testing.T) {
+	err := NewProcessError("Process error", errors.New("exit code 1"))
+	err.Stderr = []string{"first line", "second line"}
+
+	want := "Process error: exit code 1\nstderr:\nfirst line\nsecond line"
+	if err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+}
+
 func TestJSONDecodeError(t *testing.T) {
 	tests := []testErrorCase{
 		{
@@ -193,6 +204,107 @@ func TestPermissionDeniedError(t *testing.T) {
 	}
 }
 
+func TestResultError(t *testing.T) {
+	tests := []testErrorCase{
+		{
+			name:    "no cause",
+			message: "Result error",
+			cause:   nil,
+			want:    "Result error",
+		},
+		{
+			name:    "with cause",
+			message: "Result error",
+			cause:   errors.New("execution failed"),
+			want:    "Result error: execution failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewResultError(tt.message, tt.cause)
+			testErrorBehavior(t, err, tt)
+		})
+	}
+}
+
+func TestMessageTooLargeError(t *testing.T) {
+	tests := []testErrorCase{
+		{
+			name:    "no cause",
+			message: "Message too large",
+			cause:   nil,
+			want:    "Message too large",
+		},
+		{
+			name:    "with cause",
+			message: "Message too large",
+			cause:   errors.New("2048 bytes"),
+			want:    "Message too large: 2048 bytes",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewMessageTooLargeError(tt.message, tt.cause)
+			testErrorBehavior(t, err, tt)
+		})
+	}
+}
+
+func TestOutputSchemaError(t *testing.T) {
+	tests := []testErrorCase{
+		{
+			name:    "no cause",
+			message: "Output does not match schema",
+			cause:   nil,
+			want:    "Output does not match schema",
+		},
+		{
+			name:    "with cause",
+			message: "Output does not match schema",
+			cause:   errors.New("invalid JSON"),
+			want:    "Output does not match schema: invalid JSON",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewOutputSchemaError(tt.message, tt.cause)
+			testErrorBehavior(t, err, tt)
+		})
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	closed := NewCLIConnectionError("transport is closed", nil)
+	closed.Kind = ErrClosed
+	timeout := NewControlProtocolError("timed out", errors.New("deadline"))
+	timeout.Kind = ErrControlTimeout
+
+	tests := []struct {
+		name   string
+		err    error
+		target error
+		want   bool
+	}{
+		{name: "connection error matches its kind", err: closed, target: ErrClosed, want: true},
+		{name: "connection error does not match other kinds", err: closed, target: ErrNotConnected, want: false},
+		{name: "control error matches its kind", err: timeout, target: ErrControlTimeout, want: true},
+		{name: "control error does not match other kinds", err: timeout, target: ErrClosed, want: false},
+		{name: "no kind matches nothing", err: NewCLIConnectionError("failed", nil), target: ErrClosed, want: false},
+		{name: "wrapped error matches its kind", err: fmt.Errorf("send: %w", closed), target: ErrClosed, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errors.Is(tt.err, tt.target); got != tt.want {
+				t.Errorf("errors.Is(%v, %v) = %v, want %v", tt.err, tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
 func TestErrorTypes(t *testing.T) {
 	// Test that all error types implement the error interface
 	var _ error = &CLINotFoundError{}
@@ -202,4 +314,7 @@ func TestErrorTypes(t *testing.T) {
 	var _ error = &MessageParseError{}
 	var _ error = &ControlProtocolError{}
 	var _ error = &PermissionDeniedError{}
+	var _ error = &ResultError{}
+	var _ error = &MessageTooLargeError{}
+	var _ error = &OutputSchemaError{}
 }
@@ -0,0 +1,70 @@
+package types
+
+import "reflect"
+
+// Merge returns new options layering override on top of base, such as
+// per-request options over global defaults. Neither argument is modified.
+//
+// Fields are merged by kind:
+//   - pointers, strings, functions and interfaces: the override wins when set
+//     (non-nil or non-empty)
+//   - bools: true in either wins, since an unset bool cannot be told apart
+//     from false
+//   - numbers: the override wins when non-zero
+//   - slices: the override's elements are appended to the base's
+//   - maps: merged key by key, the override winning for keys in both
+//
+// Merge does not resolve conflicts between fields, such as Resume in base and
+// ContinueConversation in override; call Validate on the result.
+func Merge(base, override *ClaudeAgentOptions) *ClaudeAgentOptions {
+	if base == nil {
+		base = &ClaudeAgentOptions{}
+	}
+	if override == nil {
+		override = &ClaudeAgentOptions{}
+	}
+
+	merged := &ClaudeAgentOptions{}
+	baseValue := reflect.ValueOf(base).Elem()
+	overrideValue := reflect.ValueOf(override).Elem()
+	mergedValue := reflect.ValueOf(merged).Elem()
+	for i := 0; i < mergedValue.NumField(); i++ {
+		mergedValue.Field(i).Set(mergeField(baseValue.Field(i), overrideValue.Field(i)))
+	}
+	return merged
+}
+
+// mergeField merges one field of the options according to its kind
+func mergeField(base, override reflect.Value) reflect.Value {
+	switch base.Kind() {
+	case reflect.Bool:
+		return reflect.ValueOf(base.Bool() || override.Bool()).Convert(base.Type())
+
+	case reflect.Slice:
+		if base.IsNil() && override.IsNil() {
+			return base
+		}
+		merged := reflect.MakeSlice(base.Type(), 0, base.Len()+override.Len())
+		merged = reflect.AppendSlice(merged, base)
+		return reflect.AppendSlice(merged, override)
+
+	case reflect.Map:
+		if base.IsNil() && override.IsNil() {
+			return base
+		}
+		merged := reflect.MakeMapWithSize(base.Type(), base.Len()+override.Len())
+		for _, source := range []reflect.Value{base, override} {
+			iter := source.MapRange()
+			for iter.Next() {
+				merged.SetMapIndex(iter.Key(), iter.Value())
+			}
+		}
+		return merged
+
+	default:
+		if override.IsZero() {
+			return base
+		}
+		return override
+	}
+}
@@ -0,0 +1,71 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	base := NewClaudeAgentOptions().
+		WithModel("claude-sonnet-4-5").
+		WithMaxTurns(10).
+		WithAllowedTools("Read").
+		WithEnv(map[string]string{"REGION": "us", "DEBUG": "0"}).
+		WithSystemPrompt("base prompt")
+	override := NewClaudeAgentOptions().
+		WithMaxTurns(3).
+		WithAllowedTools("Bash").
+		WithEnv(map[string]string{"DEBUG": "1"}).
+		WithIncludePartialMessages(true)
+
+	merged := Merge(base, override)
+
+	if merged.Model == nil || *merged.Model != "claude-sonnet-4-5" {
+		t.Errorf("Model = %v, want base model", merged.Model)
+	}
+	if merged.MaxTurns == nil || *merged.MaxTurns != 3 {
+		t.Errorf("MaxTurns = %v, want 3", merged.MaxTurns)
+	}
+	if merged.SystemPrompt != "base prompt" {
+		t.Errorf("SystemPrompt = %v, want base prompt", merged.SystemPrompt)
+	}
+	if !merged.IncludePartialMessages {
+		t.Error("IncludePartialMessages = false, want true")
+	}
+	if want := []string{"Read", "Bash"}; !reflect.DeepEqual(merged.AllowedTools, want) {
+		t.Errorf("AllowedTools = %v, want %v", merged.AllowedTools, want)
+	}
+	if want := map[string]string{"REGION": "us", "DEBUG": "1"}; !reflect.DeepEqual(merged.Env, want) {
+		t.Errorf("Env = %v, want %v", merged.Env, want)
+	}
+
+	// The inputs are not modified or aliased
+	merged.Env["NEW"] = "x"
+	merged.AllowedTools[0] = "Write"
+	if _, ok := base.Env["NEW"]; ok || base.AllowedTools[0] != "Read" || len(base.AllowedTools) != 1 {
+		t.Errorf("Merge modified base: %v %v", base.Env, base.AllowedTools)
+	}
+	if len(override.Env) != 1 || len(override.AllowedTools) != 1 {
+		t.Errorf("Merge modified override: %v %v", override.Env, override.AllowedTools)
+	}
+}
+
+func TestMerge_Nil(t *testing.T) {
+	options := NewClaudeAgentOptions().WithModel("claude-sonnet-4-5")
+
+	for name, merged := range map[string]*ClaudeAgentOptions{
+		"nil base":     Merge(nil, options),
+		"nil override": Merge(options, nil),
+	} {
+		if merged == options {
+			t.Errorf("%s: Merge returned its argument, want a copy", name)
+		}
+		if merged.Model == nil || *merged.Model != "claude-sonnet-4-5" {
+			t.Errorf("%s: Model = %v, want claude-sonnet-4-5", name, merged.Model)
+		}
+	}
+
+	if Merge(nil, nil) == nil {
+		t.Error("Merge(nil, nil) = nil, want empty options")
+	}
+}
@@ -1,7 +1,11 @@
 package types
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
 )
 
 // ContentBlock represents a content block in a message
@@ -36,6 +40,25 @@ type ToolUseBlock struct {
 
 func (t *ToolUseBlock) Type() string { return ContentTypeToolUse }
 
+// UnmarshalInput decodes the input of a tool call into T, typically a struct
+// with json tags describing the tool's arguments. Arguments of the wrong type
+// fail with a JSONDecodeError; fields missing from the input are left zero.
+func UnmarshalInput[T any](block *ToolUseBlock) (T, error) {
+	var input T
+	if block == nil {
+		return input, NewJSONDecodeError("failed to decode tool input: no tool use block", nil)
+	}
+
+	data, err := json.Marshal(block.Input)
+	if err != nil {
+		return input, NewJSONDecodeError("failed to encode input of tool "+block.Name, err)
+	}
+	if err := json.Unmarshal(data, &input); err != nil {
+		return input, NewJSONDecodeError("failed to decode input of tool "+block.Name, err)
+	}
+	return input, nil
+}
+
 // ToolResultBlock represents a tool result content block
 type ToolResultBlock struct {
 	Type_     string      `json:"type"`
@@ -46,6 +69,41 @@ type ToolResultBlock struct {
 
 func (t *ToolResultBlock) Type() string { return ContentTypeToolResult }
 
+// ImageBlock represents image content, as sent to Claude in user messages
+type ImageBlock struct {
+	Type_  string      `json:"type"`
+	Source ImageSource `json:"source"`
+}
+
+func (i *ImageBlock) Type() string { return ContentTypeImage }
+
+// ImageSource holds the data of an image block
+type ImageSource struct {
+	Type      string `json:"type"` // "base64"
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+// NewImageBlock returns an image block holding data, an image of mediaType
+// such as "image/png" or "image/jpeg"
+func NewImageBlock(mediaType string, data []byte) *ImageBlock {
+	return &ImageBlock{
+		Type_: ContentTypeImage,
+		Source: ImageSource{
+			Type:      "base64",
+			MediaType: mediaType,
+			Data:      base64.StdEncoding.EncodeToString(data),
+		},
+	}
+}
+
+// ToolResultFile replaces the content of a tool result that was too large to
+// deliver inline. The content was written to Path; the caller owns the file.
+type ToolResultFile struct {
+	Path string `json:"path"`
+	Size int    `json:"size"`
+}
+
 // UnmarshalContentBlock unmarshals JSON into the appropriate ContentBlock type
 func UnmarshalContentBlock(data []byte) (ContentBlock, error) {
 	var typeField struct {
@@ -81,6 +139,12 @@ func UnmarshalContentBlock(data []byte) (ContentBlock, error) {
 			return nil, NewJSONDecodeError("failed to decode tool_result block", err)
 		}
 		return &block, nil
+	case ContentTypeImage:
+		var block ImageBlock
+		if err := json.Unmarshal(data, &block); err != nil {
+			return nil, NewJSONDecodeError("failed to decode image block", err)
+		}
+		return &block, nil
 	default:
 		return nil, NewMessageParseError("unknown content block type: "+typeField.Type, nil)
 	}
@@ -102,6 +166,9 @@ func MarshalContentBlock(block ContentBlock) ([]byte, error) {
 	case *ToolResultBlock:
 		b.Type_ = ContentTypeToolResult
 		return json.Marshal(b)
+	case *ImageBlock:
+		b.Type_ = ContentTypeImage
+		return json.Marshal(b)
 	default:
 		return nil, NewMessageParseError("unknown content block type", nil)
 	}
@@ -127,19 +194,114 @@ type AssistantMessage struct {
 	Content         []ContentBlock `json:"content"`
 	Model           string         `json:"model"`
 	ParentToolUseID *string        `json:"parent_tool_use_id,omitempty"`
+
+	// StopReason is why the model stopped generating (see the StopReason
+	// constants), or nil if the CLI did not report it
+	StopReason *string `json:"stop_reason,omitempty"`
+
+	// MessageID and RequestID identify the API message and request, for
+	// support escalations. Each is empty if the CLI did not report it.
+	MessageID string `json:"id,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+
+	// UUID identifies the message in the session transcript. Pass it to
+	// WithResumeSessionAt to branch the conversation from this message. It
+	// is empty if the CLI did not report it.
+	UUID string `json:"uuid,omitempty"`
+
+	// Usage is the token usage of the API request that produced the message,
+	// or nil if the CLI did not report it. Numbers are json.Number; read them
+	// with AsInt64 or AsFloat64.
+	Usage map[string]any `json:"usage,omitempty"`
 }
 
 func (m *AssistantMessage) Type() string { return MessageTypeAssistant }
 
+// Plan returns the plan proposed through an ExitPlanMode tool call, if the
+// message contains one. In plan mode this is the point to ask the user for
+// approval before letting the session proceed.
+func (m *AssistantMessage) Plan() (string, bool) {
+	for _, block := range m.Content {
+		toolUse, ok := block.(*ToolUseBlock)
+		if !ok || toolUse.Name != ToolNameExitPlanMode {
+			continue
+		}
+		plan, ok := toolUse.Input["plan"].(string)
+		return plan, ok
+	}
+	return "", false
+}
+
 // SystemMessage represents a system message with metadata
 type SystemMessage struct {
-	Type_   string         `json:"type"`
-	Subtype string         `json:"subtype"`
-	Data    map[string]any `json:"data"`
+	Type_   string `json:"type"`
+	Subtype string `json:"subtype"`
+
+	// Data holds the message's data object or, when the CLI sends its fields
+	// at the top level instead, those fields. Numbers are json.Number; read
+	// them with AsInt64 or AsFloat64.
+	Data map[string]any `json:"data"`
 }
 
 func (m *SystemMessage) Type() string { return MessageTypeSystem }
 
+// AvailableTools returns the tools listed in an init message: those actually
+// available in the session after allowed/disallowed filtering and MCP server
+// resolution. It returns nil for other messages.
+func (m *SystemMessage) AvailableTools() []string {
+	if m.Subtype != SystemSubtypeInit {
+		return nil
+	}
+
+	raw, _ := m.Data["tools"].([]any)
+	tools := make([]string, 0, len(raw))
+	for _, tool := range raw {
+		if name, ok := tool.(string); ok {
+			tools = append(tools, name)
+		}
+	}
+	return tools
+}
+
+// ProtocolVersion returns the stream-json protocol version reported by an
+// init message, or MinProtocolVersion when the CLI does not report one. The
+// second result is false for other messages.
+func (m *SystemMessage) ProtocolVersion() (int, bool) {
+	if m.Subtype != SystemSubtypeInit {
+		return 0, false
+	}
+
+	if version, ok := AsInt64(m.Data["protocol_version"]); ok {
+		return int(version), true
+	}
+	return MinProtocolVersion, true
+}
+
+// CompactBoundary describes a point where the CLI summarized (compacted) the
+// conversation history
+type CompactBoundary struct {
+	// Trigger is "auto" or "manual"
+	Trigger string
+
+	// PreTokens is the token count of the history before compaction, if reported
+	PreTokens int
+}
+
+// CompactBoundary returns the compaction details of a compact_boundary message
+func (m *SystemMessage) CompactBoundary() (*CompactBoundary, bool) {
+	if m.Subtype != SystemSubtypeCompactBoundary {
+		return nil, false
+	}
+
+	boundary := &CompactBoundary{}
+	metadata, _ := m.Data["compact_metadata"].(map[string]any)
+	boundary.Trigger, _ = metadata["trigger"].(string)
+	if preTokens, ok := AsInt64(metadata["pre_tokens"]); ok {
+		boundary.PreTokens = int(preTokens)
+	}
+	return boundary, true
+}
+
 // ResultMessage represents a result message with cost and usage information
 type ResultMessage struct {
 	Type_         string         `json:"type"`
@@ -150,12 +312,100 @@ type ResultMessage struct {
 	NumTurns      int            `json:"num_turns"`
 	SessionID     string         `json:"session_id"`
 	TotalCostUSD  *float64       `json:"total_cost_usd,omitempty"`
-	Usage         map[string]any `json:"usage,omitempty"`
+	Usage         map[string]any `json:"usage,omitempty"` // Numbers are json.Number
 	Result        *string        `json:"result,omitempty"`
+	Errors        []string       `json:"errors,omitempty"`
+
+	// RequestID identifies the last API request of the session, taken from the
+	// message or its usage. It is empty if the CLI did not report it.
+	RequestID string `json:"request_id,omitempty"`
+
+	// StructuredOutput is the final answer decoded from JSON when an output
+	// schema was set, or nil
+	StructuredOutput any `json:"structured_output,omitempty"`
 }
 
 func (m *ResultMessage) Type() string { return MessageTypeResult }
 
+// Output returns the structured final answer: StructuredOutput if the CLI
+// reported it, otherwise the result text decoded as JSON
+func (m *ResultMessage) Output() (any, error) {
+	if m.StructuredOutput != nil {
+		return m.StructuredOutput, nil
+	}
+	if m.Result == nil {
+		return nil, NewOutputSchemaError("result has no output", nil)
+	}
+
+	var output any
+	if err := json.Unmarshal([]byte(*m.Result), &output); err != nil {
+		return nil, NewOutputSchemaError("result is not valid JSON", err)
+	}
+	return output, nil
+}
+
+// ExecutionError returns a ResultError describing an error result, or nil if
+// the result is not an error. The detail is taken from the result text, or
+// from the errors list when the CLI reports no text.
+func (m *ResultMessage) ExecutionError() error {
+	if !m.IsError {
+		return nil
+	}
+
+	detail := ""
+	if m.Result != nil {
+		detail = *m.Result
+	}
+	if detail == "" {
+		detail = strings.Join(m.Errors, "; ")
+	}
+
+	text := detail
+	if text == "" {
+		text = "no result text"
+	}
+	return &ResultError{
+		Message: fmt.Sprintf("Claude returned an error result (%s): %s", m.Subtype, text),
+		Subtype: m.Subtype,
+		Detail:  detail,
+	}
+}
+
+// modelUnavailablePatterns match lowercase error texts of the API saying a
+// model is overloaded or unavailable: the overloaded_error type or HTTP status
+// 529, a not_found_error about the model, or the CLI's own wording. Matching
+// whole words in context keeps numbers, paths and errors of tools or MCP
+// servers that merely contain "529" or "not_found_error" from triggering a
+// fallback.
+var modelUnavailablePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`\boverloaded_error\b`),
+	regexp.MustCompile(`\b(api error|http|status):?\s*529\b`),
+	regexp.MustCompile(`\bnot_found_error\b[^{}]*\bmodel\b`),
+	regexp.MustCompile(`\bmodel (not found|is not available|unavailable)\b`),
+}
+
+// ModelUnavailable reports whether the result is an error saying the model is
+// overloaded or unavailable, so retrying with another model may succeed
+func (m *ResultMessage) ModelUnavailable() bool {
+	if !m.IsError {
+		return false
+	}
+
+	texts := m.Errors
+	if m.Result != nil {
+		texts = append([]string{*m.Result}, texts...)
+	}
+	for _, text := range texts {
+		text = strings.ToLower(text)
+		for _, pattern := range modelUnavailablePatterns {
+			if pattern.MatchString(text) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // StreamEvent represents a stream event for partial message updates during streaming
 type StreamEvent struct {
 	Type_           string         `json:"type"`
@@ -167,6 +417,31 @@ type StreamEvent struct {
 
 func (m *StreamEvent) Type() string { return MessageTypeStreamEvent }
 
+// UnknownMessage wraps a message whose type this SDK does not recognize, so
+// new CLI message types reach the consumer instead of being dropped
+type UnknownMessage struct {
+	Type_ string          `json:"type"`
+	Raw   json.RawMessage `json:"-"`
+}
+
+func (m *UnknownMessage) Type() string { return m.Type_ }
+
+// TextDelta returns the text of a content_block_delta event carrying a text_delta
+func (m *StreamEvent) TextDelta() (string, bool) {
+	if eventType, _ := m.Event["type"].(string); eventType != "content_block_delta" {
+		return "", false
+	}
+	delta, ok := m.Event["delta"].(map[string]any)
+	if !ok {
+		return "", false
+	}
+	if deltaType, _ := delta["type"].(string); deltaType != "text_delta" {
+		return "", false
+	}
+	text, ok := delta["text"].(string)
+	return text, ok
+}
+
 // Helper function to process user message content
 func processUserContent(content interface{}) (interface{}, error) {
 	if contentStr, ok := content.(string); ok {
@@ -216,16 +491,36 @@ func unmarshalAssistantMessage(data []byte) (*AssistantMessage, error) {
 		return nil, NewJSONDecodeError("failed to decode assistant message", err)
 	}
 
+	type assistantBody struct {
+		ID         string            `json:"id,omitempty"`
+		Content    []json.RawMessage `json:"content"`
+		Model      string            `json:"model"`
+		StopReason *string           `json:"stop_reason,omitempty"`
+		RequestID  string            `json:"request_id,omitempty"`
+		Usage      map[string]any    `json:"usage,omitempty"`
+	}
+
 	var assistant struct {
-		Type_           string            `json:"type"`
-		Content         []json.RawMessage `json:"content"`
-		Model           string            `json:"model"`
-		ParentToolUseID *string           `json:"parent_tool_use_id,omitempty"`
+		Type_ string `json:"type"`
+		assistantBody
+		ParentToolUseID *string `json:"parent_tool_use_id,omitempty"`
+		RequestID       string  `json:"request_id,omitempty"`
+		UUID            string  `json:"uuid,omitempty"`
+
+		// The CLI nests the API message under "message"
+		Message *assistantBody `json:"message,omitempty"`
 	}
 
-	if err := json.Unmarshal(rawMsg, &assistant); err != nil {
+	// Usage is the only untyped value; content blocks are decoded below
+	if err := decodeNumbers(rawMsg, &assistant); err != nil {
 		return nil, NewJSONDecodeError("failed to decode assistant message structure", err)
 	}
+	if assistant.Content == nil && assistant.Message != nil {
+		assistant.assistantBody = *assistant.Message
+	}
+	if assistant.RequestID == "" {
+		assistant.RequestID = assistant.assistantBody.RequestID
+	}
 
 	// Convert content blocks
 	blocks := make([]ContentBlock, len(assistant.Content))
@@ -242,6 +537,11 @@ func unmarshalAssistantMessage(data []byte) (*AssistantMessage, error) {
 		Content:         blocks,
 		Model:           assistant.Model,
 		ParentToolUseID: assistant.ParentToolUseID,
+		StopReason:      assistant.StopReason,
+		MessageID:       assistant.ID,
+		RequestID:       assistant.RequestID,
+		UUID:            assistant.UUID,
+		Usage:           assistant.Usage,
 	}, nil
 }
 
@@ -262,15 +562,38 @@ func UnmarshalMessage(data []byte) (Message, error) {
 		return unmarshalAssistantMessage(data)
 	case MessageTypeSystem:
 		var msg SystemMessage
-		if err := json.Unmarshal(data, &msg); err != nil {
+		if err := decodeNumbers(data, &msg); err != nil {
 			return nil, NewJSONDecodeError("failed to decode system message", err)
 		}
+		if msg.Data == nil {
+			var fields map[string]any
+			if err := decodeNumbers(data, &fields); err == nil {
+				delete(fields, "type")
+				delete(fields, "subtype")
+				delete(fields, "data")
+				if len(fields) > 0 {
+					msg.Data = fields
+				}
+			}
+		}
 		return &msg, nil
 	case MessageTypeResult:
 		var msg ResultMessage
 		if err := json.Unmarshal(data, &msg); err != nil {
 			return nil, NewJSONDecodeError("failed to decode result message", err)
 		}
+
+		// Only the usage keeps exact numbers; StructuredOutput is plain JSON
+		var usage struct {
+			Usage map[string]any `json:"usage"`
+		}
+		if err := decodeNumbers(data, &usage); err != nil {
+			return nil, NewJSONDecodeError("failed to decode result usage", err)
+		}
+		msg.Usage = usage.Usage
+		if msg.RequestID == "" {
+			msg.RequestID, _ = msg.Usage["request_id"].(string)
+		}
 		return &msg, nil
 	case MessageTypeStreamEvent:
 		var msg StreamEvent
@@ -278,8 +601,22 @@ func UnmarshalMessage(data []byte) (Message, error) {
 			return nil, NewJSONDecodeError("failed to decode stream event", err)
 		}
 		return &msg, nil
+	case ControlTypeRequest:
+		var msg SDKControlRequest
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, NewJSONDecodeError("failed to decode control request", err)
+		}
+		return &msg, nil
+	case ControlTypeResponse:
+		var msg SDKControlResponse
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, NewJSONDecodeError("failed to decode control response", err)
+		}
+		return &msg, nil
+	case "":
+		return nil, NewMessageParseError("message has no type", nil)
 	default:
-		return nil, NewMessageParseError("unknown message type: "+typeField.Type, nil)
+		return &UnknownMessage{Type_: typeField.Type, Raw: append(json.RawMessage(nil), data...)}, nil
 	}
 }
 
@@ -325,6 +662,80 @@ func marshalUserMessage(msg *UserMessage) ([]byte, error) {
 	return json.Marshal(msg)
 }
 
+// NewErrorToolResult builds a tool result reporting that the tool call failed with err
+func NewErrorToolResult(toolUseID string, err error) ToolResultBlock {
+	isError := true
+	return ToolResultBlock{
+		Type_:     ContentTypeToolResult,
+		ToolUseID: toolUseID,
+		Content:   err.Error(),
+		IsError:   &isError,
+	}
+}
+
+// NewAssistantMessage builds an empty assistant message from model, to be
+// filled with WithText and WithToolUse. It is meant for test fixtures and
+// replays of agent logic.
+func NewAssistantMessage(model string) *AssistantMessage {
+	return &AssistantMessage{Type_: MessageTypeAssistant, Content: []ContentBlock{}, Model: model}
+}
+
+// WithText appends a text block
+func (m *AssistantMessage) WithText(text string) *AssistantMessage {
+	m.Content = append(m.Content, &TextBlock{Type_: ContentTypeText, Text: text})
+	return m
+}
+
+// WithToolUse appends a tool_use block calling tool name with input
+func (m *AssistantMessage) WithToolUse(id, name string, input map[string]any) *AssistantMessage {
+	if input == nil {
+		input = map[string]any{}
+	}
+	m.Content = append(m.Content, &ToolUseBlock{Type_: ContentTypeToolUse, ID: id, Name: name, Input: input})
+	return m
+}
+
+// NewToolResultsMessage builds a single user message carrying the results of
+// every tool call of a (possibly parallel) tool-use turn
+func NewToolResultsMessage(results ...ToolResultBlock) *UserMessage {
+	blocks := make([]ContentBlock, len(results))
+	for i := range results {
+		result := results[i]
+		result.Type_ = ContentTypeToolResult
+		blocks[i] = &result
+	}
+	return &UserMessage{Type_: MessageTypeUser, Content: blocks}
+}
+
+// MarshalUserInput encodes a user message as a stream-json input line for the
+// CLI: {"type":"user","message":{"role":"user","content":...},...}
+func MarshalUserInput(msg *UserMessage, sessionID string) ([]byte, error) {
+	content := msg.Content
+	if blocks, ok := content.([]ContentBlock); ok {
+		marshaledBlocks, err := marshalContentBlocks(blocks)
+		if err != nil {
+			return nil, err
+		}
+		content = marshaledBlocks
+	}
+
+	payload := map[string]any{
+		"type": MessageTypeUser,
+		"message": map[string]any{
+			"role":    "user",
+			"content": content,
+		},
+		"parent_tool_use_id": msg.ParentToolUseID,
+		"session_id":         sessionID,
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, NewJSONDecodeError("failed to encode user input", err)
+	}
+	return data, nil
+}
+
 // Helper function to marshal assistant message
 func marshalAssistantMessage(msg *AssistantMessage) ([]byte, error) {
 	msg.Type_ = MessageTypeAssistant
@@ -337,15 +748,25 @@ func marshalAssistantMessage(msg *AssistantMessage) ([]byte, error) {
 
 	// Create a temporary struct for marshaling
 	tempMsg := struct {
-		Type_           string      `json:"type"`
-		Content         interface{} `json:"content"`
-		Model           string      `json:"model"`
-		ParentToolUseID *string     `json:"parent_tool_use_id,omitempty"`
+		Type_           string         `json:"type"`
+		Content         interface{}    `json:"content"`
+		Model           string         `json:"model"`
+		ParentToolUseID *string        `json:"parent_tool_use_id,omitempty"`
+		StopReason      *string        `json:"stop_reason,omitempty"`
+		MessageID       string         `json:"id,omitempty"`
+		RequestID       string         `json:"request_id,omitempty"`
+		UUID            string         `json:"uuid,omitempty"`
+		Usage           map[string]any `json:"usage,omitempty"`
 	}{
 		Type_:           msg.Type_,
 		Content:         marshaledBlocks,
 		Model:           msg.Model,
 		ParentToolUseID: msg.ParentToolUseID,
+		StopReason:      msg.StopReason,
+		MessageID:       msg.MessageID,
+		RequestID:       msg.RequestID,
+		UUID:            msg.UUID,
+		Usage:           msg.Usage,
 	}
 	return json.Marshal(tempMsg)
 }
@@ -366,6 +787,14 @@ func MarshalMessage(msg Message) ([]byte, error) {
 	case *StreamEvent:
 		m.Type_ = MessageTypeStreamEvent
 		return json.Marshal(m)
+	case *SDKControlRequest:
+		m.Type_ = ControlTypeRequest
+		return json.Marshal(m)
+	case *SDKControlResponse:
+		m.Type_ = ControlTypeResponse
+		return json.Marshal(m)
+	case *UnknownMessage:
+		return m.Raw, nil
 	default:
 		return nil, NewMessageParseError("unknown message type", nil)
 	}
@@ -2,6 +2,8 @@ package types
 
 import (
 	"encoding/json"
+	"errors"
+	"reflect"
 	"testing"
 )
 
@@ -68,6 +70,37 @@ func TestToolUseBlock(t *testing.T) {
 	}
 }
 
+func TestUnmarshalInput(t *testing.T) {
+	type editInput struct {
+		Path    string   `json:"file_path"`
+		Lines   []int    `json:"lines"`
+		Replace bool     `json:"replace_all"`
+		Tags    []string `json:"tags,omitempty"`
+	}
+
+	block := &ToolUseBlock{
+		Name:  "Edit",
+		Input: map[string]any{"file_path": "main.go", "lines": []any{float64(3), float64(7)}, "replace_all": true},
+	}
+	got, err := UnmarshalInput[editInput](block)
+	if err != nil {
+		t.Fatalf("UnmarshalInput() error = %v", err)
+	}
+	want := editInput{Path: "main.go", Lines: []int{3, 7}, Replace: true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("UnmarshalInput() = %+v, want %+v", got, want)
+	}
+
+	var decodeErr *JSONDecodeError
+	wrongType := &ToolUseBlock{Name: "Edit", Input: map[string]any{"file_path": 42}}
+	if _, err := UnmarshalInput[editInput](wrongType); !errors.As(err, &decodeErr) {
+		t.Errorf("UnmarshalInput() with a mistyped argument error = %v, want JSONDecodeError", err)
+	}
+	if _, err := UnmarshalInput[editInput](nil); !errors.As(err, &decodeErr) {
+		t.Errorf("UnmarshalInput(nil) error = %v, want JSONDecodeError", err)
+	}
+}
+
 func TestToolResultBlock(t *testing.T) {
 	content := "Tool execution completed"
 	isError := false
@@ -214,6 +247,196 @@ func TestAssistantMessage(t *testing.T) {
 	}
 }
 
+func TestAssistantMessage_StopReason(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{
+			name: "nested CLI message",
+			data: `{"type":"assistant","message":{"model":"claude","content":[{"type":"tool_use","id":"t1","name":"Read","input":{}}],"stop_reason":"tool_use"}}`,
+			want: StopReasonToolUse,
+		},
+		{
+			name: "top level",
+			data: `{"type":"assistant","model":"claude","content":[{"type":"text","text":"done"}],"stop_reason":"end_turn"}`,
+			want: StopReasonEndTurn,
+		},
+		{
+			name: "not reported",
+			data: `{"type":"assistant","model":"claude","content":[]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := UnmarshalMessage([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("UnmarshalMessage() error = %v", err)
+			}
+			assistant := msg.(*AssistantMessage)
+
+			got := ""
+			if assistant.StopReason != nil {
+				got = *assistant.StopReason
+			}
+			if got != tt.want {
+				t.Errorf("StopReason = %q, want %q", got, tt.want)
+			}
+			if len(assistant.Content) == 0 && tt.want != "" {
+				t.Error("Expected content blocks to be parsed")
+			}
+
+			if tt.want == "" {
+				return
+			}
+			data, err := MarshalMessage(assistant)
+			if err != nil {
+				t.Fatalf("MarshalMessage() error = %v", err)
+			}
+			roundTrip, err := UnmarshalMessage(data)
+			if err != nil {
+				t.Fatalf("UnmarshalMessage() error = %v", err)
+			}
+			if reason := roundTrip.(*AssistantMessage).StopReason; reason == nil || *reason != tt.want {
+				t.Errorf("Round-trip StopReason = %v, want %q", reason, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssistantMessage_UUID(t *testing.T) {
+	data := `{"type":"assistant","uuid":"uuid-1","session_id":"s1","message":{"id":"msg_1","model":"claude","content":[]}}`
+
+	msg, err := UnmarshalMessage([]byte(data))
+	if err != nil {
+		t.Fatalf("UnmarshalMessage() error = %v", err)
+	}
+	if got := msg.(*AssistantMessage).UUID; got != "uuid-1" {
+		t.Errorf("UUID = %q, want %q", got, "uuid-1")
+	}
+
+	// The UUID survives a marshal round trip
+	encoded, err := MarshalMessage(msg)
+	if err != nil {
+		t.Fatalf("MarshalMessage() error = %v", err)
+	}
+	roundTrip, err := UnmarshalMessage(encoded)
+	if err != nil {
+		t.Fatalf("UnmarshalMessage() error = %v", err)
+	}
+	if got := roundTrip.(*AssistantMessage).UUID; got != "uuid-1" {
+		t.Errorf("UUID after round trip = %q, want %q", got, "uuid-1")
+	}
+}
+
+func TestMessage_RequestIDs(t *testing.T) {
+	tests := []struct {
+		name          string
+		data          string
+		wantMessageID string
+		wantRequestID string
+	}{
+		{
+			name:          "nested CLI message",
+			data:          `{"type":"assistant","request_id":"req_1","message":{"id":"msg_1","model":"claude","content":[]}}`,
+			wantMessageID: "msg_1",
+			wantRequestID: "req_1",
+		},
+		{
+			name:          "request ID inside message",
+			data:          `{"type":"assistant","message":{"id":"msg_2","request_id":"req_2","model":"claude","content":[]}}`,
+			wantMessageID: "msg_2",
+			wantRequestID: "req_2",
+		},
+		{
+			name: "not reported",
+			data: `{"type":"assistant","model":"claude","content":[]}`,
+		},
+		{
+			name:          "result message",
+			data:          `{"type":"result","subtype":"success","session_id":"s1","request_id":"req_3"}`,
+			wantRequestID: "req_3",
+		},
+		{
+			name:          "result usage",
+			data:          `{"type":"result","subtype":"success","session_id":"s1","usage":{"input_tokens":1,"request_id":"req_4"}}`,
+			wantRequestID: "req_4",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := UnmarshalMessage([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("UnmarshalMessage() error = %v", err)
+			}
+
+			var messageID, requestID string
+			switch m := msg.(type) {
+			case *AssistantMessage:
+				// IDs survive a marshal round trip
+				data, err := MarshalMessage(m)
+				if err != nil {
+					t.Fatalf("MarshalMessage() error = %v", err)
+				}
+				roundTrip, err := UnmarshalMessage(data)
+				if err != nil {
+					t.Fatalf("UnmarshalMessage() error = %v", err)
+				}
+				messageID, requestID = roundTrip.(*AssistantMessage).MessageID, roundTrip.(*AssistantMessage).RequestID
+			case *ResultMessage:
+				requestID = m.RequestID
+			}
+
+			if messageID != tt.wantMessageID {
+				t.Errorf("MessageID = %q, want %q", messageID, tt.wantMessageID)
+			}
+			if requestID != tt.wantRequestID {
+				t.Errorf("RequestID = %q, want %q", requestID, tt.wantRequestID)
+			}
+		})
+	}
+}
+
+func TestAssistantMessage_Plan(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		want   string
+		wantOK bool
+	}{
+		{
+			name:   "exit plan mode",
+			data:   `{"type":"assistant","model":"claude","content":[{"type":"text","text":"Here is my plan"},{"type":"tool_use","id":"t1","name":"ExitPlanMode","input":{"plan":"1. Read main.go\n2. Fix the bug"}}]}`,
+			want:   "1. Read main.go\n2. Fix the bug",
+			wantOK: true,
+		},
+		{
+			name: "other tool",
+			data: `{"type":"assistant","model":"claude","content":[{"type":"tool_use","id":"t1","name":"Read","input":{"plan":"not a plan"}}]}`,
+		},
+		{
+			name: "text only",
+			data: `{"type":"assistant","model":"claude","content":[{"type":"text","text":"plan"}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := UnmarshalMessage([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("UnmarshalMessage() error = %v", err)
+			}
+			got, ok := msg.(*AssistantMessage).Plan()
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("Plan() = (%q, %v), want (%q, %v)", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
 func TestSystemMessage(t *testing.T) {
 	data := map[string]any{
 		"key1": "value1",
@@ -252,6 +475,108 @@ func TestSystemMessage(t *testing.T) {
 	}
 }
 
+func TestSystemMessage_TopLevelFields(t *testing.T) {
+	msg, err := UnmarshalMessage([]byte(`{"type":"system","subtype":"init","session_id":"s1","model":"claude"}`))
+	if err != nil {
+		t.Fatalf("UnmarshalMessage() error = %v", err)
+	}
+
+	system := msg.(*SystemMessage)
+	if system.Data["session_id"] != "s1" || system.Data["model"] != "claude" {
+		t.Errorf("Expected top-level fields in Data, got %v", system.Data)
+	}
+	if _, ok := system.Data["type"]; ok {
+		t.Error("Data should not contain the type field")
+	}
+}
+
+func TestSystemMessage_AvailableTools(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{
+			name: "init with tools",
+			data: `{"type":"system","subtype":"init","session_id":"s1","tools":["Read","Bash","mcp__calc__add"]}`,
+			want: []string{"Read", "Bash", "mcp__calc__add"},
+		},
+		{
+			name: "init with nested data",
+			data: `{"type":"system","subtype":"init","data":{"tools":["Read"]}}`,
+			want: []string{"Read"},
+		},
+		{
+			name: "init without tools",
+			data: `{"type":"system","subtype":"init","session_id":"s1"}`,
+			want: []string{},
+		},
+		{
+			name: "not init",
+			data: `{"type":"system","subtype":"compact_boundary","tools":["Read"]}`,
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := UnmarshalMessage([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("UnmarshalMessage() error = %v", err)
+			}
+			got := msg.(*SystemMessage).AvailableTools()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("AvailableTools() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSystemMessage_ProtocolVersion(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		want   int
+		wantOK bool
+	}{
+		{name: "reported", data: `{"type":"system","subtype":"init","protocol_version":2}`, want: 2, wantOK: true},
+		{name: "not reported", data: `{"type":"system","subtype":"init","session_id":"s1"}`, want: MinProtocolVersion, wantOK: true},
+		{name: "not init", data: `{"type":"system","subtype":"compact_boundary","protocol_version":2}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := UnmarshalMessage([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("UnmarshalMessage() error = %v", err)
+			}
+			got, ok := msg.(*SystemMessage).ProtocolVersion()
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("ProtocolVersion() = %d, %v, want %d, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestSystemMessage_CompactBoundary(t *testing.T) {
+	msg, err := UnmarshalMessage([]byte(`{"type":"system","subtype":"compact_boundary","session_id":"s1","compact_metadata":{"trigger":"auto","pre_tokens":155000}}`))
+	if err != nil {
+		t.Fatalf("UnmarshalMessage() error = %v", err)
+	}
+
+	boundary, ok := msg.(*SystemMessage).CompactBoundary()
+	if !ok {
+		t.Fatal("Expected a compact boundary")
+	}
+	if boundary.Trigger != "auto" || boundary.PreTokens != 155000 {
+		t.Errorf("CompactBoundary() = %+v", boundary)
+	}
+
+	if _, ok := (&SystemMessage{Subtype: SystemSubtypeInit}).CompactBoundary(); ok {
+		t.Error("Expected init message not to be a compact boundary")
+	}
+}
+
 func TestResultMessage(t *testing.T) {
 	result := "Task completed successfully"
 	cost := 0.00123
@@ -297,6 +622,104 @@ func TestResultMessage(t *testing.T) {
 	}
 }
 
+func TestResultMessage_ModelUnavailable(t *testing.T) {
+	overloaded := "API Error: 529 {\"type\":\"overloaded_error\"}"
+	other := "tool failed"
+	errorResult := func(text string) *ResultMessage {
+		return &ResultMessage{IsError: true, Errors: []string{text}}
+	}
+
+	tests := []struct {
+		name   string
+		result *ResultMessage
+		want   bool
+	}{
+		{name: "overloaded", result: &ResultMessage{IsError: true, Result: &overloaded}, want: true},
+		{name: "overloaded status", result: errorResult("API Error: 529 Overloaded"), want: true},
+		{name: "overloaded type", result: errorResult(`{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`), want: true},
+		{name: "model not found", result: errorResult("not_found_error: model: claude-x"), want: true},
+		{name: "model not found json", result: errorResult(`API Error: 404 {"type":"error","error":{"type":"not_found_error","message":"model: claude-x"}}`), want: true},
+		{name: "model unavailable", result: errorResult("The model is not available for your account"), want: true},
+		{name: "other error", result: &ResultMessage{IsError: true, Result: &other}},
+		{name: "success", result: &ResultMessage{Result: &overloaded}},
+		{name: "cost", result: errorResult("budget exceeded: spent $0.529")},
+		{name: "token count", result: errorResult("prompt is too long: 215290 tokens")},
+		{name: "file path", result: errorResult("cannot open /tmp/run-529/out.log")},
+		{name: "tool error", result: errorResult("query failed: 529 rows locked")},
+		{name: "tool not found", result: errorResult(`MCP error: {"type":"not_found_error","message":"resource missing"}`)},
+		{name: "http 404", result: errorResult("WebFetch failed: HTTP 404 not found")},
+		{name: "tool overloaded", result: errorResult("build server overloaded, try later")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.result.ModelUnavailable(); got != tt.want {
+				t.Errorf("ModelUnavailable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResultMessage_ExecutionError(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       string
+		wantErr    bool
+		wantDetail string
+	}{
+		{
+			name: "success",
+			data: `{"type":"result","subtype":"success","is_error":false,"session_id":"s1","result":"done"}`,
+		},
+		{
+			name:       "result text",
+			data:       `{"type":"result","subtype":"error_during_execution","is_error":true,"session_id":"s1","result":"API overloaded"}`,
+			wantErr:    true,
+			wantDetail: "API overloaded",
+		},
+		{
+			name:       "errors list",
+			data:       `{"type":"result","subtype":"error_during_execution","is_error":true,"session_id":"s1","errors":["tool failed","aborted"]}`,
+			wantErr:    true,
+			wantDetail: "tool failed; aborted",
+		},
+		{
+			name:    "no detail",
+			data:    `{"type":"result","subtype":"error_max_turns","is_error":true,"session_id":"s1"}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := UnmarshalMessage([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("UnmarshalMessage() error = %v", err)
+			}
+			result := msg.(*ResultMessage)
+
+			err = result.ExecutionError()
+			if !tt.wantErr {
+				if err != nil {
+					t.Errorf("ExecutionError() = %v, want nil", err)
+				}
+				return
+			}
+
+			var resultErr *ResultError
+			if !errors.As(err, &resultErr) {
+				t.Fatalf("ExecutionError() = %T, want *ResultError", err)
+			}
+			if resultErr.Subtype != result.Subtype {
+				t.Errorf("Subtype = %q, want %q", resultErr.Subtype, result.Subtype)
+			}
+			if resultErr.Detail != tt.wantDetail {
+				t.Errorf("Detail = %q, want %q", resultErr.Detail, tt.wantDetail)
+			}
+		})
+	}
+}
+
 func TestStreamEvent(t *testing.T) {
 	eventData := map[string]any{
 		"type": "content_block_delta",
@@ -339,6 +762,150 @@ func TestStreamEvent(t *testing.T) {
 	}
 }
 
+func TestStreamEvent_TextDelta(t *testing.T) {
+	tests := []struct {
+		name   string
+		event  map[string]any
+		want   string
+		wantOK bool
+	}{
+		{
+			name: "text delta",
+			event: map[string]any{
+				"type":  "content_block_delta",
+				"delta": map[string]any{"type": "text_delta", "text": "Hel"},
+			},
+			want:   "Hel",
+			wantOK: true,
+		},
+		{
+			name: "input json delta",
+			event: map[string]any{
+				"type":  "content_block_delta",
+				"delta": map[string]any{"type": "input_json_delta", "partial_json": "{"},
+			},
+		},
+		{
+			name:  "message start",
+			event: map[string]any{"type": "message_start"},
+		},
+		{
+			name:  "missing delta",
+			event: map[string]any{"type": "content_block_delta"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := &StreamEvent{Event: tt.event}
+			got, ok := event.TextDelta()
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("TextDelta() = (%q, %v), want (%q, %v)", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestNewAssistantMessage(t *testing.T) {
+	msg := NewAssistantMessage("claude").
+		WithText("Reading the file").
+		WithToolUse("tool_1", "Read", map[string]any{"file_path": "main.go"}).
+		WithToolUse("tool_2", "Glob", nil)
+
+	data, err := MarshalMessage(msg)
+	if err != nil {
+		t.Fatalf("MarshalMessage() error = %v", err)
+	}
+
+	want := `{"type":"assistant","content":[` +
+		`{"text":"Reading the file","type":"text"},` +
+		`{"id":"tool_1","input":{"file_path":"main.go"},"name":"Read","type":"tool_use"},` +
+		`{"id":"tool_2","input":{},"name":"Glob","type":"tool_use"}],` +
+		`"model":"claude"}`
+	if string(data) != want {
+		t.Errorf("MarshalMessage() =\n%s\nwant\n%s", data, want)
+	}
+}
+
+func TestNewToolResultsMessage(t *testing.T) {
+	isError := true
+	msg := NewToolResultsMessage(
+		ToolResultBlock{ToolUseID: "tool_1", Content: "file contents"},
+		ToolResultBlock{ToolUseID: "tool_2", Content: "command failed", IsError: &isError},
+	)
+
+	data, err := MarshalUserInput(msg, "default")
+	if err != nil {
+		t.Fatalf("MarshalUserInput() error = %v", err)
+	}
+
+	want := `{"message":{"content":[` +
+		`{"content":"file contents","tool_use_id":"tool_1","type":"tool_result"},` +
+		`{"content":"command failed","is_error":true,"tool_use_id":"tool_2","type":"tool_result"}],` +
+		`"role":"user"},"parent_tool_use_id":null,"session_id":"default","type":"user"}`
+	if string(data) != want {
+		t.Errorf("MarshalUserInput() =\n%s\nwant\n%s", data, want)
+	}
+}
+
+func TestNewErrorToolResult(t *testing.T) {
+	result := NewErrorToolResult("tool_1", errors.New("file not found"))
+
+	if result.Type() != ContentTypeToolResult || result.ToolUseID != "tool_1" {
+		t.Errorf("Unexpected tool result %+v", result)
+	}
+	if result.IsError == nil || !*result.IsError {
+		t.Error("Expected IsError to be true")
+	}
+	if result.Content != "file not found" {
+		t.Errorf("Content = %v, want the error message", result.Content)
+	}
+}
+
+func TestMarshalUserInput_StringContent(t *testing.T) {
+	data, err := MarshalUserInput(&UserMessage{Content: "hello"}, "default")
+	if err != nil {
+		t.Fatalf("MarshalUserInput() error = %v", err)
+	}
+
+	want := `{"message":{"content":"hello","role":"user"},"parent_tool_use_id":null,"session_id":"default","type":"user"}`
+	if string(data) != want {
+		t.Errorf("MarshalUserInput() = %s, want %s", data, want)
+	}
+}
+
+func TestControlMessages(t *testing.T) {
+	requestData := []byte(`{"type":"control_request","request_id":"req_1","request":{"subtype":"can_use_tool","tool_name":"Bash","input":{}}}`)
+
+	msg, err := UnmarshalMessage(requestData)
+	if err != nil {
+		t.Fatalf("UnmarshalMessage() error = %v", err)
+	}
+
+	request, ok := msg.(*SDKControlRequest)
+	if !ok {
+		t.Fatalf("Expected *SDKControlRequest, got %T", msg)
+	}
+	if request.Type() != ControlTypeRequest || request.RequestID() != "req_1" {
+		t.Errorf("Unexpected control request: %+v", request)
+	}
+
+	responseData := []byte(`{"type":"control_response","response":{"subtype":"success","request_id":"req_2"}}`)
+
+	msg, err = UnmarshalMessage(responseData)
+	if err != nil {
+		t.Fatalf("UnmarshalMessage() error = %v", err)
+	}
+
+	response, ok := msg.(*SDKControlResponse)
+	if !ok {
+		t.Fatalf("Expected *SDKControlResponse, got %T", msg)
+	}
+	if payload, _ := response.Response.(map[string]any); payload["request_id"] != "req_2" {
+		t.Errorf("Unexpected control response payload: %v", response.Response)
+	}
+}
+
 func TestUnknownContentBlockType(t *testing.T) {
 	data := []byte(`{"type": "unknown", "data": "test"}`)
 
@@ -355,13 +922,32 @@ func TestUnknownContentBlockType(t *testing.T) {
 func TestUnknownMessageType(t *testing.T) {
 	data := []byte(`{"type": "unknown", "data": "test"}`)
 
-	_, err := UnmarshalMessage(data)
-	if err == nil {
-		t.Error("Expected error for unknown message type")
+	msg, err := UnmarshalMessage(data)
+	if err != nil {
+		t.Fatalf("UnmarshalMessage() error = %v", err)
 	}
 
-	if msgErr, ok := err.(*MessageParseError); !ok || msgErr.Message != "unknown message type: unknown" {
-		t.Errorf("Expected MessageParseError with 'unknown message type: unknown', got %v", err)
+	unknown, ok := msg.(*UnknownMessage)
+	if !ok {
+		t.Fatalf("Expected UnknownMessage, got %T", msg)
+	}
+	if unknown.Type() != "unknown" {
+		t.Errorf("Type() = %q, want %q", unknown.Type(), "unknown")
+	}
+	if string(unknown.Raw) != string(data) {
+		t.Errorf("Raw = %s, want %s", unknown.Raw, data)
+	}
+
+	marshaled, err := MarshalMessage(unknown)
+	if err != nil || string(marshaled) != string(data) {
+		t.Errorf("MarshalMessage() = %s, %v, want the raw JSON", marshaled, err)
+	}
+}
+
+func TestMissingMessageType(t *testing.T) {
+	_, err := UnmarshalMessage([]byte(`{"data": "test"}`))
+	if msgErr, ok := err.(*MessageParseError); !ok || msgErr.Message != "message has no type" {
+		t.Errorf("Expected MessageParseError with 'message has no type', got %v", err)
 	}
 }
 
@@ -0,0 +1,28 @@
+package types
+
+import (
+	"time"
+)
+
+// Metric name constants reported to a MetricsCollector
+const (
+	MetricMessagesReceived       = "messages_received_total"
+	MetricParseErrors            = "parse_errors_total"
+	MetricSubprocessStarts       = "subprocess_starts_total"
+	MetricControlRequestsHandled = "control_requests_handled_total"
+	MetricTurnDuration           = "turn_duration"
+	MetricModelFallbacks         = "model_fallbacks_total"
+)
+
+// MetricsCollector receives counters and durations from the SDK.
+//
+// The interface is intentionally small and dependency-free so it can be
+// adapted to Prometheus, OpenTelemetry, statsd or any other backend.
+// Implementations must be safe for concurrent use.
+type MetricsCollector interface {
+	// IncCounter increments the named counter by one
+	IncCounter(name string, labels map[string]string)
+
+	// ObserveDuration records a duration sample for the named histogram
+	ObserveDuration(name string, duration time.Duration, labels map[string]string)
+}
@@ -0,0 +1,80 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+)
+
+// decodeNumbers decodes data into v like json.Unmarshal, but keeps the
+// numbers of untyped values as json.Number so that large integers are not
+// rounded to float64
+func decodeNumbers(data []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+
+	// Like json.Unmarshal, reject data after the value
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("invalid character after top-level value")
+	}
+	return nil
+}
+
+// AsInt64 converts a number held in an untyped JSON value, such as an entry
+// of Usage or SystemMessage.Data, to int64. Those maps hold numbers as
+// json.Number, which converts exactly; float64 and int values, as in maps
+// built by hand, are accepted too. It reports false for values that are not
+// integers.
+func AsInt64(v any) (int64, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		if i, err := n.Int64(); err == nil {
+			return i, true
+		}
+		// Integers written with an exponent or fraction, such as 1e6
+		f, err := n.Float64()
+		if err != nil {
+			return 0, false
+		}
+		return floatToInt64(f)
+	case float64:
+		return floatToInt64(n)
+	case int:
+		return int64(n), true
+	case int64:
+		return n, true
+	default:
+		return 0, false
+	}
+}
+
+// AsFloat64 converts a number held in an untyped JSON value, such as a cost
+// in Usage, to float64. It accepts the same values as AsInt64.
+func AsFloat64(v any) (float64, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
+
+// floatToInt64 converts f to int64 if it is an integer in range
+func floatToInt64(f float64) (int64, bool) {
+	if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, false
+	}
+	return int64(f), true
+}
@@ -0,0 +1,108 @@
+package types
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestAsInt64(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  any
+		want   int64
+		wantOK bool
+	}{
+		{name: "json.Number beyond float64 precision", value: json.Number("9007199254740993"), want: 9007199254740993, wantOK: true},
+		{name: "json.Number with exponent", value: json.Number("1e6"), want: 1000000, wantOK: true},
+		{name: "json.Number fraction", value: json.Number("1.5"), wantOK: false},
+		{name: "float64", value: float64(42), want: 42, wantOK: true},
+		{name: "float64 fraction", value: 0.5, wantOK: false},
+		{name: "int", value: 7, want: 7, wantOK: true},
+		{name: "int64", value: int64(8), want: 8, wantOK: true},
+		{name: "string", value: "42", wantOK: false},
+		{name: "nil", value: nil, wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := AsInt64(tt.value)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("AsInt64(%v) = %d, %v, want %d, %v", tt.value, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestAsFloat64(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  any
+		want   float64
+		wantOK bool
+	}{
+		{name: "json.Number", value: json.Number("0.0123"), want: 0.0123, wantOK: true},
+		{name: "float64", value: 1.5, want: 1.5, wantOK: true},
+		{name: "int", value: 3, want: 3, wantOK: true},
+		{name: "int64", value: int64(4), want: 4, wantOK: true},
+		{name: "invalid json.Number", value: json.Number("abc"), wantOK: false},
+		{name: "bool", value: true, wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := AsFloat64(tt.value)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("AsFloat64(%v) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestUnmarshalMessage_ExactNumbers(t *testing.T) {
+	const large = int64(9007199254740993) // 2^53 + 1, not representable as float64
+
+	tests := []struct {
+		name   string
+		data   string
+		number func(Message) any
+	}{
+		{
+			name:   "assistant usage",
+			data:   `{"type":"assistant","message":{"content":[],"model":"claude","usage":{"input_tokens":9007199254740993}}}`,
+			number: func(m Message) any { return m.(*AssistantMessage).Usage["input_tokens"] },
+		},
+		{
+			name:   "result usage",
+			data:   `{"type":"result","subtype":"success","usage":{"output_tokens":9007199254740993},"structured_output":{"count":1}}`,
+			number: func(m Message) any { return m.(*ResultMessage).Usage["output_tokens"] },
+		},
+		{
+			name:   "system data",
+			data:   `{"type":"system","subtype":"status","data":{"tokens":9007199254740993}}`,
+			number: func(m Message) any { return m.(*SystemMessage).Data["tokens"] },
+		},
+		{
+			name:   "system top-level fields",
+			data:   `{"type":"system","subtype":"status","tokens":9007199254740993}`,
+			number: func(m Message) any { return m.(*SystemMessage).Data["tokens"] },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := UnmarshalMessage([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("UnmarshalMessage() error = %v", err)
+			}
+			if got, ok := AsInt64(tt.number(msg)); !ok || got != large {
+				t.Errorf("Number = %v, want %d", tt.number(msg), large)
+			}
+		})
+	}
+
+	// Structured output stays plain JSON, as output schemas expect float64
+	msg, _ := UnmarshalMessage([]byte(tests[1].data))
+	if output, _ := msg.(*ResultMessage).StructuredOutput.(map[string]any); output["count"] != float64(1) {
+		t.Errorf("StructuredOutput = %#v, want float64 numbers", msg.(*ResultMessage).StructuredOutput)
+	}
+}
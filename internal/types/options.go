@@ -1,9 +1,14 @@
 package types
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 // PermissionMode represents the permission mode for Claude
@@ -25,6 +30,23 @@ const (
 	SettingSourceLocal   SettingSource = "local"
 )
 
+// ResourceLimits lowers the priority and caps the resources of the CLI process
+// and the tools it runs. Zero fields are not applied. Niceness is supported on
+// Unix; memory and CPU limits on Linux only.
+type ResourceLimits struct {
+	// Niceness is added to the process's scheduling priority, from 1 to 19
+	// (lowest priority)
+	Niceness int `json:"niceness,omitempty"`
+
+	// MaxMemoryBytes caps the process's data segment and heap (RLIMIT_DATA);
+	// allocations beyond it fail
+	MaxMemoryBytes uint64 `json:"max_memory_bytes,omitempty"`
+
+	// MaxCPUTime caps the CPU time the process may use (RLIMIT_CPU), rounded
+	// up to whole seconds; the process is killed when it is exceeded
+	MaxCPUTime time.Duration `json:"max_cpu_time,omitempty"`
+}
+
 // SystemPromptPreset represents a system prompt preset configuration
 type SystemPromptPreset struct {
 	Type   string `json:"type"`
@@ -50,6 +72,103 @@ type MCPServerConfig struct {
 	Headers  map[string]string `json:"headers,omitempty"`
 	Name     string            `json:"name,omitempty"`
 	Instance interface{}       `json:"instance,omitempty"`
+
+	// HeadersProvider supplies dynamic headers for http/sse servers (e.g. refreshed
+	// bearer tokens). It is evaluated on every connect and its values override
+	// entries in Headers with the same key.
+	HeadersProvider MCPHeadersProvider `json:"-"`
+
+	// ToolTimeouts bounds how long an in-process server may take to answer a
+	// tools/call for the named tool. On timeout the handler's context is
+	// cancelled and an error is returned to the CLI.
+	ToolTimeouts map[string]time.Duration `json:"-"`
+}
+
+// WithToolTimeout limits how long the in-process server may spend on calls to the given tool
+func (c *MCPServerConfig) WithToolTimeout(tool string, timeout time.Duration) *MCPServerConfig {
+	if c.ToolTimeouts == nil {
+		c.ToolTimeouts = make(map[string]time.Duration)
+	}
+	c.ToolTimeouts[tool] = timeout
+	return c
+}
+
+// MCP server type constants
+const (
+	MCPServerTypeStdio   = "stdio"
+	MCPServerTypeCommand = "command"
+	MCPServerTypeHTTP    = "http"
+	MCPServerTypeSSE     = "sse"
+	MCPServerTypeSDK     = "sdk"
+)
+
+// Validate checks that the fields required by the server type are set. An
+// empty type is treated as stdio.
+func (c *MCPServerConfig) Validate() error {
+	switch c.Type {
+	case "", MCPServerTypeStdio, MCPServerTypeCommand:
+		if c.Command == "" {
+			return fmt.Errorf("%s server requires a command", typeOrDefault(c.Type))
+		}
+	case MCPServerTypeHTTP, MCPServerTypeSSE:
+		if c.URL == "" {
+			return fmt.Errorf("%s server requires a URL", c.Type)
+		}
+	case MCPServerTypeSDK:
+		if c.Instance == nil {
+			return fmt.Errorf("sdk server requires an instance")
+		}
+	default:
+		return fmt.Errorf("unknown server type: %s", c.Type)
+	}
+
+	for tool, timeout := range c.ToolTimeouts {
+		if timeout <= 0 {
+			return fmt.Errorf("timeout for tool %q must be positive, got %s", tool, timeout)
+		}
+	}
+	return nil
+}
+
+// typeOrDefault returns the MCP server type, defaulting to stdio
+func typeOrDefault(serverType string) string {
+	if serverType == "" {
+		return MCPServerTypeStdio
+	}
+	return serverType
+}
+
+// MCPHeadersProvider returns headers to send to an http/sse MCP server
+type MCPHeadersProvider func() (map[string]string, error)
+
+// MCPServerHandler is implemented by in-process (SDK) MCP server instances.
+// Messages the CLI routes to the server are passed to HandleMessage and the
+// returned JSONRPC response is sent back.
+type MCPServerHandler interface {
+	HandleMessage(ctx context.Context, message map[string]any) (map[string]any, error)
+}
+
+// ToolPermissionContext carries additional information for CanUseTool callbacks
+type ToolPermissionContext struct {
+	Suggestions []interface{} `json:"suggestions,omitempty"`
+
+	// SuggestedUpdates are the permission updates the CLI suggests for the
+	// request, such as a rule allowing the command for the session, parsed
+	// from Suggestions. Allowing the request with WithUpdates applies them.
+	SuggestedUpdates []PermissionUpdate `json:"suggested_updates,omitempty"`
+
+	// BlockedPath is set when the tool tried to access a path outside the
+	// allowed directories. Allowing it with an addDirectories PermissionUpdate
+	// grants access to the directory for the rest of the session.
+	BlockedPath *string `json:"blocked_path,omitempty"`
+
+	// UntrustedDir is set when the tool accesses a path inside a directory
+	// declared untrusted with WithWorkspaceTrust
+	UntrustedDir *string `json:"untrusted_dir,omitempty"`
+
+	// Signal is derived from the session context and is cancelled when the
+	// session is closed, so long-running permission checks can abort early.
+	Signal context.Context `json:"-"`
 }
 
 // PermissionResult represents the result of a permission check
@@ -77,7 +196,14 @@ type PermissionRule struct {
 	RuleContent string `json:"ruleContent,omitempty"`
 }
 
-// HookEvent represents hook event types
+// HookEvent represents hook event types.
+//
+// UserPromptSubmit hooks run in the SDK before a prompt is sent, rather than
+// in the CLI. Their input carries the prompt; a hook may replace it by
+// returning hookSpecificOutput.updatedPrompt, add
+// hookSpecificOutput.additionalContext, or block it with
+// {"decision": "block"} or {"continue": false}. Messages written with
+// Client.WriteMessage are not prompts and skip these hooks.
 type HookEvent string
 
 const (
@@ -95,21 +221,44 @@ type HookMatcher struct {
 	Hooks   []HookFunc `json:"-"`
 }
 
-// HookFunc represents a hook function
-type HookFunc func(ctx interface{}, input interface{}, toolUseID *string, context interface{}) (map[string]interface{}, error)
+// HookFunc represents a hook function. ctx is derived from the session context
+// and is cancelled when the session is closed.
+type HookFunc func(ctx context.Context, input interface{}, toolUseID *string, hookContext *HookContext) (map[string]interface{}, error)
+
+// HookContext carries metadata about the session a hook is invoked for
+type HookContext struct {
+	// SessionID is the ID of the session, if known
+	SessionID string
+
+	// Event is the hook event that triggered the callback
+	Event HookEvent
+
+	// PermissionMode is the session's current permission mode, if known
+	PermissionMode PermissionMode
+}
 
 // ClaudeAgentOptions represents query options for Claude SDK
 type ClaudeAgentOptions struct {
 	// Basic options
 	AllowedTools         []string                   `json:"allowed_tools,omitempty"`
+	AllowedToolPatterns  []ToolPattern              `json:"allowed_tool_patterns,omitempty"`
 	SystemPrompt         interface{}                `json:"system_prompt,omitempty"` // string or SystemPromptPreset
 	MCPServers           map[string]MCPServerConfig `json:"mcp_servers,omitempty"`
 	PermissionMode       *PermissionMode            `json:"permission_mode,omitempty"`
 	ContinueConversation bool                       `json:"continue_conversation,omitempty"`
 	Resume               *string                    `json:"resume,omitempty"`
+	ResumeSessionAt      *string                    `json:"resume_session_at,omitempty"`
 	MaxTurns             *int                       `json:"max_turns,omitempty"`
 	DisallowedTools      []string                   `json:"disallowed_tools,omitempty"`
 	Model                *string                    `json:"model,omitempty"`
+	ModelFallback        []string                   `json:"model_fallback,omitempty"`
+	Attachments          []Attachment               `json:"-"`
+	MaxThinkingTokens    *int                       `json:"max_thinking_tokens,omitempty"`
+	OutputSchema         map[string]any             `json:"output_schema,omitempty"`
+
+	// DangerouslyBypassPermissions records that bypassPermissions mode was chosen
+	// deliberately through WithDangerouslyBypassAllPermissions
+	DangerouslyBypassPermissions bool `json:"dangerously_bypass_permissions,omitempty"`
 
 	// Advanced options
 	PermissionPromptToolName *string            `json:"permission_prompt_tool_name,omitempty"`
@@ -117,21 +266,88 @@ type ClaudeAgentOptions struct {
 	CLIPath                  *string            `json:"cli_path,omitempty"`
 	Settings                 *string            `json:"settings,omitempty"`
 	AddDirs                  []string           `json:"add_dirs,omitempty"`
+	WorkspaceTrust           *WorkspaceTrust    `json:"workspace_trust,omitempty"`
 	Env                      map[string]string  `json:"env,omitempty"`
+	EnvFiles                 []string           `json:"env_files,omitempty"`
+	CleanEnv                 bool               `json:"clean_env,omitempty"`
+	EnvAllowlist             []string           `json:"env_allowlist,omitempty"`
 	ExtraArgs                map[string]*string `json:"extra_args,omitempty"`
+	RawArgs                  []string           `json:"raw_args,omitempty"`
+	Debug                    bool               `json:"debug,omitempty"`
 	MaxBufferSize            *int               `json:"max_buffer_size,omitempty"`
+	MaxMessageSize           *int               `json:"max_message_size,omitempty"`
+	StdinBufferSize          *int               `json:"stdin_buffer_size,omitempty"`
+	StderrBufferLines        *int               `json:"stderr_buffer_lines,omitempty"`
+	MessageChannelBuffer     *int               `json:"message_channel_buffer,omitempty"`
+	MaxQueuedMessages        *int               `json:"max_queued_messages,omitempty"`
+	OutputThrottle           *int               `json:"output_throttle,omitempty"`
+	ResourceLimits           *ResourceLimits    `json:"resource_limits,omitempty"`
+	RecordTo                 *string            `json:"record_to,omitempty"`
+	ToolResultSpillDir       *string            `json:"tool_result_spill_dir,omitempty"`
+	ToolResultSpillThreshold *int               `json:"tool_result_spill_threshold,omitempty"`
+	ControlTimeout           *time.Duration     `json:"control_timeout,omitempty"`
+	KeepaliveInterval        *time.Duration     `json:"keepalive_interval,omitempty"`
+	HeartbeatTimeout         *time.Duration     `json:"heartbeat_timeout,omitempty"`
+	HeartbeatKill            bool               `json:"heartbeat_kill,omitempty"`
 	StderrCallback           func(string)       `json:"-"` // Not serialized
+	StderrWriter             io.Writer          `json:"-"` // Not serialized
+	PartialTextCallback      func(string)       `json:"-"` // Not serialized
+	Metrics                  MetricsCollector   `json:"-"` // Not serialized
+	Tracer                   Tracer             `json:"-"` // Not serialized
+
+	// SessionRegistry, when set, stops transports sharing it from resuming
+	// the same session concurrently
+	SessionRegistry *SessionRegistry `json:"-"`
 
 	// Callbacks and hooks
 	CanUseTool func(string, map[string]any, interface{}) (PermissionResult, error) `json:"-"`
 	Hooks      map[HookEvent][]HookMatcher                                         `json:"hooks,omitempty"`
 
+	// PermissionPromptHandler answers the CLI's permission prompts in process,
+	// served as the permission prompt tool
+	PermissionPromptHandler func(string, map[string]any, interface{}) (PermissionResult, error) `json:"-"`
+
+	// PlanApproval, when set, approves or rejects the plans Claude presents
+	// through ExitPlanMode in plan mode
+	PlanApproval func(plan string) bool `json:"-"`
+
+	// OnSessionStart is invoked once with the session ID and model from the init message
+	OnSessionStart func(sessionID, model string) `json:"-"`
+
+	// OnModelFallback is invoked when SendAndWait retries with a fallback model
+	OnModelFallback func(from, to string, err error) `json:"-"`
+
+	// BlockCallback is invoked with each content block as soon as it has streamed completely
+	BlockCallback func(block ContentBlock) `json:"-"`
+
+	// ContextWindowCallback is invoked when the context in use crosses
+	// ContextWindowThreshold, as a fraction of the model's context window
+	ContextWindowCallback  func(budget ContextBudget) `json:"-"`
+	ContextWindowThreshold float64                    `json:"-"`
+
+	// ToolOutputCallback is invoked with output an in-process tool writes to
+	// its ToolOutput while it runs, every ToolOutputFlushInterval
+	ToolOutputCallback      func(server, tool, chunk string) `json:"-"`
+	ToolOutputFlushInterval *time.Duration                   `json:"-"`
+
 	// User and session options
 	User                   *string                    `json:"user,omitempty"`
 	IncludePartialMessages bool                       `json:"include_partial_messages,omitempty"`
 	ForkSession            bool                       `json:"fork_session,omitempty"`
+	ReplayUserMessages     bool                       `json:"replay_user_messages,omitempty"`
+	FailFast               bool                       `json:"fail_fast,omitempty"`
+	SignalHandling         bool                       `json:"signal_handling,omitempty"`
+	ProcessGroup           bool                       `json:"process_group,omitempty"`
+	SkipLocalValidation    bool                       `json:"skip_local_validation,omitempty"`
+	UserAgentName          string                     `json:"user_agent_name,omitempty"`
+	UserAgentVersion       string                     `json:"user_agent_version,omitempty"`
+	StrictMessageTypes     bool                       `json:"strict_message_types,omitempty"`
 	Agents                 map[string]AgentDefinition `json:"agents,omitempty"`
 	SettingSources         []SettingSource            `json:"setting_sources,omitempty"`
+
+	// NoSettingSources loads no settings at all, for a hermetic session. An
+	// empty SettingSources leaves the choice to the CLI's defaults instead.
+	NoSettingSources bool `json:"no_setting_sources,omitempty"`
 }
 
 // NewClaudeAgentOptions creates a new ClaudeAgentOptions with defaults
@@ -158,6 +374,12 @@ func (o *ClaudeAgentOptions) WithAllowedTools(tools ...string) *ClaudeAgentOptio
 	return o
 }
 
+// WithAllowedToolPatterns adds allowed tools scoped by patterns, such as Bash(git:*)
+func (o *ClaudeAgentOptions) WithAllowedToolPatterns(patterns ...ToolPattern) *ClaudeAgentOptions {
+	o.AllowedToolPatterns = append(o.AllowedToolPatterns, patterns...)
+	return o
+}
+
 // WithSystemPrompt sets the system prompt
 func (o *ClaudeAgentOptions) WithSystemPrompt(prompt interface{}) *ClaudeAgentOptions {
 	o.SystemPrompt = prompt
@@ -179,6 +401,22 @@ func (o *ClaudeAgentOptions) WithPermissionMode(mode PermissionMode) *ClaudeAgen
 	return o
 }
 
+// WithDangerouslyBypassAllPermissions sets bypassPermissions mode, in which
+// every tool runs without asking, and records that this was intended. A
+// warning is written to stderr when the session connects.
+func (o *ClaudeAgentOptions) WithDangerouslyBypassAllPermissions() *ClaudeAgentOptions {
+	o.DangerouslyBypassPermissions = true
+	return o.WithPermissionMode(PermissionModeBypassPermission)
+}
+
+// WithOutputSchema constrains Claude's final answer to JSON matching schema.
+// The schema is passed to the CLI, and SendAndWait also validates the result
+// against it, returning an OutputSchemaError on mismatch.
+func (o *ClaudeAgentOptions) WithOutputSchema(schema map[string]any) *ClaudeAgentOptions {
+	o.OutputSchema = schema
+	return o
+}
+
 // WithContinueConversation sets whether to continue conversation
 func (o *ClaudeAgentOptions) WithContinueConversation(continueConv bool) *ClaudeAgentOptions {
 	o.ContinueConversation = continueConv
@@ -191,7 +429,17 @@ func (o *ClaudeAgentOptions) WithResume(resume string) *ClaudeAgentOptions {
 	return o
 }
 
-// WithMaxTurns sets the maximum number of turns
+// WithResumeSessionAt resumes the session only up to and including the
+// assistant message with the given UUID (see AssistantMessage.UUID), dropping
+// the messages after it. Combine with WithResume, and with WithForkSession to
+// keep the original session intact, to regenerate from that point.
+func (o *ClaudeAgentOptions) WithResumeSessionAt(messageUUID string) *ClaudeAgentOptions {
+	o.ResumeSessionAt = &messageUUID
+	return o
+}
+
+// WithMaxTurns sets the maximum number of turns, which must be at least 1.
+// Leave it unset for no limit.
 func (o *ClaudeAgentOptions) WithMaxTurns(maxTurns int) *ClaudeAgentOptions {
 	o.MaxTurns = &maxTurns
 	return o
@@ -209,6 +457,39 @@ func (o *ClaudeAgentOptions) WithModel(model string) *ClaudeAgentOptions {
 	return o
 }
 
+// WithModelFallback sets models to try in order when the model is overloaded
+// or unavailable. SendAndWait and ResumeAndSend retry with the next model when
+// a session ends with such an error result before any assistant message, so a
+// retry never repeats work the model already did.
+func (o *ClaudeAgentOptions) WithModelFallback(models ...string) *ClaudeAgentOptions {
+	o.ModelFallback = models
+	return o
+}
+
+// WithOnModelFallback sets a callback invoked before each retry with a
+// fallback model, with the model that failed (empty for the CLI default), the
+// next model and the error result that caused the retry
+func (o *ClaudeAgentOptions) WithOnModelFallback(callback func(from, to string, err error)) *ClaudeAgentOptions {
+	o.OnModelFallback = callback
+	return o
+}
+
+// WithAttachment attaches the content read from r, under name, to the prompt
+// of SendAndWait and ResumeAndSend. It is read once when the session starts
+// and sent as a text block after the prompt. Raw stdin piping is not
+// possible, since stdin carries the protocol; with a Client, send a message
+// built with NewAttachmentBlock instead.
+func (o *ClaudeAgentOptions) WithAttachment(name string, r io.Reader) *ClaudeAgentOptions {
+	o.Attachments = append(o.Attachments, Attachment{Name: name, Reader: r})
+	return o
+}
+
+// WithMaxThinkingTokens sets the token budget for extended thinking
+func (o *ClaudeAgentOptions) WithMaxThinkingTokens(tokens int) *ClaudeAgentOptions {
+	o.MaxThinkingTokens = &tokens
+	return o
+}
+
 // WithPermissionPromptToolName sets the permission prompt tool name
 func (o *ClaudeAgentOptions) WithPermissionPromptToolName(toolName string) *ClaudeAgentOptions {
 	o.PermissionPromptToolName = &toolName
@@ -239,6 +520,16 @@ func (o *ClaudeAgentOptions) WithCLIPath(cliPath string) *ClaudeAgentOptions {
 	return o
 }
 
+// WithSkipLocalValidation sets whether Validate skips checking that the
+// working directory and CLI path exist on the caller's filesystem, for paths
+// that are only valid where the CLI runs, such as a container volume mounted
+// after the options are built. The subprocess transport still needs both to
+// exist when it starts the CLI, and reports their absence then.
+func (o *ClaudeAgentOptions) WithSkipLocalValidation(skip bool) *ClaudeAgentOptions {
+	o.SkipLocalValidation = skip
+	return o
+}
+
 // WithSettings sets the settings file path
 func (o *ClaudeAgentOptions) WithSettings(settings string) *ClaudeAgentOptions {
 	o.Settings = &settings
@@ -251,6 +542,14 @@ func (o *ClaudeAgentOptions) WithAddDirs(dirs ...string) *ClaudeAgentOptions {
 	return o
 }
 
+// WithWorkspaceTrust declares trusted and untrusted directories. Trusted
+// directories are added to the allowed list; CanUseTool sees UntrustedDir set
+// for tools that access an untrusted directory.
+func (o *ClaudeAgentOptions) WithWorkspaceTrust(trust WorkspaceTrust) *ClaudeAgentOptions {
+	o.WorkspaceTrust = &trust
+	return o
+}
+
 // WithEnv sets environment variables
 func (o *ClaudeAgentOptions) WithEnv(env map[string]string) *ClaudeAgentOptions {
 	if o.Env == nil {
@@ -262,6 +561,40 @@ func (o *ClaudeAgentOptions) WithEnv(env map[string]string) *ClaudeAgentOptions
 	return o
 }
 
+// WithEnvFromFile loads environment variables from a dotenv file at connect
+// time. Keys already set in Env take precedence over the file.
+func (o *ClaudeAgentOptions) WithEnvFromFile(path string) *ClaudeAgentOptions {
+	o.EnvFiles = append(o.EnvFiles, path)
+	return o
+}
+
+// WithCleanEnv controls whether the CLI inherits the SDK's environment. With
+// a clean environment the CLI gets only Env, the variables named by
+// WithEnvAllowlist and those it needs to run, such as PATH, HOME and the
+// temporary directory, rather than every variable of the parent process.
+// Credentials such as ANTHROPIC_API_KEY must then be allowlisted or set in
+// Env.
+func (o *ClaudeAgentOptions) WithCleanEnv(clean bool) *ClaudeAgentOptions {
+	o.CleanEnv = clean
+	return o
+}
+
+// WithEnvAllowlist passes the named variables of the SDK's environment on to
+// the CLI and turns on WithCleanEnv, so that no others are passed
+func (o *ClaudeAgentOptions) WithEnvAllowlist(keys ...string) *ClaudeAgentOptions {
+	o.EnvAllowlist = append(o.EnvAllowlist, keys...)
+	o.CleanEnv = true
+	return o
+}
+
+// WithDebug turns the CLI's debug logging on or off. The logs are written to
+// the CLI's stderr, which is then always piped, and reach the stderr callback
+// and writer.
+func (o *ClaudeAgentOptions) WithDebug(enabled bool) *ClaudeAgentOptions {
+	o.Debug = enabled
+	return o
+}
+
 // WithExtraArg adds an extra CLI argument
 func (o *ClaudeAgentOptions) WithExtraArg(key string, value *string) *ClaudeAgentOptions {
 	if o.ExtraArgs == nil {
@@ -271,18 +604,176 @@ func (o *ClaudeAgentOptions) WithExtraArg(key string, value *string) *ClaudeAgen
 	return o
 }
 
+// WithRawArgs appends CLI arguments verbatim, in order, after the generated flags
+func (o *ClaudeAgentOptions) WithRawArgs(args ...string) *ClaudeAgentOptions {
+	o.RawArgs = append(o.RawArgs, args...)
+	return o
+}
+
 // WithMaxBufferSize sets the maximum buffer size
 func (o *ClaudeAgentOptions) WithMaxBufferSize(size int) *ClaudeAgentOptions {
 	o.MaxBufferSize = &size
 	return o
 }
 
-// WithStderrCallback sets the stderr callback
+// WithMaxMessageSize rejects any single message from the CLI larger than size
+// bytes with a MessageTooLargeError. Unlike MaxBufferSize it is a hard bound:
+// it also applies to tool results that would otherwise be spilled to disk.
+func (o *ClaudeAgentOptions) WithMaxMessageSize(size int) *ClaudeAgentOptions {
+	o.MaxMessageSize = &size
+	return o
+}
+
+// WithStdinBufferSize sets the buffer size of the stdin writer
+func (o *ClaudeAgentOptions) WithStdinBufferSize(size int) *ClaudeAgentOptions {
+	o.StdinBufferSize = &size
+	return o
+}
+
+// WithStderrBufferLines sets how many trailing stderr lines are kept for crash
+// reports. Zero disables capturing.
+func (o *ClaudeAgentOptions) WithStderrBufferLines(lines int) *ClaudeAgentOptions {
+	o.StderrBufferLines = &lines
+	return o
+}
+
+// WithProcessNiceness lowers the scheduling priority of the CLI process by
+// niceness, from 1 to 19, so agents do not starve other work on the host
+func (o *ClaudeAgentOptions) WithProcessNiceness(niceness int) *ClaudeAgentOptions {
+	if o.ResourceLimits == nil {
+		o.ResourceLimits = &ResourceLimits{}
+	}
+	o.ResourceLimits.Niceness = niceness
+	return o
+}
+
+// WithResourceLimits sets the priority and resource limits of the CLI process
+func (o *ClaudeAgentOptions) WithResourceLimits(limits ResourceLimits) *ClaudeAgentOptions {
+	o.ResourceLimits = &limits
+	return o
+}
+
+// WithMessageChannelBuffer sets the capacity of the channels messages are
+// delivered on (default DefaultMessageChannelBuffer); zero makes them
+// unbuffered. When a transport's buffer is full its reader stops reading
+// stdout until the consumer catches up, which in turn blocks the CLI. The
+// control layer used by Client and SendAndWait queues messages beyond its
+// buffer instead, so control requests are still answered, up to the limit set
+// with WithMaxQueuedMessages.
+func (o *ClaudeAgentOptions) WithMessageChannelBuffer(n int) *ClaudeAgentOptions {
+	o.MessageChannelBuffer = &n
+	return o
+}
+
+// WithMaxQueuedMessages limits how many messages the control layer of Client
+// and SendAndWait queues for a consumer that falls behind (default
+// DefaultMaxQueuedMessages). Once n messages are queued it stops reading from
+// the CLI until the consumer catches up, so a stalled consumer does not grow
+// memory without bound; control requests are then not answered either.
+func (o *ClaudeAgentOptions) WithMaxQueuedMessages(n int) *ClaudeAgentOptions {
+	o.MaxQueuedMessages = &n
+	return o
+}
+
+// WithOutputThrottle delivers at most perSecond stream events per second, for
+// consumers that only render them, such as terminal UIs. Stream events arriving
+// sooner are held back in order: consecutive text, thinking and tool input
+// deltas of a content block are merged into one event, and other stream events
+// follow at the limited rate. Complete messages are delivered immediately,
+// after the held events. It applies to the messages of Client and SendAndWait;
+// PartialTextCallback and BlockCallback still see every event.
+func (o *ClaudeAgentOptions) WithOutputThrottle(perSecond int) *ClaudeAgentOptions {
+	o.OutputThrottle = &perSecond
+	return o
+}
+
+// WithRecordTo records every raw stdout line and stdin write of the session to
+// path as JSON Lines, for replay with a ReplayTransport
+func (o *ClaudeAgentOptions) WithRecordTo(path string) *ClaudeAgentOptions {
+	o.RecordTo = &path
+	return o
+}
+
+// WithToolResultSpill writes tool result content larger than threshold bytes
+// to a file in dir (the system temp directory if empty) and delivers a
+// ToolResultFile reference in its place. Messages carrying such results may
+// exceed MaxBufferSize instead of failing.
+func (o *ClaudeAgentOptions) WithToolResultSpill(dir string, threshold int) *ClaudeAgentOptions {
+	o.ToolResultSpillDir = &dir
+	o.ToolResultSpillThreshold = &threshold
+	return o
+}
+
+// WithKeepalive writes a blank line to the CLI's stdin whenever no input has
+// been written for about interval, so idle pipes are not buffered or dropped.
+// It is disabled by default.
+func (o *ClaudeAgentOptions) WithKeepalive(interval time.Duration) *ClaudeAgentOptions {
+	o.KeepaliveInterval = &interval
+	return o
+}
+
+// WithHeartbeatTimeout reports a ProcessError wrapping ErrHeartbeatTimeout
+// when the CLI produces no output for timeout while a turn is waiting for its
+// result, which catches a CLI that hangs without exiting. Time spent waiting
+// for the SDK to answer a control request, such as a permission callback, is
+// not counted. If kill is set, the process is also killed and the session
+// ends. It is disabled by default.
+func (o *ClaudeAgentOptions) WithHeartbeatTimeout(timeout time.Duration, kill bool) *ClaudeAgentOptions {
+	o.HeartbeatTimeout = &timeout
+	o.HeartbeatKill = kill
+	return o
+}
+
+// WithControlTimeout sets how long a control request (initialize, interrupt,
+// set permission mode) waits for its response when the caller's context has no deadline
+func (o *ClaudeAgentOptions) WithControlTimeout(timeout time.Duration) *ClaudeAgentOptions {
+	o.ControlTimeout = &timeout
+	return o
+}
+
+// WithStderrCallback adds a callback invoked with each non-empty stderr line,
+// trimmed. Callbacks added by repeated calls are invoked in order; nil removes
+// all of them.
 func (o *ClaudeAgentOptions) WithStderrCallback(callback func(string)) *ClaudeAgentOptions {
+	if previous := o.StderrCallback; previous != nil && callback != nil {
+		o.StderrCallback = func(line string) {
+			previous(line)
+			callback(line)
+		}
+		return o
+	}
 	o.StderrCallback = callback
 	return o
 }
 
+// WithStderrWriter copies the CLI's stderr to w line by line, as written and
+// including blank lines, alongside any stderr callbacks. Write errors are
+// ignored.
+func (o *ClaudeAgentOptions) WithStderrWriter(w io.Writer) *ClaudeAgentOptions {
+	o.StderrWriter = w
+	return o
+}
+
+// WithMetrics sets the metrics collector
+func (o *ClaudeAgentOptions) WithMetrics(collector MetricsCollector) *ClaudeAgentOptions {
+	o.Metrics = collector
+	return o
+}
+
+// WithTracer sets the tracer used to create spans
+func (o *ClaudeAgentOptions) WithTracer(tracer Tracer) *ClaudeAgentOptions {
+	o.Tracer = tracer
+	return o
+}
+
+// WithSessionRegistry makes the transport claim the session it resumes in
+// registry, failing to connect while another transport sharing the registry
+// is using it
+func (o *ClaudeAgentOptions) WithSessionRegistry(registry *SessionRegistry) *ClaudeAgentOptions {
+	o.SessionRegistry = registry
+	return o
+}
+
 // WithCanUseTool sets the tool permission callback
 func (o *ClaudeAgentOptions) WithCanUseTool(
 	callback func(string, map[string]any, interface{}) (PermissionResult, error),
@@ -291,6 +782,31 @@ func (o *ClaudeAgentOptions) WithCanUseTool(
 	return o
 }
 
+// WithPlanApproval decides on the plans Claude presents in plan mode. The
+// approve callback receives the plan text of each ExitPlanMode request
+// and reports whether to accept it. An accepted plan lets Claude leave plan
+// mode and carry it out; a rejected one keeps it planning. The callback takes
+// precedence over CanUseTool and the permission prompt handler for
+// ExitPlanMode requests.
+func (o *ClaudeAgentOptions) WithPlanApproval(approve func(plan string) bool) *ClaudeAgentOptions {
+	o.PlanApproval = approve
+	return o
+}
+
+// WithPermissionPromptHandler answers permission prompts with handler instead
+// of a separate MCP server. The SDK serves it as an in-process MCP tool and
+// sets it as the permission prompt tool, so the CLI calls it whenever a tool
+// needs approval. The handler has the same signature as CanUseTool and
+// receives a *ToolPermissionContext, so one function can serve both.
+func (o *ClaudeAgentOptions) WithPermissionPromptHandler(
+	handler func(string, map[string]any, interface{}) (PermissionResult, error),
+) *ClaudeAgentOptions {
+	o.PermissionPromptHandler = handler
+	toolName := PermissionPromptToolName
+	o.PermissionPromptToolName = &toolName
+	return o
+}
+
 // WithHook adds a hook for a specific event
 func (o *ClaudeAgentOptions) WithHook(event HookEvent, matcher HookMatcher) *ClaudeAgentOptions {
 	if o.Hooks == nil {
@@ -306,6 +822,89 @@ func (o *ClaudeAgentOptions) WithUser(user string) *ClaudeAgentOptions {
 	return o
 }
 
+// WithPartialTextCallback sets a callback invoked with each text delta as the
+// response streams. It enables partial messages.
+func (o *ClaudeAgentOptions) WithPartialTextCallback(callback func(delta string)) *ClaudeAgentOptions {
+	o.PartialTextCallback = callback
+	o.IncludePartialMessages = true
+	return o
+}
+
+// WithOnSessionStart sets a callback invoked once with the session ID and
+// resolved model when the session's init message arrives
+func (o *ClaudeAgentOptions) WithOnSessionStart(callback func(sessionID, model string)) *ClaudeAgentOptions {
+	o.OnSessionStart = callback
+	return o
+}
+
+// WithFailFast sets whether SendAndWait and ResumeAndSend abort the session and
+// return the first transport or parse error instead of skipping the message
+// and waiting for the result
+func (o *ClaudeAgentOptions) WithFailFast(failFast bool) *ClaudeAgentOptions {
+	o.FailFast = failFast
+	return o
+}
+
+// WithSignalHandling sets whether a connected Client handles Ctrl-C: the first
+// SIGINT interrupts the current turn and the second closes the client,
+// stopping the CLI. A SIGINT after the interrupted turn has ended interrupts
+// again. The handler is removed when the client closes. On Unix the CLI runs
+// in its own process group, as with WithProcessGroup, so the terminal's
+// SIGINT does not kill it before it can be interrupted.
+func (o *ClaudeAgentOptions) WithSignalHandling(enabled bool) *ClaudeAgentOptions {
+	o.SignalHandling = enabled
+	return o
+}
+
+// WithProcessGroup starts the CLI in its own process group, so a terminal
+// SIGINT does not reach it and Close terminates the whole group, including MCP
+// servers and other processes the CLI spawned. Supported on Unix only.
+func (o *ClaudeAgentOptions) WithProcessGroup(enabled bool) *ClaudeAgentOptions {
+	o.ProcessGroup = enabled
+	return o
+}
+
+// WithBlockCallback sets a callback invoked with each text, thinking and
+// tool_use block as soon as it has finished streaming, before the assistant
+// message containing it is delivered. It enables partial messages.
+func (o *ClaudeAgentOptions) WithBlockCallback(callback func(block ContentBlock)) *ClaudeAgentOptions {
+	o.BlockCallback = callback
+	o.IncludePartialMessages = true
+	return o
+}
+
+// WithContextWindowCallback sets a callback invoked when the context in use,
+// estimated from the usage of each assistant message of the main agent,
+// reaches threshold (a fraction in (0, 1]) of the model's context window. It
+// fires once per crossing and again only after usage drops back below, such
+// as after a compaction, so agents can compact or stop before hitting the
+// limit.
+func (o *ClaudeAgentOptions) WithContextWindowCallback(threshold float64, callback func(budget ContextBudget)) *ClaudeAgentOptions {
+	o.ContextWindowThreshold = threshold
+	o.ContextWindowCallback = callback
+	return o
+}
+
+// WithToolOutputCallback sets a callback invoked with the output in-process
+// tools write to their ToolOutput while they run, flushed every interval (or
+// DefaultToolOutputFlushInterval if zero). Chunks of a call arrive in order,
+// and the last one before its result is sent to the CLI; see ToolOutput.
+func (o *ClaudeAgentOptions) WithToolOutputCallback(interval time.Duration, callback func(server, tool, chunk string)) *ClaudeAgentOptions {
+	if interval == 0 {
+		interval = DefaultToolOutputFlushInterval
+	}
+	o.ToolOutputCallback = callback
+	o.ToolOutputFlushInterval = &interval
+	return o
+}
+
+// WithStrictMessageTypes sets whether messages of unknown type are reported as
+// parse errors instead of being delivered as UnknownMessage
+func (o *ClaudeAgentOptions) WithStrictMessageTypes(strict bool) *ClaudeAgentOptions {
+	o.StrictMessageTypes = strict
+	return o
+}
+
 // WithIncludePartialMessages sets whether to include partial messages
 func (o *ClaudeAgentOptions) WithIncludePartialMessages(include bool) *ClaudeAgentOptions {
 	o.IncludePartialMessages = include
@@ -318,6 +917,23 @@ func (o *ClaudeAgentOptions) WithForkSession(fork bool) *ClaudeAgentOptions {
 	return o
 }
 
+// WithReplayUserMessages sets whether the CLI echoes the user messages written
+// to it back on the output stream, so transcripts can be built from the
+// output alone
+func (o *ClaudeAgentOptions) WithReplayUserMessages(replay bool) *ClaudeAgentOptions {
+	o.ReplayUserMessages = replay
+	return o
+}
+
+// WithUserAgent identifies the application built on the SDK. The name and
+// version are appended to the SDK's own entrypoint and version identifiers
+// passed to the CLI
+func (o *ClaudeAgentOptions) WithUserAgent(name, version string) *ClaudeAgentOptions {
+	o.UserAgentName = name
+	o.UserAgentVersion = version
+	return o
+}
+
 // WithAgent adds an agent definition
 func (o *ClaudeAgentOptions) WithAgent(name string, definition AgentDefinition) *ClaudeAgentOptions {
 	if o.Agents == nil {
@@ -330,6 +946,15 @@ func (o *ClaudeAgentOptions) WithAgent(name string, definition AgentDefinition)
 // WithSettingSources adds setting sources
 func (o *ClaudeAgentOptions) WithSettingSources(sources ...SettingSource) *ClaudeAgentOptions {
 	o.SettingSources = append(o.SettingSources, sources...)
+	o.NoSettingSources = false
+	return o
+}
+
+// WithoutSettingSources loads no user, project or local settings, rather
+// than the CLI's default sources
+func (o *ClaudeAgentOptions) WithoutSettingSources() *ClaudeAgentOptions {
+	o.SettingSources = make([]SettingSource, 0)
+	o.NoSettingSources = true
 	return o
 }
 
@@ -340,20 +965,191 @@ func (o *ClaudeAgentOptions) Validate() error {
 		return fmt.Errorf("cannot use both resume and continue_conversation options")
 	}
 
+	// Validate that resume_session_at names a message of a resumed session
+	if o.ResumeSessionAt != nil {
+		if o.Resume == nil {
+			return fmt.Errorf("resume_session_at requires resume")
+		}
+		if *o.ResumeSessionAt == "" {
+			return fmt.Errorf("resume_session_at must not be empty")
+		}
+	}
+
+	// Validate that a deliberate bypass was not overridden by a later permission mode
+	if o.DangerouslyBypassPermissions && (o.PermissionMode == nil || *o.PermissionMode != PermissionModeBypassPermission) {
+		return fmt.Errorf("permission mode was changed after WithDangerouslyBypassAllPermissions")
+	}
+
+	// Bypass mode skips permission checks, so a disallowed list suggests a misunderstanding
+	if o.PermissionMode != nil && *o.PermissionMode == PermissionModeBypassPermission && len(o.DisallowedTools) > 0 {
+		return fmt.Errorf("cannot combine bypassPermissions mode with disallowed tools %v; use a different permission mode to restrict tools", o.DisallowedTools)
+	}
+
 	// Check if CWD exists
-	if o.CWD != nil {
+	if o.CWD != nil && !o.SkipLocalValidation {
 		if _, err := os.Stat(*o.CWD); os.IsNotExist(err) {
 			return fmt.Errorf("working directory does not exist: %s", *o.CWD)
 		}
 	}
 
 	// Check if CLI path exists
-	if o.CLIPath != nil {
+	if o.CLIPath != nil && !o.SkipLocalValidation {
 		if _, err := os.Stat(*o.CLIPath); os.IsNotExist(err) {
 			return fmt.Errorf("CLI path does not exist: %s", *o.CLIPath)
 		}
 	}
 
+	// Validate MCP servers
+	for name, server := range o.MCPServers {
+		if err := server.Validate(); err != nil {
+			return fmt.Errorf("invalid MCP server %q: %w", name, err)
+		}
+	}
+
+	// Validate allowed tool patterns
+	for _, pattern := range o.AllowedToolPatterns {
+		if err := pattern.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// Validate turn limit; the CLI's reading of zero or negative limits is unclear
+	if o.MaxTurns != nil && *o.MaxTurns < 1 {
+		return fmt.Errorf("max turns must be at least 1: %d", *o.MaxTurns)
+	}
+
+	// Validate thinking budget
+	if o.MaxThinkingTokens != nil && *o.MaxThinkingTokens <= 0 {
+		return fmt.Errorf("max thinking tokens must be positive: %d", *o.MaxThinkingTokens)
+	}
+
+	// Validate control timeout
+	if o.ControlTimeout != nil && *o.ControlTimeout <= 0 {
+		return fmt.Errorf("control timeout must be positive: %s", *o.ControlTimeout)
+	}
+
+	// Validate tool result spill threshold
+	if o.ToolResultSpillThreshold != nil && *o.ToolResultSpillThreshold <= 0 {
+		return fmt.Errorf("tool result spill threshold must be positive: %d", *o.ToolResultSpillThreshold)
+	}
+
+	// Validate keepalive interval
+	if o.KeepaliveInterval != nil && *o.KeepaliveInterval <= 0 {
+		return fmt.Errorf("keepalive interval must be positive: %s", *o.KeepaliveInterval)
+	}
+
+	// Validate attachments
+	for _, attachment := range o.Attachments {
+		if attachment.Name == "" || attachment.Reader == nil {
+			return fmt.Errorf("attachment requires a name and a reader")
+		}
+	}
+
+	// Validate that no setting sources is not combined with explicit ones
+	if o.NoSettingSources && len(o.SettingSources) > 0 {
+		return fmt.Errorf("no_setting_sources cannot be combined with setting sources")
+	}
+
+	// Validate fallback models
+	for _, model := range o.ModelFallback {
+		if model == "" {
+			return fmt.Errorf("fallback model must not be empty")
+		}
+	}
+
+	// Validate context window threshold
+	if o.ContextWindowCallback != nil && (o.ContextWindowThreshold <= 0 || o.ContextWindowThreshold > 1) {
+		return fmt.Errorf("context window threshold must be in (0, 1]: %g", o.ContextWindowThreshold)
+	}
+
+	// Validate tool output flush interval
+	if o.ToolOutputFlushInterval != nil && *o.ToolOutputFlushInterval <= 0 {
+		return fmt.Errorf("tool output flush interval must be positive: %s", *o.ToolOutputFlushInterval)
+	}
+
+	// Validate heartbeat timeout
+	if o.HeartbeatTimeout != nil && *o.HeartbeatTimeout <= 0 {
+		return fmt.Errorf("heartbeat timeout must be positive: %s", *o.HeartbeatTimeout)
+	}
+
+	// Validate max message size
+	if o.MaxMessageSize != nil && *o.MaxMessageSize <= 0 {
+		return fmt.Errorf("max message size must be positive: %d", *o.MaxMessageSize)
+	}
+
+	// Validate stdin buffer size
+	if o.StdinBufferSize != nil && *o.StdinBufferSize <= 0 {
+		return fmt.Errorf("stdin buffer size must be positive: %d", *o.StdinBufferSize)
+	}
+
+	// Validate stderr buffer lines
+	if o.StderrBufferLines != nil && *o.StderrBufferLines < 0 {
+		return fmt.Errorf("stderr buffer lines must not be negative: %d", *o.StderrBufferLines)
+	}
+
+	// Validate resource limits
+	if limits := o.ResourceLimits; limits != nil {
+		if limits.Niceness < 0 || limits.Niceness > 19 {
+			return fmt.Errorf("process niceness must be between 0 and 19: %d", limits.Niceness)
+		}
+		if limits.MaxCPUTime < 0 {
+			return fmt.Errorf("max CPU time must not be negative: %v", limits.MaxCPUTime)
+		}
+	}
+
+	// Validate message channel buffer
+	if o.MessageChannelBuffer != nil && *o.MessageChannelBuffer < 0 {
+		return fmt.Errorf("message channel buffer must not be negative: %d", *o.MessageChannelBuffer)
+	}
+
+	if o.MaxQueuedMessages != nil && *o.MaxQueuedMessages <= 0 {
+		return fmt.Errorf("max queued messages must be positive: %d", *o.MaxQueuedMessages)
+	}
+
+	// Validate output throttle
+	if o.OutputThrottle != nil && *o.OutputThrottle <= 0 {
+		return fmt.Errorf("output throttle must be positive: %d", *o.OutputThrottle)
+	}
+
+	// Validate user agent
+	if o.UserAgentVersion != "" && o.UserAgentName == "" {
+		return fmt.Errorf("user agent version %q requires a name", o.UserAgentVersion)
+	}
+	if strings.ContainsAny(o.UserAgentName, " \t\n/") || strings.ContainsAny(o.UserAgentVersion, " \t\n/") {
+		return fmt.Errorf("user agent name and version must not contain whitespace or '/': %q %q", o.UserAgentName, o.UserAgentVersion)
+	}
+
+	// Validate the in-process permission prompt tool
+	if o.PermissionPromptHandler != nil {
+		if o.PermissionPromptToolName == nil || *o.PermissionPromptToolName != PermissionPromptToolName {
+			return fmt.Errorf("permission prompt tool name was changed after WithPermissionPromptHandler")
+		}
+		if _, ok := o.MCPServers[PermissionPromptServerName]; ok {
+			return fmt.Errorf("MCP server name %q is reserved for WithPermissionPromptHandler", PermissionPromptServerName)
+		}
+	}
+
+	// Validate workspace trust
+	if o.WorkspaceTrust != nil {
+		cwd := ""
+		if o.CWD != nil {
+			cwd = *o.CWD
+		}
+		if err := o.WorkspaceTrust.validate(o.AddDirs, cwd); err != nil {
+			return fmt.Errorf("invalid workspace trust: %w", err)
+		}
+		if len(o.WorkspaceTrust.Untrusted) > 0 && o.PermissionMode != nil && *o.PermissionMode == PermissionModeBypassPermission {
+			return fmt.Errorf("untrusted directories cannot be enforced in bypassPermissions mode")
+		}
+	}
+
+	// Validate output schema
+	if o.OutputSchema != nil {
+		if _, err := json.Marshal(o.OutputSchema); err != nil {
+			return fmt.Errorf("output schema cannot be encoded as JSON: %w", err)
+		}
+	}
+
 	// Validate permission mode
 	if o.PermissionMode != nil {
 		switch *o.PermissionMode {
@@ -378,6 +1174,22 @@ func (o *ClaudeAgentOptions) GetWorkingDirectory() string {
 	return "."
 }
 
+// GetMessageChannelBuffer returns the message channel capacity, defaulting to DefaultMessageChannelBuffer
+func (o *ClaudeAgentOptions) GetMessageChannelBuffer() int {
+	if o.MessageChannelBuffer != nil && *o.MessageChannelBuffer >= 0 {
+		return *o.MessageChannelBuffer
+	}
+	return DefaultMessageChannelBuffer
+}
+
+// GetMaxQueuedMessages returns the queued message limit, defaulting to DefaultMaxQueuedMessages
+func (o *ClaudeAgentOptions) GetMaxQueuedMessages() int {
+	if o.MaxQueuedMessages != nil && *o.MaxQueuedMessages > 0 {
+		return *o.MaxQueuedMessages
+	}
+	return DefaultMaxQueuedMessages
+}
+
 // GetCLIPath returns the CLI path
 func (o *ClaudeAgentOptions) GetCLIPath() *string {
 	return o.CLIPath
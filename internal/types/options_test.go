@@ -1,8 +1,12 @@
 package types
 
 import (
+	"context"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewClaudeAgentOptions(t *testing.T) {
@@ -150,9 +154,28 @@ func TestWithEnv(t *testing.T) {
 	}
 }
 
+func TestWithEnvAllowlist(t *testing.T) {
+	opts := NewClaudeAgentOptions().
+		WithEnvAllowlist("ANTHROPIC_API_KEY").
+		WithEnvAllowlist("HTTPS_PROXY", "NO_PROXY")
+
+	if !opts.CleanEnv {
+		t.Error("WithEnvAllowlist should turn on CleanEnv")
+	}
+	want := []string{"ANTHROPIC_API_KEY", "HTTPS_PROXY", "NO_PROXY"}
+	if !reflect.DeepEqual(opts.EnvAllowlist, want) {
+		t.Errorf("EnvAllowlist = %v, want %v", opts.EnvAllowlist, want)
+	}
+
+	opts.WithCleanEnv(false)
+	if opts.CleanEnv {
+		t.Error("WithCleanEnv(false) should turn off CleanEnv")
+	}
+}
+
 func TestWithHook(t *testing.T) {
 	opts := NewClaudeAgentOptions()
-	hook := func(ctx interface{}, input interface{}, toolUseID *string, context interface{}) (map[string]interface{}, error) {
+	hook := func(ctx context.Context, input interface{}, toolUseID *string, hookContext *HookContext) (map[string]interface{}, error) {
 		return map[string]interface{}{"continue_": true}, nil
 	}
 
@@ -220,12 +243,67 @@ func TestWithSettingSources(t *testing.T) {
 	}
 }
 
+func TestWithoutSettingSources(t *testing.T) {
+	opts := NewClaudeAgentOptions().WithSettingSources(SettingSourceUser).WithoutSettingSources()
+	if !opts.NoSettingSources || len(opts.SettingSources) != 0 {
+		t.Errorf("WithoutSettingSources() = %v, %v; want no sources", opts.NoSettingSources, opts.SettingSources)
+	}
+
+	opts.WithSettingSources(SettingSourceProject)
+	if opts.NoSettingSources {
+		t.Error("WithSettingSources() should undo WithoutSettingSources()")
+	}
+}
+
+func TestWithStderrCallback(t *testing.T) {
+	var calls []string
+	opts := NewClaudeAgentOptions().
+		WithStderrCallback(func(line string) { calls = append(calls, "log:"+line) }).
+		WithStderrCallback(func(line string) { calls = append(calls, "inspect:"+line) })
+
+	opts.StderrCallback("boom")
+	if want := []string{"log:boom", "inspect:boom"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("Callbacks = %v, want %v", calls, want)
+	}
+
+	if opts.WithStderrCallback(nil).StderrCallback != nil {
+		t.Error("WithStderrCallback(nil) should remove the callbacks")
+	}
+}
+
 func TestValidate(t *testing.T) {
 	t.Run("valid options", testValidOptions)
 	t.Run("invalid permission mode", testInvalidPermissionMode)
 	t.Run("conflicting resume and continue conversation", testConflictingOptions)
+	t.Run("bypass permissions with disallowed tools", testBypassWithDisallowedTools)
 	t.Run("non-existent CWD", testNonExistentCWD)
 	t.Run("non-existent CLI path", testNonExistentCLIPath)
+	t.Run("skip local validation", testSkipLocalValidation)
+	t.Run("non-positive stdin buffer size", testInvalidStdinBufferSize)
+	t.Run("negative stderr buffer lines", testInvalidStderrBufferLines)
+	t.Run("invalid tool pattern", testInvalidToolPattern)
+	t.Run("invalid MCP server", testInvalidMCPServer)
+	t.Run("non-positive thinking budget", testInvalidThinkingBudget)
+	t.Run("non-positive control timeout", testInvalidControlTimeout)
+	t.Run("non-positive tool result spill threshold", testInvalidToolResultSpillThreshold)
+	t.Run("non-positive keepalive interval", testInvalidKeepaliveInterval)
+	t.Run("non-positive max message size", testInvalidMaxMessageSize)
+	t.Run("invalid user agent", testInvalidUserAgent)
+	t.Run("dangerous bypass overridden", testDangerousBypassOverridden)
+	t.Run("inconsistent workspace trust", testInconsistentWorkspaceTrust)
+	t.Run("invalid resource limits", testInvalidResourceLimits)
+	t.Run("permission prompt handler conflicts", testPermissionPromptHandlerConflicts)
+	t.Run("negative message channel buffer", testNegativeMessageChannelBuffer)
+	t.Run("resume session at without resume", testResumeSessionAtWithoutResume)
+	t.Run("non-positive heartbeat timeout", testInvalidHeartbeatTimeout)
+	t.Run("negative tool output flush interval", testInvalidToolOutputFlushInterval)
+	t.Run("empty fallback model", testEmptyFallbackModel)
+	t.Run("context window threshold out of range", testInvalidContextWindowThreshold)
+	t.Run("incomplete attachment", testIncompleteAttachment)
+	t.Run("no setting sources with explicit sources", testNoSettingSourcesWithSources)
+	t.Run("non-positive output throttle", testInvalidOutputThrottle)
+	t.Run("non-positive max queued messages", testInvalidMaxQueuedMessages)
+	t.Run("non-positive max turns", testInvalidMaxTurns)
 }
 
 func testValidOptions(t *testing.T) {
@@ -264,6 +342,25 @@ func testConflictingOptions(t *testing.T) {
 	}
 }
 
+func testBypassWithDisallowedTools(t *testing.T) {
+	opts := NewClaudeAgentOptions().
+		WithPermissionMode(PermissionModeBypassPermission).
+		WithDisallowedTools("Bash")
+
+	err := opts.Validate()
+	if err == nil {
+		t.Error("Expected error for bypassPermissions with disallowed tools")
+	}
+
+	// Allowed tools are redundant but harmless in bypass mode
+	opts = NewClaudeAgentOptions().
+		WithPermissionMode(PermissionModeBypassPermission).
+		WithAllowedTools("Read")
+	if err := opts.Validate(); err != nil {
+		t.Errorf("Expected allowed tools to be accepted in bypass mode, got %v", err)
+	}
+}
+
 func testNonExistentCWD(t *testing.T) {
 	opts := NewClaudeAgentOptions().
 		WithCWD("/non/existent/path")
@@ -284,6 +381,372 @@ func testNonExistentCLIPath(t *testing.T) {
 	}
 }
 
+func testSkipLocalValidation(t *testing.T) {
+	opts := NewClaudeAgentOptions().
+		WithCWD("/non/existent/path").
+		WithCLIPath("/non/existent/cli").
+		WithSkipLocalValidation(true)
+
+	if err := opts.Validate(); err != nil {
+		t.Errorf("Validate() error = %v, want nil when local validation is skipped", err)
+	}
+}
+
+func testInvalidStdinBufferSize(t *testing.T) {
+	opts := NewClaudeAgentOptions().
+		WithStdinBufferSize(0)
+
+	err := opts.Validate()
+	if err == nil {
+		t.Error("Expected error for non-positive stdin buffer size")
+	}
+}
+
+func testInvalidStderrBufferLines(t *testing.T) {
+	opts := NewClaudeAgentOptions().
+		WithStderrBufferLines(-1)
+
+	err := opts.Validate()
+	if err == nil {
+		t.Error("Expected error for negative stderr buffer lines")
+	}
+}
+
+func testInvalidToolPattern(t *testing.T) {
+	opts := NewClaudeAgentOptions().
+		WithAllowedToolPatterns(ToolPattern{Name: "Bash", Pattern: "echo ("})
+
+	err := opts.Validate()
+	if err == nil {
+		t.Error("Expected error for invalid tool pattern")
+	}
+}
+
+func testInvalidMCPServer(t *testing.T) {
+	opts := NewClaudeAgentOptions().
+		WithMCPServer("remote", &MCPServerConfig{Type: MCPServerTypeHTTP})
+
+	err := opts.Validate()
+	if err == nil {
+		t.Fatal("Expected error for MCP server without URL")
+	}
+	if err.Error() != `invalid MCP server "remote": http server requires a URL` {
+		t.Errorf("Error message = %v", err.Error())
+	}
+}
+
+func testInvalidThinkingBudget(t *testing.T) {
+	opts := NewClaudeAgentOptions().
+		WithMaxThinkingTokens(0)
+
+	err := opts.Validate()
+	if err == nil {
+		t.Error("Expected error for non-positive thinking budget")
+	}
+}
+
+func testInvalidControlTimeout(t *testing.T) {
+	opts := NewClaudeAgentOptions().
+		WithControlTimeout(0)
+
+	err := opts.Validate()
+	if err == nil {
+		t.Error("Expected error for non-positive control timeout")
+	}
+}
+
+func testInvalidToolResultSpillThreshold(t *testing.T) {
+	opts := NewClaudeAgentOptions().
+		WithToolResultSpill("", 0)
+
+	err := opts.Validate()
+	if err == nil {
+		t.Error("Expected error for non-positive tool result spill threshold")
+	}
+}
+
+func testInvalidKeepaliveInterval(t *testing.T) {
+	opts := NewClaudeAgentOptions().
+		WithKeepalive(-time.Second)
+
+	err := opts.Validate()
+	if err == nil {
+		t.Error("Expected error for non-positive keepalive interval")
+	}
+}
+
+func testInvalidMaxMessageSize(t *testing.T) {
+	opts := NewClaudeAgentOptions().
+		WithMaxMessageSize(0)
+
+	err := opts.Validate()
+	if err == nil {
+		t.Error("Expected error for non-positive max message size")
+	}
+}
+
+func testInvalidUserAgent(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+	}{
+		{"", "1.0.0"},
+		{"my app", "1.0.0"},
+		{"my-app", "1.0/beta"},
+	}
+	for _, tt := range tests {
+		opts := NewClaudeAgentOptions().WithUserAgent(tt.name, tt.version)
+		if err := opts.Validate(); err == nil {
+			t.Errorf("Expected error for user agent %q %q", tt.name, tt.version)
+		}
+	}
+
+	opts := NewClaudeAgentOptions().WithUserAgent("my-app", "")
+	if err := opts.Validate(); err != nil {
+		t.Errorf("Expected user agent without version to be accepted, got %v", err)
+	}
+}
+
+func testDangerousBypassOverridden(t *testing.T) {
+	opts := NewClaudeAgentOptions().WithDangerouslyBypassAllPermissions()
+	if err := opts.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v, want nil", err)
+	}
+	if opts.PermissionMode == nil || *opts.PermissionMode != PermissionModeBypassPermission {
+		t.Errorf("PermissionMode = %v, want bypassPermissions", opts.PermissionMode)
+	}
+
+	opts.WithPermissionMode(PermissionModeDefault)
+	if err := opts.Validate(); err == nil {
+		t.Error("Expected error when the permission mode is changed after a deliberate bypass")
+	}
+}
+
+func testInconsistentWorkspaceTrust(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    *ClaudeAgentOptions
+		wantErr bool
+	}{
+		{
+			name: "untrusted inside trusted",
+			opts: NewClaudeAgentOptions().WithWorkspaceTrust(WorkspaceTrust{Trusted: []string{"/repo"}, Untrusted: []string{"/repo/vendor"}}),
+		},
+		{
+			name:    "trusted inside untrusted",
+			opts:    NewClaudeAgentOptions().WithWorkspaceTrust(WorkspaceTrust{Trusted: []string{"/repo/src"}, Untrusted: []string{"/repo"}}),
+			wantErr: true,
+		},
+		{
+			name:    "added dir inside untrusted",
+			opts:    NewClaudeAgentOptions().WithAddDirs("/downloads/pkg").WithWorkspaceTrust(WorkspaceTrust{Untrusted: []string{"/downloads"}}),
+			wantErr: true,
+		},
+		{
+			name:    "relative dir resolved against cwd",
+			opts:    NewClaudeAgentOptions().WithCWD("/repo").WithAddDirs("vendor").WithWorkspaceTrust(WorkspaceTrust{Untrusted: []string{"/repo/vendor"}}),
+			wantErr: true,
+		},
+		{
+			name:    "empty untrusted dir",
+			opts:    NewClaudeAgentOptions().WithWorkspaceTrust(WorkspaceTrust{Untrusted: []string{""}}),
+			wantErr: true,
+		},
+		{
+			name:    "untrusted dirs with bypass",
+			opts:    NewClaudeAgentOptions().WithPermissionMode(PermissionModeBypassPermission).WithWorkspaceTrust(WorkspaceTrust{Untrusted: []string{"/repo"}}),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func testInvalidResourceLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    *ClaudeAgentOptions
+		wantErr bool
+	}{
+		{name: "niceness", opts: NewClaudeAgentOptions().WithProcessNiceness(10)},
+		{name: "negative niceness", opts: NewClaudeAgentOptions().WithProcessNiceness(-5), wantErr: true},
+		{name: "niceness too high", opts: NewClaudeAgentOptions().WithProcessNiceness(20), wantErr: true},
+		{name: "negative CPU time", opts: NewClaudeAgentOptions().WithResourceLimits(ResourceLimits{MaxCPUTime: -time.Second}), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func testPermissionPromptHandlerConflicts(t *testing.T) {
+	handler := func(string, map[string]any, interface{}) (PermissionResult, error) {
+		return PermissionResult{Behavior: PermissionBehaviorAllow}, nil
+	}
+
+	if err := NewClaudeAgentOptions().WithPermissionPromptHandler(handler).Validate(); err != nil {
+		t.Errorf("Validate() error = %v, want nil", err)
+	}
+
+	renamed := NewClaudeAgentOptions().WithPermissionPromptHandler(handler).WithPermissionPromptToolName("mcp__auth__approve")
+	if err := renamed.Validate(); err == nil {
+		t.Error("Expected error when the permission prompt tool is renamed after WithPermissionPromptHandler")
+	}
+
+	reserved := NewClaudeAgentOptions().
+		WithPermissionPromptHandler(handler).
+		WithMCPServer(PermissionPromptServerName, &MCPServerConfig{Command: "server"})
+	if err := reserved.Validate(); err == nil {
+		t.Error("Expected error for an MCP server using the reserved name")
+	}
+}
+
+func testNegativeMessageChannelBuffer(t *testing.T) {
+	if err := NewClaudeAgentOptions().WithMessageChannelBuffer(0).Validate(); err != nil {
+		t.Errorf("Validate() error = %v, want nil for an unbuffered channel", err)
+	}
+	if err := NewClaudeAgentOptions().WithMessageChannelBuffer(-1).Validate(); err == nil {
+		t.Error("Expected error for negative message channel buffer")
+	}
+}
+
+func testResumeSessionAtWithoutResume(t *testing.T) {
+	if err := NewClaudeAgentOptions().WithResume("session_123").WithResumeSessionAt("uuid-1").Validate(); err != nil {
+		t.Errorf("Validate() error = %v, want nil", err)
+	}
+	if err := NewClaudeAgentOptions().WithResumeSessionAt("uuid-1").Validate(); err == nil {
+		t.Error("Expected error for resume_session_at without resume")
+	}
+	if err := NewClaudeAgentOptions().WithResume("session_123").WithResumeSessionAt("").Validate(); err == nil {
+		t.Error("Expected error for empty resume_session_at")
+	}
+}
+
+func testInvalidHeartbeatTimeout(t *testing.T) {
+	if err := NewClaudeAgentOptions().WithHeartbeatTimeout(time.Minute, true).Validate(); err != nil {
+		t.Errorf("Validate() error = %v, want nil", err)
+	}
+	if err := NewClaudeAgentOptions().WithHeartbeatTimeout(0, false).Validate(); err == nil {
+		t.Error("Expected error for zero heartbeat timeout")
+	}
+}
+
+func testInvalidToolOutputFlushInterval(t *testing.T) {
+	callback := func(server, tool, chunk string) {}
+
+	opts := NewClaudeAgentOptions().WithToolOutputCallback(0, callback)
+	if err := opts.Validate(); err != nil {
+		t.Errorf("Validate() error = %v, want nil", err)
+	}
+	if *opts.ToolOutputFlushInterval != DefaultToolOutputFlushInterval {
+		t.Errorf("ToolOutputFlushInterval = %s, want default %s", *opts.ToolOutputFlushInterval, DefaultToolOutputFlushInterval)
+	}
+	if err := NewClaudeAgentOptions().WithToolOutputCallback(-time.Second, callback).Validate(); err == nil {
+		t.Error("Expected error for negative tool output flush interval")
+	}
+}
+
+func testEmptyFallbackModel(t *testing.T) {
+	if err := NewClaudeAgentOptions().WithModelFallback("claude-sonnet-4-5").Validate(); err != nil {
+		t.Errorf("Validate() error = %v, want nil", err)
+	}
+	if err := NewClaudeAgentOptions().WithModelFallback("claude-sonnet-4-5", "").Validate(); err == nil {
+		t.Error("Expected error for empty fallback model")
+	}
+}
+
+func testInvalidContextWindowThreshold(t *testing.T) {
+	callback := func(budget ContextBudget) {}
+
+	if err := NewClaudeAgentOptions().WithContextWindowCallback(0.8, callback).Validate(); err != nil {
+		t.Errorf("Validate() error = %v, want nil", err)
+	}
+	for _, threshold := range []float64{0, -0.5, 1.5} {
+		if err := NewClaudeAgentOptions().WithContextWindowCallback(threshold, callback).Validate(); err == nil {
+			t.Errorf("Expected error for context window threshold %g", threshold)
+		}
+	}
+}
+
+func testIncompleteAttachment(t *testing.T) {
+	if err := NewClaudeAgentOptions().WithAttachment("notes.txt", strings.NewReader("notes")).Validate(); err != nil {
+		t.Errorf("Validate() error = %v, want nil", err)
+	}
+	if err := NewClaudeAgentOptions().WithAttachment("", strings.NewReader("notes")).Validate(); err == nil {
+		t.Error("Expected error for attachment without a name")
+	}
+	if err := NewClaudeAgentOptions().WithAttachment("notes.txt", nil).Validate(); err == nil {
+		t.Error("Expected error for attachment without a reader")
+	}
+}
+
+func testNoSettingSourcesWithSources(t *testing.T) {
+	opts := NewClaudeAgentOptions().WithoutSettingSources()
+	if err := opts.Validate(); err != nil {
+		t.Errorf("Validate() error = %v, want nil", err)
+	}
+
+	opts.SettingSources = append(opts.SettingSources, SettingSourceUser)
+	if err := opts.Validate(); err == nil {
+		t.Error("Expected error for no setting sources combined with explicit ones")
+	}
+}
+
+func testInvalidOutputThrottle(t *testing.T) {
+	if err := NewClaudeAgentOptions().WithOutputThrottle(30).Validate(); err != nil {
+		t.Errorf("Validate() error = %v, want nil", err)
+	}
+	for _, perSecond := range []int{0, -1} {
+		if err := NewClaudeAgentOptions().WithOutputThrottle(perSecond).Validate(); err == nil {
+			t.Errorf("Expected error for output throttle %d", perSecond)
+		}
+	}
+}
+
+func testInvalidMaxQueuedMessages(t *testing.T) {
+	if err := NewClaudeAgentOptions().WithMaxQueuedMessages(1).Validate(); err != nil {
+		t.Errorf("Validate() error = %v, want nil", err)
+	}
+	for _, n := range []int{0, -1} {
+		if err := NewClaudeAgentOptions().WithMaxQueuedMessages(n).Validate(); err == nil {
+			t.Errorf("Expected error for max queued messages %d", n)
+		}
+	}
+}
+
+func testInvalidMaxTurns(t *testing.T) {
+	if err := NewClaudeAgentOptions().WithMaxTurns(1).Validate(); err != nil {
+		t.Errorf("Validate() error = %v, want nil", err)
+	}
+	for _, maxTurns := range []int{0, -1} {
+		err := NewClaudeAgentOptions().WithMaxTurns(maxTurns).Validate()
+		if err == nil || !strings.Contains(err.Error(), "max turns") {
+			t.Errorf("Expected max turns error for %d, got %v", maxTurns, err)
+		}
+	}
+}
+
+func TestGetMessageChannelBuffer(t *testing.T) {
+	if got := NewClaudeAgentOptions().GetMessageChannelBuffer(); got != DefaultMessageChannelBuffer {
+		t.Errorf("GetMessageChannelBuffer() = %d, want default %d", got, DefaultMessageChannelBuffer)
+	}
+	if got := NewClaudeAgentOptions().WithMessageChannelBuffer(1000).GetMessageChannelBuffer(); got != 1000 {
+		t.Errorf("GetMessageChannelBuffer() = %d, want 1000", got)
+	}
+}
+
 func TestGetWorkingDirectory(t *testing.T) {
 	t.Run("with CWD set", func(t *testing.T) {
 		cwd := "/tmp"
@@ -366,6 +829,44 @@ func TestAgentDefinition(t *testing.T) {
 	}
 }
 
+func TestMCPServerConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  MCPServerConfig
+		wantErr string
+	}{
+		{name: "stdio", config: MCPServerConfig{Type: MCPServerTypeStdio, Command: "node"}},
+		{name: "default type", config: MCPServerConfig{Command: "node"}},
+		{name: "command", config: MCPServerConfig{Type: MCPServerTypeCommand, Command: "node"}},
+		{name: "http", config: MCPServerConfig{Type: MCPServerTypeHTTP, URL: "https://example.com/mcp"}},
+		{name: "sse", config: MCPServerConfig{Type: MCPServerTypeSSE, URL: "https://example.com/sse"}},
+		{name: "sdk", config: MCPServerConfig{Type: MCPServerTypeSDK, Instance: struct{}{}}},
+		{name: "stdio without command", config: MCPServerConfig{Type: MCPServerTypeStdio, URL: "https://example.com"}, wantErr: "stdio server requires a command"},
+		{name: "default type without command", config: MCPServerConfig{}, wantErr: "stdio server requires a command"},
+		{name: "http without URL", config: MCPServerConfig{Type: MCPServerTypeHTTP, Command: "node"}, wantErr: "http server requires a URL"},
+		{name: "sse without URL", config: MCPServerConfig{Type: MCPServerTypeSSE}, wantErr: "sse server requires a URL"},
+		{name: "sdk without instance", config: MCPServerConfig{Type: MCPServerTypeSDK}, wantErr: "sdk server requires an instance"},
+		{name: "unknown type", config: MCPServerConfig{Type: "grpc"}, wantErr: "unknown server type: grpc"},
+		{name: "sdk with tool timeout", config: MCPServerConfig{Type: MCPServerTypeSDK, Instance: struct{}{}, ToolTimeouts: map[string]time.Duration{"search": time.Second}}},
+		{name: "non-positive tool timeout", config: MCPServerConfig{Type: MCPServerTypeSDK, Instance: struct{}{}, ToolTimeouts: map[string]time.Duration{"search": 0}}, wantErr: `timeout for tool "search" must be positive, got 0s`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestMCPServerConfig(t *testing.T) {
 	config := MCPServerConfig{
 		Type:    "stdio",
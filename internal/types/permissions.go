@@ -0,0 +1,139 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// AddRule returns a copy of the result that also remembers a rule for
+// toolName with the result's behavior, e.g. AddRule("Read", "/tmp/**").
+// On an allow result the rule always allows the tool, on a deny result it
+// always denies it. The rule applies to the session unless changed with
+// WithDestination.
+func (r PermissionResult) AddRule(toolName, ruleContent string) PermissionResult {
+	return r.withUpdate(PermissionUpdate{
+		Type:        PermissionUpdateTypeAddRules,
+		Rules:       []PermissionRule{{ToolName: toolName, RuleContent: ruleContent}},
+		Behavior:    r.Behavior,
+		Destination: PermissionDestinationSession,
+	})
+}
+
+// AddDirectory returns a copy of the result that also adds dir to the
+// allowed directories of the session. Only allow results may add
+// directories; Validate rejects a deny result that does.
+func (r PermissionResult) AddDirectory(dir string) PermissionResult {
+	return r.withUpdate(PermissionUpdate{
+		Type:        PermissionUpdateTypeAddDirectories,
+		Directories: []string{dir},
+		Destination: PermissionDestinationSession,
+	})
+}
+
+// WithUpdates returns a copy of the result that also applies updates, such
+// as the SuggestedUpdates of the ToolPermissionContext
+func (r PermissionResult) WithUpdates(updates ...PermissionUpdate) PermissionResult {
+	for _, update := range updates {
+		r = r.withUpdate(update)
+	}
+	return r
+}
+
+// WithDestination returns a copy of the result whose permission updates are
+// saved to destination, e.g. PermissionDestinationProjectSettings
+func (r PermissionResult) WithDestination(destination string) PermissionResult {
+	updates := make([]PermissionUpdate, len(r.UpdatedPermissions))
+	for i, update := range r.UpdatedPermissions {
+		update.Destination = destination
+		updates[i] = update
+	}
+	r.UpdatedPermissions = updates
+	return r
+}
+
+// withUpdate returns a copy of the result with update appended
+func (r PermissionResult) withUpdate(update PermissionUpdate) PermissionResult {
+	updates := make([]PermissionUpdate, 0, len(r.UpdatedPermissions)+1)
+	updates = append(updates, r.UpdatedPermissions...)
+	r.UpdatedPermissions = append(updates, update)
+	return r
+}
+
+// Validate checks that the behavior and permission updates are consistent.
+// A deny result may only carry addRules updates that deny, such as those
+// built with AddRule.
+func (r PermissionResult) Validate() error {
+	switch r.Behavior {
+	case PermissionBehaviorAllow:
+	case PermissionBehaviorDeny:
+		for i, update := range r.UpdatedPermissions {
+			if update.Type != PermissionUpdateTypeAddRules || update.Behavior != PermissionBehaviorDeny {
+				return fmt.Errorf("invalid permission update %d: a deny result can only add deny rules", i)
+			}
+		}
+		if r.UpdatedInput != nil {
+			return fmt.Errorf("updated input is only applied when allowing a tool")
+		}
+	default:
+		return fmt.Errorf("invalid permission behavior: %s", r.Behavior)
+	}
+
+	for i, update := range r.UpdatedPermissions {
+		if err := update.Validate(); err != nil {
+			return fmt.Errorf("invalid permission update %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks that the fields required by the update type are set
+func (u PermissionUpdate) Validate() error {
+	switch u.Destination {
+	case "", PermissionDestinationUserSettings, PermissionDestinationProjectSettings,
+		PermissionDestinationLocalSettings, PermissionDestinationSession:
+	default:
+		return fmt.Errorf("invalid destination: %s", u.Destination)
+	}
+
+	switch u.Type {
+	case PermissionUpdateTypeAddRules, PermissionUpdateTypeReplaceRules, PermissionUpdateTypeRemoveRules:
+		if len(u.Rules) == 0 {
+			return fmt.Errorf("%s update requires at least one rule", u.Type)
+		}
+		switch u.Behavior {
+		case PermissionBehaviorAllow, PermissionBehaviorDeny, PermissionBehaviorAsk:
+		default:
+			return fmt.Errorf("%s update has invalid rule behavior: %q", u.Type, u.Behavior)
+		}
+	case PermissionUpdateTypeSetMode:
+		if u.Mode == "" {
+			return fmt.Errorf("setMode update requires a mode")
+		}
+	case PermissionUpdateTypeAddDirectories, PermissionUpdateTypeRemoveDirectories:
+		if len(u.Directories) == 0 {
+			return fmt.Errorf("%s update requires at least one directory", u.Type)
+		}
+	default:
+		return fmt.Errorf("unknown permission update type: %s", u.Type)
+	}
+	return nil
+}
+
+// ParsePermissionUpdates decodes the permission suggestions of a permission
+// request. Suggestions that do not decode or validate are skipped, so a newer
+// CLI cannot break the callback with update types the SDK does not know.
+func ParsePermissionUpdates(suggestions []interface{}) []PermissionUpdate {
+	var updates []PermissionUpdate
+	for _, suggestion := range suggestions {
+		data, err := json.Marshal(suggestion)
+		if err != nil {
+			continue
+		}
+		var update PermissionUpdate
+		if err := json.Unmarshal(data, &update); err != nil || update.Validate() != nil {
+			continue
+		}
+		updates = append(updates, update)
+	}
+	return updates
+}
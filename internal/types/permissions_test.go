@@ -0,0 +1,161 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPermissionResult_Builders(t *testing.T) {
+	base := PermissionResult{Behavior: PermissionBehaviorAllow}
+	result := base.
+		AddRule("Read", "/tmp/**").
+		AddDirectory("/workspace").
+		WithDestination(PermissionDestinationProjectSettings)
+
+	if len(base.UpdatedPermissions) != 0 {
+		t.Errorf("Builders must not modify the original result, got %v", base.UpdatedPermissions)
+	}
+	if len(result.UpdatedPermissions) != 2 {
+		t.Fatalf("Expected 2 permission updates, got %d", len(result.UpdatedPermissions))
+	}
+
+	rule := result.UpdatedPermissions[0]
+	if rule.Type != PermissionUpdateTypeAddRules || rule.Behavior != PermissionBehaviorAllow {
+		t.Errorf("Unexpected rule update: %+v", rule)
+	}
+	if len(rule.Rules) != 1 || rule.Rules[0].ToolName != "Read" || rule.Rules[0].RuleContent != "/tmp/**" {
+		t.Errorf("Unexpected rules: %+v", rule.Rules)
+	}
+
+	dir := result.UpdatedPermissions[1]
+	if dir.Type != PermissionUpdateTypeAddDirectories || len(dir.Directories) != 1 || dir.Directories[0] != "/workspace" {
+		t.Errorf("Unexpected directory update: %+v", dir)
+	}
+
+	for _, update := range result.UpdatedPermissions {
+		if update.Destination != PermissionDestinationProjectSettings {
+			t.Errorf("Destination = %v, want %v", update.Destination, PermissionDestinationProjectSettings)
+		}
+	}
+
+	if err := result.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+}
+
+func TestPermissionResult_WithUpdates(t *testing.T) {
+	update := PermissionUpdate{
+		Type:        PermissionUpdateTypeAddRules,
+		Rules:       []PermissionRule{{ToolName: "Bash", RuleContent: "npm test"}},
+		Behavior:    PermissionBehaviorAllow,
+		Destination: PermissionDestinationLocalSettings,
+	}
+	base := PermissionResult{Behavior: PermissionBehaviorAllow}.AddDirectory("/workspace")
+	result := base.WithUpdates(update)
+
+	if len(base.UpdatedPermissions) != 1 {
+		t.Errorf("WithUpdates must not modify the original result, got %v", base.UpdatedPermissions)
+	}
+	if len(result.UpdatedPermissions) != 2 || !reflect.DeepEqual(result.UpdatedPermissions[1], update) {
+		t.Errorf("UpdatedPermissions = %+v, want the directory followed by %+v", result.UpdatedPermissions, update)
+	}
+}
+
+func TestParsePermissionUpdates(t *testing.T) {
+	suggestions := []interface{}{
+		map[string]any{
+			"type":        "addRules",
+			"rules":       []any{map[string]any{"toolName": "Bash", "ruleContent": "npm test"}},
+			"behavior":    "allow",
+			"destination": "localSettings",
+		},
+		map[string]any{"type": "setMode", "mode": "acceptEdits", "destination": "session"},
+		map[string]any{"type": "futureUpdate", "destination": "session"},
+		map[string]any{"type": "addRules", "rules": "not a list"},
+		"not an object",
+	}
+
+	want := []PermissionUpdate{
+		{
+			Type:        PermissionUpdateTypeAddRules,
+			Rules:       []PermissionRule{{ToolName: "Bash", RuleContent: "npm test"}},
+			Behavior:    PermissionBehaviorAllow,
+			Destination: PermissionDestinationLocalSettings,
+		},
+		{Type: PermissionUpdateTypeSetMode, Mode: PermissionModeAcceptEdits, Destination: PermissionDestinationSession},
+	}
+	if got := ParsePermissionUpdates(suggestions); !reflect.DeepEqual(got, want) {
+		t.Errorf("ParsePermissionUpdates() = %+v, want %+v", got, want)
+	}
+	if got := ParsePermissionUpdates(nil); got != nil {
+		t.Errorf("ParsePermissionUpdates(nil) = %+v, want nil", got)
+	}
+}
+
+func TestPermissionResult_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		result  PermissionResult
+		wantErr bool
+	}{
+		{name: "allow", result: PermissionResult{Behavior: PermissionBehaviorAllow}},
+		{name: "deny", result: PermissionResult{Behavior: PermissionBehaviorDeny, Message: "no"}},
+		{name: "invalid behavior", result: PermissionResult{Behavior: "maybe"}, wantErr: true},
+		{name: "deny with deny rule", result: PermissionResult{Behavior: PermissionBehaviorDeny}.AddRule("Bash", "rm:*")},
+		{
+			name:    "deny with directory",
+			result:  PermissionResult{Behavior: PermissionBehaviorDeny}.AddDirectory("/tmp"),
+			wantErr: true,
+		},
+		{
+			name: "deny with allow rule",
+			result: PermissionResult{Behavior: PermissionBehaviorDeny}.WithUpdates(PermissionUpdate{
+				Type:     PermissionUpdateTypeAddRules,
+				Rules:    []PermissionRule{{ToolName: "Bash"}},
+				Behavior: PermissionBehaviorAllow,
+			}),
+			wantErr: true,
+		},
+		{
+			name:    "deny with updated input",
+			result:  PermissionResult{Behavior: PermissionBehaviorDeny, UpdatedInput: map[string]any{}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid destination",
+			result:  PermissionResult{Behavior: PermissionBehaviorAllow}.AddDirectory("/tmp").WithDestination("cloud"),
+			wantErr: true,
+		},
+		{
+			name: "rule without behavior",
+			result: PermissionResult{Behavior: PermissionBehaviorAllow, UpdatedPermissions: []PermissionUpdate{{
+				Type:  PermissionUpdateTypeAddRules,
+				Rules: []PermissionRule{{ToolName: "Read"}},
+			}}},
+			wantErr: true,
+		},
+		{
+			name: "set mode without mode",
+			result: PermissionResult{Behavior: PermissionBehaviorAllow, UpdatedPermissions: []PermissionUpdate{{
+				Type: PermissionUpdateTypeSetMode,
+			}}},
+			wantErr: true,
+		},
+		{
+			name: "directories without directory",
+			result: PermissionResult{Behavior: PermissionBehaviorAllow, UpdatedPermissions: []PermissionUpdate{{
+				Type: PermissionUpdateTypeAddDirectories,
+			}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.result.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
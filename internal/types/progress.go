@@ -0,0 +1,116 @@
+package types
+
+import (
+	"sync"
+	"time"
+)
+
+// Progress reports how far the current query has come. While the query runs
+// the counters are estimated from the messages received so far; once its
+// ResultMessage arrives they are the values the result reports.
+type Progress struct {
+	// Turns is the number of turns taken, counted as the distinct assistant
+	// messages of the main agent
+	Turns int
+
+	// MaxTurns is the turn limit set with WithMaxTurns, or 0 if unlimited
+	MaxTurns int
+
+	// Elapsed is the wall time since the prompt was sent
+	Elapsed time.Duration
+
+	// APIDuration is the time spent waiting for the model: from each prompt
+	// or tool result until the assistant messages answering it arrived
+	APIDuration time.Duration
+
+	// Done reports whether the query ended with a ResultMessage
+	Done bool
+}
+
+// ProgressTracker keeps the running Progress of a session's queries from the
+// messages it observes. It is safe for concurrent use.
+type ProgressTracker struct {
+	mu           sync.Mutex
+	now          func() time.Time
+	progress     Progress
+	started      time.Time
+	waitingSince time.Time       // When the model was last asked for a response
+	messageIDs   map[string]bool // Assistant messages counted in this query
+}
+
+// NewProgressTracker creates a ProgressTracker for queries limited to
+// maxTurns turns, or unlimited if maxTurns is 0
+func NewProgressTracker(maxTurns int) *ProgressTracker {
+	return &ProgressTracker{
+		now:        time.Now,
+		progress:   Progress{MaxTurns: maxTurns},
+		messageIDs: make(map[string]bool),
+	}
+}
+
+// Start resets the counters as a new prompt is sent
+func (p *ProgressTracker) Start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	p.progress = Progress{MaxTurns: p.progress.MaxTurns}
+	p.started = now
+	p.waitingSince = now
+	p.messageIDs = make(map[string]bool)
+}
+
+// Observe updates the counters with a message received from the CLI.
+// Messages of subagents are ignored; their time is part of the tool call
+// that ran them.
+func (p *ProgressTracker) Observe(msg Message) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.started.IsZero() || p.progress.Done {
+		return
+	}
+	now := p.now()
+
+	switch m := msg.(type) {
+	case *AssistantMessage:
+		if m.ParentToolUseID != nil {
+			return
+		}
+		p.progress.APIDuration += now.Sub(p.waitingSince)
+		p.waitingSince = now
+
+		// Each content block arrives as an assistant message of its own,
+		// sharing the ID of the API message
+		if m.MessageID == "" || !p.messageIDs[m.MessageID] {
+			p.progress.Turns++
+			if m.MessageID != "" {
+				p.messageIDs[m.MessageID] = true
+			}
+		}
+
+	case *UserMessage:
+		// Tool results: the model is asked again from now on
+		if m.ParentToolUseID == nil {
+			p.waitingSince = now
+		}
+
+	case *ResultMessage:
+		p.progress.Turns = m.NumTurns
+		p.progress.Elapsed = time.Duration(m.DurationMS) * time.Millisecond
+		p.progress.APIDuration = time.Duration(m.DurationAPIMS) * time.Millisecond
+		p.progress.Done = true
+	}
+}
+
+// Progress returns the progress of the current query
+func (p *ProgressTracker) Progress() Progress {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	progress := p.progress
+	if !p.started.IsZero() && !progress.Done {
+		progress.Elapsed = p.now().Sub(p.started)
+	}
+	return progress
+}
@@ -0,0 +1,51 @@
+package types
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProgressTracker(t *testing.T) {
+	clock := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	advance := func(d time.Duration) { clock = clock.Add(d) }
+
+	tracker := NewProgressTracker(10)
+	tracker.now = func() time.Time { return clock }
+
+	if got := tracker.Progress(); got != (Progress{MaxTurns: 10}) {
+		t.Errorf("Progress() before Start = %+v", got)
+	}
+
+	tracker.Start()
+	advance(2 * time.Second)
+	// Two content blocks of one API message count as one turn
+	tracker.Observe(&AssistantMessage{MessageID: "msg_1", Content: []ContentBlock{&TextBlock{Text: "Let me look"}}})
+	advance(time.Second)
+	tracker.Observe(&AssistantMessage{MessageID: "msg_1", Content: []ContentBlock{&ToolUseBlock{Name: "Read"}}})
+
+	// Tool time is not API time, nor are subagent messages turns
+	advance(5 * time.Second)
+	parent := "toolu_1"
+	tracker.Observe(&AssistantMessage{MessageID: "msg_sub", ParentToolUseID: &parent})
+	tracker.Observe(&UserMessage{})
+	advance(4 * time.Second)
+	tracker.Observe(&AssistantMessage{MessageID: "msg_2"})
+
+	want := Progress{Turns: 2, MaxTurns: 10, Elapsed: 12 * time.Second, APIDuration: 7 * time.Second}
+	if got := tracker.Progress(); got != want {
+		t.Errorf("Progress() = %+v, want %+v", got, want)
+	}
+
+	// The result reports the exact values, which then stay fixed
+	tracker.Observe(&ResultMessage{NumTurns: 3, DurationMS: 12500, DurationAPIMS: 6800})
+	advance(time.Minute)
+	want = Progress{Turns: 3, MaxTurns: 10, Elapsed: 12500 * time.Millisecond, APIDuration: 6800 * time.Millisecond, Done: true}
+	if got := tracker.Progress(); got != want {
+		t.Errorf("Progress() after result = %+v, want %+v", got, want)
+	}
+
+	tracker.Start()
+	if got := tracker.Progress(); got != (Progress{MaxTurns: 10}) {
+		t.Errorf("Progress() after restart = %+v", got)
+	}
+}
@@ -0,0 +1,76 @@
+package types
+
+import "strings"
+
+// Prompt is the content of a user turn: plain text, or content blocks such as
+// images alongside text. The zero value is an empty text prompt.
+type Prompt struct {
+	text   string
+	blocks []ContentBlock
+}
+
+// TextPrompt returns a prompt of plain text
+func TextPrompt(text string) Prompt {
+	return Prompt{text: text}
+}
+
+// BlocksPrompt returns a prompt of content blocks, such as an image built
+// with NewImageBlock followed by a TextBlock asking about it
+func BlocksPrompt(blocks ...ContentBlock) Prompt {
+	return Prompt{blocks: blocks}
+}
+
+// IsText reports whether the prompt is plain text
+func (p Prompt) IsText() bool {
+	return p.blocks == nil
+}
+
+// Text returns the text of the prompt; for a prompt of blocks, the text of
+// its text blocks separated by blank lines
+func (p Prompt) Text() string {
+	if p.IsText() {
+		return p.text
+	}
+
+	var texts []string
+	for _, block := range p.blocks {
+		if text, ok := block.(*TextBlock); ok {
+			texts = append(texts, text.Text)
+		}
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+// Blocks returns the content blocks of the prompt; a text prompt is a single
+// text block
+func (p Prompt) Blocks() []ContentBlock {
+	if p.IsText() {
+		return []ContentBlock{&TextBlock{Type_: ContentTypeText, Text: p.text}}
+	}
+	return append([]ContentBlock(nil), p.blocks...)
+}
+
+// Content returns the prompt as the content of a UserMessage: a string for a
+// text prompt, otherwise its blocks
+func (p Prompt) Content() interface{} {
+	if p.IsText() {
+		return p.text
+	}
+	return p.Blocks()
+}
+
+// WithText returns the prompt with its text replaced. The text blocks of a
+// prompt of blocks are replaced by one text block ahead of the other blocks.
+func (p Prompt) WithText(text string) Prompt {
+	if p.IsText() {
+		return TextPrompt(text)
+	}
+
+	blocks := []ContentBlock{&TextBlock{Type_: ContentTypeText, Text: text}}
+	for _, block := range p.blocks {
+		if _, ok := block.(*TextBlock); !ok {
+			blocks = append(blocks, block)
+		}
+	}
+	return BlocksPrompt(blocks...)
+}
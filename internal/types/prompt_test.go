@@ -0,0 +1,71 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPrompt(t *testing.T) {
+	image := NewImageBlock("image/png", []byte("png"))
+
+	tests := []struct {
+		name        string
+		prompt      Prompt
+		wantText    string
+		wantContent interface{}
+		rewritten   []ContentBlock
+	}{
+		{
+			name:        "zero value",
+			wantText:    "",
+			wantContent: "",
+			rewritten:   []ContentBlock{&TextBlock{Type_: ContentTypeText, Text: "new"}},
+		},
+		{
+			name:        "text",
+			prompt:      TextPrompt("hello"),
+			wantText:    "hello",
+			wantContent: "hello",
+			rewritten:   []ContentBlock{&TextBlock{Type_: ContentTypeText, Text: "new"}},
+		},
+		{
+			name:        "blocks",
+			prompt:      BlocksPrompt(&TextBlock{Text: "first"}, image, &TextBlock{Text: "second"}),
+			wantText:    "first\n\nsecond",
+			wantContent: []ContentBlock{&TextBlock{Text: "first"}, image, &TextBlock{Text: "second"}},
+			rewritten:   []ContentBlock{&TextBlock{Type_: ContentTypeText, Text: "new"}, image},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.prompt.Text(); got != tt.wantText {
+				t.Errorf("Text() = %q, want %q", got, tt.wantText)
+			}
+			if got := tt.prompt.Content(); !reflect.DeepEqual(got, tt.wantContent) {
+				t.Errorf("Content() = %v, want %v", got, tt.wantContent)
+			}
+			if got := tt.prompt.WithText("new").Blocks(); !reflect.DeepEqual(got, tt.rewritten) {
+				t.Errorf("WithText().Blocks() = %v, want %v", got, tt.rewritten)
+			}
+		})
+	}
+}
+
+func TestImageBlock_RoundTrip(t *testing.T) {
+	data, err := MarshalContentBlock(NewImageBlock("image/jpeg", []byte{0xff, 0xd8}))
+	if err != nil {
+		t.Fatalf("MarshalContentBlock() error = %v", err)
+	}
+	if want := `{"type":"image","source":{"type":"base64","media_type":"image/jpeg","data":"/9g="}}`; string(data) != want {
+		t.Errorf("MarshalContentBlock() = %s, want %s", data, want)
+	}
+
+	block, err := UnmarshalContentBlock(data)
+	if err != nil {
+		t.Fatalf("UnmarshalContentBlock() error = %v", err)
+	}
+	if !reflect.DeepEqual(block, NewImageBlock("image/jpeg", []byte{0xff, 0xd8})) {
+		t.Errorf("UnmarshalContentBlock() = %+v", block)
+	}
+}
@@ -0,0 +1,182 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ValidateOutputSchema checks value, as decoded by encoding/json, against a
+// JSON schema and returns an OutputSchemaError describing the first mismatch.
+//
+// It supports the keywords used to describe structured output: type, enum,
+// const, properties, required, additionalProperties (as a boolean or schema)
+// and items. Other keywords are ignored, so the CLI remains the authority on
+// the full schema.
+func ValidateOutputSchema(value any, schema map[string]any) error {
+	// Round-trip the schema so keywords built from Go slices and structs have
+	// the same shape as decoded JSON
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return NewOutputSchemaError("invalid output schema", err)
+	}
+	var normalized map[string]any
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return NewOutputSchemaError("invalid output schema", err)
+	}
+	return validateSchema(value, normalized, "$")
+}
+
+// validateSchema checks value against schema at path
+func validateSchema(value any, schema map[string]any, path string) error {
+	mismatch := func(format string, args ...any) error {
+		err := NewOutputSchemaError(fmt.Sprintf("output does not match schema at %s: ", path)+fmt.Sprintf(format, args...), nil)
+		err.Path = path
+		return err
+	}
+
+	if types, ok := schemaTypes(schema["type"]); ok {
+		matched := false
+		for _, typ := range types {
+			if matchesType(value, typ) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return mismatch("expected %v, got %s", schema["type"], jsonType(value))
+		}
+	}
+
+	if constant, ok := schema["const"]; ok && !jsonEqual(value, constant) {
+		return mismatch("expected constant %v", constant)
+	}
+
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, allowed := range enum {
+			if jsonEqual(value, allowed) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return mismatch("%v is not one of %v", value, enum)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		if required, ok := schema["required"].([]any); ok {
+			for _, name := range required {
+				if key, ok := name.(string); ok {
+					if _, present := v[key]; !present {
+						return mismatch("missing required property %q", key)
+					}
+				}
+			}
+		}
+
+		properties, _ := schema["properties"].(map[string]any)
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if propertySchema, ok := properties[key].(map[string]any); ok {
+				if err := validateSchema(v[key], propertySchema, path+"."+key); err != nil {
+					return err
+				}
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					return mismatch("unexpected property %q", key)
+				}
+			case map[string]any:
+				if err := validateSchema(v[key], additional, path+"."+key); err != nil {
+					return err
+				}
+			}
+		}
+
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				if err := validateSchema(item, items, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// schemaTypes returns the types allowed by a "type" keyword
+func schemaTypes(keyword any) ([]string, bool) {
+	switch typ := keyword.(type) {
+	case string:
+		return []string{typ}, true
+	case []any:
+		types := make([]string, 0, len(typ))
+		for _, t := range typ {
+			if name, ok := t.(string); ok {
+				types = append(types, name)
+			}
+		}
+		return types, true
+	default:
+		return nil, false
+	}
+}
+
+// matchesType reports whether value is of the named JSON schema type
+func matchesType(value any, typ string) bool {
+	switch typ {
+	case "integer":
+		number, ok := value.(float64)
+		return ok && number == math.Trunc(number)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	default:
+		return jsonType(value) == typ
+	}
+}
+
+// jsonType returns the JSON schema type name of a decoded value
+func jsonType(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+// jsonEqual reports whether two decoded JSON values are equal
+func jsonEqual(a, b any) bool {
+	left, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	right, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return string(left) == string(right)
+}
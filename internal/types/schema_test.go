@@ -0,0 +1,100 @@
+package types
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestValidateOutputSchema(t *testing.T) {
+	schema := map[string]any{
+		"type":     "object",
+		"required": []string{"name", "tags"},
+		"properties": map[string]any{
+			"name":   map[string]any{"type": "string"},
+			"age":    map[string]any{"type": "integer"},
+			"status": map[string]any{"enum": []string{"active", "inactive"}},
+			"tags": map[string]any{
+				"type":  "array",
+				"items": map[string]any{"type": "string"},
+			},
+			"note": map[string]any{"type": []string{"string", "null"}},
+		},
+		"additionalProperties": false,
+	}
+
+	tests := []struct {
+		name     string
+		value    string
+		wantPath string
+		wantErr  bool
+	}{
+		{name: "valid", value: `{"name":"Ada","age":36,"status":"active","tags":["math"],"note":null}`},
+		{name: "optional properties omitted", value: `{"name":"Ada","tags":[]}`},
+		{name: "wrong root type", value: `["Ada"]`, wantPath: "$", wantErr: true},
+		{name: "missing required", value: `{"name":"Ada"}`, wantPath: "$", wantErr: true},
+		{name: "wrong property type", value: `{"name":1,"tags":[]}`, wantPath: "$.name", wantErr: true},
+		{name: "non-integer", value: `{"name":"Ada","age":36.5,"tags":[]}`, wantPath: "$.age", wantErr: true},
+		{name: "not in enum", value: `{"name":"Ada","status":"gone","tags":[]}`, wantPath: "$.status", wantErr: true},
+		{name: "bad array item", value: `{"name":"Ada","tags":["a",2]}`, wantPath: "$.tags[1]", wantErr: true},
+		{name: "additional property", value: `{"name":"Ada","tags":[],"extra":true}`, wantPath: "$", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value any
+			if err := json.Unmarshal([]byte(tt.value), &value); err != nil {
+				t.Fatalf("Invalid test value: %v", err)
+			}
+
+			err := ValidateOutputSchema(value, schema)
+			if !tt.wantErr {
+				if err != nil {
+					t.Errorf("ValidateOutputSchema() error = %v, want nil", err)
+				}
+				return
+			}
+
+			var schemaErr *OutputSchemaError
+			if !errors.As(err, &schemaErr) {
+				t.Fatalf("ValidateOutputSchema() = %v, want OutputSchemaError", err)
+			}
+			if schemaErr.Path != tt.wantPath {
+				t.Errorf("Path = %q, want %q", schemaErr.Path, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestResultMessage_Output(t *testing.T) {
+	text := `{"answer":42}`
+	invalid := "forty-two"
+
+	tests := []struct {
+		name    string
+		result  *ResultMessage
+		want    string
+		wantErr bool
+	}{
+		{name: "structured output", result: &ResultMessage{StructuredOutput: map[string]any{"answer": 1.0}}, want: `{"answer":1}`},
+		{name: "JSON result text", result: &ResultMessage{Result: &text}, want: text},
+		{name: "non-JSON result text", result: &ResultMessage{Result: &invalid}, wantErr: true},
+		{name: "no output", result: &ResultMessage{}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := tt.result.Output()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Output() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			data, _ := json.Marshal(output)
+			if string(data) != tt.want {
+				t.Errorf("Output() = %s, want %s", data, tt.want)
+			}
+		})
+	}
+}
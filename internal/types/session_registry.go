@@ -0,0 +1,50 @@
+package types
+
+import "sync"
+
+// SessionRegistry tracks the sessions resumed by the transports of this
+// process. Two CLI processes resuming the same session at once corrupt its
+// state, so a transport sharing a registry refuses to resume a session
+// another one is still using. Use one registry for all transports that could
+// collide, such as every session of an agent server.
+type SessionRegistry struct {
+	mu     sync.Mutex
+	active map[string]struct{}
+}
+
+// NewSessionRegistry creates an empty SessionRegistry
+func NewSessionRegistry() *SessionRegistry {
+	return &SessionRegistry{active: make(map[string]struct{})}
+}
+
+// Claim marks sessionID as in use until release is called. It fails with an
+// error matching ErrSessionInUse if the session is already claimed.
+func (r *SessionRegistry) Claim(sessionID string) (release func(), err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.active[sessionID]; ok {
+		err := NewCLIConnectionError("session "+sessionID+" is already in use by another transport", nil)
+		err.Kind = ErrSessionInUse
+		return nil, err
+	}
+	r.active[sessionID] = struct{}{}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			delete(r.active, sessionID)
+		})
+	}, nil
+}
+
+// Active reports whether sessionID is claimed
+func (r *SessionRegistry) Active(sessionID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.active[sessionID]
+	return ok
+}
@@ -0,0 +1,34 @@
+package types
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSessionRegistry(t *testing.T) {
+	registry := NewSessionRegistry()
+
+	release, err := registry.Claim("session-1")
+	if err != nil {
+		t.Fatalf("Claim() error = %v", err)
+	}
+	if !registry.Active("session-1") {
+		t.Error("Expected session-1 to be active after Claim")
+	}
+
+	if _, err := registry.Claim("session-1"); !errors.Is(err, ErrSessionInUse) {
+		t.Errorf("Second Claim() error = %v, want ErrSessionInUse", err)
+	}
+	if _, err := registry.Claim("session-2"); err != nil {
+		t.Errorf("Claim() of another session error = %v", err)
+	}
+
+	release()
+	release() // Releasing twice is harmless
+	if registry.Active("session-1") {
+		t.Error("Expected session-1 to be inactive after release")
+	}
+	if _, err := registry.Claim("session-1"); err != nil {
+		t.Errorf("Claim() after release error = %v", err)
+	}
+}
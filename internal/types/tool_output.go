@@ -0,0 +1,126 @@
+package types
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultToolOutputFlushInterval is how often buffered tool output is passed
+// to ToolOutputCallback when no interval is configured
+const DefaultToolOutputFlushInterval = 250 * time.Millisecond
+
+// ToolOutput collects the output of an in-process tool that produces it
+// incrementally, such as one tailing a log. Obtain it in an MCPServerHandler
+// with ToolOutputFromContext, write to it as output arrives, and return
+// Response as the result of the tools/call.
+//
+// The control protocol answers each tools/call with a single response, so
+// the CLI and the model see the output only once the tool returns. While the
+// tool runs, buffered output is flushed periodically to ToolOutputCallback so
+// it can be shown to the user. Flushes for a call are delivered in order, one
+// at a time, and the last one completes before the result is sent to the CLI;
+// the model continues only after that.
+type ToolOutput struct {
+	mu       sync.Mutex
+	text     strings.Builder
+	flushed  int // Length of text already passed to flush
+	interval time.Duration
+	timer    *time.Timer
+	closed   bool
+
+	// flushMu serializes flushes, keeping chunks in order
+	flushMu sync.Mutex
+	flush   func(chunk string)
+}
+
+// toolOutputKey is the context key of the ToolOutput for a tools/call
+type toolOutputKey struct{}
+
+// NewToolOutput creates a ToolOutput passing buffered output to flush every
+// interval; flush may be nil to only collect output
+func NewToolOutput(interval time.Duration, flush func(chunk string)) *ToolOutput {
+	return &ToolOutput{interval: interval, flush: flush}
+}
+
+// ContextWithToolOutput returns a context carrying output for a tool call
+func ContextWithToolOutput(ctx context.Context, output *ToolOutput) context.Context {
+	return context.WithValue(ctx, toolOutputKey{}, output)
+}
+
+// ToolOutputFromContext returns the ToolOutput of the tools/call being
+// handled. Outside such a call it returns a ToolOutput that only collects
+// output, so handlers can use it unconditionally.
+func ToolOutputFromContext(ctx context.Context) *ToolOutput {
+	if output, ok := ctx.Value(toolOutputKey{}).(*ToolOutput); ok {
+		return output
+	}
+	return NewToolOutput(0, nil)
+}
+
+// Write appends output, scheduling a flush if none is pending. It never fails.
+func (o *ToolOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.text.Write(p)
+	if o.flush != nil && !o.closed && o.timer == nil {
+		o.timer = time.AfterFunc(o.interval, func() { o.flushPending(false) })
+	}
+	return len(p), nil
+}
+
+// WriteString appends output, like Write
+func (o *ToolOutput) WriteString(s string) (int, error) {
+	return o.Write([]byte(s))
+}
+
+// String returns all output written so far
+func (o *ToolOutput) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.text.String()
+}
+
+// Response builds the JSONRPC tools/call response for message carrying all
+// output written so far as a text block
+func (o *ToolOutput) Response(message map[string]any) map[string]any {
+	return map[string]any{
+		"jsonrpc": "2.0",
+		"id":      message["id"],
+		"result": map[string]any{
+			"content": []any{map[string]any{"type": ContentTypeText, "text": o.String()}},
+		},
+	}
+}
+
+// Close flushes the remaining output and stops further flushes; output
+// written afterwards is still collected
+func (o *ToolOutput) Close() {
+	o.flushPending(true)
+}
+
+// flushPending passes output not yet flushed to the flush callback
+func (o *ToolOutput) flushPending(final bool) {
+	o.flushMu.Lock()
+	defer o.flushMu.Unlock()
+
+	o.mu.Lock()
+	if o.timer != nil {
+		o.timer.Stop()
+		o.timer = nil
+	}
+	if o.closed {
+		o.mu.Unlock()
+		return
+	}
+	o.closed = final
+	chunk := o.text.String()[o.flushed:]
+	o.flushed = o.text.Len()
+	o.mu.Unlock()
+
+	if chunk != "" && o.flush != nil {
+		o.flush(chunk)
+	}
+}
@@ -0,0 +1,69 @@
+package types
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestToolOutput(t *testing.T) {
+	var mu sync.Mutex
+	var chunks []string
+	output := NewToolOutput(10*time.Millisecond, func(chunk string) {
+		mu.Lock()
+		defer mu.Unlock()
+		chunks = append(chunks, chunk)
+	})
+	flushed := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), chunks...)
+	}
+
+	_, _ = output.WriteString("line 1\n")
+	_, _ = output.WriteString("line 2\n")
+
+	// Buffered output is flushed once the interval passes
+	deadline := time.Now().Add(5 * time.Second)
+	for len(flushed()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := flushed(); len(got) != 1 || got[0] != "line 1\nline 2\n" {
+		t.Fatalf("Periodic flush = %q, want one chunk with both lines", got)
+	}
+
+	// Close flushes the rest immediately, and later output is only collected
+	_, _ = output.WriteString("line 3\n")
+	output.Close()
+	_, _ = output.WriteString("line 4\n")
+	if got := strings.Join(flushed(), ""); got != "line 1\nline 2\nline 3\n" {
+		t.Errorf("Flushed output = %q, want the first three lines", got)
+	}
+
+	response := output.Response(map[string]any{"id": 7})
+	if response["id"] != 7 {
+		t.Errorf("Response id = %v, want 7", response["id"])
+	}
+	result, _ := response["result"].(map[string]any)
+	content, _ := result["content"].([]any)
+	if len(content) != 1 || content[0].(map[string]any)["text"] != "line 1\nline 2\nline 3\nline 4\n" {
+		t.Errorf("Response content = %v, want all output", content)
+	}
+}
+
+func TestToolOutputFromContext(t *testing.T) {
+	output := NewToolOutput(time.Second, nil)
+	if got := ToolOutputFromContext(ContextWithToolOutput(context.Background(), output)); got != output {
+		t.Error("ToolOutputFromContext() did not return the output in the context")
+	}
+
+	// Outside a tool call output is collected without flushing
+	fallback := ToolOutputFromContext(context.Background())
+	_, _ = fallback.WriteString("collected")
+	fallback.Close()
+	if got := fallback.String(); got != "collected" {
+		t.Errorf("String() = %q, want %q", got, "collected")
+	}
+}
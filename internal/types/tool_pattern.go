@@ -0,0 +1,51 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ToolPattern is a tool name optionally scoped by a pattern, such as
+// Bash(git:*) or Read(/src/**).
+//
+// Each tool is passed to the CLI as its own flag value, so patterns may
+// contain commas (e.g. Bash(cmd1,cmd2)) without escaping.
+type ToolPattern struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern,omitempty"`
+}
+
+// String returns the CLI form of the pattern: Name(pattern), or Name if the pattern is empty
+func (p ToolPattern) String() string {
+	if p.Pattern == "" {
+		return p.Name
+	}
+	return p.Name + "(" + p.Pattern + ")"
+}
+
+// Validate checks that the pattern can be passed to the CLI unambiguously
+func (p ToolPattern) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("tool pattern name must not be empty")
+	}
+	if strings.ContainsAny(p.Name, "(), \t\n") {
+		return fmt.Errorf("invalid tool name %q: must not contain parentheses, commas or whitespace", p.Name)
+	}
+	depth := 0
+	for _, r := range p.Pattern {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return fmt.Errorf("invalid pattern for tool %s: unbalanced parentheses in %q", p.Name, p.Pattern)
+			}
+		}
+	}
+	if depth != 0 {
+		return fmt.Errorf("invalid pattern for tool %s: unbalanced parentheses in %q", p.Name, p.Pattern)
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package types
+
+import "testing"
+
+func TestToolPattern_String(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern ToolPattern
+		want    string
+	}{
+		{name: "bare name", pattern: ToolPattern{Name: "Read"}, want: "Read"},
+		{name: "command prefix", pattern: ToolPattern{Name: "Bash", Pattern: "git:*"}, want: "Bash(git:*)"},
+		{name: "path glob", pattern: ToolPattern{Name: "Read", Pattern: "/src/**"}, want: "Read(/src/**)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.pattern.String(); got != tt.want {
+				t.Errorf("String() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToolPattern_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern ToolPattern
+		wantErr bool
+	}{
+		{name: "bare name", pattern: ToolPattern{Name: "Read"}},
+		{name: "with pattern", pattern: ToolPattern{Name: "Bash", Pattern: "npm run test:*"}},
+		{name: "nested parentheses", pattern: ToolPattern{Name: "Bash", Pattern: "echo (a):*"}},
+		{name: "empty name", pattern: ToolPattern{Pattern: "git:*"}, wantErr: true},
+		{name: "name with parenthesis", pattern: ToolPattern{Name: "Bash(git:*)"}, wantErr: true},
+		{name: "name with comma", pattern: ToolPattern{Name: "Read,Write"}, wantErr: true},
+		{name: "pattern with comma", pattern: ToolPattern{Name: "Bash", Pattern: "cmd1,cmd2"}},
+		{name: "unbalanced close", pattern: ToolPattern{Name: "Bash", Pattern: "echo )"}, wantErr: true},
+		{name: "unbalanced open", pattern: ToolPattern{Name: "Bash", Pattern: "echo ("}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.pattern.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package types
+
+import (
+	"context"
+)
+
+// Span name constants created by the SDK
+const (
+	SpanQuery          = "claude.query"
+	SpanToolUse        = "claude.tool_use"
+	SpanControlRequest = "claude.control_request"
+)
+
+// Tracer creates spans around queries, tool calls and control requests.
+//
+// The SDK does not depend on any tracing library; implement this interface
+// with a small adapter (e.g. around an OpenTelemetry trace.Tracer) to export
+// spans. The returned context carries the new span and is used as the parent
+// for child spans and is passed to user callbacks.
+type Tracer interface {
+	StartSpan(ctx context.Context, name string, attributes map[string]string) (context.Context, Span)
+}
+
+// Span is a single traced operation
+type Span interface {
+	// SetAttribute adds or updates an attribute on the span
+	SetAttribute(key, value string)
+
+	// RecordError marks the span as failed with the given error
+	RecordError(err error)
+
+	// End completes the span
+	End()
+}
+
+// StartSpan starts a span with the given tracer, returning a no-op span if tracer is nil
+func StartSpan(ctx context.Context, tracer Tracer, name string, attributes map[string]string) (context.Context, Span) {
+	if tracer == nil {
+		return ctx, noopSpan{}
+	}
+	return tracer.StartSpan(ctx, name, attributes)
+}
+
+// noopSpan is used when no tracer is configured
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key, value string) {}
+func (noopSpan) RecordError(err error)          {}
+func (noopSpan) End()                           {}
@@ -0,0 +1,80 @@
+package types
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// WorkspaceTrust declares which directories Claude may work in freely and
+// which require confirmation. The CLI has no trust settings of its own, so
+// trusted directories are granted with --add-dir, and permission prompts for
+// paths inside untrusted directories are flagged in ToolPermissionContext.
+type WorkspaceTrust struct {
+	// Trusted directories are added to the directories Claude can access
+	Trusted []string `json:"trusted,omitempty"`
+
+	// Untrusted directories should not be accessed without confirmation.
+	// They may be nested inside trusted directories.
+	Untrusted []string `json:"untrusted,omitempty"`
+}
+
+// UntrustedDir returns the untrusted directory containing path, if any.
+// Relative paths are resolved against cwd.
+func (w *WorkspaceTrust) UntrustedDir(path, cwd string) (string, bool) {
+	if w == nil {
+		return "", false
+	}
+	for _, dir := range w.Untrusted {
+		if withinDir(resolvePath(path, cwd), resolvePath(dir, cwd)) {
+			return dir, true
+		}
+	}
+	return "", false
+}
+
+// validate checks that no trusted or added directory is also untrusted
+func (w *WorkspaceTrust) validate(addDirs []string, cwd string) error {
+	for _, untrusted := range w.Untrusted {
+		if strings.TrimSpace(untrusted) == "" {
+			return fmt.Errorf("untrusted directory cannot be empty")
+		}
+		for _, trusted := range w.Trusted {
+			if withinDir(resolvePath(trusted, cwd), resolvePath(untrusted, cwd)) {
+				return fmt.Errorf("trusted directory %q is inside untrusted directory %q", trusted, untrusted)
+			}
+		}
+		for _, dir := range addDirs {
+			if withinDir(resolvePath(dir, cwd), resolvePath(untrusted, cwd)) {
+				return fmt.Errorf("added directory %q is inside untrusted directory %q", dir, untrusted)
+			}
+		}
+	}
+	for _, trusted := range w.Trusted {
+		if strings.TrimSpace(trusted) == "" {
+			return fmt.Errorf("trusted directory cannot be empty")
+		}
+	}
+	return nil
+}
+
+// resolvePath returns path as a clean absolute path, resolving relative
+// paths against cwd
+func resolvePath(path, cwd string) string {
+	if !filepath.IsAbs(path) && cwd != "" {
+		path = filepath.Join(cwd, path)
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// withinDir reports whether path is dir or is inside it
+func withinDir(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
@@ -0,0 +1,30 @@
+package types
+
+import "testing"
+
+func TestWorkspaceTrust_UntrustedDir(t *testing.T) {
+	trust := &WorkspaceTrust{Untrusted: []string{"/repo/vendor", "third_party"}}
+
+	tests := []struct {
+		name   string
+		path   string
+		want   string
+		wantOK bool
+	}{
+		{name: "inside untrusted", path: "/repo/vendor/lib/a.go", want: "/repo/vendor", wantOK: true},
+		{name: "untrusted dir itself", path: "/repo/vendor", want: "/repo/vendor", wantOK: true},
+		{name: "sibling with shared prefix", path: "/repo/vendored/a.go"},
+		{name: "relative to cwd", path: "third_party/x.c", want: "third_party", wantOK: true},
+		{name: "escapes untrusted dir", path: "/repo/vendor/../main.go"},
+		{name: "trusted", path: "/repo/main.go"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := trust.UntrustedDir(tt.path, "/repo")
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("UntrustedDir(%q) = %q, %v, want %q, %v", tt.path, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
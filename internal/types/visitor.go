@@ -0,0 +1,42 @@
+package types
+
+import "fmt"
+
+// BlockVisitor handles each content block type. Adding a block type adds a
+// method here, so every visitor fails to compile until it handles the new type.
+// Returning an error from a method stops a Walk.
+type BlockVisitor interface {
+	VisitText(block *TextBlock) error
+	VisitThinking(block *ThinkingBlock) error
+	VisitToolUse(block *ToolUseBlock) error
+	VisitToolResult(block *ToolResultBlock) error
+	VisitImage(block *ImageBlock) error
+}
+
+// Accept dispatches block to the visitor method for its type
+func Accept(block ContentBlock, visitor BlockVisitor) error {
+	switch b := block.(type) {
+	case *TextBlock:
+		return visitor.VisitText(b)
+	case *ThinkingBlock:
+		return visitor.VisitThinking(b)
+	case *ToolUseBlock:
+		return visitor.VisitToolUse(b)
+	case *ToolResultBlock:
+		return visitor.VisitToolResult(b)
+	case *ImageBlock:
+		return visitor.VisitImage(b)
+	default:
+		return NewMessageParseError(fmt.Sprintf("unknown content block type %T", block), nil)
+	}
+}
+
+// Walk visits blocks in order, stopping at the first error
+func Walk(blocks []ContentBlock, visitor BlockVisitor) error {
+	for _, block := range blocks {
+		if err := Accept(block, visitor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package types
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// recordingVisitor records the blocks it visits and fails on stopAt
+type recordingVisitor struct {
+	visited []string
+	stopAt  string
+}
+
+func (v *recordingVisitor) visit(kind string) error {
+	v.visited = append(v.visited, kind)
+	if kind == v.stopAt {
+		return errors.New("stop")
+	}
+	return nil
+}
+
+func (v *recordingVisitor) VisitText(block *TextBlock) error {
+	return v.visit("text:" + block.Text)
+}
+
+func (v *recordingVisitor) VisitThinking(block *ThinkingBlock) error {
+	return v.visit("thinking:" + block.Thinking)
+}
+
+func (v *recordingVisitor) VisitToolUse(block *ToolUseBlock) error {
+	return v.visit("tool_use:" + block.Name)
+}
+
+func (v *recordingVisitor) VisitToolResult(block *ToolResultBlock) error {
+	return v.visit("tool_result:" + block.ToolUseID)
+}
+
+func (v *recordingVisitor) VisitImage(block *ImageBlock) error {
+	return v.visit("image:" + block.Source.MediaType)
+}
+
+// unsupportedBlock is a block type no visitor handles
+type unsupportedBlock struct{}
+
+func (b *unsupportedBlock) Type() string { return "unsupported" }
+
+func TestWalk(t *testing.T) {
+	blocks := []ContentBlock{
+		&ThinkingBlock{Thinking: "hmm"},
+		&TextBlock{Text: "hello"},
+		&ToolUseBlock{Name: "Read"},
+		&ToolResultBlock{ToolUseID: "tool_1"},
+		NewImageBlock("image/png", []byte("png")),
+	}
+
+	tests := []struct {
+		name    string
+		blocks  []ContentBlock
+		stopAt  string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:   "all blocks",
+			blocks: blocks,
+			want:   []string{"thinking:hmm", "text:hello", "tool_use:Read", "tool_result:tool_1", "image:image/png"},
+		},
+		{
+			name:    "visitor error stops the walk",
+			blocks:  blocks,
+			stopAt:  "text:hello",
+			want:    []string{"thinking:hmm", "text:hello"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown block type",
+			blocks:  []ContentBlock{&TextBlock{Text: "a"}, &unsupportedBlock{}, &TextBlock{Text: "b"}},
+			want:    []string{"text:a"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			visitor := &recordingVisitor{stopAt: tt.stopAt}
+			err := Walk(tt.blocks, visitor)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Walk() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(visitor.visited, tt.want) {
+				t.Errorf("visited = %v, want %v", visitor.visited, tt.want)
+			}
+		})
+	}
+}
+
+func TestWalk_DecodedImage(t *testing.T) {
+	msg, err := UnmarshalMessage([]byte(`{"type":"user","content":[{"type":"text","text":"look"},{"type":"image","source":{"type":"base64","media_type":"image/jpeg","data":"/9j/"}}]}`))
+	if err != nil {
+		t.Fatalf("UnmarshalMessage() error = %v", err)
+	}
+	blocks, ok := msg.(*UserMessage).Content.([]ContentBlock)
+	if !ok {
+		t.Fatalf("Expected content blocks, got %T", msg.(*UserMessage).Content)
+	}
+
+	visitor := &recordingVisitor{}
+	if err := Walk(blocks, visitor); err != nil {
+		t.Fatalf("Walk() error = %v", err)
+	}
+	if want := []string{"text:look", "image:image/jpeg"}; !reflect.DeepEqual(visitor.visited, want) {
+		t.Errorf("visited = %v, want %v", visitor.visited, want)
+	}
+}
@@ -0,0 +1,310 @@
+package claude
+
+import (
+	"context"
+	"time"
+
+	"github.com/anthropics/claude-agent-sdk-go/internal/query"
+	"github.com/anthropics/claude-agent-sdk-go/internal/transport"
+	"github.com/anthropics/claude-agent-sdk-go/internal/types"
+)
+
+// SendAndWait sends a single prompt to Claude and waits for the session to finish.
+//
+// It drives the whole lifecycle: it starts the CLI, writes the prompt, collects
+// every message until the terminal ResultMessage arrives and closes the session.
+// All received messages (including the result) are returned in order. If the
+// result is flagged as an error, a ResultError carrying the result text is
+// returned alongside the result and messages, even when the CLI fails before
+// sending any assistant message; likewise an OutputSchemaError
+// when an output schema is set and the result does not match it. With
+// WithFailFast, the first transport or parse error ends the session and is
+// returned instead.
+func SendAndWait(ctx context.Context, prompt string, options *types.ClaudeAgentOptions) (*types.ResultMessage, []types.Message, error) {
+	return SendPromptAndWait(ctx, types.TextPrompt(prompt), options)
+}
+
+// SendPromptAndWait is SendAndWait for a prompt that need not be plain text,
+// such as an image and a question about it:
+//
+//	prompt := types.BlocksPrompt(
+//		types.NewImageBlock("image/png", screenshot),
+//		&types.TextBlock{Text: "What is wrong with this page?"},
+//	)
+func SendPromptAndWait(ctx context.Context, prompt types.Prompt, options *types.ClaudeAgentOptions) (*types.ResultMessage, []types.Message, error) {
+	if options == nil {
+		options = types.NewClaudeAgentOptions()
+	}
+
+	// Hooks must be registered with the CLI before the prompt is sent
+	return runSession(ctx, prompt, options, len(options.Hooks) > 0)
+}
+
+// ResumeAndSend resumes an existing session and sends a follow-up prompt.
+//
+// Unlike setting WithResume and writing a prompt manually, it waits for the
+// initialize control response before writing the prompt, so the prompt is
+// never delivered before the resumed session is ready. The caller's options
+// are not modified. Results are returned as for SendAndWait.
+func ResumeAndSend(ctx context.Context, sessionID, prompt string, options *types.ClaudeAgentOptions) (*types.ResultMessage, []types.Message, error) {
+	resumed := types.NewClaudeAgentOptions()
+	if options != nil {
+		copied := *options
+		resumed = &copied
+	}
+	resumed.Resume = &sessionID
+	resumed.ContinueConversation = false
+
+	return runSession(ctx, types.TextPrompt(prompt), resumed, true)
+}
+
+// DryRunCommand returns the Claude Code CLI command line, starting with the CLI
+// path, that a session with options would run. Nothing is started, so it can
+// be used to check which flags an option produces or to log the command.
+func DryRunCommand(options *types.ClaudeAgentOptions) ([]string, error) {
+	if options == nil {
+		options = types.NewClaudeAgentOptions()
+	}
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
+	t := transport.NewSubprocessCLITransport("", options)
+	defer func() {
+		_ = t.Close(context.Background())
+	}()
+	return t.DryRunCommand(), nil
+}
+
+// runSession drives a session from connect to the final result, retrying with
+// the fallback models while the model is unavailable. When initialize is set,
+// the initialize handshake completes before the prompt is written.
+func runSession(ctx context.Context, prompt types.Prompt, options *types.ClaudeAgentOptions, initialize bool) (result *types.ResultMessage, messages []types.Message, err error) {
+	ctx, span := types.StartSpan(ctx, options.Tracer, types.SpanQuery, queryAttributes(options))
+	defer func() {
+		if result != nil {
+			span.SetAttribute("session_id", result.SessionID)
+		}
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	// Catch configuration mistakes before spawning the CLI
+	if err := options.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	sessionID := ""
+	if options.Resume != nil {
+		sessionID = *options.Resume
+	}
+	prompt, err = submitPrompt(ctx, options, sessionID, prompt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Attachments are read once, so retries send the same content
+	input, err := marshalSessionPrompt(prompt, options.Attachments)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	attempt := options
+	for _, fallback := range options.ModelFallback {
+		result, messages, err = runAttempt(ctx, prompt.Text(), input, attempt, initialize)
+		if ctx.Err() != nil || !modelUnavailable(result, messages) {
+			return result, messages, err
+		}
+
+		from := ""
+		if attempt.Model != nil {
+			from = *attempt.Model
+		}
+		if options.OnModelFallback != nil {
+			options.OnModelFallback(from, fallback, err)
+		}
+		if options.Metrics != nil {
+			options.Metrics.IncCounter(types.MetricModelFallbacks, map[string]string{"from": from, "to": fallback})
+		}
+
+		next := *attempt
+		next.Model = &fallback
+		attempt = &next
+	}
+	return runAttempt(ctx, prompt.Text(), input, attempt, initialize)
+}
+
+// modelUnavailable reports whether a session failed because its model was
+// unavailable before the model did any work, so it can be retried with another
+func modelUnavailable(result *types.ResultMessage, messages []types.Message) bool {
+	if result == nil || !result.ModelUnavailable() {
+		return false
+	}
+	for _, msg := range messages {
+		if _, ok := msg.(*types.AssistantMessage); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// runAttempt runs a session with one model, writing input as the prompt
+func runAttempt(ctx context.Context, prompt, input string, options *types.ClaudeAgentOptions, initialize bool) (*types.ResultMessage, []types.Message, error) {
+	t := transport.NewSubprocessCLITransport(prompt, options)
+	if err := t.Connect(ctx); err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		_ = t.Close(ctx)
+	}()
+
+	q := query.New(t, options)
+	q.Start(ctx)
+	defer q.Close()
+
+	if initialize {
+		if _, err := q.Initialize(ctx); err != nil {
+			return earlyResult(ctx, q.Messages(), options, err)
+		}
+	}
+
+	if err := t.Write(ctx, input); err != nil {
+		return earlyResult(ctx, q.Messages(), options, err)
+	}
+
+	// In fail-fast mode the first reported error ends the session
+	var errs <-chan error
+	if options.FailFast {
+		errs = t.Errors()
+	}
+
+	messages := make([]types.Message, 0)
+	messageChan := q.Messages()
+	for {
+		select {
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			return nil, messages, err
+
+		case msg, ok := <-messageChan:
+			if !ok {
+				return nil, messages, types.NewProcessError("session ended without a result message", nil)
+			}
+			messages = append(messages, msg)
+
+			if result, ok := msg.(*types.ResultMessage); ok {
+				return finishSession(result, messages, options)
+			}
+
+		case <-ctx.Done():
+			return nil, messages, ctx.Err()
+		}
+	}
+}
+
+// finishSession returns the outcome of a session that ended with result
+func finishSession(result *types.ResultMessage, messages []types.Message, options *types.ClaudeAgentOptions) (*types.ResultMessage, []types.Message, error) {
+	if result.IsError {
+		return result, messages, result.ExecutionError()
+	}
+	if options.OutputSchema != nil {
+		output, err := result.Output()
+		if err == nil {
+			err = types.ValidateOutputSchema(output, options.OutputSchema)
+		}
+		return result, messages, err
+	}
+	return result, messages, nil
+}
+
+// earlyResultGrace bounds how long a failed session start waits for a result
+const earlyResultGrace = time.Second
+
+// earlyResult handles err from starting a session. The CLI may report an
+// error result and exit before answering initialize or reading the prompt,
+// with no assistant message; that result explains the failure, so it is
+// returned instead of err when it arrives.
+func earlyResult(ctx context.Context, messageChan <-chan types.Message, options *types.ClaudeAgentOptions, err error) (*types.ResultMessage, []types.Message, error) {
+	timer := time.NewTimer(earlyResultGrace)
+	defer timer.Stop()
+
+	var messages []types.Message
+	for {
+		select {
+		case msg, ok := <-messageChan:
+			if !ok {
+				return nil, messages, err
+			}
+			messages = append(messages, msg)
+			if result, ok := msg.(*types.ResultMessage); ok {
+				return finishSession(result, messages, options)
+			}
+		case <-timer.C:
+			return nil, messages, err
+		case <-ctx.Done():
+			return nil, messages, err
+		}
+	}
+}
+
+// defaultSessionID is the session ID sent with user input; the CLI assigns the real one
+const defaultSessionID = "default"
+
+// queryAttributes returns the span attributes describing a query
+func queryAttributes(options *types.ClaudeAgentOptions) map[string]string {
+	attributes := make(map[string]string)
+	if options.Model != nil {
+		attributes["model"] = *options.Model
+	}
+	return attributes
+}
+
+// submitPrompt runs the UserPromptSubmit hooks on the text of prompt, keeping
+// a prompt of blocks as it is unless the hooks change its text
+func submitPrompt(ctx context.Context, options *types.ClaudeAgentOptions, sessionID string, prompt types.Prompt) (types.Prompt, error) {
+	text, err := query.SubmitPrompt(ctx, options, sessionID, prompt.Text())
+	if err != nil {
+		return prompt, err
+	}
+	if text == prompt.Text() {
+		return prompt, nil
+	}
+	return prompt.WithText(text), nil
+}
+
+// marshalSessionPrompt encodes a prompt and its attachments as a stream-json
+// user input line. A text prompt without attachments is sent as plain text.
+func marshalSessionPrompt(prompt types.Prompt, attachments []types.Attachment) (string, error) {
+	if len(attachments) == 0 {
+		return marshalUserPrompt(prompt)
+	}
+
+	blocks := prompt.Blocks()
+	for _, attachment := range attachments {
+		block, err := types.NewAttachmentBlock(attachment.Name, attachment.Reader)
+		if err != nil {
+			return "", err
+		}
+		blocks = append(blocks, block)
+	}
+
+	data, err := types.MarshalUserInput(&types.UserMessage{Content: blocks}, defaultSessionID)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// marshalUserPrompt encodes a prompt as a stream-json user input line
+func marshalUserPrompt(prompt types.Prompt) (string, error) {
+	data, err := types.MarshalUserInput(&types.UserMessage{Content: prompt.Content()}, defaultSessionID)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,565 @@
+package claude
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anthropics/claude-agent-sdk-go/internal/types"
+)
+
+// createMockCLI writes an executable mock CLI script and returns its path
+func createMockCLI(t *testing.T, script string) string {
+	t.Helper()
+	t.Setenv("CLAUDE_AGENT_SDK_SKIP_VERSION_CHECK", "1")
+
+	tempDir, err := os.MkdirTemp("", "claude-mock-cli-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(tempDir)
+	})
+
+	scriptPath := filepath.Join(tempDir, "mock-cli.sh")
+	if err := os.WriteFile(scriptPath, []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write mock script: %v", err)
+	}
+
+	return scriptPath
+}
+
+func TestSendAndWait(t *testing.T) {
+	mockScript := `#!/bin/bash
+echo '{"type":"system","subtype":"init","data":{"session_id":"test"}}'
+read -r line
+echo '{"type":"assistant","content":[{"type":"text","text":"4"}],"model":"claude-3-haiku-20240307"}'
+echo '{"type":"result","subtype":"success","duration_ms":100,"session_id":"test","result":"4"}'
+sleep 5
+`
+	cliPath := createMockCLI(t, mockScript)
+	options := types.NewClaudeAgentOptions().WithCLIPath(cliPath)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, messages, err := SendAndWait(ctx, "What is 2+2?", options)
+	if err != nil {
+		t.Fatalf("SendAndWait() error = %v", err)
+	}
+
+	if result == nil || result.Result == nil || *result.Result != "4" {
+		t.Errorf("Expected result '4', got %+v", result)
+	}
+
+	if len(messages) != 3 {
+		t.Fatalf("Expected 3 messages, got %d", len(messages))
+	}
+	if messages[2] != result {
+		t.Error("Expected the result message to be the last collected message")
+	}
+}
+
+func TestSendAndWait_ErrorResult(t *testing.T) {
+	mockScript := `#!/bin/bash
+read -r line
+echo '{"type":"result","subtype":"error_during_execution","is_error":true,"session_id":"test","result":"API overloaded"}'
+sleep 5
+`
+	cliPath := createMockCLI(t, mockScript)
+	options := types.NewClaudeAgentOptions().WithCLIPath(cliPath)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, messages, err := SendAndWait(ctx, "test", options)
+	if err == nil {
+		t.Fatal("Expected error for error result")
+	}
+
+	var resultErr *types.ResultError
+	if !errors.As(err, &resultErr) {
+		t.Errorf("Expected ResultError, got %T", err)
+	}
+	if !strings.Contains(err.Error(), "API overloaded") {
+		t.Errorf("Expected error to contain result text, got: %v", err)
+	}
+	if result == nil || !result.IsError {
+		t.Errorf("Expected error result to be returned, got %+v", result)
+	}
+	if len(messages) != 1 {
+		t.Errorf("Expected 1 message, got %d", len(messages))
+	}
+}
+
+func TestSendAndWait_ModelFallback(t *testing.T) {
+	// The mock succeeds as "backup", is overloaded after starting work as
+	// "busy", and is overloaded before doing anything as any other model
+	mockScript := `#!/bin/bash
+model=""
+while [ $# -gt 0 ]; do
+    [ "$1" = "--model" ] && model="$2"
+    shift
+done
+read -r line
+case "$model" in
+    backup)
+        echo '{"type":"assistant","message":{"model":"backup","content":[{"type":"text","text":"hi"}]}}'
+        echo '{"type":"result","subtype":"success","session_id":"test"}'
+        ;;
+    busy)
+        echo '{"type":"assistant","message":{"model":"busy","content":[{"type":"text","text":"hi"}]}}'
+        echo '{"type":"result","subtype":"error_during_execution","is_error":true,"session_id":"test","result":"API Error: 529 Overloaded"}'
+        ;;
+    *)
+        echo '{"type":"result","subtype":"error_during_execution","is_error":true,"session_id":"test","result":"API Error: 529 Overloaded"}'
+        ;;
+esac
+`
+	cliPath := createMockCLI(t, mockScript)
+
+	tests := []struct {
+		name          string
+		model         string
+		fallback      []string
+		wantErr       bool
+		wantFallbacks []string
+	}{
+		{
+			name:          "falls back through the list",
+			model:         "primary",
+			fallback:      []string{"down", "backup"},
+			wantFallbacks: []string{"primary->down", "down->backup"},
+		},
+		{
+			name:          "list exhausted",
+			model:         "primary",
+			fallback:      []string{"down"},
+			wantErr:       true,
+			wantFallbacks: []string{"primary->down"},
+		},
+		{
+			name:     "no retry after the model did work",
+			model:    "busy",
+			fallback: []string{"backup"},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fallbacks []string
+			options := types.NewClaudeAgentOptions().
+				WithCLIPath(cliPath).
+				WithModel(tt.model).
+				WithModelFallback(tt.fallback...).
+				WithOnModelFallback(func(from, to string, err error) {
+					if !errors.As(err, new(*types.ResultError)) {
+						t.Errorf("OnModelFallback error = %v, want ResultError", err)
+					}
+					fallbacks = append(fallbacks, from+"->"+to)
+				})
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			result, _, err := SendAndWait(ctx, "test", options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SendAndWait() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if result == nil {
+				t.Fatal("Expected a result message")
+			}
+			if !reflect.DeepEqual(fallbacks, tt.wantFallbacks) {
+				t.Errorf("fallbacks = %v, want %v", fallbacks, tt.wantFallbacks)
+			}
+			if *options.Model != tt.model {
+				t.Errorf("options.Model = %q, want the caller's options unchanged", *options.Model)
+			}
+		})
+	}
+}
+
+func TestSendAndWait_Attachment(t *testing.T) {
+	inputFile := filepath.Join(t.TempDir(), "stdin.txt")
+	mockScript := `#!/bin/bash
+IFS= read -r line
+printf '%s' "$line" > ` + inputFile + `
+echo '{"type":"result","subtype":"success","session_id":"test"}'
+`
+	cliPath := createMockCLI(t, mockScript)
+	options := types.NewClaudeAgentOptions().
+		WithCLIPath(cliPath).
+		WithAttachment("main.go", strings.NewReader("package main\n"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, _, err := SendAndWait(ctx, "review this", options); err != nil {
+		t.Fatalf("SendAndWait() error = %v", err)
+	}
+
+	data, err := os.ReadFile(inputFile)
+	if err != nil {
+		t.Fatalf("Failed to read mock input: %v", err)
+	}
+	var input struct {
+		Message struct {
+			Content []map[string]any `json:"content"`
+		} `json:"message"`
+	}
+	if err := json.Unmarshal(data, &input); err != nil {
+		t.Fatalf("CLI received invalid JSON %q: %v", data, err)
+	}
+
+	content := input.Message.Content
+	if len(content) != 2 || content[0]["text"] != "review this" {
+		t.Fatalf("Content = %v, want the prompt followed by the attachment", content)
+	}
+	if want := "<attachment name=\"main.go\">\npackage main\n\n</attachment>"; content[1]["text"] != want {
+		t.Errorf("Attachment block = %q, want %q", content[1]["text"], want)
+	}
+}
+
+func TestSendPromptAndWait(t *testing.T) {
+	inputFile := filepath.Join(t.TempDir(), "stdin.txt")
+	// Hooks make the SDK initialize the session before sending the prompt
+	mockScript := `#!/bin/bash
+IFS= read -r line
+id=$(echo "$line" | sed -E 's/.*"request_id":"([^"]+)".*/\1/')
+echo '{"type":"control_response","response":{"subtype":"success","request_id":"'"$id"'","response":{}}}'
+IFS= read -r line
+printf '%s' "$line" > ` + inputFile + `
+echo '{"type":"result","subtype":"success","session_id":"test"}'
+`
+	cliPath := createMockCLI(t, mockScript)
+	hook := func(ctx context.Context, input interface{}, toolUseID *string, hookContext *types.HookContext) (map[string]interface{}, error) {
+		return map[string]any{"hookSpecificOutput": map[string]any{"additionalContext": "Be brief."}}, nil
+	}
+	options := types.NewClaudeAgentOptions().
+		WithCLIPath(cliPath).
+		WithHook(types.HookEventUserPromptSubmit, types.HookMatcher{Hooks: []types.HookFunc{hook}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	prompt := types.BlocksPrompt(
+		types.NewImageBlock("image/png", []byte("png")),
+		&types.TextBlock{Text: "What is this?"},
+	)
+	if _, _, err := SendPromptAndWait(ctx, prompt, options); err != nil {
+		t.Fatalf("SendPromptAndWait() error = %v", err)
+	}
+
+	data, err := os.ReadFile(inputFile)
+	if err != nil {
+		t.Fatalf("Failed to read mock input: %v", err)
+	}
+	var input struct {
+		Message struct {
+			Content []map[string]any `json:"content"`
+		} `json:"message"`
+	}
+	if err := json.Unmarshal(data, &input); err != nil {
+		t.Fatalf("CLI received invalid JSON %q: %v", data, err)
+	}
+
+	want := []map[string]any{
+		{"type": "text", "text": "What is this?\n\nBe brief."},
+		{"type": "image", "source": map[string]any{"type": "base64", "media_type": "image/png", "data": "cG5n"}},
+	}
+	if !reflect.DeepEqual(input.Message.Content, want) {
+		t.Errorf("Content = %v, want %v", input.Message.Content, want)
+	}
+}
+
+func TestSendAndWait_ImmediateErrorResult(t *testing.T) {
+	// The CLI fails before any assistant message and exits without reading the prompt
+	mockScript := `#!/bin/bash
+echo '{"type":"system","subtype":"init","session_id":"test"}'
+echo '{"type":"result","subtype":"error_during_execution","is_error":true,"session_id":"test","errors":["Invalid API key"]}'
+`
+	cliPath := createMockCLI(t, mockScript)
+
+	tests := []struct {
+		name    string
+		options *types.ClaudeAgentOptions
+	}{
+		{name: "without initialize", options: types.NewClaudeAgentOptions().WithCLIPath(cliPath)},
+		{name: "with initialize", options: types.NewClaudeAgentOptions().WithCLIPath(cliPath).WithHook(types.HookEventPreToolUse, types.HookMatcher{})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			result, messages, err := SendAndWait(ctx, "test", tt.options)
+
+			var resultErr *types.ResultError
+			if !errors.As(err, &resultErr) || resultErr.Detail != "Invalid API key" {
+				t.Fatalf("Expected ResultError with the CLI's error, got %v", err)
+			}
+			if result == nil || !result.IsError {
+				t.Errorf("Expected error result to be returned, got %+v", result)
+			}
+			if len(messages) != 2 {
+				t.Errorf("Expected system and result messages, got %d", len(messages))
+			}
+		})
+	}
+}
+
+func TestResumeAndSend_ErrorResultInsteadOfInitialize(t *testing.T) {
+	// The CLI stays alive but answers initialize with an error result only
+	mockScript := `#!/bin/bash
+read -r line
+echo '{"type":"result","subtype":"error_during_execution","is_error":true,"session_id":"missing","errors":["No conversation found with session ID: missing"]}'
+sleep 5
+`
+	cliPath := createMockCLI(t, mockScript)
+	options := types.NewClaudeAgentOptions().WithCLIPath(cliPath).WithControlTimeout(5 * time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	_, _, err := ResumeAndSend(ctx, "missing", "continue", options)
+
+	var resultErr *types.ResultError
+	if !errors.As(err, &resultErr) {
+		t.Fatalf("Expected ResultError, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("ResumeAndSend took %v; expected the error result to end initialize immediately", elapsed)
+	}
+}
+
+func TestSendAndWait_FailFast(t *testing.T) {
+	mockScript := `#!/bin/bash
+read -r line
+echo '{"type":"assistant","model":"claude","content":[{"type":"bogus"}]}'
+sleep 0.5
+echo '{"type":"result","subtype":"success","session_id":"test","result":"done"}'
+`
+	cliPath := createMockCLI(t, mockScript)
+
+	tests := []struct {
+		name     string
+		failFast bool
+		wantErr  bool
+	}{
+		{"disabled skips the bad message", false, false},
+		{"enabled returns the first error", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := types.NewClaudeAgentOptions().
+				WithCLIPath(cliPath).
+				WithFailFast(tt.failFast)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			result, _, err := SendAndWait(ctx, "test", options)
+			if !tt.wantErr {
+				if err != nil || result == nil {
+					t.Errorf("SendAndWait() = %v, %v; want result", result, err)
+				}
+				return
+			}
+
+			var parseErr *types.MessageParseError
+			if !errors.As(err, &parseErr) {
+				t.Errorf("Expected MessageParseError, got %T: %v", err, err)
+			}
+			if result != nil {
+				t.Errorf("Expected no result in fail-fast mode, got %+v", result)
+			}
+		})
+	}
+}
+
+func TestSendAndWait_OutputSchema(t *testing.T) {
+	schema := map[string]any{
+		"type":       "object",
+		"required":   []string{"answer"},
+		"properties": map[string]any{"answer": map[string]any{"type": "integer"}},
+	}
+
+	tests := []struct {
+		name     string
+		result   string
+		wantErr  bool
+		wantPath string
+	}{
+		{
+			name:   "structured output matches",
+			result: `{"type":"result","subtype":"success","session_id":"test","result":"done","structured_output":{"answer":42}}`,
+		},
+		{
+			name:   "result text matches",
+			result: `{"type":"result","subtype":"success","session_id":"test","result":"{\"answer\":42}"}`,
+		},
+		{
+			name:     "mismatch",
+			result:   `{"type":"result","subtype":"success","session_id":"test","structured_output":{"answer":"42"}}`,
+			wantErr:  true,
+			wantPath: "$.answer",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockScript := `#!/bin/bash
+read -r line
+echo '` + tt.result + `'
+`
+			options := types.NewClaudeAgentOptions().
+				WithCLIPath(createMockCLI(t, mockScript)).
+				WithOutputSchema(schema)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			result, _, err := SendAndWait(ctx, "test", options)
+			if result == nil {
+				t.Fatalf("Expected the result to be returned, got error %v", err)
+			}
+			if !tt.wantErr {
+				if err != nil {
+					t.Errorf("SendAndWait() error = %v, want nil", err)
+				}
+				return
+			}
+
+			var schemaErr *types.OutputSchemaError
+			if !errors.As(err, &schemaErr) {
+				t.Fatalf("Expected OutputSchemaError, got %T: %v", err, err)
+			}
+			if schemaErr.Path != tt.wantPath {
+				t.Errorf("Path = %q, want %q", schemaErr.Path, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestSendAndWait_NoResult(t *testing.T) {
+	mockScript := `#!/bin/bash
+read -r line
+echo '{"type":"assistant","content":[{"type":"text","text":"partial"}],"model":"claude-3-haiku-20240307"}'
+`
+	cliPath := createMockCLI(t, mockScript)
+	options := types.NewClaudeAgentOptions().WithCLIPath(cliPath)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, messages, err := SendAndWait(ctx, "test", options)
+	if err == nil {
+		t.Fatal("Expected error when the session ends without a result")
+	}
+	if len(messages) != 1 {
+		t.Errorf("Expected 1 message, got %d", len(messages))
+	}
+}
+
+func TestSendAndWait_InvalidOptions(t *testing.T) {
+	mockScript := `#!/bin/bash
+echo '{"type":"result","subtype":"success","session_id":"test"}'
+`
+	cliPath := createMockCLI(t, mockScript)
+	options := types.NewClaudeAgentOptions().
+		WithCLIPath(cliPath).
+		WithMCPServer("remote", &types.MCPServerConfig{Type: types.MCPServerTypeSSE})
+
+	_, _, err := SendAndWait(context.Background(), "test", options)
+	if err == nil || !strings.Contains(err.Error(), `invalid MCP server "remote"`) {
+		t.Errorf("Expected MCP server validation error, got %v", err)
+	}
+}
+
+func TestResumeAndSend(t *testing.T) {
+	// The mock fails unless it is resumed and the initialize request arrives
+	// (and is answered) before the prompt
+	mockScript := `#!/bin/bash
+fail() {
+    echo '{"type":"result","subtype":"error_during_execution","is_error":true,"session_id":"s1","result":"'"$1"'"}'
+    sleep 5
+    exit 0
+}
+
+case " $* " in
+    *" --resume session-123 "*) ;;
+    *) fail "missing resume flag" ;;
+esac
+
+read -r line
+case "$line" in
+    *'"subtype":"initialize"'*) ;;
+    *) fail "expected initialize before prompt" ;;
+esac
+id=$(echo "$line" | sed -E 's/.*"request_id":"([^"]+)".*/\1/')
+sleep 0.2
+echo '{"type":"control_response","response":{"subtype":"success","request_id":"'"$id"'","response":{}}}'
+
+read -r line
+case "$line" in
+    *'"content":"continue please"'*) ;;
+    *) fail "expected prompt after initialize" ;;
+esac
+echo '{"type":"result","subtype":"success","session_id":"session-123","result":"resumed"}'
+sleep 5
+`
+	cliPath := createMockCLI(t, mockScript)
+	options := types.NewClaudeAgentOptions().WithCLIPath(cliPath)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, _, err := ResumeAndSend(ctx, "session-123", "continue please", options)
+	if err != nil {
+		t.Fatalf("ResumeAndSend() error = %v", err)
+	}
+	if result.Result == nil || *result.Result != "resumed" {
+		t.Errorf("Expected result 'resumed', got %+v", result)
+	}
+
+	if options.Resume != nil {
+		t.Error("ResumeAndSend should not modify the caller's options")
+	}
+}
+
+func TestDryRunCommand(t *testing.T) {
+	cliPath := createMockCLI(t, "#!/bin/bash\nexit 1\n")
+	options := types.NewClaudeAgentOptions().
+		WithCLIPath(cliPath).
+		WithModel("claude-sonnet-4-5").
+		WithMaxTurns(3)
+
+	cmd, err := DryRunCommand(options)
+	if err != nil {
+		t.Fatalf("DryRunCommand() error = %v", err)
+	}
+	if len(cmd) == 0 || cmd[0] != cliPath {
+		t.Fatalf("Expected command to start with the CLI path, got %v", cmd)
+	}
+	joined := strings.Join(cmd, " ")
+	for _, want := range []string{"--model claude-sonnet-4-5", "--max-turns 3", "--output-format stream-json"} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("Expected %q in command %v", want, cmd)
+		}
+	}
+
+	invalid := types.NewClaudeAgentOptions().WithResume("s1").WithContinueConversation(true)
+	if _, err := DryRunCommand(invalid); err == nil {
+		t.Error("Expected DryRunCommand to reject invalid options")
+	}
+}
@@ -0,0 +1,150 @@
+package claude
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/anthropics/claude-agent-sdk-go/internal/types"
+)
+
+// ToolHandler executes a tool call requested by Claude. The returned content
+// is sent back as the tool result; an error is reported to Claude as a failed
+// tool result.
+type ToolHandler func(ctx context.Context, input map[string]any) (any, error)
+
+// StepFunc observes every message of an agent run. Returning an error stops the run.
+type StepFunc func(msg types.Message) error
+
+// AgentRunner drives an agent loop: it sends a prompt, dispatches the tool
+// calls in each assistant message to registered Go handlers, feeds the tool
+// results back and repeats until Claude produces a result.
+//
+// Tool calls with no registered handler are left to the CLI, which executes
+// its built-in tools itself.
+type AgentRunner struct {
+	options *types.ClaudeAgentOptions
+	tools   map[string]ToolHandler
+	onStep  StepFunc
+}
+
+// NewAgentRunner creates a new AgentRunner. The options are validated when Run is called.
+func NewAgentRunner(options *types.ClaudeAgentOptions) *AgentRunner {
+	if options == nil {
+		options = types.NewClaudeAgentOptions()
+	}
+	return &AgentRunner{
+		options: options,
+		tools:   make(map[string]ToolHandler),
+	}
+}
+
+// WithTool registers the handler for the named tool
+func (r *AgentRunner) WithTool(name string, handler ToolHandler) *AgentRunner {
+	r.tools[name] = handler
+	return r
+}
+
+// WithStepCallback sets the callback invoked for every message of the run
+func (r *AgentRunner) WithStepCallback(onStep StepFunc) *AgentRunner {
+	r.onStep = onStep
+	return r
+}
+
+// Run sends prompt and drives the loop until a ResultMessage arrives.
+//
+// Each assistant message whose tool calls are dispatched counts as a turn.
+// When MaxTurns is set and Claude asks for tools again after that many turns,
+// the run stops with a ResultError without dispatching them; the results of
+// the last allowed turn are still sent, so Claude can finish with them. An
+// error result is returned alongside a ResultError, as for SendAndWait.
+func (r *AgentRunner) Run(ctx context.Context, prompt string) (*types.ResultMessage, error) {
+	client, err := NewClient(r.options)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Connect(ctx); err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = client.Close(ctx)
+	}()
+
+	if err := client.Query(ctx, prompt); err != nil {
+		return nil, err
+	}
+
+	turns := 0
+	messages := client.ReceiveMessages(ctx)
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				return nil, types.NewProcessError("session ended without a result message", nil)
+			}
+			if r.onStep != nil {
+				if err := r.onStep(msg); err != nil {
+					return nil, err
+				}
+			}
+
+			switch m := msg.(type) {
+			case *types.ResultMessage:
+				if m.IsError {
+					return m, m.ExecutionError()
+				}
+				return m, nil
+
+			case *types.AssistantMessage:
+				if !r.handlesTools(m) {
+					continue
+				}
+				if r.options.MaxTurns != nil && turns >= *r.options.MaxTurns {
+					return nil, types.NewResultError(fmt.Sprintf("agent stopped after reaching the maximum of %d turns", turns), nil)
+				}
+
+				results := r.dispatchTools(ctx, m)
+				if err := client.WriteMessage(ctx, types.NewToolResultsMessage(results...)); err != nil {
+					return nil, err
+				}
+				turns++
+			}
+
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// handlesTools reports whether msg calls any tool with a registered handler
+func (r *AgentRunner) handlesTools(msg *types.AssistantMessage) bool {
+	for _, block := range msg.Content {
+		if toolUse, ok := block.(*types.ToolUseBlock); ok && r.tools[toolUse.Name] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// dispatchTools runs the registered handlers for the tool calls in msg and
+// returns their results in call order
+func (r *AgentRunner) dispatchTools(ctx context.Context, msg *types.AssistantMessage) []types.ToolResultBlock {
+	var results []types.ToolResultBlock
+	for _, block := range msg.Content {
+		toolUse, ok := block.(*types.ToolUseBlock)
+		if !ok {
+			continue
+		}
+		handler, ok := r.tools[toolUse.Name]
+		if !ok {
+			continue
+		}
+
+		content, err := handler(ctx, toolUse.Input)
+		if err != nil {
+			results = append(results, types.NewErrorToolResult(toolUse.ID, err))
+			continue
+		}
+		results = append(results, types.ToolResultBlock{ToolUseID: toolUse.ID, Content: content})
+	}
+	return results
+}
@@ -0,0 +1,167 @@
+package claude
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/anthropics/claude-agent-sdk-go/internal/types"
+)
+
+// toolLoopMockCLI asks for the "add" tool on the first prompt and finishes
+// once the matching tool result arrives
+const toolLoopMockCLI = `#!/bin/bash
+while read -r line; do
+    case "$line" in
+        *'"subtype":"initialize"'*)
+            id=$(echo "$line" | sed -E 's/.*"request_id":"([^"]+)".*/\1/')
+            echo '{"type":"control_response","response":{"subtype":"success","request_id":"'"$id"'","response":{}}}'
+            ;;
+        *'"content":"5","tool_use_id":"t1"'*)
+            echo '{"type":"assistant","content":[{"type":"text","text":"The sum is 5"}],"model":"claude"}'
+            echo '{"type":"result","subtype":"success","session_id":"agent","result":"5"}'
+            ;;
+        *'"tool_result"'*)
+            echo '{"type":"result","subtype":"error_during_execution","is_error":true,"session_id":"agent","result":"unexpected tool result"}'
+            ;;
+        *'"type":"user"'*)
+            echo '{"type":"assistant","content":[{"type":"tool_use","id":"t1","name":"add","input":{"a":2,"b":3}}],"model":"claude"}'
+            ;;
+    esac
+done
+`
+
+// endlessToolMockCLI asks for the "add" tool again after every tool result
+const endlessToolMockCLI = `#!/bin/bash
+while read -r line; do
+    case "$line" in
+        *'"subtype":"initialize"'*)
+            id=$(echo "$line" | sed -E 's/.*"request_id":"([^"]+)".*/\1/')
+            echo '{"type":"control_response","response":{"subtype":"success","request_id":"'"$id"'","response":{}}}'
+            ;;
+        *'"type":"user"'*)
+            echo '{"type":"assistant","content":[{"type":"tool_use","id":"t1","name":"add","input":{"a":2,"b":3}}],"model":"claude"}'
+            ;;
+    esac
+done
+`
+
+// addTool sums the numeric inputs a and b
+func addTool(ctx context.Context, input map[string]any) (any, error) {
+	a, _ := input["a"].(float64)
+	b, _ := input["b"].(float64)
+	return fmt.Sprintf("%g", a+b), nil
+}
+
+func TestAgentRunner_Run(t *testing.T) {
+	cliPath := createMockCLI(t, toolLoopMockCLI)
+
+	var steps []string
+	runner := NewAgentRunner(types.NewClaudeAgentOptions().WithCLIPath(cliPath)).
+		WithTool("add", addTool).
+		WithStepCallback(func(msg types.Message) error {
+			steps = append(steps, msg.Type())
+			return nil
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := runner.Run(ctx, "What is 2+3?")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result.Result == nil || *result.Result != "5" {
+		t.Errorf("Expected result '5', got %+v", result)
+	}
+
+	want := []string{types.MessageTypeAssistant, types.MessageTypeAssistant, types.MessageTypeResult}
+	if fmt.Sprint(steps) != fmt.Sprint(want) {
+		t.Errorf("Steps = %v, want %v", steps, want)
+	}
+}
+
+func TestAgentRunner_MaxTurns(t *testing.T) {
+	cliPath := createMockCLI(t, endlessToolMockCLI)
+	calls := 0
+	runner := NewAgentRunner(types.NewClaudeAgentOptions().WithCLIPath(cliPath).WithMaxTurns(2)).
+		WithTool("add", func(ctx context.Context, input map[string]any) (any, error) {
+			calls++
+			return addTool(ctx, input)
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := runner.Run(ctx, "What is 2+3?")
+	var resultErr *types.ResultError
+	if !errors.As(err, &resultErr) {
+		t.Errorf("Expected ResultError after max turns, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("Expected 2 tool calls within the limit, got %d", calls)
+	}
+}
+
+func TestAgentRunner_MaxTurnsLastTurnUsesTools(t *testing.T) {
+	// The only allowed turn uses a tool; its result is still sent and Claude
+	// finishes with it
+	cliPath := createMockCLI(t, toolLoopMockCLI)
+	runner := NewAgentRunner(types.NewClaudeAgentOptions().WithCLIPath(cliPath).WithMaxTurns(1)).
+		WithTool("add", addTool)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := runner.Run(ctx, "What is 2+3?")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result.Result == nil || *result.Result != "5" {
+		t.Errorf("Expected result '5', got %+v", result)
+	}
+}
+
+func TestAgentRunner_StepErrorStopsRun(t *testing.T) {
+	cliPath := createMockCLI(t, toolLoopMockCLI)
+	stop := errors.New("stop")
+	calls := 0
+	runner := NewAgentRunner(types.NewClaudeAgentOptions().WithCLIPath(cliPath)).
+		WithTool("add", func(ctx context.Context, input map[string]any) (any, error) {
+			calls++
+			return addTool(ctx, input)
+		}).
+		WithStepCallback(func(msg types.Message) error {
+			return stop
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := runner.Run(ctx, "What is 2+3?"); !errors.Is(err, stop) {
+		t.Errorf("Expected step error, got %v", err)
+	}
+	if calls != 0 {
+		t.Errorf("Expected no tool calls after the step error, got %d", calls)
+	}
+}
+
+func TestAgentRunner_ToolErrorReported(t *testing.T) {
+	cliPath := createMockCLI(t, toolLoopMockCLI)
+	runner := NewAgentRunner(types.NewClaudeAgentOptions().WithCLIPath(cliPath)).
+		WithTool("add", func(ctx context.Context, input map[string]any) (any, error) {
+			return nil, errors.New("overflow")
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The mock rejects any tool result other than "5" with an error result
+	result, err := runner.Run(ctx, "What is 2+3?")
+	var resultErr *types.ResultError
+	if !errors.As(err, &resultErr) || result == nil || !result.IsError {
+		t.Errorf("Expected error result for the failed tool, got %+v, %v", result, err)
+	}
+}
@@ -0,0 +1,88 @@
+package claude
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// signalActionTimeout bounds the Interrupt or Close triggered by a SIGINT
+const signalActionTimeout = 10 * time.Second
+
+// handleSignals installs a SIGINT handler for the client: the first signal
+// interrupts the current turn and the second closes the client. Once the
+// interrupted turn has ended, the next signal interrupts again. It returns a
+// function that removes the handler, restoring the previous signal behavior.
+func (c *Client) handleSignals() (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+
+	done := make(chan struct{})
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			signal.Stop(signals)
+			close(done)
+		})
+	}
+
+	go func() {
+		presses := 0
+		var interrupted turnState
+		for {
+			select {
+			case <-signals:
+			case <-done:
+				return
+			}
+
+			if presses > 0 && c.turnState().endedSince(interrupted) {
+				presses = 0
+			}
+			presses++
+
+			ctx, cancel := context.WithTimeout(context.Background(), signalActionTimeout)
+			if presses == 1 {
+				interrupted = c.turnState()
+
+				// Interrupt in the background so a second press is not held up
+				// waiting for the control response
+				go func() {
+					defer cancel()
+					_ = c.Interrupt(ctx)
+				}()
+				continue
+			}
+
+			// Close removes the handler, so a third press gets the default behavior
+			_ = c.Close(ctx)
+			cancel()
+			return
+		}
+	}()
+
+	return stop
+}
+
+// turnState identifies the client's current turn and whether it is running
+type turnState struct {
+	prompts int64
+	running bool
+}
+
+// turnState returns the state of the client's current turn
+func (c *Client) turnState() turnState {
+	state := turnState{prompts: c.prompts.Load()}
+	if _, q, err := c.session(); err == nil && state.prompts > 0 {
+		state.running = !q.Progress().Done
+	}
+	return state
+}
+
+// endedSince reports whether the turn that was current at previous has
+// ended: its result arrived or another prompt was sent
+func (s turnState) endedSince(previous turnState) bool {
+	return s.prompts != previous.prompts || (previous.running && !s.running)
+}
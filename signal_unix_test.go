@@ -0,0 +1,147 @@
+//go:build unix
+
+package claude
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/anthropics/claude-agent-sdk-go/internal/types"
+)
+
+func TestClient_SignalHandling(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "requests")
+	script := `#!/bin/bash
+while read -r line; do
+    case "$line" in
+        *'"subtype":"initialize"'*|*'"subtype":"interrupt"'*)
+            echo "$line" >> ` + logFile + `
+            id=$(echo "$line" | sed -E 's/.*"request_id":"([^"]+)".*/\1/')
+            echo '{"type":"control_response","response":{"subtype":"success","request_id":"'"$id"'","response":{}}}'
+            ;;
+    esac
+done
+`
+	options := types.NewClaudeAgentOptions().
+		WithCLIPath(createMockCLI(t, script)).
+		WithSignalHandling(true)
+	client, err := NewClient(options)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close(context.Background())
+	}()
+
+	waitFor := func(what string, done func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !done() {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timeout waiting for %s", what)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// The first Ctrl-C interrupts the turn
+	if err := syscall.Kill(os.Getpid(), syscall.SIGINT); err != nil {
+		t.Fatalf("Failed to send SIGINT: %v", err)
+	}
+	waitFor("interrupt request", func() bool {
+		data, _ := os.ReadFile(logFile)
+		return strings.Contains(string(data), `"subtype":"interrupt"`)
+	})
+	if _, _, err := client.session(); err != nil {
+		t.Fatalf("Expected client to stay connected after the first SIGINT, got %v", err)
+	}
+
+	// The second closes the client
+	if err := syscall.Kill(os.Getpid(), syscall.SIGINT); err != nil {
+		t.Fatalf("Failed to send SIGINT: %v", err)
+	}
+	waitFor("client to close", func() bool {
+		_, _, err := client.session()
+		return err != nil
+	})
+
+	client.mu.RLock()
+	removed := client.stopSignals == nil
+	client.mu.RUnlock()
+	if !removed {
+		t.Error("Expected the signal handler to be removed on close")
+	}
+}
+
+func TestClient_SignalHandlingInterruptsEachTurn(t *testing.T) {
+	// Prompts start a turn that only ends when it is interrupted
+	logFile := filepath.Join(t.TempDir(), "requests")
+	script := `#!/bin/bash
+while read -r line; do
+    case "$line" in
+        *'"subtype":"interrupt"'*)
+            echo "$line" >> ` + logFile + `
+            id=$(echo "$line" | sed -E 's/.*"request_id":"([^"]+)".*/\1/')
+            echo '{"type":"control_response","response":{"subtype":"success","request_id":"'"$id"'","response":{}}}'
+            echo '{"type":"result","subtype":"error_during_execution","is_error":true,"session_id":"client-session"}'
+            ;;
+        *'"subtype":"initialize"'*)
+            id=$(echo "$line" | sed -E 's/.*"request_id":"([^"]+)".*/\1/')
+            echo '{"type":"control_response","response":{"subtype":"success","request_id":"'"$id"'","response":{}}}'
+            ;;
+        *'"type":"user"'*)
+            echo '{"type":"assistant","content":[{"type":"text","text":"working"}],"model":"claude"}'
+            ;;
+    esac
+done
+`
+	client, err := NewClient(types.NewClaudeAgentOptions().
+		WithCLIPath(createMockCLI(t, script)).
+		WithSignalHandling(true))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close(context.Background())
+	}()
+
+	interrupts := func() int {
+		data, _ := os.ReadFile(logFile)
+		return strings.Count(string(data), `"subtype":"interrupt"`)
+	}
+
+	// Each Ctrl-C after the previous interrupted turn ended interrupts again
+	for turn := 1; turn <= 2; turn++ {
+		if err := client.Query(ctx, "hello"); err != nil {
+			t.Fatalf("Query() error = %v", err)
+		}
+		if err := syscall.Kill(os.Getpid(), syscall.SIGINT); err != nil {
+			t.Fatalf("Failed to send SIGINT: %v", err)
+		}
+		for range client.ReceiveResponse(ctx) {
+		}
+		if got := interrupts(); got != turn {
+			t.Fatalf("Interrupt requests after turn %d = %d, want %d", turn, got, turn)
+		}
+		if _, _, err := client.session(); err != nil {
+			t.Fatalf("Expected client to stay connected after turn %d, got %v", turn, err)
+		}
+	}
+}
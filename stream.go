@@ -0,0 +1,91 @@
+package claude
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/anthropics/claude-agent-sdk-go/internal/types"
+)
+
+// ParseMessageStream reads a newline-delimited stream-json transcript, such as
+// saved CLI output, and yields each line as a typed message. Lines that fail to
+// parse are yielded as errors and skipped; a read error is yielded last.
+//
+// The returned function has the shape of iter.Seq2[types.Message, error], so
+// with Go 1.23 or later it can be ranged over directly:
+//
+//	for msg, err := range claude.ParseMessageStream(file) { ... }
+func ParseMessageStream(r io.Reader) func(yield func(types.Message, error) bool) {
+	return func(yield func(types.Message, error) bool) {
+		parseLines(r, func(line int, msg types.Message, err error) bool {
+			return yield(msg, err)
+		})
+	}
+}
+
+// parseLines yields each non-blank line of r as a typed message along with its
+// 1-based line number, then a read error, if any, with the line being read
+func parseLines(r io.Reader, yield func(line int, msg types.Message, err error) bool) {
+	reader := bufio.NewReader(r)
+	for number := 1; ; number++ {
+		line, readErr := reader.ReadString('\n')
+		if line = strings.TrimSpace(line); line != "" {
+			msg, err := types.UnmarshalMessage([]byte(line))
+			if !yield(number, msg, err) {
+				return
+			}
+		}
+
+		if readErr != nil {
+			if !errors.Is(readErr, io.EOF) {
+				yield(number, nil, types.NewJSONDecodeError("failed to read message stream", readErr))
+			}
+			return
+		}
+	}
+}
+
+// WriteTranscript writes messages to w as JSON Lines, one message per line, in
+// the format read by ReadTranscript and ParseMessageStream. Together they give
+// a portable record of a conversation, independent of the CLI's own session
+// storage.
+func WriteTranscript(w io.Writer, messages []types.Message) error {
+	writer := bufio.NewWriter(w)
+	for i, msg := range messages {
+		data, err := types.MarshalMessage(msg)
+		if err != nil {
+			return types.NewMessageParseError(fmt.Sprintf("failed to encode transcript message %d", i), err)
+		}
+		if _, err := writer.Write(data); err != nil {
+			return err
+		}
+		if err := writer.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// ReadTranscript reads a transcript written by WriteTranscript. Unlike
+// ParseMessageStream it is strict: the first line that fails to parse ends the
+// read with an error naming the line.
+func ReadTranscript(r io.Reader) ([]types.Message, error) {
+	var messages []types.Message
+	failedLine := 0
+	var readErr error
+	parseLines(r, func(line int, msg types.Message, err error) bool {
+		if err != nil {
+			failedLine, readErr = line, err
+			return false
+		}
+		messages = append(messages, msg)
+		return true
+	})
+	if readErr != nil {
+		return nil, types.NewMessageParseError(fmt.Sprintf("failed to read transcript line %d", failedLine), readErr)
+	}
+	return messages, nil
+}
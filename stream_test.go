@@ -0,0 +1,119 @@
+package claude
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/anthropics/claude-agent-sdk-go/internal/types"
+)
+
+func TestParseMessageStream(t *testing.T) {
+	transcript := `{"type":"system","subtype":"init","data":{"session_id":"s1"}}
+
+{"type":"assistant","content":[{"type":"text","text":"hi"}],"model":"claude"}
+not json
+{"type":"result","subtype":"success","session_id":"s1","result":"hi"}`
+
+	var got []string
+	var errs []error
+	ParseMessageStream(strings.NewReader(transcript))(func(msg types.Message, err error) bool {
+		if err != nil {
+			errs = append(errs, err)
+			return true
+		}
+		got = append(got, msg.Type())
+		return true
+	})
+
+	want := []string{types.MessageTypeSystem, types.MessageTypeAssistant, types.MessageTypeResult}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Messages = %v, want %v", got, want)
+	}
+
+	var decodeErr *types.JSONDecodeError
+	if len(errs) != 1 || !errors.As(errs[0], &decodeErr) {
+		t.Errorf("Expected one JSONDecodeError for the invalid line, got %v", errs)
+	}
+}
+
+func TestParseMessageStream_StopEarly(t *testing.T) {
+	transcript := strings.Repeat(`{"type":"system","subtype":"init","data":{}}`+"\n", 5)
+
+	count := 0
+	ParseMessageStream(strings.NewReader(transcript))(func(msg types.Message, err error) bool {
+		count++
+		return count < 2
+	})
+
+	if count != 2 {
+		t.Errorf("Expected iteration to stop after 2 messages, got %d", count)
+	}
+}
+
+func TestTranscript_RoundTrip(t *testing.T) {
+	result := "done"
+	messages := []types.Message{
+		&types.SystemMessage{Subtype: types.SystemSubtypeInit, Data: map[string]any{"session_id": "s1"}},
+		&types.UserMessage{Content: "hello"},
+		&types.AssistantMessage{
+			Content: []types.ContentBlock{
+				&types.TextBlock{Type_: types.ContentTypeText, Text: "reading"},
+				&types.ToolUseBlock{Type_: types.ContentTypeToolUse, ID: "tool_1", Name: "Read", Input: map[string]any{"file_path": "a.go"}},
+			},
+			Model: "claude",
+			UUID:  "uuid-1",
+		},
+		&types.ResultMessage{Subtype: "success", SessionID: "s1", Result: &result},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteTranscript(&buf, messages); err != nil {
+		t.Fatalf("WriteTranscript() error = %v", err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != len(messages) {
+		t.Errorf("Transcript has %d lines, want %d", lines, len(messages))
+	}
+
+	got, err := ReadTranscript(&buf)
+	if err != nil {
+		t.Fatalf("ReadTranscript() error = %v", err)
+	}
+	if len(got) != len(messages) {
+		t.Fatalf("ReadTranscript() returned %d messages, want %d", len(got), len(messages))
+	}
+	for i := range messages {
+		want, _ := types.MarshalMessage(messages[i])
+		have, _ := types.MarshalMessage(got[i])
+		if string(have) != string(want) {
+			t.Errorf("Message %d = %s, want %s", i, have, want)
+		}
+	}
+}
+
+func TestReadTranscript_InvalidLine(t *testing.T) {
+	transcript := `{"type":"user","message":{"role":"user","content":"hi"}}
+not json
+`
+	_, err := ReadTranscript(strings.NewReader(transcript))
+
+	var parseErr *types.MessageParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("ReadTranscript() error = %v, want MessageParseError", err)
+	}
+	if !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Error %q does not name the failing line", err)
+	}
+}
+
+func TestReadTranscript_InvalidLineAfterBlankLine(t *testing.T) {
+	transcript := `{"type":"user","message":{"role":"user","content":"hi"}}
+
+not json
+`
+	_, err := ReadTranscript(strings.NewReader(transcript))
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("ReadTranscript() error = %v, want it to name line 3", err)
+	}
+}